@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode flags from linux/fs.h that make truncate fail.
+const (
+	fsImmutableFl = 0x00000010 // chattr +i
+	fsAppendFl    = 0x00000020 // chattr +a
+
+	protectedAttrs = fsImmutableFl | fsAppendFl
+)
+
+// fileAttrFlags returns path's append-only and immutable inode flags, or 0 when
+// it has none or the filesystem doesn't support them.
+func fileAttrFlags(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err == unix.ENOTTY || err == unix.EOPNOTSUPP {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return flags & protectedAttrs, nil
+}
+
+// setFileAttrFlags clears or sets the protectedAttrs bits of path to match flags,
+// leaving its other inode flags alone. Changing them needs CAP_LINUX_IMMUTABLE.
+func setFileAttrFlags(path string, flags uint32) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cur, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, int(cur&^protectedAttrs|flags&protectedAttrs))
+}
+
+// describeAttrs renders flags the way lsattr/chattr spell them.
+func describeAttrs(flags uint32) string {
+	switch {
+	case flags&fsImmutableFl != 0 && flags&fsAppendFl != 0:
+		return "immutable, append-only (chattr +ia)"
+	case flags&fsImmutableFl != 0:
+		return "immutable (chattr +i)"
+	default:
+		return "append-only (chattr +a)"
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendOnlyFile creates a chattr +a file, skipping when the filesystem or our
+// privileges don't allow it.
+func appendOnlyFile(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "audit.log")
+	os.WriteFile(path, []byte("append only\n"), 0644)
+	if err := setFileAttrFlags(path, fsAppendFl); err != nil {
+		t.Skipf("cannot set append-only flag here: %v", err)
+	}
+	t.Cleanup(func() { setFileAttrFlags(path, 0) })
+	return path
+}
+
+func TestRotateAppendOnlySkipped(t *testing.T) {
+	dir := t.TempDir()
+	path := appendOnlyFile(t, dir)
+
+	res := rotateLogFile(path, makeTestCfg(t, dir))
+	if res.Status != statusSkipped || res.Reason != "append-only (chattr +a)" {
+		t.Fatalf("res = %+v, want skipped as append-only", res)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Error("archive written for a file that was skipped")
+	}
+}
+
+func TestRotateAppendOnlyHandled(t *testing.T) {
+	dir := t.TempDir()
+	path := appendOnlyFile(t, dir)
+	cfg := makeTestCfg(t, dir)
+	cfg.AppendOnly = true
+
+	if res := rotateLogFile(path, cfg); res.Status != statusRotated {
+		t.Fatalf("status = %s (err=%v)", res.Status, res.Err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("source not truncated: %d bytes", info.Size())
+	}
+	if flags, err := fileAttrFlags(path); err != nil || flags != fsAppendFl {
+		t.Errorf("flags after rotation = %#x, %v; want append-only restored", flags, err)
+	}
+}
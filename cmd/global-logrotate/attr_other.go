@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Inode attribute flags are Linux-only; elsewhere no file ever carries them.
+
+func fileAttrFlags(path string) (uint32, error) { return 0, nil }
+
+func setFileAttrFlags(path string, flags uint32) error {
+	return errors.New("inode attribute flags are not supported on this platform")
+}
+
+func describeAttrs(flags uint32) string { return "" }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ============================================================
+// Tar bundles (--read <bundle.tar> --member <name>)
+// ============================================================
+
+// isBundle reports whether path names a tar bundle of rotated archives.
+func isBundle(path string) bool {
+	return strings.HasSuffix(path, ".tar")
+}
+
+// streamBundleMember finds member in the tar bundle at path and streams its
+// decrypted, decompressed content to w. Members are matched by their full name
+// in the bundle, or by base name when that is unambiguous.
+func streamBundleMember(w io.Writer, path, member string, cfg *Config) error {
+	name, err := resolveBundleMember(path, member)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s: no member %q", path, member)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if hdr.Name == name {
+			return streamArchive(w, hdr.Name, tr, cfg)
+		}
+	}
+}
+
+// resolveBundleMember maps member to the name of exactly one regular entry in
+// the bundle at path.
+func resolveBundleMember(path, member string) (string, error) {
+	hdrs, err := bundleMembers(path)
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, h := range hdrs {
+		if h.Name == member {
+			return h.Name, nil
+		}
+		if h.Name[strings.LastIndex(h.Name, "/")+1:] == member {
+			matches = append(matches, h.Name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%s: no member %q (use --list-members)", path, member)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s: %q is ambiguous: %s", path, member, strings.Join(matches, ", "))
+	}
+}
+
+// bundleMembers returns the headers of the regular files in the bundle at path.
+func bundleMembers(path string) ([]*tar.Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hdrs []*tar.Header
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return hdrs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdrs = append(hdrs, hdr)
+		}
+	}
+}
+
+// listBundleMembers prints each member's size, modification time and name.
+func listBundleMembers(w io.Writer, path string) error {
+	hdrs, err := bundleMembers(path)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, h := range hdrs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", formatSize(h.Size), h.ModTime.Format("2006-01-02 15:04:05"), h.Name)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestBundle(t *testing.T, members map[string][]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for name, data := range members {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	tw.Close()
+	f.Close()
+	return path
+}
+
+func TestStreamBundleMember(t *testing.T) {
+	content := []byte(strings.Repeat("bundled line\n", 200))
+	gz, _ := compressGzip(bytes.NewReader(content))
+	enc, _ := encryptData(gz, "bundle-pw", nil)
+	path := writeTestBundle(t, map[string][]byte{
+		"20240115/app.log.20240115.gz.enc": enc,
+		"20240115/db.log.20240115.gz":      gz,
+		"20240116/db.log.20240115.gz":      gz,
+	})
+	cfg := &Config{EncryptPassword: "bundle-pw"}
+
+	for _, member := range []string{"app.log.20240115.gz.enc", "20240116/db.log.20240115.gz"} {
+		cfg.Member = member
+		var out bytes.Buffer
+		if err := streamLogFile(&out, path, cfg); err != nil {
+			t.Fatalf("%s: %v", member, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("%s: content mismatch", member)
+		}
+	}
+
+	cfg.Member = "db.log.20240115.gz"
+	if err := streamLogFile(&bytes.Buffer{}, path, cfg); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous base name: err = %v", err)
+	}
+	cfg.Member = "missing.gz"
+	if err := streamLogFile(&bytes.Buffer{}, path, cfg); err == nil {
+		t.Error("missing member should fail")
+	}
+}
+
+func TestListBundleMembers(t *testing.T) {
+	path := writeTestBundle(t, map[string][]byte{"a.log.20240115.gz": []byte("xx")})
+	var out bytes.Buffer
+	if err := listBundleMembers(&out, path); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "a.log.20240115.gz") || !strings.HasPrefix(out.String(), "2 B") {
+		t.Errorf("listing = %q", out.String())
+	}
+	if !isBundle(path) || isBundle("a.log.gz") {
+		t.Error("isBundle")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ============================================================
+// Checkpoints for resumable bulk operations (--resume)
+// ============================================================
+
+const defaultCheckpointDir = "/var/lib/global-sys-utils/checkpoints"
+
+// checkpoint is a journal of the items a bulk operation has finished, one
+// quoted item per line. Each line goes out in a single write followed by an
+// fsync, so a crash loses at most the line being written, and a torn last
+// line is dropped on load. Methods are safe for concurrent use and do nothing
+// on a nil checkpoint.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	done map[string]bool
+}
+
+// checkpointPath names the checkpoint of operation op over target, e.g. a
+// directory read of /var/log/apps/old_logs.
+func checkpointPath(dir, op, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(dir, op+"-"+hex.EncodeToString(sum[:8])+".ckpt")
+}
+
+// openCheckpoint opens the checkpoint of op over target in dir. With resume,
+// items recorded by an earlier, interrupted run count as done; without it any
+// such checkpoint is discarded and the operation starts over.
+func openCheckpoint(dir, op, target string, resume bool) (*checkpoint, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no CHECKPOINT_DIR configured")
+	}
+	c := &checkpoint{path: checkpointPath(dir, op, target), done: make(map[string]bool)}
+	if resume {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	} else if err := os.Remove(c.path); err == nil {
+		logInfo("Discarded the checkpoint of an earlier %s of %s (use --resume to continue it)", op, target)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	c.f = f
+	return c, nil
+}
+
+// load reads the journal, cutting off a torn last line so appends stay aligned.
+func (c *checkpoint) load() error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	valid := bytes.LastIndexByte(data, '\n') + 1
+	sc := bufio.NewScanner(bytes.NewReader(data[:valid]))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		item, err := strconv.Unquote(sc.Text())
+		if err != nil {
+			return fmt.Errorf("checkpoint %s: bad entry %q", c.path, sc.Text())
+		}
+		c.done[item] = true
+	}
+	if valid < len(data) {
+		return os.Truncate(c.path, int64(valid))
+	}
+	return nil
+}
+
+// isDone reports whether item was finished by this run or a resumed one.
+func (c *checkpoint) isDone(item string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[item]
+}
+
+// count returns how many items are recorded as done.
+func (c *checkpoint) count() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// markDone records item as finished and syncs the journal.
+func (c *checkpoint) markDone(item string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.WriteString(strconv.Quote(item) + "\n"); err != nil {
+		return err
+	}
+	c.done[item] = true
+	return c.f.Sync()
+}
+
+// finish closes and removes the checkpoint once the whole operation succeeded.
+func (c *checkpoint) finish() {
+	if c == nil {
+		return
+	}
+	c.f.Close()
+	if err := os.Remove(c.path); err != nil {
+		logError("Could not remove checkpoint %s: %v", c.path, err)
+	}
+}
+
+// keep closes the checkpoint, leaving it for a later --resume.
+func (c *checkpoint) keep() {
+	if c == nil {
+		return
+	}
+	c.f.Close()
+	logInfo("Checkpoint kept at %s; rerun with --resume to continue", c.path)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	c, err := openCheckpoint(dir, "read", "/data/old_logs", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.markDone("/data/old_logs/a.gz")
+	c.markDone("/data/old_logs/odd\nname.gz")
+	c.keep()
+
+	// A crash mid-write leaves a torn last line behind.
+	f, _ := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`"/data/old_logs/c.g`)
+	f.Close()
+
+	c, err = openCheckpoint(dir, "read", "/data/old_logs", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.isDone("/data/old_logs/a.gz") || !c.isDone("/data/old_logs/odd\nname.gz") || c.isDone("/data/old_logs/c.gz") || c.count() != 2 {
+		t.Errorf("resumed done set = %v", c.done)
+	}
+	c.markDone("/data/old_logs/c.gz")
+	c.finish()
+	if _, err := os.Stat(c.path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed after finish: %v", err)
+	}
+
+	// Without --resume an old checkpoint is discarded.
+	c, _ = openCheckpoint(dir, "read", "/data/old_logs", false)
+	c.markDone("/data/old_logs/a.gz")
+	c.keep()
+	c, _ = openCheckpoint(dir, "read", "/data/old_logs", false)
+	if c.count() != 0 {
+		t.Errorf("fresh run saw %d done items", c.count())
+	}
+	c.finish()
+}
+
+func TestStreamLogDirResume(t *testing.T) {
+	dir := t.TempDir()
+	gz := func(s string) []byte {
+		out, err := compressGzip(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	os.WriteFile(filepath.Join(dir, "app.log.20240115.gz"), gz("one\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.log.20240116.gz"), []byte("not gzip"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.log.20240117.gz"), gz("three\n"), 0644)
+	cfg := &Config{CheckpointDir: t.TempDir()}
+
+	var buf bytes.Buffer
+	if err := streamLogFile(&buf, dir, cfg); err == nil {
+		t.Fatal("expected an error for the corrupt archive")
+	}
+	if buf.String() != "one\nthree\n" {
+		t.Fatalf("first pass = %q", buf.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "app.log.20240116.gz"), gz("two\n"), 0644)
+	cfg.Resume = true
+	buf.Reset()
+	if err := streamLogFile(&buf, dir, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "two\n" {
+		t.Errorf("resumed pass = %q, want only the archive that failed", buf.String())
+	}
+	if left, _ := filepath.Glob(filepath.Join(cfg.CheckpointDir, "*.ckpt")); len(left) != 0 {
+		t.Errorf("checkpoint left after a complete read: %v", left)
+	}
+}
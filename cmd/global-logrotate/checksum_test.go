@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateChecksumSidecar(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.Checksum = true
+
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte(strings.Repeat("2024-01-15 INFO summed\n", 50)), 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated {
+		t.Fatalf("status %s: %v", res.Status, res.Err)
+	}
+	line, err := os.ReadFile(checksumSidecar(res.Archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(res.Archive)
+	if want := hexSum(data) + "  app.log.20240115.gz\n"; string(line) != want {
+		t.Errorf("sidecar %q, want %q", line, want)
+	}
+	if _, err := verifyChecksum(res.Archive); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if sha, err := exec.LookPath("sha256sum"); err == nil {
+		cmd := exec.Command(sha, "-c", filepath.Base(checksumSidecar(res.Archive)))
+		cmd.Dir = filepath.Dir(res.Archive)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("sha256sum -c: %v: %s", err, out)
+		}
+	}
+
+	// Bitrot: one flipped byte is reported, with a non-zero exit.
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(res.Archive, data, 0644)
+	var code int
+	out := captureStdout(t, func() { code = runVerify([]string{res.Archive}, &Config{}) })
+	if code != 1 || !strings.Contains(out, "FAILED: checksum mismatch") {
+		t.Errorf("corrupt archive: exit %d, output %q", code, out)
+	}
+}
+
+func TestVerifyChecksumSplitAndMissing(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.Checksum = true
+	cfg.SplitSizeMB = 1
+
+	logPath := filepath.Join(dir, "big.log")
+	os.WriteFile(logPath, randomBytes(t, 2<<20), 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if !splitPartsExist(res.Archive) {
+		t.Fatal("archive was not split")
+	}
+	var code int
+	out := captureStdout(t, func() { code = runVerify([]string{partPath(res.Archive, 2)}, &Config{}) })
+	if code != 0 || !strings.Contains(out, res.Archive+": OK") {
+		t.Errorf("split archive: exit %d, output %q", code, out)
+	}
+
+	os.Remove(checksumSidecar(res.Archive))
+	if _, err := verifyChecksum(res.Archive); err == nil {
+		t.Error("an archive without a sidecar must not verify")
+	}
+}
+
+func hexSum(data []byte) string {
+	return hex.EncodeToString(sha256Sum(data))
+}
+
+func TestVerifyEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	gz, _ := compressGzip(strings.NewReader(strings.Repeat("2024-01-15 INFO sealed\n", 1000)))
+	write := func(name string, payload []byte) string {
+		sealed, err := encryptData(payload, "verify-pw", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, sealed, 0600)
+		return path
+	}
+	good := write("app.log.20240115.gz.enc", gz)
+	truncated := write("app.log.20240116.gz.enc", gz[:len(gz)/2])
+	flipped := write("app.log.20240117.gz.enc", gz)
+	data, _ := os.ReadFile(flipped)
+	data[len(data)-20] ^= 1
+	os.WriteFile(flipped, data, 0600)
+
+	cfg := &Config{EncryptPassword: "verify-pw"}
+	for _, tt := range []struct {
+		path, password, want string
+		code                 int
+	}{
+		{good, "verify-pw", good + ": OK (authenticated, stream decodes)", 0},
+		{good, "wrong", "FAILED: unreadable: the configured key does not open it", 1},
+		{flipped, "verify-pw", "FAILED: corrupt: payload authentication failed", 1},
+		{truncated, "verify-pw", "FAILED: corrupt: unexpected EOF", 1},
+	} {
+		cfg.EncryptPassword = tt.password
+		var code int
+		out := captureStdout(t, func() { code = runVerify([]string{tt.path}, cfg) })
+		if code != tt.code || !strings.Contains(out, tt.want) {
+			t.Errorf("verify %s with %q: exit %d, output %q; want %d and %q", filepath.Base(tt.path), tt.password, code, out, tt.code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ============================================================
+// Chunked archives (archive formats 3 to 5)
+// ============================================================
+//
+// Format 2 seals the whole payload under one GCM tag, so an archive can only
+// be written or read with all of it in memory. Formats 3 to 5 have the key
+// header of format 2 but seal the payload in chunks, so a rotation compresses
+// and encrypts straight to disk and a read streams. Formats 4 and 5 end with
+// the SHA-256 of the log as it was before compression, so a read can prove
+// the archive decompressed to exactly the original bytes. Format 5, which we
+// write now, also records the KDF the key was wrapped with (see kdf.go):
+//
+//	MAGIC(4) | "GLRKEY3\0"(8) | SALT(32) | WRAP_NONCE(12) | WRAPPED_KEY(48) | NONCE(12) | CHUNK...
+//	MAGIC(4) | "GLRKEY4\0"(8) | SALT(32) | WRAP_NONCE(12) | WRAPPED_KEY(48) | NONCE(12) | CHUNK... | LOG_SHA256(32)
+//	MAGIC(4) | "GLRKEY5\0"(8) | KDF(12) | SALT(32) | WRAP_NONCE(12) | WRAPPED_KEY(48) | NONCE(12) | CHUNK... | LOG_SHA256(32)
+//
+// Each CHUNK is up to chunkSize bytes of payload sealed with the data key,
+// plus its 16-byte tag. Every chunk but the last is full; the last is shorter,
+// possibly empty. A chunk's nonce is NONCE with the chunk's index XORed into
+// bytes 7 to 10 and, for the last chunk only, 1 into byte 11, so chunks can't
+// be reordered or dropped and an archive cut off at a chunk boundary doesn't
+// open. Chunks are bound to MAGIC and the marker, and in formats 4 and 5 the
+// last one to LOG_SHA256 too, which comes at the end because it is only known once the
+// whole log has been read. All zeroes there means no checksum was recorded.
+// The wrapped key is bound to the KDF block as well. A re-key rewrites the
+// key header as in format 2. Format 6 (see pubkey.go) wraps the data key with
+// a public key instead and is otherwise format 5.
+
+// Markers following the magic in format 3, 4 and 5 archives. Format 6 has
+// publicKeyMarker.
+const (
+	chunkedMarker  = "GLRKEY3\x00"
+	checksumMarker = "GLRKEY4\x00"
+	kdfMarker      = "GLRKEY5\x00"
+)
+
+// chunkSize is how much payload one chunk seals.
+const chunkSize = 64 << 10
+
+// chunkedMarkerOf returns the marker of a format 3 to 6 archive, or "" for
+// anything else.
+func chunkedMarkerOf(data []byte) string {
+	if isPublicKeyArchive(data) {
+		return publicKeyMarker
+	}
+	switch marker := envelopeMarkerOf(data); marker {
+	case chunkedMarker, checksumMarker, kdfMarker:
+		return marker
+	}
+	return ""
+}
+
+// isChunked reports whether data is a format 3 to 6 archive.
+func isChunked(data []byte) bool {
+	return chunkedMarkerOf(data) != ""
+}
+
+// chunkedHeaderLen is the length of the header data starts with, up to and
+// including NONCE.
+func chunkedHeaderLen(data []byte) int {
+	if isPublicKeyArchive(data) {
+		return publicHeaderLen(data) + nonceSize
+	}
+	return keyHeaderLen(envelopeMarkerOf(data)) + nonceSize
+}
+
+// chunkedTrailerLen is how many bytes follow the last chunk of an archive
+// with marker.
+func chunkedTrailerLen(marker string) int {
+	switch marker {
+	case checksumMarker, kdfMarker, publicKeyMarker:
+		return sha256.Size
+	}
+	return 0
+}
+
+// chunkedSize is the size of an archive of n payload bytes as encryptData
+// writes it: format 6 with ENCRYPT_PUBLIC_KEY, otherwise format 5.
+func chunkedSize(n int64) int64 {
+	header := keyHeaderLen(kdfMarker)
+	if archivePublicKey != nil {
+		header = publicHeaderBase + archivePublicKey.Size()
+	}
+	return int64(header+nonceSize) + n + (n/chunkSize+1)*16 + sha256.Size
+}
+
+// chunkNonce is the nonce of chunk i of an archive with NONCE base.
+func chunkNonce(base []byte, i uint32, last bool) []byte {
+	nonce := bytes.Clone(base)
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], i)
+	for j, b := range index {
+		nonce[7+j] ^= b
+	}
+	if last {
+		nonce[11] ^= 1
+	}
+	return nonce
+}
+
+// encryptWriter seals what is written to it as a format 5 or 6 (or 3 or 4)
+// archive.
+// The archive is only complete once finish has written the last chunk.
+type encryptWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	nonce  []byte
+	marker string
+	index  uint32
+	buf    []byte // payload not sealed yet, less than a chunk
+	sealed []byte
+}
+
+// newEncryptWriter writes the header of a new archive to w: format 6 sealed
+// to ENCRYPT_PUBLIC_KEY when that is set, otherwise format 5 keyed with
+// password through encryptKDF. An empty password is refused: it would seal an
+// archive anyone can open.
+func newEncryptWriter(w io.Writer, password string) (*encryptWriter, error) {
+	if archivePublicKey != nil {
+		return newPublicWriter(w, archivePublicKey)
+	}
+	return newChunkWriter(w, password, kdfMarker)
+}
+
+// newChunkWriter is newEncryptWriter for the format marker names.
+func newChunkWriter(w io.Writer, password, marker string) (*encryptWriter, error) {
+	if password == "" {
+		return nil, fmt.Errorf("no password to encrypt with")
+	}
+	dataKey, err := cryptoRandom(keySize)
+	if err != nil {
+		return nil, err
+	}
+	header, err := wrapKey(dataKey, password, envelopeAAD(marker), encryptKDF)
+	if err != nil {
+		return nil, err
+	}
+	return startChunks(w, marker, header, dataKey)
+}
+
+// startChunks writes header, the key header of an archive with marker, and a
+// fresh NONCE to w and returns the writer for chunks sealed with dataKey.
+func startChunks(w io.Writer, marker string, header, dataKey []byte) (*encryptWriter, error) {
+	nonce, err := cryptoRandom(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, nonce...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		gcm:    gcm,
+		nonce:  nonce,
+		marker: marker,
+		buf:    make([]byte, 0, chunkSize),
+		sealed: make([]byte, 0, chunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:k]...)
+		p = p[k:]
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false, envelopeAAD(e.marker)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// seal writes the buffered payload as the next chunk, bound to aad.
+func (e *encryptWriter) seal(last bool, aad []byte) error {
+	if e.index == ^uint32(0) {
+		return fmt.Errorf("payload too large for one archive")
+	}
+	e.sealed = e.gcm.Seal(e.sealed[:0], chunkNonce(e.nonce, e.index, last), e.buf, aad)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// finish writes what is left as the last chunk and, in formats 4 and 5, ends
+// the archive with logSum, the SHA-256 of the log the payload was compressed
+// from, or zeroes when that is nil.
+func (e *encryptWriter) finish(logSum []byte) error {
+	if chunkedTrailerLen(e.marker) == 0 {
+		return e.seal(true, envelopeAAD(e.marker))
+	}
+	sumField := make([]byte, sha256.Size)
+	if logSum != nil {
+		if len(logSum) != sha256.Size {
+			return fmt.Errorf("log checksum is %d bytes, want %d", len(logSum), sha256.Size)
+		}
+		copy(sumField, logSum)
+	}
+	if err := e.seal(true, append(envelopeAAD(e.marker), sumField...)); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sumField)
+	return err
+}
+
+// sealChunked encrypts payload, already in memory, as newEncryptWriter does,
+// recording logSum (see finish).
+func sealChunked(payload []byte, password string, logSum []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(int(chunkedSize(int64(len(payload)))))
+	e, err := newEncryptWriter(&buf, password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := e.finish(logSum); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// errChunkedTruncated is returned for an archive that ends inside its header
+// or before its last chunk.
+var errChunkedTruncated = errors.New("encrypted data too short: the archive is cut off")
+
+// readChunkedHeader reads the header of a format 3 to 6 archive from r:
+// MAGIC and the marker first, which say how long the rest is, and in format 6
+// the key's WRAPPED_LEN next.
+func readChunkedHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(encryptMagic)+len(envelopeMarker))
+	if err := readHeaderPart(r, header); err != nil {
+		return nil, err
+	}
+	if !hasArchiveMagic(header) {
+		return nil, fmt.Errorf("not a %s archive: magic %q, expected %q", archiveBrand, header[:len(encryptMagic)], encryptMagic)
+	}
+	if !isChunked(header) {
+		return nil, fmt.Errorf("not a chunked (format 3 to 6) archive")
+	}
+	if isPublicKeyArchive(header) {
+		n := len(header)
+		header = append(header, make([]byte, publicHeaderBase-n)...)
+		if err := readHeaderPart(r, header[n:]); err != nil {
+			return nil, err
+		}
+	}
+	n := len(header)
+	header = append(header, make([]byte, chunkedHeaderLen(header)-n)...)
+	if err := readHeaderPart(r, header[n:]); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// readHeaderPart fills b from r, where running out means a cut-off archive.
+func readHeaderPart(r io.Reader, b []byte) error {
+	if _, err := io.ReadFull(r, b); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errChunkedTruncated
+		}
+		return err
+	}
+	return nil
+}
+
+// decryptReader reads the payload of a format 3 to 6 archive, a chunk at a
+// time: only what a chunk's tag has authenticated is returned. An archive
+// that was cut short or altered fails at the chunk where that shows, which may
+// be the last one, after the rest was read.
+type decryptReader struct {
+	r       io.Reader
+	gcm     cipher.AEAD
+	nonce   []byte
+	aad     []byte // MAGIC and the marker, as the archive has them
+	trailer int    // length of what follows the last chunk
+	index   uint32
+	in      []byte // read ahead: a chunk, the trailer and the first byte after them
+	plain   []byte
+	out     []byte // opened payload not read yet
+	sum     []byte // the trailer, once the last chunk is open
+	done    bool   // the last chunk is open
+	err     error
+}
+
+// newDecryptReader reads the header of a format 3 to 6 archive from r and
+// unwraps its data key with password, or in format 6 with archivePrivateKey.
+func newDecryptReader(r io.Reader, password string) (*decryptReader, error) {
+	header, err := readChunkedHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var dataKey []byte
+	if isPublicKeyArchive(header) {
+		dataKey, err = unwrapPublicKey(header)
+	} else {
+		dataKey, err = unwrapKey(header, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	trailer := chunkedTrailerLen(chunkedMarkerOf(header))
+	return &decryptReader{
+		r:       r,
+		gcm:     gcm,
+		nonce:   header[len(header)-nonceSize:],
+		aad:     headerAAD(header),
+		trailer: trailer,
+		in:      make([]byte, 0, chunkSize+gcm.Overhead()+trailer+1),
+		plain:   make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// next opens the next chunk into out, or returns io.EOF after the last one.
+// A chunk is the last when less than a full chunk and the trailer follow it.
+func (d *decryptReader) next() error {
+	if d.done {
+		return io.EOF
+	}
+	n, err := io.ReadFull(d.r, d.in[len(d.in):cap(d.in)])
+	d.in = d.in[:len(d.in)+n]
+	if err == nil {
+		full := chunkSize + d.gcm.Overhead()
+		if d.out, err = d.gcm.Open(d.plain[:0], chunkNonce(d.nonce, d.index, false), d.in[:full], d.aad); err != nil {
+			return fmt.Errorf("decryption failed (corrupted payload in chunk %d): %w", d.index, err)
+		}
+		d.index++
+		d.in = append(d.in[:0], d.in[full:]...)
+		return nil
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if len(d.in) < d.gcm.Overhead()+d.trailer {
+		return errChunkedTruncated
+	}
+	end := len(d.in) - d.trailer
+	aad := append(bytes.Clone(d.aad), d.in[end:]...)
+	if d.out, err = d.gcm.Open(d.plain[:0], chunkNonce(d.nonce, d.index, true), d.in[:end], aad); err != nil {
+		return fmt.Errorf("decryption failed (corrupted or truncated payload): %w", err)
+	}
+	d.sum = bytes.Clone(d.in[end:])
+	d.done = true
+	return nil
+}
+
+// logSum returns the log checksum a format 4 to 6 archive recorded, once the
+// whole payload has been read, or nil when it recorded none.
+func (d *decryptReader) logSum() []byte {
+	if len(d.sum) != sha256.Size || bytes.Equal(d.sum, make([]byte, sha256.Size)) {
+		return nil
+	}
+	return d.sum
+}
+
+// archiveLogSum returns the log checksum a format 4 to 6 archive held in
+// memory recorded, or nil for none. It is only trustworthy once decryptData
+// succeeded.
+func archiveLogSum(data []byte) []byte {
+	if chunkedTrailerLen(chunkedMarkerOf(data)) == 0 || len(data) < chunkedHeaderLen(data)+16+sha256.Size {
+		return nil
+	}
+	sum := data[len(data)-sha256.Size:]
+	if bytes.Equal(sum, make([]byte, sha256.Size)) {
+		return nil
+	}
+	return sum
+}
+
+// openChunked decrypts a format 3 to 6 archive held in memory.
+func openChunked(data []byte, password string) ([]byte, error) {
+	if len(data) < chunkedHeaderLen(data)+16+chunkedTrailerLen(chunkedMarkerOf(data)) {
+		return nil, fmt.Errorf("encrypted data too short (%d bytes)", len(data))
+	}
+	d, err := newDecryptReader(bytes.NewReader(data), password)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(d)
+}
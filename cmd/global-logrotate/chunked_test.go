@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+func TestChunkedBoundaries(t *testing.T) {
+	for _, n := range []int{0, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		data := randomBytes(t, n)
+		sealed, err := encryptData(data, "pw", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := chunkedSize(int64(n)); int64(len(sealed)) != want {
+			t.Errorf("%d bytes sealed to %d, want %d", n, len(sealed), want)
+		}
+
+		// Read as a stream, a few bytes at a time.
+		d, err := newDecryptReader(bytes.NewReader(sealed), "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if _, err := io.CopyBuffer(&got, struct{ io.Reader }{d}, make([]byte, 1000)); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got.Bytes(), data) {
+			t.Errorf("%d bytes: stream changed the data", n)
+		}
+	}
+}
+
+func TestChunkedRejectsTampering(t *testing.T) {
+	data := randomBytes(t, 3*chunkSize+100)
+	sealed, err := encryptData(data, "pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := chunkedHeaderLen(sealed)
+	full := chunkSize + 16
+	chunk := func(i int) []byte { return sealed[header+i*full : header+(i+1)*full] }
+
+	cases := map[string][]byte{
+		"cut at a chunk":       sealed[:header+2*full],
+		"last chunk dropped":   sealed[:header+3*full],
+		"last chunk cut":       sealed[:len(sealed)-sha256.Size-1],
+		"checksum dropped":     sealed[:len(sealed)-sha256.Size],
+		"chunks swapped":       append(append(append(bytes.Clone(sealed[:header]), chunk(1)...), chunk(0)...), sealed[header+2*full:]...),
+		"chunk altered":        func() []byte { b := bytes.Clone(sealed); b[header+full+5] ^= 1; return b }(),
+		"header nonce altered": func() []byte { b := bytes.Clone(sealed); b[header-1] ^= 1; return b }(),
+	}
+	for name, bad := range cases {
+		if _, err := decryptData(bad, "pw"); err == nil {
+			t.Errorf("%s: decrypted", name)
+		}
+	}
+	if _, err := decryptData(sealed, "wrong"); err == nil {
+		t.Error("wrong password decrypted")
+	}
+}
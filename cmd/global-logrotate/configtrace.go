@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// ============================================================
+// Config provenance (--trace-config)
+// ============================================================
+
+// configTrace, while non-nil, records which file set each key and every key
+// buildConfig resolves. parseFlags enables it around its own config load only.
+var configTrace *configTracer
+
+// traceEntry is one resolved config value and where it came from: "default", a
+// config file path, "env <NAME>" or "flag --<name>".
+type traceEntry struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type configTracer struct {
+	files   map[string]string // key -> last config file that set it
+	order   []string
+	entries map[string]*traceEntry
+}
+
+func newConfigTracer() *configTracer {
+	return &configTracer{files: make(map[string]string), entries: make(map[string]*traceEntry)}
+}
+
+// noteFile records that path set every key in keys, overriding earlier files.
+func (t *configTracer) noteFile(path string, keys map[string]string) {
+	if t == nil {
+		return
+	}
+	for k := range keys {
+		t.files[k] = path
+	}
+}
+
+// resolve records the value buildConfig used for key: the file value when one
+// was set, else defaultVal.
+func (t *configTracer) resolve(key, value string, fromFile bool) {
+	if t == nil {
+		return
+	}
+	source := "default"
+	if fromFile {
+		source = t.files[key]
+	}
+	t.set(key, value, source)
+}
+
+func (t *configTracer) set(key, value, source string) {
+	if e, ok := t.entries[key]; ok {
+		e.Value, e.Source = value, source
+		return
+	}
+	t.order = append(t.order, key)
+	t.entries[key] = &traceEntry{Key: key, Value: value, Source: source}
+}
+
+// flagConfigKeys maps command-line flags to the config key they override.
+var flagConfigKeys = map[string]string{
+	"pattern":             "PATTERN",
+	"regex":               "PATTERN_REGEX",
+	"p":                   "LOG_DIR",
+	"n":                   "DRY_RUN",
+	"fsync":               "FSYNC",
+	"no-fsync":            "FSYNC",
+	"checksum":            "CHECKSUM",
+	"estimate-sample":     "ESTIMATE_SAMPLE_MB",
+	"split-size":          "SPLIT_SIZE_MB",
+	"report-dir":          "REPORT_DIR",
+	"metrics-file":        "METRICS_FILE",
+	"lock-file":           "LOCK_FILE",
+	"signal-pidfile":      "SIGNAL_PIDFILE",
+	"signal":              "SIGNAL",
+	"post-rotate":         "POST_ROTATE",
+	"post-rotate-mode":    "POST_ROTATE_MODE",
+	"upload":              "UPLOAD",
+	"upload-region":       "UPLOAD_REGION",
+	"upload-profile":      "UPLOAD_PROFILE",
+	"upload-delete-local": "UPLOAD_DELETE_LOCAL",
+	"o":                   "OLD_LOGS_DIR",
+	"exclude-from":        "EXCLUDE_FILE",
+	"parallel":            "PARALLEL_JOBS",
+	"threads-for-io":      "IO_THREADS",
+	"threads-for-cpu":     "CPU_THREADS",
+	"compress":            "COMPRESS",
+	"compress-level":      "COMPRESS_LEVEL",
+	"retention-days":      "RETENTION_DAYS",
+	"max-archives":        "MAX_ARCHIVES",
+	"max-total-size":      "MAX_TOTAL_SIZE",
+	"min-size":            "MIN_SIZE",
+	"min-age":             "MIN_AGE",
+	"max-depth":           "MAX_DEPTH",
+	"rate-limit":          "RATE_LIMIT",
+	"order":               "ORDER",
+	"fs-usage-threshold":  "FS_USAGE_THRESHOLD",
+	"encrypt":             "ENCRYPT",
+	"keyfile":             "ENCRYPT_KEYFILE",
+	"log-file":            "LOG_FILE",
+	"log-level":           "LOG_LEVEL",
+	"plain":               "PLAIN_OUTPUT",
+	"H":                   "DATE_FORMAT",
+	"D":                   "DATE_FORMAT",
+	"date-pattern":        "DATE_PATTERN",
+	"utc":                 "USE_UTC",
+}
+
+// applyFlags overrides entries for every flag set on the command line and for
+// the LOGROTATE_PASSWORD environment variable, which beats the config files.
+func (t *configTracer) applyFlags(fs *flag.FlagSet) {
+	if os.Getenv("LOGROTATE_PASSWORD") != "" {
+		t.set("ENCRYPT_PASSWORD", "(set)", "env LOGROTATE_PASSWORD")
+	}
+	fs.Visit(func(f *flag.Flag) {
+		key, ok := flagConfigKeys[f.Name]
+		if !ok {
+			return
+		}
+		value := f.Value.String()
+		switch f.Name {
+		case "H":
+			value = "full"
+		case "D":
+			value = "date"
+		case "no-fsync":
+			value = "false"
+		}
+		t.set(key, value, "flag "+flagSpelling(f.Name))
+	})
+}
+
+// flagSpelling renders a flag name the way it is documented: -p, --parallel.
+func flagSpelling(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// entriesForOutput returns the trace in resolution order with secrets masked.
+func (t *configTracer) entriesForOutput() []traceEntry {
+	out := make([]traceEntry, 0, len(t.order))
+	for _, k := range t.order {
+		e := *t.entries[k]
+		if k == "ENCRYPT_PASSWORD" && e.Value != "" {
+			e.Value = "********"
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// writeConfigTrace prints the trace as an aligned table or, for format "json",
+// a JSON array.
+func writeConfigTrace(w io.Writer, t *configTracer, format string) error {
+	entries := t.entriesForOutput()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+		for _, e := range entries {
+			value := e.Value
+			if value == "" {
+				value = `""`
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Key, value, e.Source)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown --trace-config format %q (want table or json)", format)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func traceBuild(t *testing.T, files map[string]map[string]string, order []string) *configTracer {
+	t.Helper()
+	tr := newConfigTracer()
+	merged := make(map[string]string)
+	for _, path := range order {
+		tr.noteFile(path, files[path])
+		for k, v := range files[path] {
+			merged[k] = v
+		}
+	}
+	configTrace = tr
+	defer func() { configTrace = nil }()
+	buildConfig(merged)
+	return tr
+}
+
+func TestConfigTraceSources(t *testing.T) {
+	tr := traceBuild(t, map[string]map[string]string{
+		"/etc/global-sys-utils/global.conf":               {"ENCRYPT": "false", "PATTERN": "*.txt"},
+		"/etc/global-sys-utils/global.conf.d/secure.conf": {"ENCRYPT": "true", "ENCRYPT_PASSWORD": "hunter2"},
+	}, []string{"/etc/global-sys-utils/global.conf", "/etc/global-sys-utils/global.conf.d/secure.conf"})
+
+	want := map[string]traceEntry{
+		"ENCRYPT":          {"ENCRYPT", "true", "/etc/global-sys-utils/global.conf.d/secure.conf"},
+		"PATTERN":          {"PATTERN", "*.txt", "/etc/global-sys-utils/global.conf"},
+		"LOG_DIR":          {"LOG_DIR", defaultDir, "default"},
+		"ENCRYPT_PASSWORD": {"ENCRYPT_PASSWORD", "********", "/etc/global-sys-utils/global.conf.d/secure.conf"},
+	}
+	got := make(map[string]traceEntry)
+	for _, e := range tr.entriesForOutput() {
+		got[e.Key] = e
+	}
+	for k, w := range want {
+		if got[k] != w {
+			t.Errorf("%s = %+v, want %+v", k, got[k], w)
+		}
+	}
+}
+
+func TestConfigTraceFlagsAndEnv(t *testing.T) {
+	tr := traceBuild(t, map[string]map[string]string{"/etc/g.conf": {"PARALLEL_JOBS": "2"}}, []string{"/etc/g.conf"})
+	t.Setenv("LOGROTATE_PASSWORD", "from-env")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("parallel", 2, "")
+	fs.Bool("H", false, "")
+	fs.String("p", "", "")
+	if err := fs.Parse([]string{"--parallel", "8", "-H"}); err != nil {
+		t.Fatal(err)
+	}
+	tr.applyFlags(fs)
+
+	var buf bytes.Buffer
+	if err := writeConfigTrace(&buf, tr, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var entries []traceEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	got := make(map[string]traceEntry)
+	for _, e := range entries {
+		got[e.Key] = e
+	}
+	if e := got["PARALLEL_JOBS"]; e.Value != "8" || e.Source != "flag --parallel" {
+		t.Errorf("PARALLEL_JOBS = %+v", e)
+	}
+	if e := got["DATE_FORMAT"]; e.Value != "full" || e.Source != "flag -H" {
+		t.Errorf("DATE_FORMAT = %+v", e)
+	}
+	if e := got["ENCRYPT_PASSWORD"]; e.Source != "env LOGROTATE_PASSWORD" || strings.Contains(e.Value, "from-env") {
+		t.Errorf("ENCRYPT_PASSWORD = %+v", e)
+	}
+	if e := got["LOG_DIR"]; e.Source != "default" {
+		t.Errorf("unset -p should stay default, got %+v", e)
+	}
+}
+
+func TestWriteConfigTraceTable(t *testing.T) {
+	tr := traceBuild(t, nil, nil)
+	var buf bytes.Buffer
+	if err := writeConfigTrace(&buf, tr, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasPrefix(lines[0], "KEY") || !strings.Contains(lines[0], "SOURCE") {
+		t.Errorf("missing header: %q", lines[0])
+	}
+	if col := strings.Index(lines[0], "VALUE"); col < 0 || strings.Index(lines[1], "/var/log/apps") != col {
+		t.Errorf("columns not aligned:\n%s", buf.String())
+	}
+	if err := writeConfigTrace(&buf, tr, "yaml"); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
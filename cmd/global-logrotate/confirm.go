@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ============================================================
+// Confirmation for large destructive batches (--yes)
+// ============================================================
+
+// defaultConfirmMin is how many files a destructive batch must touch before we
+// ask first.
+const defaultConfirmMin = 100
+
+// Overridden by tests.
+var (
+	confirmInput io.Reader = os.Stdin
+	stdinIsTTY             = func() bool { return term.IsTerminal(int(os.Stdin.Fd())) }
+)
+
+// confirmBatch decides whether a destructive batch may go ahead. what describes
+// it ("Retention will delete"), n and size its impact. Batches under
+// CONFIRM_THRESHOLD files, dry runs and runs with --yes proceed without asking.
+// Otherwise the user is prompted on a terminal; without one the batch is
+// refused, since nobody is there to say yes.
+func confirmBatch(cfg *Config, what string, n int, size int64) bool {
+	if cfg.DryRun || cfg.AssumeYes || cfg.ConfirmMin <= 0 || n < cfg.ConfirmMin {
+		return true
+	}
+	impact := fmt.Sprintf("%s %d file(s), %s", what, n, formatSize(size))
+	if !stdinIsTTY() {
+		fmt.Fprintf(os.Stderr, "Refusing: %s. Re-run with --yes to confirm.\n", impact)
+		logError("Refused unconfirmed batch (no terminal, no --yes): %s", impact)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s. Continue? [y/N] ", impact)
+	answer, _ := bufio.NewReader(confirmInput).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		logInfo("Confirmed: %s", impact)
+		return true
+	}
+	fmt.Fprintln(os.Stderr, "Aborted.")
+	logInfo("Declined: %s", impact)
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConfirmInput makes confirmBatch see a terminal (or not) that answers answer.
+func fakeConfirmInput(t *testing.T, tty bool, answer string) {
+	t.Helper()
+	oldInput, oldTTY := confirmInput, stdinIsTTY
+	confirmInput = strings.NewReader(answer)
+	stdinIsTTY = func() bool { return tty }
+	t.Cleanup(func() { confirmInput, stdinIsTTY = oldInput, oldTTY })
+}
+
+func TestConfirmBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    Config
+		n      int
+		tty    bool
+		answer string
+		want   bool
+	}{
+		{"below threshold", Config{ConfirmMin: 10}, 9, false, "", true},
+		{"disabled", Config{}, 1000, false, "", true},
+		{"dry run", Config{ConfirmMin: 10, DryRun: true}, 50, false, "", true},
+		{"--yes", Config{ConfirmMin: 10, AssumeYes: true}, 50, false, "", true},
+		{"no terminal", Config{ConfirmMin: 10}, 10, false, "y\n", false},
+		{"answered yes", Config{ConfirmMin: 10}, 10, true, "YES\n", true},
+		{"answered no", Config{ConfirmMin: 10}, 10, true, "n\n", false},
+		{"no answer", Config{ConfirmMin: 10}, 10, true, "", false},
+	} {
+		fakeConfirmInput(t, tc.tty, tc.answer)
+		if got := confirmBatch(&tc.cfg, "Would delete", tc.n, 1<<20); got != tc.want {
+			t.Errorf("%s: confirmBatch = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestApplyRetentionRefusedWithoutConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	var expired []string
+	for i := range 3 {
+		expired = append(expired, writeArchive(t, root, "app.log", time.Now().AddDate(0, 0, -10-i)))
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.RetentionRules = "*:7d"
+	cfg.ConfirmMin = 3
+	fakeConfirmInput(t, false, "")
+	applyRetention(cfg)
+	for _, p := range expired {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("refused batch deleted %s", p)
+		}
+	}
+
+	cfg.AssumeYes = true
+	applyRetention(cfg)
+	for _, p := range expired {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should be deleted with --yes", p)
+		}
+	}
+}
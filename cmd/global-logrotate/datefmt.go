@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Archive dates (DATE_FORMAT, DATE_PATTERN)
+// ============================================================
+
+// Layouts of the built-in date suffixes.
+const (
+	dateLayout     = "20060102"          // -D, DATE_FORMAT=date
+	fullDateLayout = "20060102T15:04:05" // -H, DATE_FORMAT=full
+)
+
+// strftimeLayouts maps strftime conversions to Go layout elements.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'Z': "MST", 'z': "-0700",
+	'F': "2006-01-02", 'T': "15:04:05", 'R': "15:04", 'D': "01/02/06",
+	'%': "%",
+}
+
+// datePatternLayout turns a DATE_PATTERN into a Go time layout. A pattern with
+// a '%' is strftime-style ("%Y%m%d-%H%M"); anything else is a Go layout
+// ("20060102-1504") already. Text between strftime conversions is copied as
+// is, so digits or names that are Go layout elements ("1", "Jan") are
+// formatted all the same.
+func datePatternLayout(pattern string) (string, error) {
+	if !strings.Contains(pattern, "%") {
+		return pattern, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		if i++; i == len(pattern) {
+			return "", fmt.Errorf("%q ends in a lone %%", pattern)
+		}
+		elem, ok := strftimeLayouts[pattern[i]]
+		if !ok {
+			return "", fmt.Errorf("%q: unsupported conversion %%%c", pattern, pattern[i])
+		}
+		b.WriteString(elem)
+	}
+	return b.String(), nil
+}
+
+// formatDatePattern formats t with pattern and checks the result can name
+// archives: it must not be empty or the same whatever the time, and may not
+// hold a '.' (archive names are split on the last one) or a backslash. Slashes
+// are allowed between non-empty parts and make nested directories.
+func formatDatePattern(pattern string, t time.Time) (string, error) {
+	layout, err := datePatternLayout(pattern)
+	if err != nil {
+		return "", err
+	}
+	s := t.Format(layout)
+	switch {
+	case strings.Trim(s, "/ ") == "":
+		return "", fmt.Errorf("%q formats to nothing", pattern)
+	case s == t.Add(400*24*time.Hour+time.Hour+time.Minute+time.Second).Format(layout):
+		return "", fmt.Errorf("%q has no date or time in it", pattern)
+	case strings.ContainsAny(s, ".\\"):
+		return "", fmt.Errorf("%q formats to %q; '.' and '\\' can't be used in archive names", pattern, s)
+	case strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") || strings.Contains(s, "//"):
+		return "", fmt.Errorf("%q formats to %q; slashes must separate directory names", pattern, s)
+	}
+	return s, nil
+}
+
+// useUTC makes the process keep time in UTC rather than the host's zone
+// (USE_UTC). It is one switch for everything: archive dates and directories,
+// retention's reading of them, and log and console timestamps. It applies to
+// the whole process, so in daemon mode it is taken from global.conf.
+func useUTC() {
+	time.Local = time.UTC
+}
+
+// setRunDate stamps cfg for a run starting at now: DateSuffix goes in its
+// archive names, and BackupDate names the directory they are written to.
+// DATE_PATTERN, when set and valid, replaces layout (the -D or -H suffix); if it
+// formats with slashes, such as "2024/01/15", that becomes nested directories
+// and the suffix is the same without them ("20240115").
+func setRunDate(cfg *Config, now time.Time, layout string) {
+	cfg.DateSuffix = now.Format(layout)
+	cfg.BackupDate = now.Format(dateLayout)
+	if cfg.DatePattern == "" {
+		return
+	}
+	s, err := formatDatePattern(cfg.DatePattern, now)
+	if err != nil {
+		return // reported when the run is validated
+	}
+	if strings.Contains(s, "/") {
+		cfg.BackupDate = s
+		s = strings.ReplaceAll(s, "/", "")
+	}
+	cfg.DateSuffix = s
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatDatePattern(t *testing.T) {
+	at := time.Date(2024, 1, 15, 15, 30, 45, 0, time.UTC)
+	tests := []struct {
+		pattern, want, err string
+	}{
+		{"%Y%m%d-%H%M", "20240115-1530", ""},
+		{"%Y/%m/%d", "2024/01/15", ""},
+		{"20060102-1504", "20240115-1530", ""},
+		{"%F_%T", "2024-01-15_15:30:45", ""},
+		{"%j%%", "015%", ""},
+		{"%Y%q", "", "unsupported conversion %q"},
+		{"%Y%", "", "lone %"},
+		{"archive", "", "no date or time"},
+		{"%Y.%m", "", "can't be used"},
+		{"/%Y", "", "slashes"},
+		{"%Y//%m", "", "slashes"},
+	}
+	for _, tt := range tests {
+		got, err := formatDatePattern(tt.pattern, at)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("formatDatePattern(%q) = %q, %v; want an error with %q", tt.pattern, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("formatDatePattern(%q) = %q, %v; want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetRunDate(t *testing.T) {
+	at := time.Date(2024, 1, 15, 15, 30, 45, 0, time.UTC)
+	tests := []struct {
+		pattern, layout, suffix, dir string
+	}{
+		{"", dateLayout, "20240115", "20240115"},
+		{"", fullDateLayout, "20240115T15:30:45", "20240115"},
+		{"%Y%m%d-%H%M", dateLayout, "20240115-1530", "20240115"},
+		{"%Y/%m/%d", dateLayout, "20240115", "2024/01/15"},
+		{"%Y.%m", fullDateLayout, "20240115T15:30:45", "20240115"}, // invalid: the default stands
+	}
+	for _, tt := range tests {
+		cfg := &Config{DatePattern: tt.pattern}
+		setRunDate(cfg, at, tt.layout)
+		if cfg.DateSuffix != tt.suffix || cfg.BackupDate != tt.dir {
+			t.Errorf("DATE_PATTERN %q: suffix %q in %q, want %q in %q", tt.pattern, cfg.DateSuffix, cfg.BackupDate, tt.suffix, tt.dir)
+		}
+	}
+}
+
+func TestUseUTC(t *testing.T) {
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	time.Local = time.FixedZone("UTC+14", 14*3600)
+
+	useUTC()
+	if loc := time.Now().Location(); loc != time.UTC {
+		t.Fatalf("time.Now() in %v after useUTC", loc)
+	}
+	cfg := &Config{}
+	setRunDate(cfg, time.Now(), fullDateLayout)
+	if want := time.Now().UTC().Format(dateLayout); cfg.BackupDate != want {
+		t.Errorf("backup dir %s, want the UTC date %s", cfg.BackupDate, want)
+	}
+	if !strings.HasSuffix(timestamp(), "UTC "+time.Now().UTC().Format("2006")) {
+		t.Errorf("console timestamp %q is not in UTC", timestamp())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================
+// Decrypting to files (--decrypt-dir <src> --out <dst>, --read --out)
+// ============================================================
+
+// plainName returns the name the content of archive is written under: the
+// archive's name without its part, encryption and compression extensions,
+// e.g. "app.log.20240115" for "app.log.20240115.gz.enc".
+func plainName(archive string) string {
+	name, _ := splitArchiveOf(archive)
+	if isEncryptedArchive(name) {
+		name = name[:strings.LastIndex(name, ".")]
+	}
+	if c, ok := codecForPath(name); ok {
+		name = strings.TrimSuffix(name, "."+c.ext)
+	}
+	return name
+}
+
+// decryptArchiveTo writes the decrypted, decompressed content of archive to
+// dst, through a temporary file so a failure leaves nothing half-written. An
+// existing dst is only replaced with --force. dst gets the archive's mode; the
+// content of a directory of archives (--read <dir> --out) is written 0600.
+func decryptArchiveTo(archive, dst string, cfg *Config) error {
+	if _, err := os.Lstat(dst); err == nil && !cfg.Force {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", dst)
+	}
+	perm := os.FileMode(0600)
+	info, err := os.Stat(archive)
+	if err != nil {
+		// Split archives are found by their first part.
+		if info, err = os.Stat(partPath(archive, 1)); err != nil {
+			return fmt.Errorf("file not found: %s", archive)
+		}
+	}
+	if !info.IsDir() {
+		perm = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = streamRead(f, archive, cfg)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// runDecryptDir writes the content of every archive under src to the same
+// relative path under dst, named by plainName, and reports each one. The
+// password is resolved (and prompted for) once, before the first archive. It
+// returns how many archives failed.
+func runDecryptDir(src, dst string, cfg *Config) (int, error) {
+	archives, err := dirArchives(src, readAll)
+	if err != nil {
+		return 0, err
+	}
+	if len(archives) == 0 {
+		return 0, fmt.Errorf("no archives under %s", src)
+	}
+	for _, a := range archives {
+		if archive, _ := splitArchiveOf(a.path); strings.HasSuffix(archive, ".enc") {
+			if cfg.EncryptKeyfile == "" && cfg.EncryptPassword == "" {
+				once := *cfg
+				once.EncryptPassword = getDecryptionPassword(cfg)
+				cfg = &once
+			}
+			break
+		}
+	}
+
+	var written, failed int
+	for _, a := range archives {
+		archive, _ := splitArchiveOf(a.path)
+		rel, err := filepath.Rel(src, archive)
+		if err != nil {
+			rel = filepath.Base(archive)
+		}
+		out := filepath.Join(dst, plainName(rel))
+		if err := decryptArchiveTo(archive, out, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", a.path, err)
+			logError("--decrypt-dir of %s failed: %v", a.path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("OK     %s -> %s\n", a.path, out)
+		logInfo("--decrypt-dir: %s -> %s", a.path, out)
+		written++
+	}
+	fmt.Printf("decrypt-dir: %d archive(s) written to %s, %d failed\n", written, dst, failed)
+	return failed, nil
+}
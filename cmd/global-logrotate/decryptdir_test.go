@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlainName(t *testing.T) {
+	for in, want := range map[string]string{
+		"20240115/app.log.20240115.gz.enc":     "20240115/app.log.20240115",
+		"app.log.20240115.xz.gpg":              "app.log.20240115",
+		"app.log.20240115.gz.enc.part002":      "app.log.20240115",
+		"nginx%2Faccess.log.20240115.1.gz":     "nginx%2Faccess.log.20240115.1",
+		"app.log.20240115T10:00:00.gz.enc.tmp": "app.log.20240115T10:00:00.gz.enc.tmp",
+	} {
+		if got := plainName(in); got != want {
+			t.Errorf("plainName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRunDecryptDir(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	write := func(rel string, data []byte) {
+		path := filepath.Join(src, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gz := func(s string) []byte {
+		out, err := compressGzip(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	enc, err := encryptData(gz("secret-16\n"), "dir-pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("20240116/app.log.20240116.gz.enc", enc)
+	write("20240115/app.log.20240115.gz", gz("plain-15\n"))
+	write("20240117/db.log.20240117.gz.enc", []byte("not really encrypted"))
+	cfg := &Config{EncryptPassword: "dir-pw"}
+
+	var failed int
+	out := captureStdout(t, func() {
+		captureStderr(t, func() { failed, err = runDecryptDir(src, dst, cfg) })
+	})
+	if err != nil || failed != 1 {
+		t.Fatalf("runDecryptDir = %d, %v; want 1 failure", failed, err)
+	}
+	for rel, want := range map[string]string{
+		"20240116/app.log.20240116": "secret-16\n",
+		"20240115/app.log.20240115": "plain-15\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dst, rel))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", rel, got, err, want)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dst, "20240117")); len(entries) != 0 {
+		t.Errorf("failed archive left %v behind", entries)
+	}
+	if !strings.Contains(out, "2 archive(s) written to "+dst+", 1 failed") {
+		t.Errorf("summary missing:\n%s", out)
+	}
+
+	// A second run keeps what the first wrote, unless forced.
+	kept := filepath.Join(dst, "20240115", "app.log.20240115")
+	os.WriteFile(kept, []byte("edited\n"), 0644)
+	captureStdout(t, func() {
+		captureStderr(t, func() { failed, _ = runDecryptDir(src, dst, cfg) })
+	})
+	if got, _ := os.ReadFile(kept); failed != 3 || string(got) != "edited\n" {
+		t.Errorf("second run: %d failed, %s = %q; want 3 and the file kept", failed, kept, got)
+	}
+	cfg.Force = true
+	captureStdout(t, func() {
+		captureStderr(t, func() { runDecryptDir(src, dst, cfg) })
+	})
+	if got, _ := os.ReadFile(kept); string(got) != "plain-15\n" {
+		t.Errorf("--force left %q", got)
+	}
+}
+
+func TestReadLogFileOut(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "app.log.20240115.gz")
+	data, _ := compressGzip(strings.NewReader("line one\nline two\n"))
+	os.WriteFile(archive, data, 0640)
+
+	out := filepath.Join(dir, "plain", "app.log")
+	stdout := captureStdout(t, func() {
+		if err := readLogFile(archive, &Config{Out: out}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stdout != "" {
+		t.Errorf("--out still printed %q", stdout)
+	}
+	got, _ := os.ReadFile(out)
+	info, _ := os.Stat(out)
+	if string(got) != "line one\nline two\n" || info.Mode().Perm() != 0640 {
+		t.Errorf("%s = %q (mode %v), want the log with the archive's mode", out, got, info.Mode().Perm())
+	}
+	if err := readLogFile(archive, &Config{Out: out}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second read over %s: %v", out, err)
+	}
+}
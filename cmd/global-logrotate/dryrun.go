@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ============================================================
+// Dry-run plan (-n)
+// ============================================================
+
+// Kinds of step a dry run reports, in the order its closing line counts them.
+const (
+	planRotate    = "rotate"
+	planOverwrite = "overwrite"
+	planDelete    = "delete"
+	planCommand   = "command"
+	planSignal    = "signal"
+	planUpload    = "upload"
+)
+
+var planLabels = []struct{ kind, label string }{
+	{planRotate, "file(s) to rotate"},
+	{planOverwrite, "existing archive(s) to overwrite"},
+	{planDelete, "archive file(s) to delete"},
+	{planCommand, "command(s) to run"},
+	{planSignal, "signal(s) to send"},
+	{planUpload, "upload(s)"},
+}
+
+// dryRunPlan counts, by kind, the steps the current dry run has reported.
+var dryRunPlan = struct {
+	sync.Mutex
+	steps map[string]int
+}{steps: make(map[string]int)}
+
+// plan reports one step a dry run would take, on stdout and in the log, and
+// counts it under kind. Every rotation, deletion, command, signal and upload a
+// real run would make is reported through here, so the lines read as one plan.
+// (WEBHOOK_URL is still notified, of a run marked dry_run.)
+func plan(kind, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Printf("[DRY-RUN] %s\n", msg)
+	logInfo("[DRY-RUN] %s", msg)
+	notePlanned(kind)
+}
+
+// notePlanned counts a step under kind without printing it.
+func notePlanned(kind string) {
+	dryRunPlan.Lock()
+	dryRunPlan.steps[kind]++
+	dryRunPlan.Unlock()
+}
+
+// printDryRunPlan closes a dry run with the count of each kind of step it
+// reported, and starts the count afresh for the next run.
+func printDryRunPlan() {
+	dryRunPlan.Lock()
+	steps := dryRunPlan.steps
+	dryRunPlan.steps = make(map[string]int)
+	dryRunPlan.Unlock()
+
+	var parts []string
+	for _, l := range planLabels {
+		if n := steps[l.kind]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, l.label))
+		}
+	}
+	summary := "nothing to do"
+	if len(parts) > 0 {
+		summary = strings.Join(parts, ", ")
+	}
+	fmt.Printf("[DRY-RUN] Plan: %s; nothing was changed\n", summary)
+	logInfo("[DRY-RUN] Plan: %s", summary)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRunReportsWholePlan(t *testing.T) {
+	captureStdout(t, printDryRunPlan) // drop what earlier tests counted
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("first\n"), 0644)
+	captureStdout(t, func() { rotateLogFile(logPath, cfg) })
+	archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+	before, _ := os.ReadFile(archive)
+	expired := writeArchive(t, filepath.Join(dir, "old"), "app.log", time.Now().AddDate(0, 0, -90))
+
+	content := []byte("second\n")
+	os.WriteFile(logPath, content, 0644)
+	marker := filepath.Join(dir, "hook-ran")
+	cfg.DryRun = true
+	cfg.Force = true
+	cfg.RetentionDays = 30
+	cfg.PostRotate = "touch " + marker
+	out := captureStdout(t, func() {
+		rotateLogFile(logPath, cfg) // runs the post-rotate hook too
+		applyRetention(cfg)
+		printDryRunPlan()
+	})
+
+	for _, want := range []string{
+		"[DRY-RUN] Would Rotate: " + logPath,
+		"over the existing archive (--force)",
+		"[DRY-RUN] Would run post-rotate command for " + logPath,
+		"): " + expired, // and the 20240115 archive, older than 30 days too
+		"[DRY-RUN] Plan: 1 file(s) to rotate, 1 existing archive(s) to overwrite, 2 archive file(s) to delete, " +
+			"1 command(s) to run; nothing was changed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry-run output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Already rotated, overwriting") {
+		t.Error("dry run announced an overwrite as if it were happening")
+	}
+
+	if got, _ := os.ReadFile(logPath); !bytes.Equal(got, content) {
+		t.Errorf("source = %q after a dry run", got)
+	}
+	if after, _ := os.ReadFile(archive); !bytes.Equal(after, before) {
+		t.Error("dry run overwrote the existing archive")
+	}
+	if _, err := os.Stat(expired); err != nil {
+		t.Error("dry run deleted an expired archive")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("dry run ran the post-rotate command")
+	}
+
+	// The next run counts from nothing.
+	out = captureStdout(t, printDryRunPlan)
+	if !strings.Contains(out, "Plan: nothing to do") {
+		t.Errorf("second plan: %q", out)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// ============================================================
+// Envelope encryption (archive formats 2 to 5)
+// ============================================================
+//
+// A format 2 archive encrypts its payload with a random data key and stores
+// that key, wrapped with the password-derived key, in a fixed-size header:
+//
+//	MAGIC(4) | "GLRKEY2\0"(8) | SALT(32) | WRAP_NONCE(12) | WRAPPED_KEY(48) | NONCE(12) | CIPHERTEXT
+//
+// Changing the password only rewrites the header; the ciphertext, which is
+// bound to MAGIC and the marker but not to the wrapping, stays as it is.
+// Formats 3 to 5 seal the payload in chunks (see chunked.go). Formats 3 and 4
+// have the same key header; format 5 adds a KDF block after the marker, naming
+// the key derivation and its parameters (see kdf.go), so archives stay
+// readable whatever ENCRYPT_KDF is later set to. Format 6 (see pubkey.go)
+// wraps the data key with a public key instead of a password.
+
+// envelopeMarker follows the magic in format 2 archives. Format 1 has a random
+// salt there, which matches one of the markers with probability 2^-62.
+const envelopeMarker = "GLRKEY2\x00"
+
+// wrappedKeySize is a sealed data key: the key plus its GCM tag.
+const wrappedKeySize = keySize + 16
+
+// envelopeHeaderSize is everything before the payload nonce, i.e. the part a
+// re-key rewrites, in formats 2 to 4; format 5 adds the KDF block (see
+// keyHeaderLen). MAGIC is always 4 bytes (see setArchiveMagic).
+const envelopeHeaderSize = 4 + len(envelopeMarker) + saltSize + nonceSize + wrappedKeySize
+
+// maxKeyHeaderLen is the longest key header of any format.
+const maxKeyHeaderLen = envelopeHeaderSize + kdfBlockSize
+
+// envelopeMarkerOf returns the marker of a format 2 to 5 archive, or ""
+// for anything else.
+func envelopeMarkerOf(data []byte) string {
+	m := len(encryptMagic)
+	if len(data) < m+len(envelopeMarker) {
+		return ""
+	}
+	switch marker := string(data[m : m+len(envelopeMarker)]); marker {
+	case envelopeMarker, chunkedMarker, checksumMarker, kdfMarker:
+		return marker
+	}
+	return ""
+}
+
+// isEnvelope reports whether data is a format 2 to 5 archive: one whose
+// data key is wrapped with a password.
+func isEnvelope(data []byte) bool {
+	return envelopeMarkerOf(data) != ""
+}
+
+// keyHeaderLen is the length of the header a re-key rewrites in an archive
+// with marker.
+func keyHeaderLen(marker string) int {
+	if marker == kdfMarker {
+		return envelopeHeaderSize + kdfBlockSize
+	}
+	return envelopeHeaderSize
+}
+
+// envelopeAAD is what both the wrapped key and the payload of a new archive
+// with marker are bound to.
+func envelopeAAD(marker string) []byte {
+	return append(bytes.Clone(encryptMagic), marker...)
+}
+
+// headerAAD is envelopeAAD of an existing archive: its own MAGIC, which may
+// be legacyMagic, and marker.
+func headerAAD(header []byte) []byte {
+	return bytes.Clone(header[:len(encryptMagic)+len(envelopeMarker)])
+}
+
+// keyAAD is what the wrapped key in header is bound to: headerAAD and, in
+// format 5, the KDF block, so its parameters can't be altered.
+func keyAAD(header []byte) []byte {
+	n := len(encryptMagic) + len(envelopeMarker)
+	if envelopeMarkerOf(header) == kdfMarker {
+		n += kdfBlockSize
+	}
+	return bytes.Clone(header[:n])
+}
+
+// headerKDF returns the KDF the key in header was wrapped with.
+func headerKDF(header []byte) (kdfParams, error) {
+	if envelopeMarkerOf(header) != kdfMarker {
+		return legacyKDF, nil
+	}
+	return parseKDF(header[len(encryptMagic)+len(kdfMarker):])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+func cryptoRandom(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("reading random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// wrapKey builds a key header starting with prefix, its MAGIC and marker,
+// holding dataKey sealed under password. The key is derived with kdf when the
+// format records one and with legacyKDF otherwise.
+func wrapKey(dataKey []byte, password string, prefix []byte, kdf kdfParams) ([]byte, error) {
+	salt, err := cryptoRandom(saltSize)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := cryptoRandom(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	marker := envelopeMarkerOf(prefix)
+	header := make([]byte, 0, keyHeaderLen(marker))
+	header = append(header, prefix...)
+	if marker == kdfMarker {
+		header = append(header, encodeKDF(kdf)...)
+	} else {
+		kdf = legacyKDF
+	}
+	aad := bytes.Clone(header)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+	gcm, err := newGCM(deriveKey(password, salt, kdf))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(header, nonce, dataKey, aad), nil
+}
+
+// unwrapKey returns the data key in the format 2 to 5 key header data starts
+// with.
+func unwrapKey(data []byte, password string) ([]byte, error) {
+	n := keyHeaderLen(envelopeMarkerOf(data))
+	if len(data) < n || !isEnvelope(data) {
+		return nil, fmt.Errorf("not a format 2 to 5 header")
+	}
+	kdf, err := headerKDF(data)
+	if err != nil {
+		return nil, err
+	}
+	header := data[:n]
+	aad := keyAAD(header)
+	off := len(aad)
+	salt := header[off : off+saltSize]
+	off += saltSize
+	nonce := header[off : off+nonceSize]
+	off += nonceSize
+	gcm, err := newGCM(deriveKey(password, salt, kdf))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := gcm.Open(nil, nonce, header[off:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	return dataKey, nil
+}
+
+// openEnvelope decrypts a format 2 archive.
+func openEnvelope(data []byte, password string) ([]byte, error) {
+	if len(data) < envelopeHeaderSize+nonceSize+16 {
+		return nil, fmt.Errorf("encrypted data too short (%d bytes)", len(data))
+	}
+	dataKey, err := unwrapKey(data[:envelopeHeaderSize], password)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := data[envelopeHeaderSize : envelopeHeaderSize+nonceSize]
+	plaintext, err := gcm.Open(nil, nonce, data[envelopeHeaderSize+nonceSize:], headerAAD(data))
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (corrupted payload): %w", err)
+	}
+	return plaintext, nil
+}
+
+// rekeyHeader rewraps a key header's data key from oldPassword to
+// newPassword. Format 5 headers are rewritten with the current ENCRYPT_KDF.
+func rekeyHeader(header []byte, oldPassword, newPassword string) ([]byte, error) {
+	dataKey, err := unwrapKey(header, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	return wrapKey(dataKey, newPassword, headerAAD(header), encryptKDF)
+}
+
+// encryptedHeaderLen is how many bytes of data precede an encrypted archive's
+// ciphertext in its format.
+func encryptedHeaderLen(data []byte) int {
+	if isChunked(data) {
+		return chunkedHeaderLen(data)
+	}
+	if isEnvelope(data) {
+		return envelopeHeaderSize + nonceSize
+	}
+	return len(encryptMagic) + saltSize + nonceSize
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sealFormat1 writes the layout earlier releases produced, for migration tests.
+func sealFormat1(t *testing.T, plaintext []byte, password string) []byte {
+	t.Helper()
+	salt := make([]byte, saltSize)
+	nonce := make([]byte, nonceSize)
+	rand.Read(salt)
+	rand.Read(nonce)
+	gcm, err := newGCM(deriveKey(password, salt, legacyKDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append(bytes.Clone(encryptMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil)
+}
+
+// sealFormat2 writes a format 2 archive, as releases before chunked archives
+// did.
+func sealFormat2(t *testing.T, payload []byte, password string) []byte {
+	t.Helper()
+	dataKey, _ := cryptoRandom(keySize)
+	header, err := wrapKey(dataKey, password, envelopeAAD(envelopeMarker), legacyKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _ := cryptoRandom(nonceSize)
+	gcm, _ := newGCM(dataKey)
+	return gcm.Seal(append(header, nonce...), nonce, payload, envelopeAAD(envelopeMarker))
+}
+
+func TestEnvelopeFormat(t *testing.T) {
+	sealed, err := encryptData([]byte("payload"), "pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isChunked(sealed) || int64(len(sealed)) != chunkedSize(int64(len("payload"))) {
+		t.Fatalf("encryptData wrote %d bytes, marker %q", len(sealed), envelopeMarkerOf(sealed))
+	}
+	if _, err := encryptData([]byte("payload"), "", nil); err == nil {
+		t.Error("encryptData accepted an empty password")
+	}
+
+	// Every earlier format still decrypts.
+	if got, err := decryptData(sealFormat1(t, []byte("old"), "pw"), "pw"); err != nil || string(got) != "old" {
+		t.Errorf("format 1 archive: %q, %v", got, err)
+	}
+	if got, err := decryptData(sealFormat2(t, []byte("two"), "pw"), "pw"); err != nil || string(got) != "two" {
+		t.Errorf("format 2 archive: %q, %v", got, err)
+	}
+
+	// Swapping in another archive's header must not decrypt this payload.
+	other, _ := encryptData([]byte("payload"), "pw", nil)
+	n := keyHeaderLen(kdfMarker)
+	spliced := append(bytes.Clone(other[:n]), sealed[n:]...)
+	if _, err := decryptData(spliced, "pw"); err == nil {
+		t.Error("payload decrypted under another archive's data key")
+	}
+}
+
+func TestChecksumFormat(t *testing.T) {
+	log := []byte(strings.Repeat("2024-01-15 INFO checked\n", 50))
+	gz, _ := compressGzip(bytes.NewReader(log))
+	sum := sha256.Sum256(log)
+	sealed, err := encryptData(gz, "pw", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelopeMarkerOf(sealed) != kdfMarker || !bytes.Equal(archiveLogSum(sealed), sum[:]) {
+		t.Fatalf("marker %q, recorded checksum %x", envelopeMarkerOf(sealed), archiveLogSum(sealed))
+	}
+	if got, err := decryptData(sealed, "pw"); err != nil || !bytes.Equal(got, gz) {
+		t.Fatalf("format 5 archive: %v", err)
+	}
+	if unrecorded, _ := encryptData(gz, "pw", nil); archiveLogSum(unrecorded) != nil {
+		t.Error("an archive sealed without a checksum claims one")
+	}
+
+	// The checksum is authenticated: altering it, or passing the archive off
+	// as one without it, fails to decrypt.
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := decryptData(tampered, "pw"); err == nil {
+		t.Error("archive with an altered checksum decrypted")
+	}
+	blanked := bytes.Clone(sealed)
+	copy(blanked[len(blanked)-sha256.Size:], make([]byte, sha256.Size))
+	if _, err := decryptData(blanked, "pw"); err == nil {
+		t.Error("archive with its checksum blanked decrypted")
+	}
+
+	// Format 3, written before the checksum, and format 4, written before the
+	// KDF block, still decrypt.
+	for _, marker := range []string{chunkedMarker, checksumMarker} {
+		var old bytes.Buffer
+		e, err := newChunkWriter(&old, "pw", marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Write(gz)
+		e.finish(sum[:])
+		want := sum[:]
+		if marker == chunkedMarker {
+			want = nil
+		}
+		if got, err := decryptData(old.Bytes(), "pw"); err != nil || !bytes.Equal(got, gz) || !bytes.Equal(archiveLogSum(old.Bytes()), want) {
+			t.Errorf("%q archive: %v", marker, err)
+		}
+	}
+
+	// Re-keying keeps the format and the checksum.
+	path := filepath.Join(t.TempDir(), "app.log.20240115.gz.enc")
+	os.WriteFile(path, sealed, 0600)
+	if err := rekeyArchive(path, "pw", "new-pw"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if got, err := decryptData(data, "new-pw"); err != nil || !bytes.Equal(got, gz) || !bytes.Equal(archiveLogSum(data), sum[:]) {
+		t.Errorf("re-keyed format 5 archive: %v", err)
+	}
+}
+
+func TestRekeyKeepsLegacyMagic(t *testing.T) {
+	defer setArchiveMagic(encryptMagicStr)
+	setArchiveMagic(legacyMagic)
+	sealed, _ := encryptData([]byte("written before GLR2"), "pw", nil)
+	setArchiveMagic(defaultMagic)
+
+	path := filepath.Join(t.TempDir(), "app.log.20240115.gz.enc")
+	os.WriteFile(path, sealed, 0600)
+	if err := rekeyArchive(path, "pw", "new-pw"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(data, []byte(legacyMagic)) {
+		t.Errorf("re-key rewrote the magic to %q; the payload is bound to the old one", data[:4])
+	}
+	if got, err := decryptData(data, "new-pw"); err != nil || string(got) != "written before GLR2" {
+		t.Errorf("re-keyed %s archive: %q, %v", legacyMagic, got, err)
+	}
+}
+
+func TestReadWarnsOnChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	log := []byte("2024-01-15 INFO original\n")
+	gz, _ := compressGzip(bytes.NewReader(log))
+	cfg := &Config{EncryptPassword: "pw"}
+
+	good := filepath.Join(dir, "good.log.20240115.gz.enc")
+	sum := sha256.Sum256(log)
+	sealed, _ := encryptData(gz, "pw", sum[:])
+	os.WriteFile(good, sealed, 0600)
+	var out bytes.Buffer
+	stderr := captureStderr(t, func() {
+		if err := streamLogFile(&out, good, cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out.String() != string(log) || strings.Contains(stderr, "WARNING") {
+		t.Errorf("matching archive: output %q, stderr %q", out.String(), stderr)
+	}
+
+	// An archive whose compressed payload doesn't match what was logged at
+	// rotation, as a broken compressor would leave it.
+	bad := filepath.Join(dir, "bad.log.20240115.gz.enc")
+	wrong := sha256.Sum256([]byte("something else\n"))
+	sealed, _ = encryptData(gz, "pw", wrong[:])
+	os.WriteFile(bad, sealed, 0600)
+	out.Reset()
+	stderr = captureStderr(t, func() {
+		if err := streamLogFile(&out, bad, cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stderr, "WARNING") || !strings.Contains(stderr, "did NOT decompress to the original log") {
+		t.Errorf("mismatch not warned about: stderr %q", stderr)
+	}
+}
+
+func TestRekeyHeaderOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log.20240115.gz.enc")
+	sealed, _ := encryptData(bytes.Repeat([]byte("log line\n"), 1000), "old-pw", nil)
+	os.WriteFile(path, sealed, 0640)
+
+	if err := rekeyArchive(path, "old-pw", "new-pw"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if n := keyHeaderLen(kdfMarker); !bytes.Equal(data[n:], sealed[n:]) {
+		t.Error("re-key rewrote more than the header")
+	}
+	if _, err := decryptData(data, "old-pw"); err == nil {
+		t.Error("old password still opens the archive")
+	}
+	if got, err := decryptData(data, "new-pw"); err != nil || !bytes.Equal(got, bytes.Repeat([]byte("log line\n"), 1000)) {
+		t.Errorf("new password: %v", err)
+	}
+	if _, err := os.Stat(path + ".rekey"); !os.IsNotExist(err) {
+		t.Error("header backup left behind")
+	}
+	if err := rekeyArchive(path, "old-pw", "new-pw"); err != errAlreadyCurrent {
+		t.Errorf("second re-key: err = %v, want errAlreadyCurrent", err)
+	}
+}
+
+func TestMigrateAndRekeyDir(t *testing.T) {
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "20240115", "app.log.20240115.gz.enc")
+	v2 := filepath.Join(dir, "20240116", "app.log.20240116.gz.enc")
+	os.MkdirAll(filepath.Dir(v1), 0755)
+	os.MkdirAll(filepath.Dir(v2), 0755)
+	os.WriteFile(v1, sealFormat1(t, []byte("one"), "pw"), 0600)
+	os.WriteFile(v2, sealFormat2(t, []byte("two"), "pw"), 0600)
+	cfg := &Config{EncryptPassword: "pw", CheckpointDir: t.TempDir()}
+
+	failed, err := runMigrate(dir, cfg)
+	if err != nil || failed != 0 {
+		t.Fatalf("migrate: failed=%d err=%v", failed, err)
+	}
+	data, _ := os.ReadFile(v1)
+	if got, err := decryptData(data, "pw"); !isChunked(data) || err != nil || string(got) != "one" {
+		t.Errorf("migrated archive: marker %q %q %v", envelopeMarkerOf(data), got, err)
+	}
+	if info, _ := os.Stat(v1); info.Mode().Perm() != 0600 {
+		t.Errorf("migrated mode = %v", info.Mode().Perm())
+	}
+
+	t.Setenv("LOGROTATE_OLD_PASSWORD", "pw")
+	cfg.EncryptPassword = "rotated"
+	if failed, err := runRekey(dir, cfg); err != nil || failed != 0 {
+		t.Fatalf("rekey: failed=%d err=%v", failed, err)
+	}
+	for path, want := range map[string]string{v1: "one", v2: "two"} {
+		data, _ := os.ReadFile(path)
+		if got, err := decryptData(data, "rotated"); err != nil || string(got) != want {
+			t.Errorf("%s after rekey: %q %v", path, got, err)
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(cfg.CheckpointDir, "*")); len(left) != 0 {
+		t.Errorf("checkpoints left after complete runs: %v", left)
+	}
+}
+
+func TestReencryptDir(t *testing.T) {
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "20240115", "app.log.20240115.gz.enc")
+	v4 := filepath.Join(dir, "20240116", "app.log.20240116.gz.enc")
+	done := filepath.Join(dir, "20240117", "app.log.20240117.gz.enc")
+	for _, p := range []string{v1, v4, done} {
+		os.MkdirAll(filepath.Dir(p), 0755)
+	}
+	os.WriteFile(v1, sealFormat1(t, []byte("one"), "old"), 0640)
+	sum := sha256.Sum256([]byte("log two"))
+	sealed, _ := encryptData([]byte("two"), "old", sum[:])
+	os.WriteFile(v4, sealed, 0600)
+	current, _ := encryptData([]byte("three"), "new", nil)
+	os.WriteFile(done, current, 0600)
+
+	t.Setenv("LOGROTATE_OLD_PASSWORD", "old")
+	cfg := &Config{EncryptPassword: "new", CheckpointDir: t.TempDir()}
+	var failed int
+	var err error
+	out := captureStdout(t, func() { failed, err = runReencrypt(dir, cfg) })
+	if err != nil || failed != 0 {
+		t.Fatalf("reencrypt: failed=%d err=%v", failed, err)
+	}
+	if !strings.Contains(out, "reencrypt: 2 archive(s) updated, 1 already current, 0 failed") {
+		t.Errorf("summary: %q", out)
+	}
+	for path, want := range map[string]string{v1: "one", v4: "two", done: "three"} {
+		data, _ := os.ReadFile(path)
+		if got, err := decryptData(data, "new"); err != nil || string(got) != want {
+			t.Errorf("%s: %q %v", path, got, err)
+		}
+		if _, err := decryptData(data, "old"); err == nil {
+			t.Errorf("%s still opens with the old password", path)
+		}
+	}
+	if data, _ := os.ReadFile(v4); !bytes.Equal(archiveLogSum(data), sum[:]) {
+		t.Error("re-encryption dropped the recorded log checksum")
+	}
+	if data, _ := os.ReadFile(done); !bytes.Equal(data, current) {
+		t.Error("archive already on the new password was rewritten")
+	}
+	if info, _ := os.Stat(v1); info.Mode().Perm() != 0640 {
+		t.Errorf("re-encrypted mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	// A wrong old password fails the archive and leaves it untouched.
+	os.WriteFile(v1, sealFormat1(t, []byte("one"), "older"), 0640)
+	before, _ := os.ReadFile(v1)
+	captureStderr(t, func() {
+		captureStdout(t, func() { failed, _ = runReencrypt(v1, cfg) })
+	})
+	if after, _ := os.ReadFile(v1); failed != 1 || !bytes.Equal(after, before) {
+		t.Errorf("wrong old password: failed=%d, archive changed=%v", failed, !bytes.Equal(after, before))
+	}
+}
+
+func TestEncryptExisting(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "20240115", "app.log.20240115.gz")
+	os.MkdirAll(filepath.Dir(plain), 0755)
+	gz, err := compressGzip(bytes.NewReader([]byte("historical\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(plain, gz, 0640)
+	cachedPassword = ""
+	t.Cleanup(func() { cachedPassword = "" })
+	cfg := &Config{EncryptPassword: "pw", CheckpointDir: t.TempDir()}
+
+	cfg.DryRun = true
+	if failed, err := runEncryptExisting(dir, cfg); err != nil || failed != 0 {
+		t.Fatalf("dry run: failed=%d err=%v", failed, err)
+	}
+	if _, err := os.Stat(plain + ".enc"); !os.IsNotExist(err) {
+		t.Fatal("dry run wrote an encrypted archive")
+	}
+
+	cfg.DryRun = false
+	cfg.RemovePlain = true
+	if failed, err := runEncryptExisting(dir, cfg); err != nil || failed != 0 {
+		t.Fatalf("encrypt-existing: failed=%d err=%v", failed, err)
+	}
+	data, err := os.ReadFile(plain + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decryptData(data, "pw"); err != nil || !bytes.Equal(got, gz) {
+		t.Errorf("encrypted archive should wrap the original gzip bytes unchanged (err %v)", err)
+	}
+	if info, _ := os.Stat(plain + ".enc"); info.Mode().Perm() != 0640 {
+		t.Errorf("encrypted archive mode = %v, want 0640", info.Mode().Perm())
+	}
+	if _, err := os.Stat(plain); !os.IsNotExist(err) {
+		t.Error("--remove-plain should delete the original")
+	}
+
+	// Nothing plain is left, and the .enc is never encrypted twice.
+	if failed, err := runEncryptExisting(dir, cfg); err != nil || failed != 0 {
+		t.Errorf("second run: failed=%d err=%v", failed, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ============================================================
+// Compression estimate (--estimate)
+// ============================================================
+
+// estimateArchiveSize compresses at most sampleBytes from the start of path with
+// c and scales the result to the whole file. sampled reports how many bytes were
+// actually compressed; when it equals size the estimate is exact.
+func estimateArchiveSize(path string, size int64, c codec, sampleBytes int64) (estimate, sampled int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cw := &countingWriter{w: io.Discard}
+	w, err := c.newWriter(cw)
+	if err != nil {
+		return 0, 0, err
+	}
+	sampled, err = io.Copy(w, io.LimitReader(f, sampleBytes))
+	if err != nil {
+		w.Close()
+		return 0, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, 0, err
+	}
+	if sampled == 0 {
+		return 0, 0, nil
+	}
+	return int64(float64(cw.n) * float64(size) / float64(sampled)), sampled, nil
+}
+
+// runEstimate prints the expected archive size and ratio of every file without
+// writing anything. Only the first ESTIMATE_SAMPLE_MB of each file is compressed,
+// so numbers for larger files are extrapolations and are marked with "~".
+func runEstimate(files []fileInfo, cfg *Config) {
+	c, err := lookupCodec(cfg.Compress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	c = c.withLevel(cfg.CompressLevel)
+	sampleBytes := max(cfg.EstimateMB, 1) * 1024 * 1024
+
+	var totalIn, totalOut int64
+	for _, f := range files {
+		if f.size == 0 {
+			continue
+		}
+		est, sampled, err := estimateArchiveSize(f.path, f.size, c, sampleBytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error estimating %s: %v\n", f.path, err)
+			logError("Error estimating %s: %v", f.path, err)
+			continue
+		}
+		if encryptFor(f.path, cfg) && cfg.EncryptBackend != backendGPG {
+			est = chunkedSize(est)
+		}
+		mark := "~"
+		if sampled >= f.size {
+			mark = ""
+		}
+		ratio := max((1-float64(est)/float64(f.size))*100, 0)
+		fmt.Printf("[ESTIMATE] %s: %s -> %s%s (%s%.1f%% compression, sampled %s)\n",
+			f.path, formatSize(f.size), mark, formatSize(est), mark, ratio, formatSize(sampled))
+		totalIn += f.size
+		totalOut += est
+	}
+
+	if totalIn > 0 {
+		fmt.Printf("[ESTIMATE] Total: %s -> ~%s (~%.1f%% compression, saves ~%s) using %s\n",
+			formatSize(totalIn), formatSize(totalOut), max((1-float64(totalOut)/float64(totalIn))*100, 0),
+			formatSize(max(totalIn-totalOut, 0)), c.name)
+	}
+	logInfo("Estimate: %d file(s), %d -> ~%d bytes with %s", len(files), totalIn, totalOut, c.name)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimateArchiveSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	data := []byte(strings.Repeat("GET /health 200 OK\n", 50000))
+	os.WriteFile(path, data, 0644)
+	c, _ := lookupCodec("gzip")
+
+	// A sample covering the whole file gives the real compressed size.
+	est, sampled, err := estimateArchiveSize(path, int64(len(data)), c, int64(len(data))+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, _ := c.newWriter(&buf)
+	w.Write(data)
+	w.Close()
+	if sampled != int64(len(data)) || est != int64(buf.Len()) {
+		t.Errorf("full sample: est=%d sampled=%d, want est=%d sampled=%d", est, sampled, buf.Len(), len(data))
+	}
+
+	// A partial sample is scaled up to the whole file.
+	est, sampled, err = estimateArchiveSize(path, int64(len(data)), c, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampled != 64*1024 {
+		t.Errorf("sampled = %d, want %d", sampled, 64*1024)
+	}
+	if est <= 0 || est >= int64(len(data)) {
+		t.Errorf("extrapolated estimate %d out of range for %d repetitive bytes", est, len(data))
+	}
+}
+
+func TestRunEstimateWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	os.WriteFile(path, []byte(strings.Repeat("line\n", 1000)), 0644)
+	cfg := &Config{LogDir: dir, Compress: "gzip", EstimateMB: 1}
+
+	out := captureStdout(t, func() { runEstimate(findLogFiles(dir, "*.log", nil, 0, walkScope{}), cfg) })
+	if !strings.Contains(out, "[ESTIMATE] "+path) || !strings.Contains(out, "[ESTIMATE] Total:") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("estimate created files: %v", entries)
+	}
+	if got, _ := os.ReadFile(path); len(got) != 5000 {
+		t.Errorf("source file modified: %d bytes", len(got))
+	}
+}
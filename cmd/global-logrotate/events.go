@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ============================================================
+// Rotation events on a Unix socket (EVENT_SOCKET)
+// ============================================================
+
+// eventTimeout bounds connecting to EVENT_SOCKET and each write to it, so a
+// wedged agent can't stall a rotation.
+var eventTimeout = 2 * time.Second
+
+// rotationEvent is one JSON line written to EVENT_SOCKET: a "file" event per
+// rotated file, carrying its report entry, and a final "summary" carrying the
+// run report without the per-file list.
+type rotationEvent struct {
+	Event string `json:"event"`
+	RunID string `json:"run_id"`
+	*reportFile
+	*runReport
+}
+
+// eventSink writes events to a connected EVENT_SOCKET. Methods are safe for
+// concurrent use and do nothing on a nil sink. After the first failed write
+// the sink warns once and goes quiet for the rest of the run.
+type eventSink struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	enc     *json.Encoder
+	path    string
+	runID   string
+	started time.Time
+}
+
+// openEventSocket connects to cfg.EventSocket for a run starting at started.
+// Failing to connect is a warning: the rotation goes ahead without events.
+func openEventSocket(cfg *Config, started time.Time) {
+	cfg.events = nil
+	if cfg.EventSocket == "" || cfg.DryRun {
+		return
+	}
+	conn, err := net.DialTimeout("unix", cfg.EventSocket, eventTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: no rotation events: %v\n", err)
+		logError("Could not connect to EVENT_SOCKET %s: %v", cfg.EventSocket, err)
+		return
+	}
+	cfg.events = &eventSink{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		path:    cfg.EventSocket,
+		runID:   runID(started, cfg.JobName),
+		started: started,
+	}
+}
+
+// send writes ev as one line, giving up on the socket if that fails.
+func (s *eventSink) send(ev rotationEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	ev.RunID = s.runID
+	s.conn.SetWriteDeadline(time.Now().Add(eventTimeout))
+	if err := s.enc.Encode(ev); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: stopped sending rotation events: %v\n", err)
+		logError("Writing to EVENT_SOCKET %s failed, no more events this run: %v", s.path, err)
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// fileEvent reports one file's result.
+func (s *eventSink) fileEvent(res FileResult) {
+	if s == nil {
+		return
+	}
+	f := newReportFile(res)
+	s.send(rotationEvent{Event: "file", reportFile: &f})
+}
+
+// closeEventSocket sends the run summary and disconnects.
+func closeEventSocket(cfg *Config, results []FileResult) {
+	s := cfg.events
+	if s == nil {
+		return
+	}
+	summary := newRunReport(cfg, s.started, results)
+	summary.Files = nil
+	s.send(rotationEvent{Event: "summary", runReport: summary})
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.mu.Unlock()
+	cfg.events = nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventSocket(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "events.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer ln.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			lines <- nil
+			return
+		}
+		defer conn.Close()
+		var got []string
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			got = append(got, sc.Text())
+		}
+		lines <- got
+	}()
+
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("event me\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.EventSocket = sock
+
+	started := time.Now()
+	openEventSocket(cfg, started)
+	results := []FileResult{rotateLogFile(logPath, cfg)}
+	closeEventSocket(cfg, results)
+
+	got := <-lines
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2: %q", len(got), got)
+	}
+	var file, summary map[string]any
+	json.Unmarshal([]byte(got[0]), &file)
+	json.Unmarshal([]byte(got[1]), &summary)
+	if file["event"] != "file" || file["path"] != logPath || file["status"] != statusRotated {
+		t.Errorf("file event = %v", file)
+	}
+	if summary["event"] != "summary" || summary["run_id"] != file["run_id"] || summary["files"] != nil {
+		t.Errorf("summary event = %v", summary)
+	}
+	if counts, _ := summary["counts"].(map[string]any); counts[statusRotated] != 1.0 {
+		t.Errorf("summary counts = %v", summary["counts"])
+	}
+}
+
+func TestEventSocketUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("no listener\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.EventSocket = filepath.Join(dir, "missing.sock")
+
+	openEventSocket(cfg, time.Now())
+	if cfg.events != nil {
+		t.Fatal("expected no event sink without a listener")
+	}
+	res := rotateLogFile(logPath, cfg)
+	closeEventSocket(cfg, []FileResult{res})
+	if res.Status != statusRotated {
+		t.Errorf("status = %s, want %s", res.Status, statusRotated)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ============================================================
+// Archive store integrity sweep (--fsck)
+// ============================================================
+
+// fsck verdicts.
+const (
+	fsckHealthy    = "healthy"
+	fsckCorrupt    = "corrupt"    // the archive's own bytes are damaged
+	fsckUnreadable = "unreadable" // it couldn't be checked: I/O error, or the key doesn't open it
+)
+
+// Exit codes of --fsck.
+const (
+	fsckExitHealthy    = 0
+	fsckExitCorrupt    = 1
+	fsckExitUnreadable = 2
+)
+
+type fsckResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// checksumSidecar is where a "sha256sum"-style checksum for archive is kept,
+// if anything wrote one.
+func checksumSidecar(archive string) string {
+	return archive + ".sha256"
+}
+
+// checkSidecar compares sum, the SHA-256 of an archive's bytes, with archive's
+// checksum sidecar.
+func checkSidecar(archive string, sum []byte) error {
+	want, err := readChecksumSidecar(archive)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(want, hex.EncodeToString(sum)) {
+		return fmt.Errorf("does not match %s", checksumSidecar(archive))
+	}
+	return nil
+}
+
+// openArchive opens the archive at path, joining the parts when it is a split
+// archive (named by any part, or by the name the parts share). It returns the
+// archive's name without any part suffix, and a func that closes it.
+func openArchive(path string) (string, io.Reader, func(), error) {
+	archive, split := splitArchiveOf(path)
+	if _, err := os.Stat(path); os.IsNotExist(err) && splitPartsExist(path) {
+		split = true
+	}
+	if !split {
+		f, err := os.Open(path)
+		if err != nil {
+			return path, nil, nil, err
+		}
+		return path, f, func() { f.Close() }, nil
+	}
+	r, closeParts, err := openSplitArchive(archive)
+	return archive, r, closeParts, err
+}
+
+// fsckArchive checks one archive: its checksum sidecar when there is one, the
+// encrypted header, the payload when password (or, for .gpg, the keyring) can
+// open it, and finally that the compressed stream decodes to the end. checkKey
+// false limits encrypted archives to the header. The archive is read as a
+// stream: chunked .enc archives (formats 3 to 6), like unencrypted ones, are
+// checked in constant memory, but older .enc formats and .gpg archives are
+// read whole and need memory for the archive's size.
+func fsckArchive(path string, cfg *Config, password string, checkKey bool) fsckResult {
+	archive, r, closeArchive, err := openArchive(path)
+	res := fsckResult{Path: archive, Status: fsckHealthy}
+	if err != nil {
+		res.Status, res.Detail = fsckUnreadable, err.Error()
+		return res
+	}
+	defer closeArchive()
+
+	// The sidecar covers the archive's bytes as stored: they are hashed as the
+	// content is checked, and whatever the check left unread after it.
+	var sidecar hash.Hash
+	if _, err := os.Stat(checksumSidecar(archive)); err == nil {
+		sidecar = sha256.New()
+		r = io.TeeReader(r, sidecar)
+	}
+	br := bufio.NewReader(r)
+	status, notes := fsckContent(archive, br, cfg, password, checkKey)
+	if sidecar != nil {
+		if _, err := io.Copy(io.Discard, br); err != nil {
+			res.Status, res.Detail = fsckUnreadable, err.Error()
+			return res
+		}
+		if err := checkSidecar(archive, sidecar.Sum(nil)); err != nil {
+			res.Status, res.Detail = fsckCorrupt, "checksum "+err.Error()
+			return res
+		}
+		if status == fsckHealthy {
+			notes = append([]string{"checksum ok"}, notes...)
+		}
+	}
+	res.Status, res.Detail = status, strings.Join(notes, "; ")
+	return res
+}
+
+// fsckContent checks what fsckArchive reads from r and returns the verdict
+// with notes on what was checked or, for a failure, why.
+func fsckContent(archive string, r *bufio.Reader, cfg *Config, password string, checkKey bool) (string, []string) {
+	var src io.Reader = r
+	var logSum []byte
+	var chunked *decryptReader
+	inner := archive
+	switch {
+	case strings.HasSuffix(archive, ".enc"):
+		inner = strings.TrimSuffix(archive, ".enc")
+		head, _ := r.Peek(maxKeyHeaderLen)
+		if len(head) < len(encryptMagic) || !hasArchiveMagic(head) {
+			return fsckCorrupt, []string{"bad magic bytes"}
+		}
+		// The first peek holds the public key header's length; the second
+		// the whole header and at least one GCM tag.
+		head, _ = r.Peek(encryptedHeaderLen(head) + 16)
+		if len(head) < encryptedHeaderLen(head)+16 {
+			return fsckCorrupt, []string{"truncated header"}
+		}
+		if isPublicKeyArchive(head) {
+			if archivePrivateKey == nil || !bytes.Equal(archiveKeyID(head), publicKeyID(&archivePrivateKey.PublicKey)) {
+				return fsckHealthy, []string{fmt.Sprintf("header ok, sealed to public key %x (no matching --private-key)", archiveKeyID(head))}
+			}
+		} else if !checkKey {
+			return fsckHealthy, []string{"header ok, payload not decrypted"}
+		}
+		if isChunked(head) {
+			d, err := newDecryptReader(r, password)
+			switch {
+			case errors.Is(err, errChunkedTruncated):
+				return fsckCorrupt, []string{"truncated header"}
+			case err != nil && isPublicKeyArchive(head):
+				return fsckCorrupt, []string{err.Error()}
+			case err != nil:
+				return fsckUnreadable, []string{"the configured key does not open it"}
+			}
+			src, chunked = d, d
+			break
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fsckUnreadable, []string{err.Error()}
+		}
+		if isEnvelope(data) {
+			if _, err := unwrapKey(data, password); err != nil {
+				return fsckUnreadable, []string{"the configured key does not open it"}
+			}
+		}
+		payload, err := decryptData(data, password)
+		if err != nil {
+			if isEnvelope(data) || isPublicKeyArchive(data) {
+				return fsckCorrupt, []string{"payload authentication failed"} // the key opened, so the payload is damaged
+			}
+			return fsckUnreadable, []string{"payload authentication failed"} // format 1 can't tell a wrong key from damage
+		}
+		src, logSum = bytes.NewReader(payload), archiveLogSum(data)
+	case strings.HasSuffix(archive, ".gpg"):
+		inner = strings.TrimSuffix(archive, ".gpg")
+		if !checkKey {
+			return fsckHealthy, []string{"payload not decrypted"}
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fsckUnreadable, []string{err.Error()}
+		}
+		payload, err := gpgDecrypt(data, cfg)
+		if err != nil {
+			return fsckUnreadable, []string{err.Error()}
+		}
+		src = bytes.NewReader(payload)
+	}
+
+	dr, err := decompressReader(inner, src)
+	h := sha256.New()
+	if err == nil {
+		_, err = io.Copy(h, dr)
+	}
+	if chunked != nil {
+		// Authenticate the rest, down to the last chunk and the checksum, even
+		// if the compressed stream ended first.
+		if err == nil {
+			_, err = io.Copy(io.Discard, chunked)
+		}
+		if chunked.err != nil && chunked.err != io.EOF {
+			return fsckCorrupt, []string{"payload authentication failed: " + chunked.err.Error()}
+		}
+		logSum = chunked.logSum()
+	}
+	if err != nil {
+		return fsckCorrupt, []string{err.Error()}
+	}
+	if logSum != nil {
+		if !bytes.Equal(h.Sum(nil), logSum) {
+			return fsckCorrupt, []string{"log checksum mismatch: does not decompress to the rotated log"}
+		}
+		return fsckHealthy, []string{"log checksum ok"}
+	}
+	return fsckHealthy, nil
+}
+
+// runFsck checks every archive under root and prints a line per archive and a
+// summary, or with asJSON the results as one JSON document. Encrypted payloads
+// are opened with the configured password unless skipKey is set. It returns
+// the exit code: 0 when all are healthy, 1 when any is corrupt, 2 when none is
+// corrupt but some couldn't be checked.
+func runFsck(root string, cfg *Config, skipKey, asJSON bool) (int, error) {
+	entries, err := dirArchives(root, readAll)
+	if err != nil {
+		return 0, err
+	}
+
+	checkKey := !skipKey
+	var password string
+	if checkKey {
+		for _, a := range entries {
+			if archive, _ := splitArchiveOf(a.path); strings.HasSuffix(archive, ".enc") {
+				if password = getDecryptionPassword(cfg); password == "" {
+					fmt.Fprintln(os.Stderr, "Warning: no password available, checking encrypted headers only")
+					checkKey = false
+				}
+				break
+			}
+		}
+	}
+
+	results := make([]fsckResult, 0, len(entries))
+	counts := make(map[string]int)
+	for _, a := range entries {
+		res := fsckArchive(a.path, cfg, password, checkKey)
+		results = append(results, res)
+		counts[res.Status]++
+		if res.Status != fsckHealthy {
+			logError("fsck: %s %s: %s", res.Path, res.Status, res.Detail)
+		}
+		if asJSON {
+			continue
+		}
+		line := fmt.Sprintf("%-10s %s", strings.ToUpper(res.Status), res.Path)
+		if res.Detail != "" {
+			line += ": " + res.Detail
+		}
+		fmt.Println(line)
+	}
+
+	summary := fmt.Sprintf("fsck %s: %d archive(s), %d healthy, %d corrupt, %d unreadable",
+		root, len(results), counts[fsckHealthy], counts[fsckCorrupt], counts[fsckUnreadable])
+	logInfo("%s", summary)
+	if asJSON {
+		out, _ := json.MarshalIndent(struct {
+			Root    string         `json:"root"`
+			Counts  map[string]int `json:"counts"`
+			Results []fsckResult   `json:"archives"`
+		}{root, counts, results}, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(summary)
+	}
+
+	switch {
+	case counts[fsckCorrupt] > 0:
+		return fsckExitCorrupt, nil
+	case counts[fsckUnreadable] > 0:
+		return fsckExitUnreadable, nil
+	}
+	return fsckExitHealthy, nil
+}
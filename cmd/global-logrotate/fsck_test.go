@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	root := t.TempDir()
+	day := filepath.Join(root, "20240115")
+	os.MkdirAll(day, 0755)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(day, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	log := strings.Repeat("log line\n", 1000)
+	gz, _ := compressGzip(strings.NewReader(log))
+	logSum := sha256.Sum256([]byte(log))
+	enc, _ := encryptData(gz, "pw", logSum[:])
+	wrongSum := sha256.Sum256([]byte("another log"))
+	mislogged, _ := encryptData(gz, "pw", wrongSum[:])
+	other, _ := encryptData(gz, "someone else's", nil)
+	damaged := append([]byte(nil), enc...)
+	damaged[len(damaged)-20] ^= 0xff
+
+	healthy := write("ok.log.20240115.gz", gz)
+	sum := sha256.Sum256(gz)
+	os.WriteFile(healthy+".sha256", []byte(hex.EncodeToString(sum[:])+"  ok.log.20240115.gz\n"), 0644)
+	write("enc.log.20240115.gz.enc", enc)
+	truncated := write("cut.log.20240115.gz", gz[:len(gz)/2])
+	badSum := write("sum.log.20240115.gz", gz)
+	os.WriteFile(badSum+".sha256", []byte(strings.Repeat("0", 64)+"  sum.log.20240115.gz\n"), 0644)
+	tampered := write("bad.log.20240115.gz.enc", damaged)
+	foreignKey := write("key.log.20240115.gz.enc", other)
+	mismatch := write("log.log.20240115.gz.enc", mislogged)
+	split := filepath.Join(day, "big.log.20240115.gz")
+	os.WriteFile(partPath(split, 1), gz[:len(gz)/2], 0600)
+	os.WriteFile(partPath(split, 2), gz[len(gz)/2:], 0600)
+
+	cfg := &Config{EncryptPassword: "pw"}
+	want := map[string]string{
+		healthy: fsckHealthy,
+		filepath.Join(day, "enc.log.20240115.gz.enc"): fsckHealthy,
+		split:      fsckHealthy,
+		truncated:  fsckCorrupt,
+		badSum:     fsckCorrupt,
+		tampered:   fsckCorrupt,
+		foreignKey: fsckUnreadable,
+		mismatch:   fsckCorrupt,
+	}
+	for path, status := range want {
+		if got := fsckArchive(path, cfg, "pw", true); got.Status != status {
+			t.Errorf("%s: %s (%s), want %s", filepath.Base(path), got.Status, got.Detail, status)
+		}
+	}
+	if got := fsckArchive(partPath(split, 1), cfg, "pw", true); got.Path != split || got.Status != fsckHealthy {
+		t.Errorf("split set via its first part: %+v", got)
+	}
+
+	// Without the key, encrypted archives are checked up to their header.
+	for _, path := range []string{tampered, foreignKey} {
+		if got := fsckArchive(path, cfg, "", false); got.Status != fsckHealthy {
+			t.Errorf("%s without key: %s (%s)", filepath.Base(path), got.Status, got.Detail)
+		}
+	}
+
+	var code int
+	out := captureStdout(t, func() { code, _ = runFsck(root, cfg, false, false) })
+	if code != fsckExitCorrupt {
+		t.Errorf("exit code %d, want %d", code, fsckExitCorrupt)
+	}
+	if !strings.Contains(out, fmt.Sprintf("%d archive(s), 3 healthy, 4 corrupt, 1 unreadable", len(want))) {
+		t.Errorf("summary missing from:\n%s", out)
+	}
+
+	for _, p := range []string{truncated, badSum, tampered, mismatch} {
+		os.Remove(p)
+	}
+	captureStdout(t, func() { code, _ = runFsck(root, cfg, false, true) })
+	if code != fsckExitUnreadable {
+		t.Errorf("exit code %d with only an unreadable archive, want %d", code, fsckExitUnreadable)
+	}
+}
+
+func TestFsckChunkedArchive(t *testing.T) {
+	dir := t.TempDir()
+	log := []byte(hex.EncodeToString(randomBytes(t, 2*chunkSize)))
+	gz, _ := compressGzip(bytes.NewReader(log))
+	logSum := sha256.Sum256(log)
+	enc, err := encryptData(gz, "pw", logSum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := chunkedHeaderLen(enc)
+	cfg := &Config{EncryptPassword: "pw"}
+
+	cases := map[string]struct {
+		data []byte
+		want string
+	}{
+		"intact":            {enc, fsckHealthy},
+		"cut at a chunk":    {enc[:header+chunkSize+16], fsckCorrupt},
+		"trailer dropped":   {enc[:len(enc)-sha256.Size], fsckCorrupt},
+		"header cut short":  {enc[:header], fsckCorrupt},
+		"last chunk eroded": {append(bytes.Clone(enc[:len(enc)-sha256.Size-1]), logSum[:]...), fsckCorrupt},
+	}
+	for name, c := range cases {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".log.gz.enc")
+		os.WriteFile(path, c.data, 0600)
+		if got := fsckArchive(path, cfg, "pw", true); got.Status != c.want {
+			t.Errorf("%s: %s (%s), want %s", name, got.Status, got.Detail, c.want)
+		}
+	}
+
+	// The sidecar is checked over the same read, and wins over the content.
+	path := filepath.Join(dir, "sidecar.log.gz.enc")
+	os.WriteFile(path, enc, 0600)
+	fileSum := sha256.Sum256(enc)
+	os.WriteFile(path+".sha256", []byte(hex.EncodeToString(fileSum[:])+"  sidecar.log.gz.enc\n"), 0644)
+	if got := fsckArchive(path, cfg, "pw", true); got.Status != fsckHealthy || got.Detail != "checksum ok; log checksum ok" {
+		t.Errorf("with sidecar: %s (%s)", got.Status, got.Detail)
+	}
+	os.WriteFile(path+".sha256", []byte(strings.Repeat("0", 64)+"  sidecar.log.gz.enc\n"), 0644)
+	if got := fsckArchive(path, cfg, "pw", true); got.Status != fsckCorrupt || !strings.HasPrefix(got.Detail, "checksum ") {
+		t.Errorf("with a wrong sidecar: %s (%s)", got.Status, got.Detail)
+	}
+}
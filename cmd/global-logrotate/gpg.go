@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ============================================================
+// GPG encryption backend
+// ============================================================
+
+// Encryption backends selectable via ENCRYPT_BACKEND.
+const (
+	backendAES = "aes"
+	backendGPG = "gpg"
+)
+
+// encryptExt returns the extension appended to a compressed archive name when
+// encryption is enabled for cfg: ".enc" for the built-in AES format, ".gpg" when
+// archives are handed to gpg.
+func encryptExt(cfg *Config) string {
+	if cfg.EncryptBackend == backendGPG {
+		return ".gpg"
+	}
+	return ".enc"
+}
+
+// gpgCommand returns the configured gpg binary, run non-interactively with args.
+func gpgCommand(cfg *Config, args ...string) *exec.Cmd {
+	bin := cfg.GPGBinary
+	if bin == "" {
+		bin = "gpg"
+	}
+	return exec.Command(bin, append([]string{"--batch", "--yes", "--quiet"}, args...)...)
+}
+
+// gpgError folds gpg's stderr into err, so a missing key or keyring problem is
+// reported verbatim.
+func gpgError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, msg)
+	}
+	return fmt.Errorf("%s: %v", cmd.Args[0], err)
+}
+
+// runGPG runs the configured gpg binary with args, feeding it input on stdin and
+// returning stdout.
+func runGPG(cfg *Config, input []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := gpgCommand(cfg, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, gpgError(cmd, err, &stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gpgEncryptArgs returns the arguments that make gpg encrypt to every
+// recipient listed (comma-separated) in GPG_RECIPIENT.
+func gpgEncryptArgs(cfg *Config) ([]string, error) {
+	args := []string{"--trust-model", "always", "--encrypt"}
+	for _, r := range strings.Split(cfg.GPGRecipient, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			args = append(args, "--recipient", r)
+		}
+	}
+	if len(args) == 3 {
+		return nil, fmt.Errorf("ENCRYPT_BACKEND=gpg requires GPG_RECIPIENT")
+	}
+	return append(args, "--output", "-"), nil
+}
+
+// gpgEncrypt encrypts data to GPG_RECIPIENT. The output is a binary OpenPGP
+// message that plain `gpg --decrypt` can open.
+func gpgEncrypt(data []byte, cfg *Config) ([]byte, error) {
+	args, err := gpgEncryptArgs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return runGPG(cfg, data, args...)
+}
+
+// gpgWriter pipes what is written to it into gpg, which encrypts it to
+// GPG_RECIPIENT and writes the message on to another writer as it goes, so
+// the payload is never held in memory whole.
+type gpgWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	done   bool
+	err    error
+}
+
+// newGPGWriter starts gpg with its output going to w.
+func newGPGWriter(w io.Writer, cfg *Config) (*gpgWriter, error) {
+	args, err := gpgEncryptArgs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	g := &gpgWriter{cmd: gpgCommand(cfg, args...)}
+	g.cmd.Stdout = w
+	g.cmd.Stderr = &g.stderr
+	if g.stdin, err = g.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := g.cmd.Start(); err != nil {
+		return nil, gpgError(g.cmd, err, &g.stderr)
+	}
+	return g, nil
+}
+
+func (g *gpgWriter) Write(p []byte) (int, error) {
+	n, err := g.stdin.Write(p)
+	if err != nil {
+		// gpg stopped reading; what it said on the way out is the better error.
+		g.stdin.Close()
+		if werr := g.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close ends gpg's input and waits for it to write the rest of the message.
+func (g *gpgWriter) Close() error {
+	g.stdin.Close()
+	return g.wait()
+}
+
+// kill stops gpg if it is still running, after the write was abandoned.
+func (g *gpgWriter) kill() {
+	if !g.done {
+		g.stdin.Close()
+		g.cmd.Process.Kill()
+		g.wait()
+	}
+}
+
+func (g *gpgWriter) wait() error {
+	if !g.done {
+		g.done = true
+		if err := g.cmd.Wait(); err != nil {
+			g.err = gpgError(g.cmd, err, &g.stderr)
+		}
+	}
+	return g.err
+}
+
+// gpgDecrypt decrypts an OpenPGP message using the caller's keyring; any
+// passphrase prompt is left to gpg-agent.
+func gpgDecrypt(data []byte, cfg *Config) ([]byte, error) {
+	return runGPG(cfg, data, "--decrypt", "--output", "-")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGPGStub installs a fake gpg that tags its input on --encrypt, strips the
+// tag on --decrypt and records its arguments, so the backend can be exercised
+// without a keyring.
+func writeGPGStub(t *testing.T) (bin, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	bin = filepath.Join(dir, "gpg")
+	argsFile = filepath.Join(dir, "args")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + argsFile + "\n" +
+		"case \" $* \" in\n" +
+		"*\" --encrypt \"*) printf 'GPGSTUB:'; cat ;;\n" +
+		"*\" --decrypt \"*) tail -c +9 ;;\n" +
+		"*) echo 'stub: unsupported' >&2; exit 2 ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, argsFile
+}
+
+func TestRotateLogFileGPG(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := []byte(strings.Repeat("gpg interop line\n", 40))
+	os.WriteFile(logPath, content, 0644)
+
+	bin, argsFile := writeGPGStub(t)
+	cfg := makeTestCfg(t, dir)
+	cfg.Encrypt = true
+	cfg.EncryptBackend = backendGPG
+	cfg.GPGRecipient = "ops@example.com, 0xDEADBEEF"
+	cfg.GPGBinary = bin
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status = %s (%v), want rotated", res.Status, res.Err)
+	}
+	archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz.gpg")
+	if res.Archive != archive {
+		t.Fatalf("archive = %s, want %s", res.Archive, archive)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("gpg archive not found: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("GPGSTUB:")) {
+		t.Fatal("archive was not passed through gpg")
+	}
+	plain, err := gpgDecrypt(data, cfg)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	recovered, err := decompressGzip(plain)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(recovered, content) {
+		t.Error("gpg roundtrip failed")
+	}
+
+	args, _ := os.ReadFile(argsFile)
+	for _, want := range []string{"--recipient ops@example.com", "--recipient 0xDEADBEEF", "--batch"} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("gpg args %q missing %q", args, want)
+		}
+	}
+}
+
+func TestRotateLogFileGPGFailsMidStream(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := randomBytes(t, 1<<20)
+	os.WriteFile(logPath, content, 0644)
+
+	// A gpg that gives up after reading a little of its input.
+	bin := filepath.Join(t.TempDir(), "gpg")
+	os.WriteFile(bin, []byte("#!/bin/sh\nhead -c 100 >/dev/null\necho 'stub: no public key' >&2\nexit 2\n"), 0755)
+	cfg := makeTestCfg(t, dir)
+	cfg.Encrypt = true
+	cfg.EncryptBackend = backendGPG
+	cfg.GPGRecipient = "ops@example.com"
+	cfg.GPGBinary = bin
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusFailed || res.Err == nil || !strings.Contains(res.Err.Error(), "stub: no public key") {
+		t.Fatalf("status %s (%v), want failed with gpg's stderr", res.Status, res.Err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "old", "20240115", "*")); len(matches) != 0 {
+		t.Errorf("nothing should be left behind, found %v", matches)
+	}
+	if got, _ := os.ReadFile(logPath); !bytes.Equal(got, content) {
+		t.Error("source must be untouched when gpg fails")
+	}
+}
+
+func TestGPGEncryptRequiresRecipient(t *testing.T) {
+	bin, _ := writeGPGStub(t)
+	cfg := &Config{EncryptBackend: backendGPG, GPGBinary: bin, GPGRecipient: " , "}
+	if _, err := gpgEncrypt([]byte("x"), cfg); err == nil {
+		t.Error("expected error without a recipient")
+	}
+}
+
+func TestRunGPGReportsStderr(t *testing.T) {
+	bin, _ := writeGPGStub(t)
+	_, err := runGPG(&Config{GPGBinary: bin}, nil, "--list-keys")
+	if err == nil || !strings.Contains(err.Error(), "stub: unsupported") {
+		t.Errorf("err = %v, want gpg stderr included", err)
+	}
+}
+
+func TestEncryptExt(t *testing.T) {
+	if got := encryptExt(&Config{EncryptBackend: backendAES}); got != ".enc" {
+		t.Errorf("aes ext = %q", got)
+	}
+	if got := encryptExt(&Config{EncryptBackend: backendGPG}); got != ".gpg" {
+		t.Errorf("gpg ext = %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// ============================================================
+// Searching archives (--read <file|dir> --grep)
+// ============================================================
+
+// Exit statuses of --grep, as grep(1) has them.
+const (
+	grepExitMatch   = 0
+	grepExitNoMatch = 1
+	grepExitError   = 2
+)
+
+// compileGrep compiles a --grep pattern, case-insensitively with -i.
+func compileGrep(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --grep pattern: %w", err)
+	}
+	return re, nil
+}
+
+// grepWriter splits what is written to it into lines and passes those matching
+// re to w, each prefixed with "name:". A partial line is held until the rest
+// of it arrives, or until flush.
+type grepWriter struct {
+	w       io.Writer
+	re      *regexp.Regexp
+	name    string
+	partial []byte
+	matches int
+}
+
+func (g *grepWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			g.partial = append(g.partial, p...)
+			break
+		}
+		line := p[:i]
+		if len(g.partial) > 0 {
+			line = append(g.partial, line...)
+			g.partial = g.partial[:0]
+		}
+		if err := g.match(line); err != nil {
+			return 0, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush matches the final line when the content didn't end in a newline.
+func (g *grepWriter) flush() error {
+	if len(g.partial) == 0 {
+		return nil
+	}
+	line := g.partial
+	g.partial = nil
+	return g.match(line)
+}
+
+func (g *grepWriter) match(line []byte) error {
+	if !g.re.Match(line) {
+		return nil
+	}
+	g.matches++
+	_, err := fmt.Fprintf(g.w, "%s:%s\n", g.name, line)
+	return err
+}
+
+// runGrep prints to w the lines of the archive at path, or of every archive
+// under it that passes cfg.ReadFilter, that match cfg.Grep. Archives are
+// decrypted and decompressed in memory as they are streamed; no plaintext is
+// written to disk. An archive that can't be read is reported and skipped. The
+// result is the exit status: 0 if any line matched, 1 if none did, 2 if an
+// archive couldn't be read.
+func runGrep(w io.Writer, path string, cfg *Config) int {
+	re, err := compileGrep(cfg.Grep, cfg.GrepIgnoreCase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return grepExitError
+	}
+	paths := []string{path}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		archives, err := dirArchives(path, cfg.ReadFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return grepExitError
+		}
+		paths = paths[:0]
+		for _, a := range archives {
+			archive, _ := splitArchiveOf(a.path)
+			paths = append(paths, archive)
+		}
+	}
+
+	var matches, failed int
+	for _, p := range paths {
+		g := &grepWriter{w: w, re: re, name: p}
+		err := streamLogFile(g, p, cfg)
+		if err == nil {
+			err = g.flush()
+		}
+		matches += g.matches
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", p, err)
+			failed++
+		}
+	}
+	switch {
+	case failed > 0:
+		return grepExitError
+	case matches == 0:
+		return grepExitNoMatch
+	}
+	return grepExitMatch
+}
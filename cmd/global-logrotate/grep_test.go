@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGrepWriter(t *testing.T) {
+	var out bytes.Buffer
+	re, _ := compileGrep("error", true)
+	g := &grepWriter{w: &out, re: re, name: "app.log.20240115.gz"}
+	g.Write([]byte("ok\nERROR: disk"))
+	g.Write([]byte(" full\nfine\nlast error"))
+	g.flush()
+	want := "app.log.20240115.gz:ERROR: disk full\napp.log.20240115.gz:last error\n"
+	if out.String() != want || g.matches != 2 {
+		t.Errorf("got %d matches:\n%s\nwant:\n%s", g.matches, out.String(), want)
+	}
+}
+
+func TestRunGrep(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel string, data []byte) string {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	gz := func(s string) []byte {
+		out, err := compressGzip(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	enc, err := encryptData(gz("login ok\nlogin FAILED for root\n"), "grep-pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := write("20240116/auth.log.20240116.gz.enc", enc)
+	plain := write("20240115/auth.log.20240115.gz", gz("login failed for bob\n"))
+	cfg := &Config{EncryptPassword: "grep-pw", Grep: "failed", GrepIgnoreCase: true}
+
+	var out bytes.Buffer
+	if code := runGrep(&out, dir, cfg); code != grepExitMatch {
+		t.Errorf("exit %d, want %d", code, grepExitMatch)
+	}
+	want := plain + ":login failed for bob\n" + secret + ":login FAILED for root\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	cfg.GrepIgnoreCase = false
+	if code := runGrep(&out, secret, cfg); code != grepExitNoMatch || out.Len() != 0 {
+		t.Errorf("case-sensitive grep of %s: exit %d, output %q", secret, code, out.String())
+	}
+
+	cfg.EncryptPassword = "wrong"
+	captureStderr(t, func() {
+		if code := runGrep(&out, dir, cfg); code != grepExitError {
+			t.Errorf("exit %d with an unreadable archive, want %d", code, grepExitError)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// External command hooks
+// ============================================================
+
+// hookKillGrace is how long a timed-out hook's process group gets to exit after
+// SIGTERM before it is sent SIGKILL.
+var hookKillGrace = 5 * time.Second
+
+// hookTimeoutError reports a hook that was killed for exceeding HOOK_TIMEOUT.
+type hookTimeoutError struct {
+	command string
+	timeout time.Duration
+}
+
+func (e *hookTimeoutError) Error() string {
+	return fmt.Sprintf("hook-timeout: %s did not finish within %s", e.command, e.timeout)
+}
+
+// runHookCommand runs cmd in its own process group. With a positive timeout, a
+// command still running when it expires has its whole group sent SIGTERM, then
+// SIGKILL after hookKillGrace, so children it spawned (a blocked kill, a stuck
+// upload) die with it, and a *hookTimeoutError is returned.
+func runHookCommand(cmd *exec.Cmd, timeout time.Duration) error {
+	cmdline := strings.Join(cmd.Args, " ")
+	setProcessGroup(cmd)
+	// Don't wait forever on output pipes held open by a grandchild that left the group.
+	cmd.WaitDelay = hookKillGrace
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	if timeout <= 0 {
+		return <-done
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+
+	pgid := cmd.Process.Pid
+	logError("Hook timed out after %s: %s; sending SIGTERM to process group %d", timeout, cmdline, pgid)
+	stopProcessGroup(cmd, false)
+	select {
+	case <-done:
+	case <-time.After(hookKillGrace):
+		logError("Hook ignored SIGTERM: %s; sending SIGKILL to process group %d", cmdline, pgid)
+		stopProcessGroup(cmd, true)
+		<-done
+	}
+	return &hookTimeoutError{command: cmdline, timeout: timeout}
+}
+
+// POST_ROTATE_MODE values: when the POST_ROTATE command runs.
+const (
+	postRotateFile = "file" // after each rotated file
+	postRotateRun  = "run"  // once, after every file of the run
+)
+
+// postRotateEnv is the environment a POST_ROTATE command runs with. Per file,
+// ROTATED_FILE is the source and ARCHIVE_FILE its archive; once per run,
+// ROTATED_FILES and ARCHIVE_FILES list them one per line.
+func postRotateEnv(results []FileResult, perFile bool) []string {
+	env := os.Environ()
+	if perFile {
+		return append(env, "ROTATED_FILE="+results[0].Path, "ARCHIVE_FILE="+results[0].Archive)
+	}
+	var sources, archives []string
+	for _, r := range results {
+		sources = append(sources, r.Path)
+		archives = append(archives, r.Archive)
+	}
+	return append(env, "ROTATED_FILES="+strings.Join(sources, "\n"), "ARCHIVE_FILES="+strings.Join(archives, "\n"))
+}
+
+// runPostRotate runs POST_ROTATE through sh for results, logging each line it
+// writes. A non-zero exit (or a HOOK_TIMEOUT kill) is returned as an error.
+func runPostRotate(cfg *Config, results []FileResult, perFile bool) error {
+	cmd := exec.Command("sh", "-c", cfg.PostRotate)
+	cmd.Env = postRotateEnv(results, perFile)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := runHookCommand(cmd, time.Duration(cfg.HookTimeout)*time.Second)
+	for _, out := range []struct {
+		name string
+		buf  *bytes.Buffer
+	}{{"stdout", &stdout}, {"stderr", &stderr}} {
+		for _, line := range strings.Split(strings.TrimRight(out.buf.String(), "\n"), "\n") {
+			if line != "" {
+				logInfo("post-rotate [%s]: %s", out.name, line)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("post-rotate command %q failed: %w", cfg.PostRotate, err)
+	}
+	return nil
+}
+
+// postRotateFileHook runs POST_ROTATE for one file rotateFile just finished, when
+// POST_ROTATE_MODE is "file". A failing command fails the file: its archive
+// is kept, but the application may still be writing to the old file.
+func postRotateFileHook(cfg *Config, res FileResult) FileResult {
+	if cfg.PostRotate == "" || cfg.PostRotateMode == postRotateRun {
+		return res
+	}
+	switch res.Status {
+	case statusDryRun:
+		plan(planCommand, "Would run post-rotate command for %s: %s", res.Path, cfg.PostRotate)
+		return res
+	case statusRotated:
+	default:
+		return res
+	}
+	if err := runPostRotate(cfg, []FileResult{res}, true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", res.Path, err)
+		logError("Rotated %s, but %v", res.Path, err)
+		res.Status, res.Err = statusFailed, err
+	}
+	return res
+}
+
+// postRotateRunHook runs POST_ROTATE once for the whole run, when
+// POST_ROTATE_MODE is "run" and at least one file was rotated. A failing
+// command fails every file it was run for.
+func postRotateRunHook(cfg *Config, results []FileResult) {
+	if cfg.PostRotate == "" || cfg.PostRotateMode != postRotateRun {
+		return
+	}
+	var rotated []FileResult
+	for _, r := range results {
+		if r.Status == statusRotated || r.Status == statusDryRun {
+			rotated = append(rotated, r)
+		}
+	}
+	if len(rotated) == 0 {
+		logInfo("Nothing rotated, not running the post-rotate command")
+		return
+	}
+	if cfg.DryRun {
+		plan(planCommand, "Would run post-rotate command once for %d file(s): %s", len(rotated), cfg.PostRotate)
+		return
+	}
+	if err := runPostRotate(cfg, rotated, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		logError("Rotated %d file(s), but %v", len(rotated), err)
+		for i := range results {
+			if results[i].Status == statusRotated {
+				results[i].Status, results[i].Err = statusFailed, err
+			}
+		}
+		return
+	}
+	logInfo("Ran post-rotate command after rotating %d file(s)", len(rotated))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunHookCommandTimeoutKillsGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	// The hook backgrounds a child and waits on it, like a hook stuck on a subprocess.
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo $! > "+pidFile+"; wait")
+
+	start := time.Now()
+	err := runHookCommand(cmd, 200*time.Millisecond)
+	var te *hookTimeoutError
+	if !errors.As(err, &te) || !strings.Contains(err.Error(), "hook-timeout") {
+		t.Fatalf("err = %v, want hook timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > hookKillGrace {
+		t.Errorf("took %s to give up", elapsed)
+	}
+
+	data, _ := os.ReadFile(pidFile)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if pid == 0 {
+		t.Fatal("child pid not recorded")
+	}
+	for range 50 {
+		if !processAlive(pid) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("grandchild %d survived the process-group kill", pid)
+}
+
+func TestRunHookCommandSIGKILLAfterGrace(t *testing.T) {
+	old := hookKillGrace
+	hookKillGrace = 100 * time.Millisecond
+	defer func() { hookKillGrace = old }()
+
+	cmd := exec.Command("sh", "-c", "trap '' TERM; while :; do sleep 1; done")
+	if err := runHookCommand(cmd, 100*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if cmd.ProcessState == nil || cmd.ProcessState.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+		t.Errorf("state = %v, want killed by SIGKILL", cmd.ProcessState)
+	}
+}
+
+func TestRunHookCommandNoTimeout(t *testing.T) {
+	if err := runHookCommand(exec.Command("true"), 0); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	err := runHookCommand(exec.Command("false"), time.Second)
+	var te *hookTimeoutError
+	if err == nil || errors.As(err, &te) {
+		t.Errorf("failing hook: err = %v, want plain exit error", err)
+	}
+}
+
+// processAlive reports whether pid is running. A killed process nobody has
+// reaped yet shows up as a zombie, which counts as dead.
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return sendSignal(pid, 0) == nil
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestPostRotatePerFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	seen := filepath.Join(dir, "seen")
+	cfg.PostRotate = `echo "$ROTATED_FILE $ARCHIVE_FILE" >> ` + seen
+
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("2024-01-15 INFO hello\n"), 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated {
+		t.Fatalf("status %s (%v), want rotated", res.Status, res.Err)
+	}
+	data, _ := os.ReadFile(seen)
+	if want := logPath + " " + res.Archive + "\n"; string(data) != want {
+		t.Errorf("hook saw %q, want %q", data, want)
+	}
+
+	cfg.PostRotate = "exit 3"
+	logPath = filepath.Join(dir, "failing.log")
+	os.WriteFile(logPath, []byte("2024-01-15 INFO again\n"), 0644)
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusFailed || res.Err == nil {
+		t.Errorf("failing hook: status %s, want failed", res.Status)
+	}
+
+	cfg.DryRun = true
+	cfg.PostRotate = "touch " + filepath.Join(dir, "ran")
+	logPath = filepath.Join(dir, "dry.log")
+	os.WriteFile(logPath, []byte("2024-01-15 INFO dry\n"), 0644)
+	out := captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if !strings.Contains(out, "Would run post-rotate command") {
+		t.Errorf("dry-run output %q does not mention the hook", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("dry run executed the hook")
+	}
+}
+
+func TestPostRotateRunMode(t *testing.T) {
+	dir := t.TempDir()
+	seen := filepath.Join(dir, "seen")
+	cfg := &Config{PostRotateMode: postRotateRun, PostRotate: `printf '%s|%s' "$ROTATED_FILES" "$ARCHIVE_FILES" > ` + seen}
+	results := []FileResult{
+		{Path: "/var/log/a.log", Archive: "/old/a.gz", Status: statusRotated},
+		{Path: "/var/log/b.log", Status: statusSkipped},
+		{Path: "/var/log/c.log", Archive: "/old/c.gz", Status: statusRotated},
+	}
+	postRotateRunHook(cfg, results)
+	data, _ := os.ReadFile(seen)
+	if want := "/var/log/a.log\n/var/log/c.log|/old/a.gz\n/old/c.gz"; string(data) != want {
+		t.Errorf("hook saw %q, want %q", data, want)
+	}
+
+	cfg.PostRotate = "false"
+	postRotateRunHook(cfg, results)
+	if results[0].Status != statusFailed || results[2].Status != statusFailed || results[1].Status != statusSkipped {
+		t.Errorf("after a failing hook: %s, %s, %s; want failed, skipped, failed",
+			results[0].Status, results[1].Status, results[2].Status)
+	}
+}
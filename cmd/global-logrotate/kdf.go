@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// ============================================================
+// Key derivation (ENCRYPT_KDF)
+// ============================================================
+
+// KDF identifiers as recorded in format 5 headers.
+const (
+	kdfPBKDF2   byte = 1 // PBKDF2-HMAC-SHA256
+	kdfArgon2id byte = 2
+)
+
+// kdfBlockSize is a KDF as recorded in a format 5 header:
+// ID(1) | TIME(4) | MEMORY(4) | THREADS(1) | reserved(2), integers big-endian.
+const kdfBlockSize = 12
+
+// Argon2id defaults, the second recommended option of RFC 9106.
+const (
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024 // KiB
+	defaultArgon2Threads = 4
+)
+
+// Bounds on parameters read from a header, so a crafted archive can't make a
+// read allocate or spin without limit.
+const (
+	maxKDFTime          = 100_000_000
+	maxArgon2Memory     = 4 * 1024 * 1024 // KiB, i.e. 4 GiB
+	minPBKDF2Iterations = 1000
+)
+
+// kdfParams says how a password becomes a key-wrapping key.
+type kdfParams struct {
+	ID      byte
+	Time    uint32 // PBKDF2 iterations, or Argon2 passes
+	Memory  uint32 // Argon2 memory in KiB
+	Threads uint8  // Argon2 parallelism
+}
+
+// legacyKDF is what formats 1 to 4, which record no KDF, were derived with.
+var legacyKDF = kdfParams{ID: kdfPBKDF2, Time: iterations}
+
+// encryptKDF is the KDF new archives are written with (ENCRYPT_KDF).
+var encryptKDF = legacyKDF
+
+func (p kdfParams) String() string {
+	if p.ID == kdfArgon2id {
+		return fmt.Sprintf("argon2id (t=%d, m=%d KiB, p=%d)", p.Time, p.Memory, p.Threads)
+	}
+	return fmt.Sprintf("pbkdf2 (%d iterations)", p.Time)
+}
+
+// setEncryptKDF selects the KDF new archives use by name, pbkdf2 or argon2id,
+// with pbkdf2Iter PBKDF2 iterations (ENCRYPT_ITERATIONS).
+func setEncryptKDF(name string, pbkdf2Iter int) error {
+	switch strings.ToLower(name) {
+	case "", "pbkdf2":
+		if pbkdf2Iter < minPBKDF2Iterations || pbkdf2Iter > maxKDFTime {
+			return fmt.Errorf("ENCRYPT_ITERATIONS must be between %d and %d, got %d", minPBKDF2Iterations, maxKDFTime, pbkdf2Iter)
+		}
+		encryptKDF = kdfParams{ID: kdfPBKDF2, Time: uint32(pbkdf2Iter)}
+	case "argon2id":
+		encryptKDF = kdfParams{ID: kdfArgon2id, Time: defaultArgon2Time, Memory: defaultArgon2Memory, Threads: defaultArgon2Threads}
+	default:
+		return fmt.Errorf("ENCRYPT_KDF: unknown KDF %q (want pbkdf2 or argon2id)", name)
+	}
+	return nil
+}
+
+// encodeKDF is p as a header block.
+func encodeKDF(p kdfParams) []byte {
+	b := make([]byte, kdfBlockSize)
+	b[0] = p.ID
+	binary.BigEndian.PutUint32(b[1:5], p.Time)
+	binary.BigEndian.PutUint32(b[5:9], p.Memory)
+	b[9] = p.Threads
+	return b
+}
+
+// parseKDF reads a header block, refusing unknown KDFs and parameters outside
+// what we would ever write.
+func parseKDF(b []byte) (kdfParams, error) {
+	if len(b) < kdfBlockSize {
+		return kdfParams{}, fmt.Errorf("KDF block truncated")
+	}
+	p := kdfParams{
+		ID:      b[0],
+		Time:    binary.BigEndian.Uint32(b[1:5]),
+		Memory:  binary.BigEndian.Uint32(b[5:9]),
+		Threads: b[9],
+	}
+	switch p.ID {
+	case kdfPBKDF2:
+		if p.Time < minPBKDF2Iterations || p.Time > maxKDFTime {
+			return kdfParams{}, fmt.Errorf("PBKDF2 iteration count %d out of range", p.Time)
+		}
+	case kdfArgon2id:
+		if p.Time == 0 || p.Time > maxKDFTime || p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory || p.Threads == 0 {
+			return kdfParams{}, fmt.Errorf("argon2id parameters t=%d m=%d p=%d out of range", p.Time, p.Memory, p.Threads)
+		}
+	default:
+		return kdfParams{}, fmt.Errorf("unknown KDF id %d", p.ID)
+	}
+	return p, nil
+}
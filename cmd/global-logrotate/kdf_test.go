@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useKDF makes new archives use the named KDF for the test.
+func useKDF(t *testing.T, name string) {
+	t.Helper()
+	if err := setEncryptKDF(name, iterations); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { encryptKDF = legacyKDF })
+}
+
+func TestArgon2idArchives(t *testing.T) {
+	useKDF(t, "argon2id")
+	sealed, err := encryptData([]byte("memory-hard"), "pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := headerKDF(sealed)
+	if err != nil || kdf.ID != kdfArgon2id || kdf.Memory != defaultArgon2Memory || kdf.Time != defaultArgon2Time || kdf.Threads != defaultArgon2Threads {
+		t.Fatalf("header records %v (%v)", kdf, err)
+	}
+
+	// The header, not the configuration, decides how the key is derived.
+	encryptKDF = legacyKDF
+	if got, err := decryptData(sealed, "pw"); err != nil || string(got) != "memory-hard" {
+		t.Fatalf("argon2id archive under pbkdf2 config: %q, %v", got, err)
+	}
+	pbkdf2Sealed, _ := encryptData([]byte("compatible"), "pw", nil)
+	useKDF(t, "argon2id")
+	if got, err := decryptData(pbkdf2Sealed, "pw"); err != nil || string(got) != "compatible" {
+		t.Errorf("pbkdf2 archive under argon2id config: %q, %v", got, err)
+	}
+
+	// The KDF parameters are bound to the wrapped key.
+	weakened := bytes.Clone(sealed)
+	weakened[len(encryptMagic)+len(kdfMarker)+9] = 1 // threads
+	if _, err := decryptData(weakened, "pw"); err == nil {
+		t.Error("archive with altered KDF parameters decrypted")
+	}
+
+	// A re-key moves the archive to the configured KDF.
+	path := filepath.Join(t.TempDir(), "app.log.20240115.gz.enc")
+	os.WriteFile(path, pbkdf2Sealed, 0600)
+	if err := rekeyArchive(path, "pw", "new-pw"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if kdf, _ := headerKDF(data); kdf.ID != kdfArgon2id {
+		t.Errorf("re-keyed archive uses %v", kdf)
+	}
+	if got, err := decryptData(data, "new-pw"); err != nil || string(got) != "compatible" {
+		t.Errorf("re-keyed archive: %q, %v", got, err)
+	}
+}
+
+func TestParseKDF(t *testing.T) {
+	for _, p := range []kdfParams{
+		legacyKDF,
+		{ID: kdfArgon2id, Time: defaultArgon2Time, Memory: defaultArgon2Memory, Threads: defaultArgon2Threads},
+	} {
+		if got, err := parseKDF(encodeKDF(p)); err != nil || got != p {
+			t.Errorf("parseKDF(encodeKDF(%v)) = %v, %v", p, got, err)
+		}
+	}
+	for _, bad := range []kdfParams{
+		{ID: 9, Time: 1},
+		{ID: kdfPBKDF2, Time: 1},
+		{ID: kdfArgon2id, Time: 1, Memory: maxArgon2Memory + 1, Threads: 1},
+		{ID: kdfArgon2id, Time: 1, Memory: 64, Threads: 0},
+		{ID: kdfArgon2id, Time: 0, Memory: 64, Threads: 1},
+	} {
+		if _, err := parseKDF(encodeKDF(bad)); err == nil {
+			t.Errorf("parseKDF accepted %+v", bad)
+		}
+	}
+	if err := setEncryptKDF("scrypt", iterations); err == nil {
+		t.Error("setEncryptKDF accepted scrypt")
+	}
+	if err := setEncryptKDF("pbkdf2", 10); err == nil {
+		t.Error("setEncryptKDF accepted 10 PBKDF2 iterations")
+	}
+	encryptKDF = legacyKDF
+}
+
+func TestEncryptIterationsRecorded(t *testing.T) {
+	t.Cleanup(func() { encryptKDF = legacyKDF })
+	if err := setEncryptKDF("pbkdf2", 250000); err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := encryptData([]byte("harder"), "pw", nil)
+	if kdf, err := headerKDF(sealed); err != nil || kdf.ID != kdfPBKDF2 || kdf.Time != 250000 {
+		t.Fatalf("header records %v (%v), want 250000 iterations", kdf, err)
+	}
+
+	// Lowering the count again (or any later change) leaves the archive readable.
+	encryptKDF = legacyKDF
+	if got, err := decryptData(sealed, "pw"); err != nil || string(got) != "harder" {
+		t.Errorf("archive written with 250000 iterations: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ============================================================
+// Keyfile encryption (--keyfile, ENCRYPT_KEYFILE)
+// ============================================================
+
+// readKeyfile returns the contents of a keyfile, used in place of a password
+// as the material deriveKey works from. The bytes are taken as they are,
+// trailing newline included. Like ssh with private keys, a keyfile others
+// can read is refused rather than trusted.
+func readKeyfile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("keyfile: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("keyfile %s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		return "", fmt.Errorf("keyfile %s is world-readable (mode %04o); chmod o-r it first", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("keyfile: %w", err)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("keyfile %s is empty", path)
+	}
+	return string(data), nil
+}
+
+// keyfileSecret is ENCRYPT_KEYFILE's key material, or "" after reporting why
+// it can't be used.
+func keyfileSecret(cfg *Config) string {
+	key, err := readKeyfile(cfg.EncryptKeyfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		logError("Cannot use keyfile: %v", err)
+		return ""
+	}
+	logDebug("Key material loaded from keyfile %s", cfg.EncryptKeyfile)
+	return key
+}
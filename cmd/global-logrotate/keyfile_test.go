@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyfileEncryptAndRead(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "archive.key")
+	os.WriteFile(keyfile, randomBytes(t, 64), 0600)
+	t.Setenv("LOGROTATE_PASSWORD", "not-this-one")
+	cachedPassword = ""
+	t.Cleanup(func() { cachedPassword = "" })
+
+	cfg := makeTestCfg(t, dir)
+	cfg.Encrypt = true
+	cfg.EncryptKeyfile = keyfile
+	logPath := filepath.Join(dir, "app.log")
+	content := []byte("2024-01-15 INFO keyed\n")
+	os.WriteFile(logPath, content, 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated {
+		t.Fatalf("status %s: %v", res.Status, res.Err)
+	}
+
+	var out bytes.Buffer
+	if err := streamLogFile(&out, res.Archive, &Config{EncryptKeyfile: keyfile}); err != nil || !bytes.Equal(out.Bytes(), content) {
+		t.Fatalf("read with keyfile: %q, %v", out.String(), err)
+	}
+	if err := streamLogFile(&out, res.Archive, &Config{EncryptPassword: "not-this-one"}); err == nil {
+		t.Error("archive written with a keyfile opened with the password")
+	}
+}
+
+func TestReadKeyfileRefusesUnsafeFiles(t *testing.T) {
+	dir := t.TempDir()
+	open := filepath.Join(dir, "open.key")
+	os.WriteFile(open, []byte("secret"), 0644)
+	os.Chmod(open, 0644)
+	if _, err := readKeyfile(open); err == nil || !strings.Contains(err.Error(), "world-readable") {
+		t.Errorf("world-readable keyfile: err = %v", err)
+	}
+	empty := filepath.Join(dir, "empty.key")
+	os.WriteFile(empty, nil, 0600)
+	if _, err := readKeyfile(empty); err == nil {
+		t.Error("empty keyfile accepted")
+	}
+	if _, err := readKeyfile(dir); err == nil {
+		t.Error("directory accepted as a keyfile")
+	}
+	if _, err := readKeyfile(filepath.Join(dir, "missing.key")); err == nil {
+		t.Error("missing keyfile accepted")
+	}
+
+	cachedPassword = ""
+	t.Cleanup(func() { cachedPassword = "" })
+	cfg := &Config{EncryptKeyfile: open, EncryptPassword: "fallback"}
+	var err error
+	captureStderr(t, func() { err = resolveEncryptionPassword(cfg) })
+	if err == nil || !strings.Contains(err.Error(), "ENCRYPT_KEYFILE") {
+		t.Errorf("resolving with a world-readable keyfile: err = %v (must not fall back to the password)", err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ============================================================
+// Listing a log's archives (--list <logname>)
+// ============================================================
+
+// listedArchive is one archive in --list output. OriginalSize is only set when
+// it can be had without decompressing: from a gzip archive's footer, or the
+// size of one stored uncompressed; or with --exact-size, by decompressing.
+// OriginalExact is false for a footer size that may have wrapped at 4 GiB,
+// which is then only the true size's remainder.
+type listedArchive struct {
+	Path          string `json:"path"`
+	Log           string `json:"log"`
+	Date          string `json:"date"`
+	Size          int64  `json:"size"`
+	Encrypted     bool   `json:"encrypted"`
+	Parts         int    `json:"parts,omitempty"`
+	OriginalSize  *int64 `json:"original_size"`
+	OriginalExact bool   `json:"original_size_exact"`
+}
+
+// matchLogName reports whether an archive of logName is one --list asked for:
+// name is the log's name, its path relative to the log dir ("nginx/access.log")
+// or a glob of either.
+func matchLogName(name, logName string) bool {
+	for _, s := range []string{logName, filepath.Base(logName)} {
+		if ok, _ := filepath.Match(name, s); ok || s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// listArchives returns the archives of the logs matching name under the backup
+// roots of cfg, oldest first. A split archive is listed once, by its name,
+// with the size of all its parts.
+func listArchives(name string, cfg *Config) []listedArchive {
+	var out []listedArchive
+	for _, root := range backupRoots(cfg) {
+		entries, err := dirArchives(root, readAll)
+		if err != nil {
+			logDebug("--list: skipping %s: %v", root, err)
+			continue
+		}
+		for _, a := range entries {
+			if !matchLogName(name, a.logName) {
+				continue
+			}
+			archive, split := splitArchiveOf(a.path)
+			l := listedArchive{
+				Path:      archive,
+				Log:       a.logName,
+				Date:      a.date.Format("2006-01-02"),
+				Size:      a.size,
+				Encrypted: isEncryptedArchive(archive),
+			}
+			if split {
+				l.Size = 0
+				for i := 1; ; i++ {
+					info, err := os.Stat(partPath(archive, i))
+					if err != nil {
+						break
+					}
+					l.Size += info.Size()
+					l.Parts++
+				}
+			} else if n, exact, ok := originalSize(archive, a.size); ok {
+				l.OriginalSize, l.OriginalExact = &n, exact
+			}
+			if cfg.ExactSize && !l.OriginalExact && !l.Encrypted {
+				if n, err := countOriginalSize(archive, cfg); err != nil {
+					logError("--list: could not decompress %s: %v", archive, err)
+				} else {
+					l.OriginalSize, l.OriginalExact = &n, true
+				}
+			}
+			out = append(out, l)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Date < out[j].Date })
+	return out
+}
+
+// gzipMaxRatio is about the most deflate can compress: no gzip stream holds
+// more than 1032 times its own size.
+const gzipMaxRatio = 1032
+
+// originalSize returns the size of the log an unencrypted archive of size
+// bytes holds, when that is recorded, and whether it is exact. A stored
+// archive is the log itself. A gzip archive ends with the log's size modulo
+// 4 GiB (ISIZE, RFC 1952): that is the size only when the archive is too small
+// to hold 4 GiB more, and otherwise a remainder the true size may exceed by any
+// multiple of 4 GiB.
+func originalSize(archive string, size int64) (n int64, exact, ok bool) {
+	if isEncryptedArchive(archive) {
+		return 0, false, false
+	}
+	c, ok := codecForPath(archive)
+	if !ok {
+		return size, true, true
+	}
+	if c.name != "gzip" || size < 18 {
+		return 0, false, false
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return 0, false, false
+	}
+	defer f.Close()
+	var footer [4]byte
+	if _, err := f.ReadAt(footer[:], size-4); err != nil {
+		return 0, false, false
+	}
+	n = int64(binary.LittleEndian.Uint32(footer[:]))
+	return n, n+1<<32 > size*gzipMaxRatio, true
+}
+
+// countOriginalSize decompresses an unencrypted archive to count the size of
+// the log it holds (--exact-size).
+func countOriginalSize(archive string, cfg *Config) (int64, error) {
+	cw := &countingWriter{w: io.Discard}
+	err := streamLogFile(cw, archive, cfg)
+	return cw.n, err
+}
+
+// writeArchiveList prints archives as a table, or with --format json as a JSON
+// array.
+func writeArchiveList(w io.Writer, archives []listedArchive, format string) error {
+	if format == formatJSON {
+		if archives == nil {
+			archives = []listedArchive{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(archives)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tSIZE\tORIGINAL\tENCRYPTED\tARCHIVE")
+	var wrapped bool
+	for _, a := range archives {
+		original := "-"
+		if a.OriginalSize != nil {
+			original = formatSize(*a.OriginalSize)
+			if !a.OriginalExact {
+				original += "?"
+				wrapped = true
+			}
+		}
+		encrypted := "no"
+		if a.Encrypted {
+			encrypted = "yes"
+		}
+		path := a.Path
+		if a.Parts > 0 {
+			path += fmt.Sprintf(" (%d parts)", a.Parts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Date, formatSize(a.Size), original, encrypted, path)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if wrapped {
+		_, err := fmt.Fprintln(w, "?: from the gzip footer, which wraps at 4 GiB; the log may be larger (--exact-size counts it)")
+		return err
+	}
+	return nil
+}
+
+// runList prints the archives of the logs matching name, and fails when there
+// are none.
+func runList(name string, cfg *Config) error {
+	archives := listArchives(name, cfg)
+	if err := writeArchiveList(os.Stdout, archives, cfg.Format); err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no archives of %s under %s", name, strings.Join(backupRoots(cfg), ", "))
+	}
+	return nil
+}
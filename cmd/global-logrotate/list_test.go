@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListArchives(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	write := func(rel string, data []byte) string {
+		path := filepath.Join(root, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	log := strings.Repeat("a line of the log\n", 100)
+	gz, _ := compressGzip(strings.NewReader(log))
+	plain := write("20240115/app.log.20240115.gz", gz)
+	enc := write("20240116/app.log.20240116.gz.enc", []byte("sealed"))
+	write("20240117/app.log.20240117.gz.part001", []byte("12345"))
+	write("20240117/app.log.20240117.gz.part002", []byte("678"))
+	write("20240115/db.log.20240115.gz", gz)
+
+	cfg := &Config{LogDir: dir, OldLogsDir: root}
+	got := listArchives("app.log", cfg)
+	if len(got) != 3 {
+		t.Fatalf("listed %d archives of app.log, want 3: %+v", len(got), got)
+	}
+	if got[0].Path != plain || got[0].Date != "2024-01-15" || got[0].Encrypted ||
+		got[0].OriginalSize == nil || *got[0].OriginalSize != int64(len(log)) {
+		t.Errorf("gzip archive: %+v", got[0])
+	}
+	if got[1].Path != enc || !got[1].Encrypted || got[1].OriginalSize != nil {
+		t.Errorf("encrypted archive: %+v", got[1])
+	}
+	if got[2].Parts != 2 || got[2].Size != 8 || !strings.HasSuffix(got[2].Path, ".gz") {
+		t.Errorf("split archive: %+v", got[2])
+	}
+	if n := len(listArchives("*.log", cfg)); n != 4 {
+		t.Errorf("glob listed %d archives, want 4", n)
+	}
+
+	var out bytes.Buffer
+	writeArchiveList(&out, got, formatJSON)
+	var decoded []listedArchive
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded) != 3 {
+		t.Fatalf("JSON list %v: %s", err, out.String())
+	}
+	out.Reset()
+	writeArchiveList(&out, got, formatText)
+	if !strings.Contains(out.String(), "(2 parts)") || !strings.Contains(out.String(), "yes") {
+		t.Errorf("table:\n%s", out.String())
+	}
+}
+
+func TestListOriginalSizeWrap(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	os.MkdirAll(filepath.Join(root, "20240115"), 0755)
+	// Random data doesn't compress, so this archive is big enough to hold a
+	// log past 4 GiB: its footer alone can't tell.
+	log := make([]byte, (1<<32)/(gzipMaxRatio-1)+1024)
+	rand.New(rand.NewSource(1)).Read(log)
+	gz, _ := compressGzip(bytes.NewReader(log))
+	archive := filepath.Join(root, "20240115", "big.log.20240115.gz")
+	os.WriteFile(archive, gz, 0644)
+
+	n, exact, ok := originalSize(archive, int64(len(gz)))
+	if !ok || exact || n != int64(len(log)) {
+		t.Errorf("originalSize = %d, exact %v, ok %v; want %d, not exact", n, exact, ok, len(log))
+	}
+	cfg := &Config{LogDir: dir, OldLogsDir: root}
+	var out bytes.Buffer
+	writeArchiveList(&out, listArchives("big.log", cfg), formatText)
+	if !strings.Contains(out.String(), "?") || !strings.Contains(out.String(), "wraps at 4 GiB") {
+		t.Errorf("a wrapped footer size is not marked:\n%s", out.String())
+	}
+
+	cfg.ExactSize = true
+	got := listArchives("big.log", cfg)
+	if len(got) != 1 || !got[0].OriginalExact || *got[0].OriginalSize != int64(len(log)) {
+		t.Errorf("--exact-size: %+v", got)
+	}
+}
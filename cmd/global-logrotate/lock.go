@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ============================================================
+// Run lock (LOCK_FILE)
+// ============================================================
+
+const defaultLockFile = "/var/run/global-logrotate.lock"
+
+// exitLocked is the exit status of a run that found another one holding the
+// lock, so cron wrappers can tell "skipped" from "failed".
+const exitLocked = 3
+
+// errLocked reports a lock held by another process.
+type errLocked struct {
+	path string
+	pid  int // 0 when the holder didn't record one
+}
+
+func (e *errLocked) Error() string {
+	if e.pid > 0 {
+		return fmt.Sprintf("another global-logrotate run (PID %d) holds %s", e.pid, e.path)
+	}
+	return fmt.Sprintf("another global-logrotate run holds %s", e.path)
+}
+
+// acquireRunLock takes an exclusive flock on path without waiting and records
+// our PID in it. release drops the lock; the file itself stays, since removing
+// it would let a third run lock a fresh inode while a second still holds the
+// old one. The kernel drops the lock if we die, so a crash never leaves it held.
+func acquireRunLock(path string) (release func(), err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if held, err := lockFile(f); err != nil {
+		defer f.Close()
+		if held {
+			data := make([]byte, 32)
+			n, _ := f.Read(data)
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+			return nil, &errLocked{path: path, pid: pid}
+		}
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
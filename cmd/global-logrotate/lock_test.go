@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "global-logrotate.lock")
+	release, err := acquireRunLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock is per open file, so a second open conflicts even in this process.
+	_, err = acquireRunLock(path)
+	var locked *errLocked
+	if !errors.As(err, &locked) {
+		t.Fatalf("second lock: %v, want errLocked", err)
+	}
+	if locked.pid != os.Getpid() {
+		t.Errorf("holder PID %d, want %d", locked.pid, os.Getpid())
+	}
+
+	release()
+	release, err = acquireRunLock(path)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	release()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("lock file should stay after release: %v", err)
+	}
+}
+
+func TestAcquireRunLockUnopenable(t *testing.T) {
+	_, err := acquireRunLock(filepath.Join(t.TempDir(), "missing", "x.lock"))
+	var locked *errLocked
+	if err == nil || errors.As(err, &locked) {
+		t.Errorf("err = %v, want an open error", err)
+	}
+}
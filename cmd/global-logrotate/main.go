@@ -9,13 +9,19 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,29 +29,32 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/term"
 )
 
 const (
-	version         = "2.2.0"
-	defaultDir      = "/var/log/apps"
-	defaultJobs     = 4
-	mainConfigFile  = "/etc/global-sys-utils/global.conf"
-	configDropinDir = "/etc/global-sys-utils/global.conf.d"
-	defaultLogFile  = "/var/log/global-sys-utils/global-logrotate.log"
+	version        = "2.2.0"
+	defaultDir     = "/var/log/apps"
+	defaultJobs    = 4
+	defaultLogFile = "/var/log/global-sys-utils/global-logrotate.log"
 
 	// Encryption constants
 	saltSize   = 32
 	nonceSize  = 12
-	keySize    = 32 // AES-256
-	iterations = 100000
+	keySize    = 32     // AES-256
+	iterations = 100000 // PBKDF2 default; archives before format 5 all used it
 
 	// Daemon defaults
 	defaultDiskCriticalPct = 90   // trigger emergency rotation when disk reaches this %
 	defaultDiskMinFreeMB   = 200  // refuse to write archive if less free MB than this
 	defaultDiskCheckSec    = 60   // seconds between disk checks
 	defaultPIDFile         = "/run/global-logrotate.pid"
+
+	defaultEstimateMB = 8 // --estimate compresses this much of each file
 )
 
 // Log levels
@@ -55,55 +64,194 @@ const (
 	LogLevelDebug
 )
 
-// encryptMagic identifies our encrypted file format: MAGIC(4)+SALT(32)+NONCE(12)+CIPHERTEXT
-const encryptMagicStr = "GLRE"
+// encryptMagic starts every encrypted archive we write (see encryptData for the layout).
+// Forks can rebrand at build time so their archives can't be cross-decrypted with
+// ours (or set ARCHIVE_MAGIC per deployment):
+//
+//	go build -ldflags "-X main.encryptMagicStr=ACME -X main.archiveBrand=acme-logrotate"
+var (
+	encryptMagicStr = "GLR2"
+	archiveBrand    = "global-logrotate"
+)
 
 var encryptMagic = []byte(encryptMagicStr)
 
+// Archives before format 4 started with "GLRE". They stay readable under the
+// default magic; a rebranded build or ARCHIVE_MAGIC never wrote them.
+const (
+	defaultMagic = "GLR2"
+	legacyMagic  = "GLRE"
+)
+
 // Logger handles application logging
 type Logger struct {
 	level    int
 	file     *os.File
 	filePath string
+	size     int64 // bytes in file, tracked for self-rotation
+	rotation logRotation
 	mu       sync.Mutex
 }
 
 var logger *Logger
 var cachedPassword string
+
+// mainConfigFile and configDropinDir are where the config is read from, and
+// where --pass-gen writes; --config (or GLOBAL_SYS_UTILS_CONFIG) and
+// --config-dir move them.
+var (
+	mainConfigFile  = "/etc/global-sys-utils/global.conf"
+	configDropinDir = "/etc/global-sys-utils/global.conf.d"
+)
+
+// plainOutput switches stdout to one ASCII line per event (no boxes, no
+// continuation lines) for log collectors that mangle the decorated output.
+var plainOutput bool
 var passwordMu sync.Mutex
 
+// passwordPromptOnce guards the interactive password prompt in getEncryptionPassword.
+var passwordPromptOnce sync.Once
+
+// inFlightArchives maps archive paths currently being written to the source that
+// claimed them, so two sources resolving to the same archive can't race on the
+// existence check and clobber each other under rotateParallel.
+var inFlightArchives = make(map[string]string)
+var inFlightMu sync.Mutex
+
 type Config struct {
 	LogDir          string
 	Pattern         string
+	PatternRegex    bool // match Pattern as an RE2 expression against base names instead of a glob
 	DateSuffix      string
 	DateFormat      string
+	DatePattern     string // strftime or Go layout for DateSuffix, replacing DATE_FORMAT
+	UseUTC          bool   // date archives and timestamp logs in UTC instead of the host's zone
+	Compress        string // compression codec: gzip | xz
+	CompressLevel   int    // 0-9, or defaultCompressLevel for the codec's own
+	Order           string // rotation order: size | age | name
 	OldLogsDir      string
 	ExcludeFile     string
+	ExcludePatterns string // comma-separated globs, on top of EXCLUDE_FILE
+	RetentionRules  string // "glob:age" list, e.g. "audit*.log:365d, debug*.log:7d"
+	RetentionDays   int    // age limit for archives no RETENTION_RULES rule matches (0 = keep)
+	MaxArchives     int    // archives kept per log, newest first (0 = no limit)
+	MaxTotalSize    string // cap on everything under the backup roots, e.g. "10G" ("" = none)
+	RouteRules      string // "glob:dir" list routing archives to other backup roots
+	EncryptRules    string // "glob:on|off" list overriding ENCRYPT per file
 	DryRun          bool
+	Force           bool   // --force: rotate again over an existing archive instead of skipping
+	IncrementSuffix bool   // a repeat rotation the same day writes name.DATE.1.gz, .2, ... instead of skipping
+	AssumeYes       bool   // --yes: don't ask before large destructive batches
+	ConfirmMin      int    // batches touching this many files need confirmation (0 = never)
+	Estimate        bool   // sample-compress each file and print the expected savings
+	EstimateMB      int64  // how much of each file --estimate actually compresses
+	StreamArchive   bool   // write archives to stdout as frames instead of to disk
+	Format          string // "text", or "json" for one summary document on stdout
+	Fsync           bool   // fsync archives and their directories before truncating sources
+	NoFsync         bool   // --no-fsync: turn Fsync off
+	Checksum        bool   // write <archive>.sha256 next to each archive
+	AppendOnly      bool   // lift chattr +a/+i around the truncate instead of skipping the file
+	HardlinkPolicy  string // warn | skip | rotate, for sources with more than one link
+	MinArchiveBytes int64  // refuse to truncate a source whose archive came out smaller (0 = off)
+	KeepTailLines   int    // leave this many trailing lines in the source instead of emptying it
+	ReportDir       string // write report-<runid>.json here after each run
+	MetricsFile     string // Prometheus textfile updated after each run
+	ReportKeep      int    // report files kept in ReportDir
+	EventSocket     string // Unix socket that gets a JSON line per rotated file
+	WebhookURL      string // POSTed a JSON summary after each run
+	WebhookTimeout  int    // seconds per WebhookURL attempt (0 = no limit)
+	WebhookRetries  int    // extra attempts after a failed WebhookURL POST
+	SignalPIDFile   string // after rotating, signal the process whose PID is here
+	Signal          string // signal sent to SignalPIDFile's process (default HUP)
+	PostRotate      string // shell command run after rotating (see PostRotateMode)
+	PostRotateMode  string // "file": after each rotated file; "run": once at the end
+	Upload          string // s3://bucket/prefix each new archive is uploaded to
+	UploadRegion    string // AWS region for Upload (default: from the environment)
+	UploadProfile   string // AWS shared-config profile for Upload
+	UploadDelete    bool   // remove the local archive once it is uploaded
+	SkipUnchanged   bool   // skip sources untouched since their last rotation
+	SkipIfArchived  bool   // skip sources that predate or duplicate their newest archive
+	SkipBlank       bool   // skip small sources holding nothing but whitespace
+	MinSize         string // skip sources smaller than this, e.g. "1M" ("" = rotate any non-empty file)
+	MinAge          string // skip sources modified more recently than this, e.g. "24h", "7d"
+	RateLimit       string // cap on bytes/s read from sources, all workers together, e.g. "50M"
+	MaxDepth        int    // directory levels below LogDir searched for logs (0 = no limit, 1 = LogDir only)
+	NoRecurse       bool   // --no-recurse: search LogDir itself only, as MaxDepth 1
+	StagingDir      string // write+verify archives here, truncate, then move to the backup dir
+	SplitSizeMB     int64  // write archives larger than this as .partNNN files (0 = never)
+	StateFile       string // where SKIP_UNCHANGED remembers post-rotation stats
 	Parallel        bool
 	ParallelJobs    int
+	IOThreads       int     // concurrent archive writes/truncates (0 = ParallelJobs)
+	CPUThreads      int     // concurrent compress/encrypt (0 = ParallelJobs)
+	FDFraction      float64 // share of RLIMIT_NOFILE the workers may use
 	CustomPath      bool
 	Encrypt         bool
 	EncryptPassword string
 	EncryptPassHash string
+	EncryptPassFP   string // ENCRYPT_PASSWORD_FINGERPRINT: checks LOGROTATE_PASSWORD when there is no hash
+	EncryptKeyfile  string // ENCRYPT_KEYFILE: key material from this file instead of a password
+	EncryptPubKey   string // ENCRYPT_PUBLIC_KEY: PEM RSA key new archives are sealed to instead of a password
+	PrivateKey      string // --private-key: PEM RSA key that opens archives sealed to ENCRYPT_PUBLIC_KEY
+	ArchiveMagic    string // 4-byte header magic; defaults to the build's encryptMagicStr
+	EncryptKDF      string // ENCRYPT_KDF: pbkdf2 (default) | argon2id, for new archives
+	EncryptIter     int    // ENCRYPT_ITERATIONS: PBKDF2 iterations for new archives
+	EncryptBackend  string // "aes" (built-in .enc) | "gpg" (shells out to gpg, .gpg)
+	GPGRecipient    string // comma-separated key IDs/emails for ENCRYPT_BACKEND=gpg
+	GPGBinary       string
 	ReadFile        string
+	RepairFile      string
+	FsckPath        string // --fsck: verify every archive under this dir
+	VerifyFile      string // --verify: check this archive against its .sha256 sidecar
+	FsckNoKey       bool   // with --fsck: check encrypted headers only, without a password
+	FsckJSON        bool   // with --fsck: print the results as JSON
+	ToFIFO          string // with --read: stream into this named pipe instead of stdout
+	Grep            string // with --read: print only the lines matching this RE2 pattern
+	GrepIgnoreCase  bool   // -i: match Grep regardless of case
+	Tail            int    // with --read: show only the last N lines
+	Member          string // with --read <bundle.tar>: the member to stream
+	ListMembers     bool   // with --read <bundle.tar>: list members instead
+	ReadFilter      string // with --read <dir>: "" | encrypted | plain
+	Resume          bool   // continue an interrupted bulk operation from its checkpoint
+	MigratePath     string // --migrate: convert format 1 .enc archives here to the current format
+	RekeyPath       string // --rekey: rewrap format 2 to 5 .enc archives here to the current password
+	ReencryptPath   string // --reencrypt: decrypt .enc archives here and encrypt them again under the current password
+	EncryptExisting string // --encrypt-existing: encrypt the plain archives here as they are
+	DecryptDir      string // --decrypt-dir: write the content of every archive here under Out
+	Out             string // --out: where --decrypt-dir or --read writes instead of stdout
+	List            string // --list: print the archives of the logs matching this name or glob
+	ExactSize       bool   // with --list: decompress to count original sizes the archive doesn't record exactly
+	RemovePlain     bool   // with --encrypt-existing: delete each original once encrypted
+	CheckpointDir   string // where bulk operations keep their checkpoints
+	PlainOutput     bool
 	PassGen         bool
 	PassReset       bool
 	// BackupDate is computed once at startup so all files in a run use the same date.
 	BackupDate string
 	// Logging config
-	LogFile  string
-	LogLevel int
+	LogFile     string
+	LogLevel    int
+	LogMaxMB    int  // rotate LogFile past this size (0 = never)
+	LogBackups  int  // rotated copies of LogFile kept
+	LogCompress bool // gzip rotated copies from .2 on
+	Quiet       bool // --quiet: nothing on stdout but the data asked for; errors still go to stderr
+	Verbose     bool // --verbose: mirror every log line, debug included, to stderr
 	// Daemon / scheduling
 	JobName    string // human label derived from conf.d filename
 	Daemon     bool
 	DaemonOnce bool   // run all jobs once then exit (cron/systemd-timer use case)
 	Schedule   string // cron expression or interval string (e.g. "6h", "0 2 * * *")
 	PIDFile    string
+	LockFile   string // flock'd for the length of a rotation run
+	NoLock     bool   // run without LockFile
 	// Disk safety
 	DiskCriticalPct int   // % disk used — triggers immediate rotation
 	DiskMinFreeMB   int64 // minimum free MB required to write an archive
 	DiskCheckSec    int   // interval between disk checks in daemon mode
+	// FSThreshold ("85%") makes a run a no-op until LogDir's filesystem is fuller than this.
+	FSThreshold string
+	// HookTimeout bounds each external hook command, in seconds (0 = no limit).
+	HookTimeout int
 	// Cloud backup integration (triggered by daemon after rotation or in panic mode)
 	CloudProvider       string // "aws" | "gcp" | "" (empty = disabled)
 	CloudSource         string // local directory to backup (defaults to OldLogsDir or LogDir/old_logs)
@@ -117,6 +265,18 @@ type Config struct {
 	CloudGCPCredentials string
 	CloudOnSchedule     bool // run cloud backup after every scheduled rotation
 	CloudOnPanic        bool // run cloud backup when disk reaches DISK_CRITICAL_PERCENT
+	// Hooks are optional callbacks invoked as each file is rotated.
+	Hooks *RotationHooks
+	// pools bounds the CPU and IO phases of rotateFile; set only by rotateParallel.
+	pools *workerPools
+	// state holds STATE_FILE while SKIP_UNCHANGED is on.
+	state *rotationState
+	// events is the EVENT_SOCKET connection for the current run, if any.
+	events *eventSink
+	// usage measures the current run's CPU, memory and IO.
+	usage *usageMeter
+	// limiter throttles the current run's source reads to RateLimit.
+	limiter *rateLimiter
 }
 
 // initLogger initializes the global logger
@@ -149,7 +309,8 @@ func closeLogger() {
 
 // logWrite writes a log entry. String formatting happens outside the mutex to minimize lock hold time.
 func logWrite(level int, format string, args ...interface{}) {
-	if logger == nil || level > logger.level {
+	toFile := logger != nil && level <= logger.level
+	if !toFile && verboseOut == nil {
 		return
 	}
 
@@ -167,13 +328,38 @@ func logWrite(level int, format string, args ...interface{}) {
 		fmt.Sprintf(format, args...),
 	)
 
+	if verboseOut != nil {
+		fmt.Fprint(verboseOut, line)
+	}
+	if !toFile {
+		return
+	}
 	logger.mu.Lock()
-	if _, err := logger.file.WriteString(line); err != nil {
-		fmt.Fprint(os.Stderr, line) // disk full or closed — fall back to stderr
+	if n, err := logger.file.WriteString(line); err != nil {
+		if verboseOut == nil {
+			fmt.Fprint(os.Stderr, line) // disk full or closed — fall back to stderr
+		}
+	} else {
+		logger.size += int64(n)
+	}
+	if logger.rotation.maxSize > 0 && logger.size >= logger.rotation.maxSize {
+		logger.rotateSelf()
 	}
 	logger.mu.Unlock()
 }
 
+// verboseOut, set by --verbose, receives every log line whatever the file
+// logger's level.
+var verboseOut io.Writer
+
+// silenceStdout points os.Stdout at the null device for --quiet. Writers that
+// carry requested data (jsonOut, streamOut) keep the real stdout.
+func silenceStdout() {
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+}
+
 // Convenience logging functions
 func logError(format string, args ...interface{}) {
 	logWrite(LogLevelError, format, args...)
@@ -207,20 +393,45 @@ func parseLogLevel(level string) int {
 
 // diskStats returns usage info for the filesystem containing path.
 func diskStats(path string) (totalMB, freeMB int64, usedPct float64, err error) {
-	var st syscall.Statfs_t
-	if err = syscall.Statfs(path, &st); err != nil {
+	total, free, err := diskSpace(path)
+	if err != nil {
 		return 0, 0, 0, fmt.Errorf("statfs %s: %w", path, err)
 	}
-	total := int64(st.Blocks) * int64(st.Bsize)
-	free := int64(st.Bavail) * int64(st.Bsize)
-	totalMB = total / (1024 * 1024)
-	freeMB = free / (1024 * 1024)
+	totalMB = int64(total / (1024 * 1024))
+	freeMB = int64(free / (1024 * 1024))
 	if total > 0 {
 		usedPct = float64(total-free) / float64(total) * 100
 	}
 	return
 }
 
+// parsePercent parses a usage threshold such as "85%" or "85" into 0 < pct <= 100.
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v <= 0 || v > 100 {
+		return 0, fmt.Errorf("invalid percentage %q (want 1-100, e.g. 85%%)", s)
+	}
+	return v, nil
+}
+
+// belowFSThreshold reports whether the filesystem holding cfg.LogDir is still
+// under FS_USAGE_THRESHOLD, i.e. whether a threshold-gated run has nothing to do.
+func belowFSThreshold(cfg *Config) (bool, error) {
+	if cfg.FSThreshold == "" {
+		return false, nil
+	}
+	threshold, err := parsePercent(cfg.FSThreshold)
+	if err != nil {
+		return false, err
+	}
+	_, freeMB, usedPct, err := diskStats(cfg.LogDir)
+	if err != nil {
+		return false, err
+	}
+	logInfo("Filesystem usage for %s: %.1f%% (threshold %.1f%%, %d MB free)", cfg.LogDir, usedPct, threshold, freeMB)
+	return usedPct < threshold, nil
+}
+
 // ============================================================
 // Schedule parsing — cron expressions and interval strings
 // ============================================================
@@ -407,21 +618,88 @@ func buildConfig(fc map[string]string) *Config {
 	cfg := &Config{
 		LogDir:          getConfigDefault(fc, "LOG_DIR", defaultDir),
 		Pattern:         getConfigDefault(fc, "PATTERN", "*.log"),
+		PatternRegex:    getConfigDefaultBool(fc, "PATTERN_REGEX", false),
 		ParallelJobs:    getConfigDefaultInt(fc, "PARALLEL_JOBS", defaultJobs),
+		IOThreads:       getConfigDefaultInt(fc, "IO_THREADS", 0),
+		CPUThreads:      getConfigDefaultInt(fc, "CPU_THREADS", 0),
+		FDFraction:      getConfigDefaultFloat(fc, "FD_SAFETY_FRACTION", defaultFDFraction),
 		OldLogsDir:      getConfigDefault(fc, "OLD_LOGS_DIR", ""),
 		ExcludeFile:     getConfigDefault(fc, "EXCLUDE_FILE", ""),
+		ExcludePatterns: getConfigDefault(fc, "EXCLUDE_PATTERNS", ""),
+		RetentionRules:  getConfigDefault(fc, "RETENTION_RULES", ""),
+		RetentionDays:   getConfigDefaultInt(fc, "RETENTION_DAYS", 0),
+		MaxArchives:     getConfigDefaultInt(fc, "MAX_ARCHIVES", 0),
+		MaxTotalSize:    getConfigDefault(fc, "MAX_TOTAL_SIZE", ""),
+		RouteRules:      getConfigDefault(fc, "ROUTE_RULES", ""),
+		EncryptRules:    getConfigDefault(fc, "ENCRYPT_RULES", ""),
 		DateFormat:      getConfigDefault(fc, "DATE_FORMAT", "date"),
+		DatePattern:     getConfigDefault(fc, "DATE_PATTERN", ""),
+		UseUTC:          getConfigDefaultBool(fc, "USE_UTC", false),
+		Compress:        strings.ToLower(getConfigDefault(fc, "COMPRESS", "gzip")),
+		CompressLevel:   getConfigDefaultInt(fc, "COMPRESS_LEVEL", defaultCompressLevel),
+		Order:           strings.ToLower(getConfigDefault(fc, "ORDER", orderSize)),
 		DryRun:          getConfigDefaultBool(fc, "DRY_RUN", false),
+		ConfirmMin:      getConfigDefaultInt(fc, "CONFIRM_THRESHOLD", defaultConfirmMin),
+		Fsync:           getConfigDefaultBool(fc, "FSYNC", true),
+		Checksum:        getConfigDefaultBool(fc, "CHECKSUM", false),
+		AppendOnly:      getConfigDefaultBool(fc, "HANDLE_APPEND_ONLY", false),
+		HardlinkPolicy:  getConfigDefault(fc, "HARDLINK_POLICY", hardlinkWarn),
+		MinArchiveBytes: int64(getConfigDefaultInt(fc, "MIN_ARCHIVE_BYTES", 0)),
+		KeepTailLines:   getConfigDefaultInt(fc, "KEEP_TAIL_LINES", 0),
+		ReportDir:       getConfigDefault(fc, "REPORT_DIR", ""),
+		ReportKeep:      getConfigDefaultInt(fc, "REPORT_KEEP", defaultReportKeep),
+		EventSocket:     getConfigDefault(fc, "EVENT_SOCKET", ""),
+		WebhookURL:      getConfigDefault(fc, "WEBHOOK_URL", ""),
+		MetricsFile:     getConfigDefault(fc, "METRICS_FILE", ""),
+		WebhookTimeout:  getConfigDefaultInt(fc, "WEBHOOK_TIMEOUT", defaultWebhookTimeout),
+		WebhookRetries:  getConfigDefaultInt(fc, "WEBHOOK_RETRIES", defaultWebhookRetries),
+		SignalPIDFile:   getConfigDefault(fc, "SIGNAL_PIDFILE", ""),
+		Signal:          getConfigDefault(fc, "SIGNAL", defaultReloadSignal),
+		PostRotate:      getConfigDefault(fc, "POST_ROTATE", ""),
+		PostRotateMode:  getConfigDefault(fc, "POST_ROTATE_MODE", postRotateFile),
+		Upload:          getConfigDefault(fc, "UPLOAD", ""),
+		UploadRegion:    getConfigDefault(fc, "UPLOAD_REGION", ""),
+		UploadProfile:   getConfigDefault(fc, "UPLOAD_PROFILE", ""),
+		UploadDelete:    getConfigDefaultBool(fc, "UPLOAD_DELETE_LOCAL", false),
+		SkipUnchanged:   getConfigDefaultBool(fc, "SKIP_UNCHANGED", false),
+		SkipIfArchived:  getConfigDefaultBool(fc, "SKIP_IF_ARCHIVED", false),
+		IncrementSuffix: getConfigDefaultBool(fc, "INCREMENT_SUFFIX", false),
+		SkipBlank:       getConfigDefaultBool(fc, "SKIP_WHITESPACE_ONLY", false),
+		MinSize:         getConfigDefault(fc, "MIN_SIZE", ""),
+		MinAge:          getConfigDefault(fc, "MIN_AGE", ""),
+		RateLimit:       getConfigDefault(fc, "RATE_LIMIT", ""),
+		MaxDepth:        getConfigDefaultInt(fc, "MAX_DEPTH", 0),
+		StagingDir:      getConfigDefault(fc, "STAGING_DIR", ""),
+		SplitSizeMB:     int64(getConfigDefaultInt(fc, "SPLIT_SIZE_MB", 0)),
+		StateFile:       getConfigDefault(fc, "STATE_FILE", defaultStateFile),
+		CheckpointDir:   getConfigDefault(fc, "CHECKPOINT_DIR", defaultCheckpointDir),
+		EstimateMB:      int64(getConfigDefaultInt(fc, "ESTIMATE_SAMPLE_MB", defaultEstimateMB)),
+		PlainOutput:     getConfigDefaultBool(fc, "PLAIN_OUTPUT", false),
 		Encrypt:         getConfigDefaultBool(fc, "ENCRYPT", false),
 		EncryptPassword: getConfigDefault(fc, "ENCRYPT_PASSWORD", ""),
 		EncryptPassHash: getConfigDefault(fc, "ENCRYPT_PASSWORD_HASH", ""),
+		EncryptPassFP:   strings.ToLower(getConfigDefault(fc, "ENCRYPT_PASSWORD_FINGERPRINT", "")),
+		EncryptKeyfile:  getConfigDefault(fc, "ENCRYPT_KEYFILE", ""),
+		EncryptPubKey:   getConfigDefault(fc, "ENCRYPT_PUBLIC_KEY", ""),
+		ArchiveMagic:    getConfigDefault(fc, "ARCHIVE_MAGIC", encryptMagicStr),
+		EncryptKDF:      strings.ToLower(getConfigDefault(fc, "ENCRYPT_KDF", "pbkdf2")),
+		EncryptIter:     getConfigDefaultInt(fc, "ENCRYPT_ITERATIONS", iterations),
+		EncryptBackend:  strings.ToLower(getConfigDefault(fc, "ENCRYPT_BACKEND", backendAES)),
+		GPGRecipient:    getConfigDefault(fc, "GPG_RECIPIENT", ""),
+		GPGBinary:       getConfigDefault(fc, "GPG_BINARY", "gpg"),
 		LogFile:         getConfigDefault(fc, "LOG_FILE", defaultLogFile),
 		LogLevel:        parseLogLevel(getConfigDefault(fc, "LOG_LEVEL", "info")),
+		LogMaxMB:        getConfigDefaultInt(fc, "LOG_MAX_SIZE_MB", 0),
+		LogBackups:      getConfigDefaultInt(fc, "LOG_BACKUPS", defaultLogBackups),
+		LogCompress:     getConfigDefaultBool(fc, "LOG_COMPRESS", false),
 		Schedule:        getConfigDefault(fc, "SCHEDULE", ""),
 		PIDFile:         getConfigDefault(fc, "PID_FILE", defaultPIDFile),
+		LockFile:        getConfigDefault(fc, "LOCK_FILE", defaultLockFile),
 		DiskCriticalPct: getConfigDefaultInt(fc, "DISK_CRITICAL_PERCENT", defaultDiskCriticalPct),
 		DiskMinFreeMB:   int64(getConfigDefaultInt(fc, "DISK_MIN_FREE_MB", defaultDiskMinFreeMB)),
 		DiskCheckSec:    getConfigDefaultInt(fc, "DISK_CHECK_INTERVAL", defaultDiskCheckSec),
+		FSThreshold:     getConfigDefault(fc, "FS_USAGE_THRESHOLD", ""),
+		HookTimeout:     getConfigDefaultInt(fc, "HOOK_TIMEOUT", 0),
 		// Cloud backup
 		CloudProvider:       getConfigDefault(fc, "CLOUD_PROVIDER", ""),
 		CloudSource:         getConfigDefault(fc, "CLOUD_SOURCE", ""),
@@ -436,11 +714,9 @@ func buildConfig(fc map[string]string) *Config {
 		CloudOnSchedule:     getConfigDefaultBool(fc, "CLOUD_BACKUP_ON_SCHEDULE", false),
 		CloudOnPanic:        getConfigDefaultBool(fc, "CLOUD_BACKUP_ON_PANIC", false),
 	}
-	cfg.Parallel = cfg.ParallelJobs > 1
+	cfg.Parallel = cfg.ParallelJobs > 1 || cfg.IOThreads > 1 || cfg.CPUThreads > 1
 	cfg.LogDir = strings.TrimSuffix(cfg.LogDir, "/")
-	now := time.Now()
-	cfg.DateSuffix = now.Format("20060102")
-	cfg.BackupDate = cfg.DateSuffix
+	setRunDate(cfg, time.Now(), dateLayout)
 	// Default cloud source to the old_logs directory for this job.
 	if cfg.CloudSource == "" {
 		if cfg.OldLogsDir != "" {
@@ -476,6 +752,9 @@ func loadJobConfigs() []*Config {
 		}
 		loadConfigFile(f, fc)
 		job := buildConfig(fc)
+		if policy, _ := loadTreePolicy(job.LogDir); policy != nil {
+			job = buildConfig(mergeConfig(fc, policy))
+		}
 		job.JobName = strings.TrimSuffix(filepath.Base(f), ".conf")
 		jobs = append(jobs, job)
 	}
@@ -536,12 +815,16 @@ func runCloudBackup(cfg *Config, emergency bool) {
 	if emergency {
 		mode = "PANIC"
 	}
+	if cfg.DryRun {
+		plan(planCommand, "Would run %s cloud backup: %s %s", mode, prog, strings.Join(args, " "))
+		return
+	}
 	logInfo("Job [%s]: starting %s cloud backup (%s) → %s", cfg.JobName, mode, prog, cfg.CloudDestination)
 
 	cmd := exec.Command(prog, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := runHookCommand(cmd, time.Duration(cfg.HookTimeout)*time.Second); err != nil {
 		logError("Job [%s]: cloud backup failed: %v", cfg.JobName, err)
 	} else {
 		logInfo("Job [%s]: cloud backup completed", cfg.JobName)
@@ -582,6 +865,8 @@ func runDaemon(jobs []*Config, once bool) {
 			continue
 		}
 		nr, _ := nextRunTime(cfg.Schedule, time.Now())
+		// Scheduled jobs run unattended; their config is the confirmation.
+		cfg.AssumeYes = true
 		djobs = append(djobs, &daemonJob{cfg: cfg, nextRun: nr})
 		logInfo("Job [%s] dir=%s  schedule=%q  next=%s",
 			cfg.JobName, cfg.LogDir, cfg.Schedule, nr.Format("2006-01-02 15:04:05"))
@@ -618,8 +903,7 @@ func runDaemon(jobs []*Config, once bool) {
 
 		case cfg := <-diskAlert:
 			logError("DISK CRITICAL on %s — triggering emergency rotation + cloud panic backup", cfg.LogDir)
-			cfg.DateSuffix = time.Now().Format("20060102")
-			cfg.BackupDate = cfg.DateSuffix
+			setRunDate(cfg, time.Now(), dateLayout)
 			executeJob(cfg, true) // emergency=true → triggers CLOUD_BACKUP_ON_PANIC if set
 			// Reset that job's next-run after emergency rotation.
 			for _, dj := range djobs {
@@ -636,8 +920,7 @@ func runDaemon(jobs []*Config, once bool) {
 					continue
 				}
 				logInfo("Running scheduled job [%s]", dj.cfg.LogDir)
-				dj.cfg.DateSuffix = now.Format("20060102")
-				dj.cfg.BackupDate = dj.cfg.DateSuffix
+				setRunDate(dj.cfg, now, dateLayout)
 				executeJob(dj.cfg, false)
 				nr, err := nextRunTime(dj.cfg.Schedule, now)
 				if err != nil {
@@ -654,18 +937,88 @@ func runDaemon(jobs []*Config, once bool) {
 // executeJob runs a rotation job and optionally triggers cloud backup after.
 // emergency=true means the job was triggered by disk pressure (panic mode).
 func executeJob(cfg *Config, emergency bool) {
-	excludePatterns := loadExcludePatterns(cfg.ExcludeFile)
-	files := findLogFiles(cfg.LogDir, cfg.Pattern, excludePatterns)
+	if !validCompressLevel(cfg.CompressLevel) {
+		logError("Job [%s]: COMPRESS_LEVEL %d is out of range (0-9), using the default", cfg.JobName, cfg.CompressLevel)
+		cfg.CompressLevel = defaultCompressLevel
+	}
+	if _, err := parseSize(cfg.MinSize); cfg.MinSize != "" && err != nil {
+		logError("Job [%s]: MIN_SIZE: %v; rotating files of any size", cfg.JobName, err)
+		cfg.MinSize = ""
+	}
+	if _, err := parseInterval(cfg.MinAge); cfg.MinAge != "" && err != nil {
+		logError("Job [%s]: MIN_AGE: %v; rotating files of any age", cfg.JobName, err)
+		cfg.MinAge = ""
+	}
+	if _, err := formatDatePattern(cfg.DatePattern, time.Now()); cfg.DatePattern != "" && err != nil {
+		logError("Job [%s]: DATE_PATTERN %v; using YYYYMMDD", cfg.JobName, err)
+		cfg.DatePattern = ""
+	}
+	if n, err := parseSize(cfg.RateLimit); cfg.RateLimit != "" && (err != nil || n <= 0) {
+		logError("Job [%s]: RATE_LIMIT %q is not a positive size; reading unthrottled", cfg.JobName, cfg.RateLimit)
+		cfg.RateLimit = ""
+	}
+	if cfg.MaxDepth < 0 {
+		logError("Job [%s]: MAX_DEPTH %d is negative; searching the whole tree", cfg.JobName, cfg.MaxDepth)
+		cfg.MaxDepth = 0
+	}
+	if _, err := compilePattern(cfg.Pattern); cfg.PatternRegex && err != nil {
+		logError("Job [%s]: PATTERN: %v; skipped", cfg.JobName, err)
+		return
+	}
+	if cfg.PostRotateMode != postRotateFile && cfg.PostRotateMode != postRotateRun {
+		logError("Job [%s]: POST_ROTATE_MODE %q is not file or run, using file", cfg.JobName, cfg.PostRotateMode)
+		cfg.PostRotateMode = postRotateFile
+	}
+	if _, _, err := parseS3URL(cfg.Upload); cfg.Upload != "" && err != nil {
+		logError("Job [%s]: UPLOAD: %v; not uploading", cfg.JobName, err)
+		cfg.Upload = ""
+	}
+	if release, err := acquireRunLock(cfg.LockFile); err != nil {
+		var locked *errLocked
+		if errors.As(err, &locked) {
+			logError("Job [%s]: skipped: %v", cfg.JobName, err)
+			return
+		}
+		logError("Job [%s]: could not open lock file %s, running without it: %v", cfg.JobName, cfg.LockFile, err)
+	} else {
+		defer release()
+	}
+	if cfg.DryRun {
+		defer printDryRunPlan()
+	}
+	excludePatterns := append(loadExcludePatterns(cfg.ExcludeFile), splitPatternList(cfg.ExcludePatterns)...)
+	files := findLogFiles(cfg.LogDir, cfg.Pattern, excludePatterns, minAgeDuration(cfg), walkScopeFor(cfg))
+	orderLogFiles(files, cfg.Order)
 	if len(files) == 0 {
 		logInfo("Job [%s]: no files found in %s", cfg.JobName, cfg.LogDir)
+		applyRetention(cfg)
+		saveMetrics(cfg, nil)
 		return
 	}
 	logInfo("Job [%s]: rotating %d file(s) in %s (emergency=%v)", cfg.JobName, len(files), cfg.LogDir, emergency)
+	started := time.Now()
+	startUsage(cfg)
+	startRateLimit(cfg)
+	openRotationState(cfg)
+	openEventSocket(cfg, started)
+	var results []FileResult
 	if cfg.Parallel {
-		rotateParallel(files, cfg)
+		results = rotateParallel(files, cfg)
 	} else {
-		rotateSequential(files, cfg)
-	}
+		results = rotateSequential(files, cfg)
+	}
+	saveRotationState(cfg)
+	postRotateRunHook(cfg, results)
+	logStatusSummary(results)
+	logUploadSummary(cfg, results)
+	logTimingSummary(results)
+	logResourceUsage(cfg)
+	saveRunReport(cfg, started, results)
+	closeEventSocket(cfg, results)
+	signalAfterRotation(cfg, results)
+	applyRetention(cfg)
+	notifyWebhook(cfg, started, results)
+	saveMetrics(cfg, results)
 	runCloudBackup(cfg, emergency)
 }
 
@@ -712,9 +1065,15 @@ func monitorDisk(jobs []*daemonJob, alert chan<- *Config, stop <-chan os.Signal)
 
 func main() {
 	cfg := parseFlags()
+	if cfg.Verbose {
+		verboseOut = os.Stderr
+	}
 
 	// Daemon mode: load all job configs and run the scheduling loop.
 	if cfg.Daemon || cfg.DaemonOnce {
+		if cfg.Quiet {
+			silenceStdout()
+		}
 		jobs := loadJobConfigs()
 		if len(jobs) == 0 {
 			fmt.Fprintln(os.Stderr, "Error: no jobs found in config (add SCHEDULE to global.conf or conf.d files)")
@@ -724,6 +1083,7 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Warning: Could not initialize logging: %v\n", err)
 		} else {
 			defer closeLogger()
+			setLogRotation(jobs[0].LogMaxMB, jobs[0].LogBackups, jobs[0].LogCompress)
 		}
 		runDaemon(jobs, cfg.DaemonOnce)
 		return
@@ -735,6 +1095,7 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Warning: Could not initialize logging: %v\n", err)
 		} else {
 			defer closeLogger()
+			setLogRotation(cfg.LogMaxMB, cfg.LogBackups, cfg.LogCompress)
 			logInfo("global-logrotate v%s started", version)
 			logDebug("Log level: %d, Log file: %s", cfg.LogLevel, cfg.LogFile)
 		}
@@ -752,7 +1113,101 @@ func main() {
 		return
 	}
 
+	// Handle --repair mode
+	if cfg.RepairFile != "" {
+		if err := repairArchive(cfg.RepairFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error repairing file: %v\n", err)
+			logError("Repair of %s failed: %v", cfg.RepairFile, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle --fsck
+	if cfg.FsckPath != "" {
+		code, err := runFsck(cfg.FsckPath, cfg, cfg.FsckNoKey, cfg.FsckJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			logError("fsck of %s failed: %v", cfg.FsckPath, err)
+			os.Exit(1)
+		}
+		os.Exit(code)
+	}
+
+	// Handle --verify
+	if cfg.VerifyFile != "" {
+		os.Exit(runVerify([]string{cfg.VerifyFile}, cfg))
+	}
+
+	// Handle --list
+	if cfg.List != "" {
+		if err := runList(cfg.List, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle --decrypt-dir
+	if cfg.DecryptDir != "" {
+		failed, err := runDecryptDir(cfg.DecryptDir, cfg.Out, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			logError("--decrypt-dir of %s failed: %v", cfg.DecryptDir, err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle --migrate, --rekey, --reencrypt and --encrypt-existing
+	if cfg.MigratePath != "" || cfg.RekeyPath != "" || cfg.ReencryptPath != "" || cfg.EncryptExisting != "" {
+		var failed int
+		var err error
+		switch {
+		case cfg.MigratePath != "":
+			failed, err = runMigrate(cfg.MigratePath, cfg)
+		case cfg.RekeyPath != "":
+			failed, err = runRekey(cfg.RekeyPath, cfg)
+		case cfg.ReencryptPath != "":
+			failed, err = runReencrypt(cfg.ReencryptPath, cfg)
+		default:
+			failed, err = runEncryptExisting(cfg.EncryptExisting, cfg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			logError("%v", err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle --read mode
+	if cfg.ReadFile != "" && cfg.ToFIFO != "" {
+		if err := streamToFIFO(cfg.ToFIFO, cfg.ReadFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.ReadFile != "" && cfg.Grep != "" {
+		os.Exit(runGrep(os.Stdout, cfg.ReadFile, cfg))
+	}
+
+	if cfg.ReadFile != "" && (cfg.ListMembers || (cfg.Member == "" && isBundle(cfg.ReadFile))) {
+		if err := listBundleMembers(os.Stdout, cfg.ReadFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading bundle: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if cfg.ReadFile != "" {
 		if err := readLogFile(cfg.ReadFile, cfg); err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
@@ -761,6 +1216,33 @@ func main() {
 		return
 	}
 
+	// Long rotations report progress to stderr, but only to someone watching
+	// the human-readable output on a terminal.
+	if cfg.Format != formatJSON && !cfg.StreamArchive && !cfg.Quiet && term.IsTerminal(int(os.Stdout.Fd())) {
+		progressOut = os.Stderr
+	}
+	// --stream-archive: stdout carries frames only, everything else goes to
+	// stderr. A consumer that goes away fails the write instead of killing us
+	// with SIGPIPE, so files not yet framed are left untouched.
+	if cfg.StreamArchive && !cfg.DryRun && !cfg.Estimate {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			fmt.Fprintln(os.Stderr, "Error: --stream-archive writes binary frames; pipe stdout into a consumer")
+			os.Exit(1)
+		}
+		signal.Ignore(syscall.SIGPIPE)
+		streamOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	// --format json: stdout carries the summary document only.
+	if cfg.Format == formatJSON {
+		jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	// --quiet: only errors, on stderr, for silence on success under cron.
+	if cfg.Quiet {
+		silenceStdout()
+	}
+
 	if cfg.CustomPath {
 		if info, err := os.Stat(cfg.LogDir); err != nil || !info.IsDir() {
 			fmt.Fprintf(os.Stderr, "Error: Custom log path '%s' does not exist.\n", cfg.LogDir)
@@ -770,8 +1252,17 @@ func main() {
 	}
 
 	// Validate encryption settings
-	if cfg.Encrypt {
-		if cfg.EncryptPassword == "" && cfg.EncryptPassHash == "" {
+	encrypting := encryptionUsed(cfg)
+	if encrypting && cfg.EncryptBackend == backendGPG {
+		if strings.TrimSpace(cfg.GPGRecipient) == "" {
+			fmt.Fprintln(os.Stderr, "Error: ENCRYPT_BACKEND=gpg requires GPG_RECIPIENT to be configured")
+			logError("GPG encryption requested but no recipient configured")
+			os.Exit(1)
+		}
+	} else if encrypting && archivePublicKey != nil {
+		logInfo("Encrypting to public key %x (ENCRYPT_PUBLIC_KEY); no password needed", publicKeyID(archivePublicKey))
+	} else if encrypting {
+		if cfg.EncryptPassword == "" && cfg.EncryptPassHash == "" && cfg.EncryptKeyfile == "" && os.Getenv("LOGROTATE_PASSWORD") == "" {
 			fmt.Fprintln(os.Stderr, "Error: --encrypt requires password to be configured")
 			fmt.Fprintln(os.Stderr, "")
 			fmt.Fprintln(os.Stderr, "First-time setup required! Run:")
@@ -782,32 +1273,112 @@ func main() {
 			logError("Encryption requested but no password configured")
 			os.Exit(1)
 		}
+		// Resolve (and verify against the hash) the password now, before any file
+		// is touched or any parallel worker starts, so a bad hash or unreadable
+		// credentials file fails the run up front instead of file by file.
+		if !cfg.DryRun && !cfg.Estimate {
+			if err := resolveEncryptionPassword(cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				logError("%v", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if below, err := belowFSThreshold(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		logError("FS usage check failed: %v", err)
+		os.Exit(1)
+	} else if below {
+		fmt.Printf("Filesystem usage for %s is below %s, nothing to rotate\n", cfg.LogDir, cfg.FSThreshold)
+		printRunSummary(cfg, time.Now(), nil)
+		os.Exit(0)
+	}
+
+	// Two runs rotating the same files corrupt each other's archives; the
+	// second one bows out. Dry runs and estimates change nothing and don't lock.
+	if !cfg.NoLock && !cfg.DryRun && !cfg.Estimate {
+		release, err := acquireRunLock(cfg.LockFile)
+		var locked *errLocked
+		switch {
+		case errors.As(err, &locked):
+			fmt.Fprintf(os.Stderr, "Error: %v; not rotating (use --no-lock to override)\n", err)
+			logError("Not rotating: %v", err)
+			os.Exit(exitLocked)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: running without a lock: %v\n", err)
+			logError("Could not open lock file %s, running without it: %v", cfg.LockFile, err)
+		default:
+			defer release()
+		}
 	}
 
 	logInfo("Starting rotation - Dir: %s, Pattern: %s, Encrypt: %v, DryRun: %v",
 		cfg.LogDir, cfg.Pattern, cfg.Encrypt, cfg.DryRun)
 
-	excludePatterns := loadExcludePatterns(cfg.ExcludeFile)
-	logFiles := findLogFiles(cfg.LogDir, cfg.Pattern, excludePatterns)
+	excludePatterns := append(loadExcludePatterns(cfg.ExcludeFile), splitPatternList(cfg.ExcludePatterns)...)
+	logFiles := findLogFiles(cfg.LogDir, cfg.Pattern, excludePatterns, minAgeDuration(cfg), walkScopeFor(cfg))
+	orderLogFiles(logFiles, cfg.Order)
 
 	if len(logFiles) == 0 {
 		fmt.Printf("No files matching pattern '%s' found in %s\n", cfg.Pattern, cfg.LogDir)
 		logInfo("No files matching pattern '%s' found in %s", cfg.Pattern, cfg.LogDir)
+		if !cfg.Estimate {
+			applyRetention(cfg)
+			saveMetrics(cfg, nil)
+		}
+		printRunSummary(cfg, time.Now(), nil)
+		if cfg.DryRun && !cfg.Estimate {
+			printDryRunPlan()
+		}
 		os.Exit(0)
 	}
 
 	logInfo("Found %d files to rotate", len(logFiles))
 	logDebug("Files: %v", logFiles)
 
+	if cfg.Estimate {
+		runEstimate(logFiles, cfg)
+		return
+	}
+
+	started := time.Now()
+	startUsage(cfg)
+	startRateLimit(cfg)
+	openRotationState(cfg)
+	openEventSocket(cfg, started)
+	var results []FileResult
 	if cfg.Parallel {
-		logDebug("Using parallel rotation with %d jobs", cfg.ParallelJobs)
-		rotateParallel(logFiles, cfg)
+		ioN, cpuN := poolSizes(cfg)
+		logDebug("Using parallel rotation with %d IO / %d CPU slots", ioN, cpuN)
+		results = rotateParallel(logFiles, cfg)
 	} else {
 		logDebug("Using sequential rotation")
-		rotateSequential(logFiles, cfg)
+		results = rotateSequential(logFiles, cfg)
+	}
+	saveRotationState(cfg)
+	postRotateRunHook(cfg, results)
+	logStatusSummary(results)
+	logUploadSummary(cfg, results)
+	logTimingSummary(results)
+	logResourceUsage(cfg)
+	saveRunReport(cfg, started, results)
+	closeEventSocket(cfg, results)
+	signalAfterRotation(cfg, results)
+
+	applyRetention(cfg)
+	notifyWebhook(cfg, started, results)
+	saveMetrics(cfg, results)
+	printRunSummary(cfg, started, results)
+	if cfg.DryRun {
+		printDryRunPlan()
 	}
 
 	logInfo("Rotation completed")
+	if n := failedCount(results); n > 0 {
+		logError("%d file(s) failed to rotate; exiting with status %d", n, exitFailures)
+		os.Exit(exitFailures)
+	}
 }
 
 func generatePassword() {
@@ -882,14 +1453,20 @@ func generatePassword() {
 	maskedPassword := maskPassword(password)
 
 	fmt.Println()
-	fmt.Println("╔══════════════════════════════════════════════════════════════════╗")
-	fmt.Println("║                    PASSWORD SETUP COMPLETE                       ║")
-	fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
-	fmt.Printf("║  Password: %-54s ║\n", maskedPassword)
-	fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
-	fmt.Println("║  Password saved to credentials file. No need to enter it again. ║")
-	fmt.Println("║  Keep your credentials file secure!                             ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+	if plainOutput {
+		fmt.Println("Password setup complete")
+		fmt.Printf("Password: %s\n", maskedPassword)
+		fmt.Println("Password saved to credentials file. No need to enter it again. Keep your credentials file secure!")
+	} else {
+		fmt.Println("╔══════════════════════════════════════════════════════════════════╗")
+		fmt.Println("║                    PASSWORD SETUP COMPLETE                       ║")
+		fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
+		fmt.Printf("║  Password: %-54s ║\n", maskedPassword)
+		fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
+		fmt.Println("║  Password saved to credentials file. No need to enter it again. ║")
+		fmt.Println("║  Keep your credentials file secure!                             ║")
+		fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+	}
 	fmt.Println()
 	fmt.Println("Password stored in:")
 	fmt.Printf("  %s\n", getUserCredentialsFile())
@@ -1086,16 +1663,24 @@ func resetPassword() {
 	maskedPassword := maskPassword(newPassword)
 
 	fmt.Println()
-	fmt.Println("╔══════════════════════════════════════════════════════════════════╗")
-	fmt.Println("║                    PASSWORD RESET COMPLETE                       ║")
-	fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
-	fmt.Printf("║  New Password: %-50s ║\n", maskedPassword)
-	fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
-	fmt.Println("║  WARNING: Previously encrypted files will still need the OLD    ║")
-	fmt.Println("║  password to decrypt. Only new files will use this password.    ║")
-	fmt.Println("║                                                                  ║")
-	fmt.Println("║  Password saved to credentials file. No need to enter it again. ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+	if plainOutput {
+		fmt.Println("Password reset complete")
+		fmt.Printf("New Password: %s\n", maskedPassword)
+		fmt.Println("WARNING: Previously encrypted files still need the OLD password. Move them to the new one with --rekey <dir> or --reencrypt <dir>.")
+		fmt.Println("Password saved to credentials file. No need to enter it again.")
+	} else {
+		fmt.Println("╔══════════════════════════════════════════════════════════════════╗")
+		fmt.Println("║                    PASSWORD RESET COMPLETE                       ║")
+		fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
+		fmt.Printf("║  New Password: %-50s ║\n", maskedPassword)
+		fmt.Println("╠══════════════════════════════════════════════════════════════════╣")
+		fmt.Println("║  WARNING: Previously encrypted files still need the OLD         ║")
+		fmt.Println("║  password. Move them to the new one with --rekey <dir> or       ║")
+		fmt.Println("║  --reencrypt <dir>.                                             ║")
+		fmt.Println("║                                                                  ║")
+		fmt.Println("║  Password saved to credentials file. No need to enter it again. ║")
+		fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+	}
 	fmt.Println()
 	fmt.Println("Password stored in:")
 	fmt.Printf("  %s\n", getUserCredentialsFile())
@@ -1136,23 +1721,90 @@ ENCRYPT_PASSWORD_HASH = %s
 	return os.WriteFile(configPath, []byte(content), 0600)
 }
 
+// setConfigPaths points mainConfigFile and configDropinDir at the file named
+// by --config, or else GLOBAL_SYS_UTILS_CONFIG, and the directory named by
+// --config-dir. It reads them straight from args because the config has to be
+// loaded before the flags are parsed: it supplies their defaults. A config file
+// given without --config-dir takes its drop-ins from "<file>.d", as
+// global.conf does, so the hard-coded paths are never read.
+func setConfigPaths(args []string) error {
+	file, dir := os.Getenv("GLOBAL_SYS_UTILS_CONFIG"), ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		// Anything else, other flags' values included, is passed over.
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !strings.HasPrefix(arg, "-") || (name != "config" && name != "config-dir") {
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				break // flag.Parse reports the missing value
+			}
+			i++
+			value = args[i]
+		}
+		if name == "config" {
+			file = value
+		} else {
+			dir = value
+		}
+	}
+
+	if file != "" {
+		if info, err := os.Stat(file); err != nil {
+			return fmt.Errorf("config file: %w", err)
+		} else if info.IsDir() {
+			return fmt.Errorf("config file %s is a directory (use --config-dir for drop-ins)", file)
+		}
+		mainConfigFile = file
+		configDropinDir = file + ".d"
+	}
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("config dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("config dir %s is not a directory", dir)
+		}
+		configDropinDir = dir
+	}
+	return nil
+}
+
 func loadConfigFiles() map[string]string {
 	config := make(map[string]string)
 
 	// Load main config
-	loadConfigFile(mainConfigFile, config)
+	loadTracedConfigFile(mainConfigFile, config)
 
 	// Load drop-in configs (sorted for predictable order)
 	if files, err := filepath.Glob(filepath.Join(configDropinDir, "*.conf")); err == nil {
 		sort.Strings(files)
 		for _, f := range files {
-			loadConfigFile(f, config)
+			loadTracedConfigFile(f, config)
 		}
 	}
 
 	return config
 }
 
+// loadTracedConfigFile is loadConfigFile that also tells an active configTrace
+// which keys path set.
+func loadTracedConfigFile(path string, config map[string]string) {
+	if configTrace == nil {
+		loadConfigFile(path, config)
+		return
+	}
+	fileConfig := make(map[string]string)
+	loadConfigFile(path, fileConfig)
+	configTrace.noteFile(path, fileConfig)
+	for k, v := range fileConfig {
+		config[k] = v
+	}
+}
+
 func loadConfigFile(path string, config map[string]string) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -1176,37 +1828,173 @@ func loadConfigFile(path string, config map[string]string) {
 }
 
 func parseFlags() *Config {
+	if err := setConfigPaths(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	configTrace = newConfigTracer()
 	fileConfig := loadConfigFiles()
 	cfg := buildConfig(fileConfig)
+	trace := configTrace
+	configTrace = nil
 
 	var useFullTime, useDateOnly, showVersion, showHelp, enableEncrypt bool
-	var readFile string
+	var versionJSON bool
+	var onlyEncrypted, onlyPlain bool
+	var readFile, repairFile string
 	var passGen, passReset bool
 	var logLevel string
+	var traceConfig bool
+	var selfTest bool
+	var traceFormat string
+	var configFile, configDir string
 
 	flag.BoolVar(&useFullTime, "H", false, "Use full timestamp format (YYYYMMDDTHH:MM:SS)")
 	flag.BoolVar(&useDateOnly, "D", false, "Use date-only format (YYYYMMDD)")
+	flag.BoolVar(&cfg.UseUTC, "utc", cfg.UseUTC, "Date archive names and backup dirs, and timestamp log lines, in UTC")
+	flag.StringVar(&cfg.DatePattern, "date-pattern", cfg.DatePattern, "Date in archive names as a strftime (%Y%m%d-%H%M) or Go (20060102-1504) layout")
 	flag.StringVar(&cfg.Pattern, "pattern", cfg.Pattern, "File pattern to rotate")
+	flag.BoolVar(&cfg.PatternRegex, "regex", cfg.PatternRegex, "Match --pattern as an RE2 regular expression against base names")
 	flag.StringVar(&cfg.LogDir, "p", cfg.LogDir, "Specify custom log directory")
 	flag.BoolVar(&cfg.DryRun, "n", cfg.DryRun, "Dry-run mode (no changes made)")
+	flag.BoolVar(&cfg.Force, "force", false, "Rotate files whose archive for the date already exists, overwriting it")
+	flag.BoolVar(&cfg.Estimate, "estimate", false, "Estimate compressed sizes from a sample of each file; writes nothing")
+	flag.StringVar(&cfg.Format, "format", formatText, "Output: text (progress lines) or json (one summary document on stdout)")
+	flag.Int64Var(&cfg.EstimateMB, "estimate-sample", cfg.EstimateMB, "MB of each file --estimate compresses")
+	flag.BoolVar(&cfg.StreamArchive, "stream-archive", false, "Write each archive to stdout as a framed chunk instead of to disk")
+	flag.Int64Var(&cfg.SplitSizeMB, "split-size", cfg.SplitSizeMB, "Write archives larger than N MB as .partNNN files (0 = never)")
+	flag.StringVar(&cfg.ReportDir, "report-dir", cfg.ReportDir, "Write a JSON report of each run into this directory")
+	flag.StringVar(&cfg.MetricsFile, "metrics-file", cfg.MetricsFile, "Update this Prometheus textfile (for node_exporter) after each run")
+	flag.StringVar(&cfg.SignalPIDFile, "signal-pidfile", cfg.SignalPIDFile, "After rotating, signal the process whose PID is in this file")
+	flag.StringVar(&cfg.Signal, "signal", cfg.Signal, "Signal sent with --signal-pidfile (HUP, USR1, ...)")
+	flag.StringVar(&cfg.PostRotate, "post-rotate", cfg.PostRotate, "Shell command run after rotating, with $ROTATED_FILE and $ARCHIVE_FILE set")
+	flag.StringVar(&cfg.PostRotateMode, "post-rotate-mode", cfg.PostRotateMode, "When --post-rotate runs: file (after each file) or run (once at the end)")
+	flag.StringVar(&cfg.Upload, "upload", cfg.Upload, "Upload each new archive to s3://bucket/prefix")
+	flag.StringVar(&cfg.UploadRegion, "upload-region", cfg.UploadRegion, "AWS region for --upload")
+	flag.StringVar(&cfg.UploadProfile, "upload-profile", cfg.UploadProfile, "AWS credentials profile for --upload")
+	flag.BoolVar(&cfg.UploadDelete, "upload-delete-local", cfg.UploadDelete, "Remove the local archive once --upload succeeded")
+	flag.BoolVar(&cfg.Fsync, "fsync", cfg.Fsync, "fsync archives and backup directories for crash durability")
+	flag.BoolVar(&cfg.NoFsync, "no-fsync", false, "Don't fsync archives or backup directories (faster, less durable)")
+	flag.BoolVar(&cfg.Checksum, "checksum", cfg.Checksum, "Write <archive>.sha256 (sha256sum format) next to each archive")
+	flag.StringVar(&cfg.LockFile, "lock-file", cfg.LockFile, "Lock file that keeps two rotation runs from overlapping")
+	flag.BoolVar(&cfg.NoLock, "no-lock", false, "Rotate without taking the lock file")
+	flag.BoolVar(&cfg.AssumeYes, "yes", false, "Don't ask before deleting CONFIRM_THRESHOLD or more archives")
 	flag.StringVar(&cfg.OldLogsDir, "o", cfg.OldLogsDir, "Specify old_logs directory")
 	flag.StringVar(&cfg.ExcludeFile, "exclude-from", cfg.ExcludeFile, "Path to file containing exclude patterns")
 	flag.IntVar(&cfg.ParallelJobs, "parallel", cfg.ParallelJobs, "Rotate up to N log files in parallel")
-	flag.BoolVar(&enableEncrypt, "encrypt", cfg.Encrypt, "Encrypt rotated logs with AES-256-GCM")
-	flag.StringVar(&readFile, "read", "", "Read a rotated log file (.gz or .gz.enc)")
+	flag.IntVar(&cfg.IOThreads, "threads-for-io", cfg.IOThreads, "Concurrent archive writes (default: --parallel)")
+	flag.IntVar(&cfg.CPUThreads, "threads-for-cpu", cfg.CPUThreads, "Concurrent compress/encrypt operations (default: --parallel)")
+	flag.StringVar(&cfg.Compress, "compress", cfg.Compress, "Compression codec: gzip, xz, zstd, none")
+	flag.IntVar(&cfg.CompressLevel, "compress-level", cfg.CompressLevel, "Compression level 1 (fastest) to 9 (smallest); 0 stores gzip uncompressed")
+	flag.IntVar(&cfg.RetentionDays, "retention-days", cfg.RetentionDays, "After rotating, delete archives older than N days (0 = never)")
+	flag.IntVar(&cfg.MaxArchives, "max-archives", cfg.MaxArchives, "After rotating, keep only the N newest archives of each log (0 = all)")
+	flag.StringVar(&cfg.MaxTotalSize, "max-total-size", cfg.MaxTotalSize, "After rotating, delete the oldest archives until the backup roots fit in this size (e.g. 10G)")
+	flag.StringVar(&cfg.MinSize, "min-size", cfg.MinSize, "Skip files smaller than this (e.g. 1M)")
+	flag.StringVar(&cfg.MinAge, "min-age", cfg.MinAge, "Skip files modified more recently than this (e.g. 24h, 7d)")
+	flag.StringVar(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "Read sources at no more than this many bytes/s across all workers (e.g. 50M)")
+	flag.IntVar(&cfg.MaxDepth, "max-depth", cfg.MaxDepth, "Search at most N directory levels for logs, 1 being the log dir itself (0 = no limit)")
+	flag.BoolVar(&cfg.NoRecurse, "no-recurse", false, "Only rotate logs directly in the log dir, same as --max-depth 1")
+	flag.StringVar(&cfg.Order, "order", cfg.Order, "Rotation order: size (smallest first), age (oldest first), name")
+	flag.StringVar(&cfg.FSThreshold, "fs-usage-threshold", cfg.FSThreshold, "Only rotate when LogDir's filesystem usage exceeds N%")
+	flag.BoolVar(&enableEncrypt, "encrypt", false, "Encrypt rotated logs with AES-256-GCM")
+	flag.StringVar(&cfg.EncryptKeyfile, "keyfile", cfg.EncryptKeyfile, "Use this file's contents as the key material instead of a password")
+	flag.StringVar(&cfg.PrivateKey, "private-key", "", "PEM RSA private key that opens archives sealed to ENCRYPT_PUBLIC_KEY")
+	flag.StringVar(&readFile, "read", "", "Read a rotated log file (gzip, xz, bzip2 or zstd, optionally .enc or .gpg)")
+	flag.StringVar(&cfg.ToFIFO, "to-fifo", "", "With --read: stream content into this named pipe")
+	flag.StringVar(&cfg.Grep, "grep", "", "With --read <file|dir>: print only matching lines, prefixed with the archive name")
+	flag.BoolVar(&cfg.GrepIgnoreCase, "i", false, "With --grep: ignore case")
+	flag.IntVar(&cfg.Tail, "tail", 0, "With --read: output only the last N lines")
+	flag.StringVar(&cfg.Member, "member", "", "With --read <bundle.tar>: stream this member")
+	flag.BoolVar(&cfg.ListMembers, "list-members", false, "With --read <bundle.tar>: list its members")
+	flag.BoolVar(&onlyEncrypted, "only-encrypted", false, "With --read <dir>: only encrypted (.enc/.gpg) archives")
+	flag.BoolVar(&onlyPlain, "only-plain", false, "With --read <dir>: only unencrypted archives")
+	flag.BoolVar(&cfg.Resume, "resume", false, "With --read <dir>, --migrate or --rekey: skip what an interrupted run already finished")
+	flag.StringVar(&cfg.MigratePath, "migrate", "", "Convert format 1 encrypted archives (file or dir) to the envelope format")
+	flag.StringVar(&cfg.RekeyPath, "rekey", "", "Rewrap encrypted archives (file or dir) from LOGROTATE_OLD_PASSWORD to the current password")
+	flag.StringVar(&cfg.ReencryptPath, "reencrypt", "", "Decrypt archives (file or dir) with LOGROTATE_OLD_PASSWORD and encrypt them again with the current password")
+	flag.StringVar(&cfg.EncryptExisting, "encrypt-existing", "", "Encrypt already-rotated plain archives (file or dir) without recompressing")
+	flag.BoolVar(&cfg.RemovePlain, "remove-plain", false, "With --encrypt-existing: delete each plain archive once its encrypted copy is verified")
+	flag.StringVar(&cfg.DecryptDir, "decrypt-dir", "", "Decrypt and decompress every archive under a dir into --out, mirroring its layout")
+	flag.StringVar(&cfg.List, "list", "", "List the archives of a log (name or glob) under the backup root: date, sizes, encryption")
+	flag.BoolVar(&cfg.ExactSize, "exact-size", false, "With --list: decompress archives whose original size isn't recorded exactly")
+	flag.StringVar(&cfg.Out, "out", "", "With --read: file to write the content to instead of stdout; with --decrypt-dir: destination dir")
+	flag.StringVar(&repairFile, "repair", "", "Salvage a damaged archive into a new file next to it")
+	flag.StringVar(&cfg.FsckPath, "fsck", "", "Verify every archive under a dir; exit 1 if any is corrupt, 2 if any couldn't be checked")
+	flag.BoolVar(&cfg.FsckNoKey, "fsck-no-key", false, "With --fsck: check encrypted archives' headers only, without a password")
+	flag.BoolVar(&cfg.FsckJSON, "fsck-json", false, "With --fsck: print the results as JSON")
+	flag.StringVar(&cfg.VerifyFile, "verify", "", "Check an archive against its .sha256 sidecar, or an encrypted one by authenticating and decoding it; exit 1 on failure")
 	flag.BoolVar(&passGen, "pass-gen", false, "Generate and configure encryption password (first-time setup)")
 	flag.BoolVar(&passReset, "pass-reset", false, "Reset/change encryption password")
 	flag.StringVar(&cfg.LogFile, "log-file", cfg.LogFile, "Path to log file")
 	flag.StringVar(&logLevel, "log-level", "", "Log level: error, info, debug")
+	flag.BoolVar(&cfg.Quiet, "quiet", false, "Print nothing on stdout; errors still go to stderr")
+	flag.BoolVar(&cfg.Verbose, "verbose", false, "Mirror every log line, debug included, to stderr")
 	flag.BoolVar(&cfg.Daemon, "daemon", false, "Run as daemon; reads SCHEDULE from config files")
 	flag.BoolVar(&cfg.DaemonOnce, "daemon-once", false, "Run all scheduled jobs once then exit (for systemd timers)")
+	flag.BoolVar(&cfg.PlainOutput, "plain", cfg.PlainOutput, "Plain single-line ASCII output (no boxes)")
+	flag.BoolVar(&selfTest, "self-test", false, "Check compression, encryption and the configured KDF on random data, then exit")
+	flag.BoolVar(&traceConfig, "trace-config", false, "Print each effective config value and where it was set, then exit")
+	flag.StringVar(&traceFormat, "trace-format", "table", "Output format for --trace-config: table, json")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
+	flag.BoolVar(&versionJSON, "json", false, "With --version: print version, commit, formats, codecs and ciphers as JSON")
 	flag.BoolVar(&showHelp, "h", false, "Show help")
+	// Already applied by setConfigPaths; registered so flag.Parse accepts them.
+	flag.StringVar(&configFile, "config", mainConfigFile, "Read this config file instead of /etc/global-sys-utils/global.conf (also GLOBAL_SYS_UTILS_CONFIG)")
+	flag.StringVar(&configDir, "config-dir", configDropinDir, "Read drop-in *.conf files from this dir instead of <config>.d")
 
 	flag.Usage = showUsage
 	flag.Parse()
 
+	// A .logrotaterc at the root of the (possibly flag-chosen) log tree overrides
+	// the global config. Rebuild from both, then re-apply the flags on top.
+	if policy, policyPath := loadTreePolicy(strings.TrimSuffix(cfg.LogDir, "/")); policy != nil {
+		setFlags := make(map[string]string)
+		flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = f.Value.String() })
+		configTrace = trace
+		trace.noteFile(policyPath, policy)
+		*cfg = *buildConfig(mergeConfig(fileConfig, policy))
+		configTrace = nil
+		for name, value := range setFlags {
+			flag.Set(name, value)
+		}
+	}
+	plainOutput = cfg.PlainOutput
+	if err := setArchiveMagic(cfg.ArchiveMagic); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: ARCHIVE_MAGIC: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setEncryptKDF(cfg.EncryptKDF, cfg.EncryptIter); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.NoFsync {
+		cfg.Fsync = false
+	}
+	if cfg.EncryptPubKey != "" {
+		pub, err := loadPublicKey(cfg.EncryptPubKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: ENCRYPT_PUBLIC_KEY: %v\n", err)
+			os.Exit(1)
+		}
+		archivePublicKey = pub
+	}
+	if cfg.PrivateKey != "" {
+		priv, err := loadPrivateKey(cfg.PrivateKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --private-key: %v\n", err)
+			os.Exit(1)
+		}
+		archivePrivateKey = priv
+	}
+
 	if showVersion {
+		if versionJSON {
+			if err := writeVersionJSON(os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 		fmt.Printf("global-logrotate version %s\n", version)
 		os.Exit(0)
 	}
@@ -1216,7 +2004,79 @@ func parseFlags() *Config {
 		os.Exit(0)
 	}
 
+	if cfg.ToFIFO != "" && readFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: --to-fifo requires --read <file>")
+		os.Exit(1)
+	}
+	if (cfg.Member != "" || cfg.ListMembers) && readFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: --member and --list-members require --read <bundle.tar>")
+		os.Exit(1)
+	}
+	switch {
+	case cfg.Grep != "" && readFile == "":
+		fmt.Fprintln(os.Stderr, "Error: --grep requires --read <file|dir>")
+		os.Exit(1)
+	case cfg.GrepIgnoreCase && cfg.Grep == "":
+		fmt.Fprintln(os.Stderr, "Error: -i requires --grep")
+		os.Exit(1)
+	case cfg.Grep != "" && (cfg.ToFIFO != "" || cfg.Out != "" || cfg.ListMembers):
+		fmt.Fprintln(os.Stderr, "Error: --grep prints to stdout; it can't be combined with --to-fifo, --out or --list-members")
+		os.Exit(1)
+	case cfg.Tail < 0:
+		fmt.Fprintf(os.Stderr, "Error: --tail %d is negative\n", cfg.Tail)
+		os.Exit(1)
+	case cfg.Tail > 0 && readFile == "":
+		fmt.Fprintln(os.Stderr, "Error: --tail requires --read <file|dir>")
+		os.Exit(1)
+	case cfg.Tail > 0 && (cfg.Grep != "" || cfg.ToFIFO != "" || cfg.ListMembers):
+		fmt.Fprintln(os.Stderr, "Error: --tail can't be combined with --grep, --to-fifo or --list-members")
+		os.Exit(1)
+	}
+	if _, err := compileGrep(cfg.Grep, cfg.GrepIgnoreCase); cfg.Grep != "" && err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case onlyEncrypted && onlyPlain:
+		fmt.Fprintln(os.Stderr, "Error: --only-encrypted and --only-plain are mutually exclusive")
+		os.Exit(1)
+	case (onlyEncrypted || onlyPlain) && readFile == "":
+		fmt.Fprintln(os.Stderr, "Error: --only-encrypted and --only-plain require --read <dir>")
+		os.Exit(1)
+	case cfg.Resume && readFile == "" && cfg.MigratePath == "" && cfg.RekeyPath == "" && cfg.ReencryptPath == "" && cfg.EncryptExisting == "":
+		fmt.Fprintln(os.Stderr, "Error: --resume requires --read <dir>, --migrate, --rekey, --reencrypt or --encrypt-existing")
+		os.Exit(1)
+	case cfg.RemovePlain && cfg.EncryptExisting == "":
+		fmt.Fprintln(os.Stderr, "Error: --remove-plain requires --encrypt-existing")
+		os.Exit(1)
+	case cfg.ExactSize && cfg.List == "":
+		fmt.Fprintln(os.Stderr, "Error: --exact-size requires --list")
+		os.Exit(1)
+	case cfg.DecryptDir != "" && cfg.Out == "":
+		fmt.Fprintln(os.Stderr, "Error: --decrypt-dir requires --out <dir>")
+		os.Exit(1)
+	case cfg.Out != "" && cfg.DecryptDir == "" && readFile == "":
+		fmt.Fprintln(os.Stderr, "Error: --out requires --read or --decrypt-dir")
+		os.Exit(1)
+	case cfg.Out != "" && (cfg.ToFIFO != "" || cfg.ListMembers):
+		fmt.Fprintln(os.Stderr, "Error: --out can't be combined with --to-fifo or --list-members")
+		os.Exit(1)
+	case onlyEncrypted:
+		cfg.ReadFilter = readEncrypted
+	case onlyPlain:
+		cfg.ReadFilter = readPlain
+	}
+
 	cfg.ReadFile = readFile
+	cfg.RepairFile = repairFile
+	if cfg.FsckNoKey && cfg.FsckPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --fsck-no-key requires --fsck")
+		os.Exit(1)
+	}
+	if cfg.FsckJSON && cfg.FsckPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --fsck-json requires --fsck")
+		os.Exit(1)
+	}
 	cfg.PassGen = passGen
 	cfg.PassReset = passReset
 
@@ -1230,13 +2090,29 @@ func parseFlags() *Config {
 	if logLevel != "" {
 		cfg.LogLevel = parseLogLevel(logLevel)
 	}
+	if cfg.Quiet && cfg.Verbose {
+		fmt.Fprintln(os.Stderr, "Error: --quiet and --verbose can't be combined")
+		os.Exit(1)
+	}
+
+	if traceConfig {
+		trace.applyFlags(flag.CommandLine)
+		if err := writeConfigTrace(os.Stdout, trace, traceFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if selfTest {
+		os.Exit(runSelfTest(os.Stdout))
+	}
 
 	// Daemon flags bypass the rest of the normal single-run validation.
 	if cfg.Daemon || cfg.DaemonOnce {
 		return cfg
 	}
 
-	if cfg.ReadFile != "" || cfg.PassGen || cfg.PassReset {
+	if cfg.ReadFile != "" || cfg.RepairFile != "" || cfg.FsckPath != "" || cfg.DecryptDir != "" || cfg.List != "" || cfg.MigratePath != "" || cfg.RekeyPath != "" || cfg.ReencryptPath != "" || cfg.EncryptExisting != "" || cfg.PassGen || cfg.PassReset {
 		return cfg
 	}
 
@@ -1247,49 +2123,240 @@ func parseFlags() *Config {
 
 	cfg.CustomPath = cfg.LogDir != defaultDir
 
-	if useFullTime {
-		cfg.DateSuffix = time.Now().Format("20060102T15:04:05")
-	} else if useDateOnly {
-		cfg.DateSuffix = time.Now().Format("20060102")
-	} else if cfg.DateFormat == "full" {
-		cfg.DateSuffix = time.Now().Format("20060102T15:04:05")
-	} else {
-		cfg.DateSuffix = time.Now().Format("20060102")
+	if cfg.UseUTC {
+		useUTC()
+	}
+	// -H and -D override a DATE_PATTERN from the config, but not --date-pattern.
+	datePatternSet := false
+	flag.Visit(func(f *flag.Flag) { datePatternSet = datePatternSet || f.Name == "date-pattern" })
+	if useFullTime || useDateOnly {
+		if datePatternSet {
+			fmt.Fprintln(os.Stderr, "Error: --date-pattern can't be combined with -H or -D")
+			os.Exit(1)
+		}
+		cfg.DatePattern = ""
+	}
+	if cfg.DatePattern != "" {
+		if _, err := formatDatePattern(cfg.DatePattern, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: DATE_PATTERN: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	layout := dateLayout
+	if useFullTime || (!useDateOnly && cfg.DateFormat == "full") {
+		layout = fullDateLayout
+	}
+	setRunDate(cfg, time.Now(), layout)
+
+	if cfg.PatternRegex {
+		if _, err := compilePattern(cfg.Pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --pattern: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	if cfg.ParallelJobs <= 0 {
 		fmt.Fprintln(os.Stderr, "Error: --parallel must be >= 1")
 		os.Exit(1)
 	}
-
-	cfg.Parallel = cfg.ParallelJobs > 1
-	cfg.LogDir = strings.TrimSuffix(cfg.LogDir, "/")
-	cfg.BackupDate = time.Now().Format("20060102")
-
-	return cfg
-}
-
-func getConfigDefault(config map[string]string, key, defaultVal string) string {
-	if val, ok := config[key]; ok && val != "" {
-		return val
+	if cfg.IOThreads < 0 || cfg.CPUThreads < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --threads-for-io and --threads-for-cpu must be >= 1")
+		os.Exit(1)
 	}
-	return defaultVal
-}
 
-func getConfigDefaultInt(config map[string]string, key string, defaultVal int) int {
-	if val, ok := config[key]; ok && val != "" {
-		if i, err := strconv.Atoi(val); err == nil {
-			return i
+	if _, err := parseNameRules(cfg.RetentionRules); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: RETENTION_RULES: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.RetentionDays < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --retention-days must be >= 0 (0 disables it)")
+		os.Exit(1)
+	}
+	if cfg.MaxArchives < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --max-archives must be >= 0 (0 disables it)")
+		os.Exit(1)
+	}
+	if cfg.MaxTotalSize != "" {
+		if _, err := parseSize(cfg.MaxTotalSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: MAX_TOTAL_SIZE: %v\n", err)
+			os.Exit(1)
 		}
 	}
-	return defaultVal
-}
-
-func getConfigDefaultBool(config map[string]string, key string, defaultVal bool) bool {
-	if val, ok := config[key]; ok {
-		lower := strings.ToLower(val)
-		return lower == "true" || lower == "yes" || lower == "1"
+	if cfg.MinSize != "" {
+		if _, err := parseSize(cfg.MinSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: MIN_SIZE: %v\n", err)
+			os.Exit(1)
+		}
 	}
+	if cfg.MinAge != "" {
+		if _, err := parseInterval(cfg.MinAge); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: MIN_AGE: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.MaxDepth < 0 {
+		fmt.Fprintf(os.Stderr, "Error: MAX_DEPTH must be 0 (no limit) or more, got %d\n", cfg.MaxDepth)
+		os.Exit(1)
+	}
+	if cfg.RateLimit != "" {
+		if n, err := parseSize(cfg.RateLimit); err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: RATE_LIMIT must be a positive size per second such as 50M, got %q\n", cfg.RateLimit)
+			os.Exit(1)
+		}
+	}
+	if _, err := parseNameRules(cfg.RouteRules); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: ROUTE_RULES: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := parseEncryptRules(cfg.EncryptRules); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: ENCRYPT_RULES: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.WebhookTimeout < 0 || cfg.WebhookRetries < 0 {
+		fmt.Fprintln(os.Stderr, "Error: WEBHOOK_TIMEOUT and WEBHOOK_RETRIES must be >= 0")
+		os.Exit(1)
+	}
+	if cfg.HookTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: HOOK_TIMEOUT must be >= 0 seconds")
+		os.Exit(1)
+	}
+	if _, err := parseSignal(cfg.Signal); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --signal: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.PostRotateMode != postRotateFile && cfg.PostRotateMode != postRotateRun {
+		fmt.Fprintf(os.Stderr, "Error: --post-rotate-mode must be file or run (got %q)\n", cfg.PostRotateMode)
+		os.Exit(1)
+	}
+	if cfg.Format != formatText && cfg.Format != formatJSON {
+		fmt.Fprintf(os.Stderr, "Error: --format must be text or json (got %q)\n", cfg.Format)
+		os.Exit(1)
+	}
+	if cfg.Format == formatJSON && (cfg.StreamArchive || cfg.Estimate) {
+		fmt.Fprintln(os.Stderr, "Error: --format json summarises rotation runs; it can't be combined with --stream-archive or --estimate")
+		os.Exit(1)
+	}
+	if cfg.Upload != "" {
+		if _, _, err := parseS3URL(cfg.Upload); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --upload: %v\n", err)
+			os.Exit(1)
+		}
+		if cfg.StreamArchive {
+			fmt.Fprintln(os.Stderr, "Error: --upload uploads archives from disk; it can't be combined with --stream-archive")
+			os.Exit(1)
+		}
+	}
+	if cfg.FDFraction <= 0 || cfg.FDFraction > 1 {
+		fmt.Fprintf(os.Stderr, "Error: FD_SAFETY_FRACTION must be > 0 and <= 1 (got %g)\n", cfg.FDFraction)
+		os.Exit(1)
+	}
+	if cfg.ConfirmMin < 0 {
+		fmt.Fprintln(os.Stderr, "Error: CONFIRM_THRESHOLD must be >= 0")
+		os.Exit(1)
+	}
+	if cfg.SplitSizeMB < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --split-size must be >= 0")
+		os.Exit(1)
+	}
+	if cfg.SplitSizeMB > 0 && cfg.StagingDir != "" {
+		fmt.Fprintln(os.Stderr, "Error: SPLIT_SIZE_MB can't be combined with STAGING_DIR")
+		os.Exit(1)
+	}
+	if cfg.MinArchiveBytes < 0 {
+		fmt.Fprintln(os.Stderr, "Error: MIN_ARCHIVE_BYTES must be >= 0")
+		os.Exit(1)
+	}
+	if cfg.KeepTailLines < 0 {
+		fmt.Fprintln(os.Stderr, "Error: KEEP_TAIL_LINES must be >= 0")
+		os.Exit(1)
+	}
+	cfg.Order = strings.ToLower(cfg.Order)
+	switch cfg.Order {
+	case orderSize, orderLargest, orderAge, orderName:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --order must be size, largest, age or name (got %q)\n", cfg.Order)
+		os.Exit(1)
+	}
+	cfg.HardlinkPolicy = strings.ToLower(cfg.HardlinkPolicy)
+	switch cfg.HardlinkPolicy {
+	case hardlinkWarn, hardlinkSkip, hardlinkRotate:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: HARDLINK_POLICY must be warn, skip or rotate (got %q)\n", cfg.HardlinkPolicy)
+		os.Exit(1)
+	}
+
+	if cfg.FSThreshold != "" {
+		if _, err := parsePercent(cfg.FSThreshold); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --fs-usage-threshold: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cfg.Compress = strings.ToLower(cfg.Compress)
+	if _, err := lookupCodec(cfg.Compress); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !validCompressLevel(cfg.CompressLevel) {
+		fmt.Fprintf(os.Stderr, "Warning: compression level %d is out of range (0-9), using the default\n", cfg.CompressLevel)
+		cfg.CompressLevel = defaultCompressLevel
+	}
+	if cfg.EncryptBackend != backendAES && cfg.EncryptBackend != backendGPG {
+		fmt.Fprintf(os.Stderr, "Error: unknown ENCRYPT_BACKEND %q (must be aes or gpg)\n", cfg.EncryptBackend)
+		os.Exit(1)
+	}
+	if fp := cfg.EncryptPassFP; fp != "" {
+		if _, err := hex.DecodeString(fp); err != nil || len(fp) != 8 {
+			fmt.Fprintf(os.Stderr, "Error: ENCRYPT_PASSWORD_FINGERPRINT %q must be 8 hex digits\n", fp)
+			os.Exit(1)
+		}
+	}
+
+	ioN, cpuN := poolSizes(cfg)
+	cfg.Parallel = cfg.ParallelJobs > 1 || ioN > 1 || cpuN > 1
+	cfg.LogDir = strings.TrimSuffix(cfg.LogDir, "/")
+
+	return cfg
+}
+
+func getConfigDefault(config map[string]string, key, defaultVal string) string {
+	if val, ok := config[key]; ok && val != "" {
+		configTrace.resolve(key, val, true)
+		return val
+	}
+	configTrace.resolve(key, defaultVal, false)
+	return defaultVal
+}
+
+func getConfigDefaultInt(config map[string]string, key string, defaultVal int) int {
+	if val, ok := config[key]; ok && val != "" {
+		if i, err := strconv.Atoi(val); err == nil {
+			configTrace.resolve(key, val, true)
+			return i
+		}
+	}
+	configTrace.resolve(key, strconv.Itoa(defaultVal), false)
+	return defaultVal
+}
+
+func getConfigDefaultFloat(config map[string]string, key string, defaultVal float64) float64 {
+	if val, ok := config[key]; ok && val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			configTrace.resolve(key, val, true)
+			return f
+		}
+	}
+	configTrace.resolve(key, strconv.FormatFloat(defaultVal, 'g', -1, 64), false)
+	return defaultVal
+}
+
+func getConfigDefaultBool(config map[string]string, key string, defaultVal bool) bool {
+	if val, ok := config[key]; ok {
+		lower := strings.ToLower(val)
+		configTrace.resolve(key, val, true)
+		return lower == "true" || lower == "yes" || lower == "1"
+	}
+	configTrace.resolve(key, strconv.FormatBool(defaultVal), false)
 	return defaultVal
 }
 
@@ -1301,19 +2368,122 @@ func showUsage() {
 	fmt.Println("Options:")
 	fmt.Println("  -H                  Use full timestamp format (YYYYMMDDTHH:MM:SS)")
 	fmt.Println("  -D                  Use date-only format (YYYYMMDD)")
+	fmt.Println("  --utc               Use UTC instead of the host's time zone for archive dates, backup dirs")
+	fmt.Println("                      and log timestamps")
+	fmt.Printf("  --date-pattern <p>  Date in archive names as strftime (%%Y%%m%%d-%%H%%M) or a Go layout\n")
+	fmt.Printf("                      (20060102-1504); slashes (%%Y/%%m/%%d) make nested backup dirs\n")
 	fmt.Println("  --pattern <glob>    File pattern to rotate (default: *.log)")
+	fmt.Println("  --regex             Match --pattern as an RE2 regular expression against the whole base name")
 	fmt.Println("  -p <path>           Specify custom log directory (default: /var/log/apps)")
 	fmt.Println("  -n                  Dry-run mode (no changes made)")
+	fmt.Println("  --force             Rotate files already archived for the date, replacing the archive")
+	fmt.Println("                      (written aside and renamed over it, as always)")
+	fmt.Println("  --yes               Don't ask before large destructive batches (required without a terminal)")
+	fmt.Println("  --estimate          Estimate savings by compressing a sample of each file (writes nothing)")
+	fmt.Println("  --stream-archive    Write each archive to stdout as a frame (\"GLRS1 <length> <name>\\n\" + bytes)")
+	fmt.Println("                      instead of to disk, e.g. for an object-store uploader; progress goes to stderr")
+	fmt.Println("  --estimate-sample N MB of each file --estimate compresses (default: 8)")
+	fmt.Println("  --format <fmt>      text (default) or json: print one JSON summary of the run on stdout")
+	fmt.Println("                      (per file and totals); progress lines go to stderr")
+	fmt.Println("  --split-size <MB>   Write archives larger than this as <archive>.part001, .part002, ... (all or none)")
+	fmt.Println("  --report-dir <dir>  Write report-<runid>.json for each run, keeping the last REPORT_KEEP (default: 30)")
+	fmt.Println("  --metrics-file <file.prom>  After each run, update this Prometheus textfile for node_exporter")
+	fmt.Println("  --signal-pidfile <file>  After rotating, send --signal once to the PID in this file (e.g. rsyslog's)")
+	fmt.Println("  --signal <sig>      Signal for --signal-pidfile: HUP (default), USR1, USR2, ...")
+	fmt.Println("  --post-rotate <cmd> Shell command run after each rotated file, with $ROTATED_FILE and")
+	fmt.Println("                      $ARCHIVE_FILE set; its output is logged and a non-zero exit fails the file")
+	fmt.Println("  --post-rotate-mode <m>  file (default): run per file; run: once at the end, with")
+	fmt.Println("                      $ROTATED_FILES and $ARCHIVE_FILES listing them one per line")
+	fmt.Println("  --upload <s3://bucket/prefix>  Upload each new archive to <prefix>/<date>/<archive>;")
+	fmt.Println("                      a failed upload is reported and the archive kept locally")
+	fmt.Println("  --upload-region <r> AWS region for --upload (default: from the environment)")
+	fmt.Println("  --upload-profile <p>  AWS credentials profile for --upload")
+	fmt.Println("  --upload-delete-local Remove the local archive once it is uploaded")
+	fmt.Println("  --fsync             fsync each archive and its directory before truncating the source (default)")
+	fmt.Println("  --no-fsync          Skip those fsyncs: faster, but a power loss can lose a just-rotated log")
+	fmt.Println("  --checksum          Write <archive>.sha256 in sha256sum format next to each archive")
+	fmt.Println("  --lock-file <file>  Lock held while rotating (default: /var/run/global-logrotate.lock); a run")
+	fmt.Println("                      that finds it held exits with status 3")
+	fmt.Println("  --no-lock           Rotate without taking the lock")
 	fmt.Println("  --exclude-from      Path to file containing exclude patterns")
 	fmt.Println("  -o <path>           Specify old_logs directory (default: <logdir>/old_logs)")
 	fmt.Println("  --parallel N        Rotate up to N log files in parallel (default: 4)")
+	fmt.Println("  --threads-for-io N  Concurrent archive writes/truncates (default: --parallel)")
+	fmt.Println("  --threads-for-cpu N Concurrent compress/encrypt operations (default: --parallel)")
+	fmt.Println("  --compress <codec>  Compression codec: gzip, xz, zstd, none (default: gzip)")
+	fmt.Println("  --compress-level N  1 (fastest) to 9 (smallest) for gzip and zstd; 0 stores gzip uncompressed")
+	fmt.Println("  --min-size <size>   Skip files smaller than this, e.g. 1M (default: only empty files are skipped)")
+	fmt.Println("  --min-age <age>     Skip files modified more recently than this, e.g. 24h, 7d (default: any age)")
+	fmt.Println("  --max-depth N       Search at most N directory levels for logs, 1 being the log dir itself")
+	fmt.Println("                      (default: 0, the whole tree); backup roots inside it are never searched")
+	fmt.Println("  --no-recurse        Only rotate logs directly in the log dir (--max-depth 1)")
+	fmt.Println("  --rate-limit <size> Read sources at no more than <size> bytes/s in total, e.g. 50M, so")
+	fmt.Println("                      rotation leaves disk bandwidth for other services (default: unlimited)")
+	fmt.Println("  --retention-days N  After rotating, delete archives older than N days (0 = never, the default);")
+	fmt.Println("                      RETENTION_RULES still decide for the logs they match")
+	fmt.Println("  --max-archives N    After rotating, keep only the N newest archives of each log (0 = all)")
+	fmt.Println("  --max-total-size S  After rotating, delete the oldest archives until the backup roots fit in S (500M, 10G)")
+	fmt.Println("  --order <order>     Rotation order: size (smallest first, default), largest (biggest first,")
+	fmt.Println("                      shortest --parallel runs), age (oldest first), name")
+	fmt.Println("  --fs-usage-threshold N%  Only rotate when the log filesystem is more than N% full")
 	fmt.Println("  --encrypt           Encrypt rotated logs with AES-256-GCM")
-	fmt.Println("  --read <file>       Read a rotated log file (.gz or .gz.enc)")
+	fmt.Println("  --keyfile <path>    Encrypt and decrypt with this file's contents instead of a password")
+	fmt.Println("                      (must not be world-readable)")
+	fmt.Println("  --private-key <pem> Open archives sealed to ENCRYPT_PUBLIC_KEY with this RSA private key")
+	fmt.Println("  --read <file|dir>   Read a rotated log file (.gz, .xz, optionally .enc or .gpg), or every archive under a dir;")
+	fmt.Println("                      other tools' .bz2 and .zst files read too (format sniffed from content)")
+	fmt.Println("  --to-fifo <path>    With --read: stream into a named pipe (created if missing)")
+	fmt.Println("  --grep <regex>      With --read <file|dir>: print only matching lines as <archive>:<line>, decrypting")
+	fmt.Println("                      in memory (no plaintext on disk); exit 0 on a match, 1 on none, 2 on an error")
+	fmt.Println("  -i                  With --grep: ignore case")
+	fmt.Println("  --tail <n>          With --read <file|dir>: output only the last n lines (for a dir, of its archives")
+	fmt.Println("                      read oldest first); only those lines are held in memory")
+	fmt.Println("  --member <name>     With --read <bundle.tar>: stream that member (decrypted, decompressed)")
+	fmt.Println("  --list-members      With --read <bundle.tar>: list members (the default without --member)")
+	fmt.Println("  --only-encrypted    With --read <dir>: read only encrypted (.enc/.gpg) archives")
+	fmt.Println("  --only-plain        With --read <dir>: read only unencrypted archives")
+	fmt.Println("  --resume            With --read <dir>, --migrate, --rekey, --reencrypt or --encrypt-existing: continue an")
+	fmt.Println("                      interrupted run")
+	fmt.Println("  --migrate <path>    Convert format 1 .enc archives (file or dir) to the current envelope format")
+	fmt.Println("  --rekey <path>      Move envelope .enc archives from LOGROTATE_OLD_PASSWORD (or prompt) to the current")
+	fmt.Println("                      password by rewriting only their headers")
+	fmt.Println("  --reencrypt <path>  Decrypt .enc archives with LOGROTATE_OLD_PASSWORD (or prompt) and encrypt them")
+	fmt.Println("                      again with the current password, in place; archives already on it are skipped")
+	fmt.Println("  --encrypt-existing <path>")
+	fmt.Println("                      Encrypt already-rotated plain archives (file or dir) as they are, without")
+	fmt.Println("                      recompressing; each encrypted copy is verified before it is kept")
+	fmt.Println("  --remove-plain      With --encrypt-existing: delete each plain archive once encrypted")
+	fmt.Println("  --list <logname>    List the archives of a log (name or glob) under the backup root of -p/-o: date,")
+	fmt.Println("                      size, original size (gzip footer), encrypted; --format json for a JSON array.")
+	fmt.Println("                      A footer size marked '?' may have wrapped at 4 GiB")
+	fmt.Println("  --exact-size        With --list: decompress unencrypted archives to count sizes not known exactly")
+	fmt.Println("  --decrypt-dir <dir> Decrypt and decompress every archive under <dir> into --out <dst>, at the same")
+	fmt.Println("                      relative paths without .enc/.gz; one password prompt, OK or FAILED per archive")
+	fmt.Println("  --out <path>        With --read: write the content to this file instead of stdout; with --decrypt-dir:")
+	fmt.Println("                      the destination directory (existing files kept unless --force)")
+	fmt.Println("  --repair <file>     Salvage a damaged archive into <name>.repaired.<ext>")
+	fmt.Println("  --fsck <dir>        Verify every archive under a backup root (checksum sidecars, encrypted headers,")
+	fmt.Println("                      decryption, full decompression); exit 0 healthy, 1 corrupt, 2 unreadable")
+	fmt.Println("  --fsck-no-key       With --fsck: check encrypted archives' headers only (no password needed)")
+	fmt.Println("  --fsck-json         With --fsck: print the results as one JSON document")
+	fmt.Println("  --verify <archive>  Recompute an archive's SHA-256 and compare it with <archive>.sha256; exit 1 on mismatch.")
+	fmt.Println("                      An encrypted archive is checked by content: magic, key, GCM tag and the")
+	fmt.Println("                      compressed stream, discarding the plaintext")
 	fmt.Println("  --pass-gen          Generate and setup encryption password (REQUIRED for first use)")
 	fmt.Println("  --pass-reset        Reset/change encryption password")
 	fmt.Println("  --log-file <path>   Path to log file (default: /var/log/global-sys-utils/global-logrotate.log)")
 	fmt.Println("  --log-level <level> Log level: error, info, debug (default: info)")
+	fmt.Println("  --quiet             Print nothing on stdout, only errors on stderr (silence on success for cron)")
+	fmt.Println("  --verbose           Mirror every log line, debug included, to stderr; --log-level still")
+	fmt.Println("                      governs the log file")
+	fmt.Println("  --plain             Plain single-line ASCII output (no boxes)")
+	fmt.Println("  --self-test         Run random data through gzip, encryption with a throwaway password, decryption")
+	fmt.Println("                      and decompression with the configured KDF; PASS/FAIL and timing per step,")
+	fmt.Println("                      exit 1 on a failure")
+	fmt.Println("  --trace-config      Show each effective config value and its source, then exit")
+	fmt.Println("  --trace-format <f>  --trace-config output: table (default) or json")
 	fmt.Println("  --version           Show version")
+	fmt.Println("  --version --json    Version, commit, archive formats, codecs and ciphers as JSON")
 	fmt.Println("  -h                  Show this help")
 	fmt.Println()
 	fmt.Println("Log Levels:")
@@ -1330,10 +2500,23 @@ func showUsage() {
 	fmt.Println("Configuration files:")
 	fmt.Println("  /etc/global-sys-utils/global.conf")
 	fmt.Println("  /etc/global-sys-utils/global.conf.d/*.conf")
+	fmt.Println("  --config <file>     Read this config file instead (also GLOBAL_SYS_UTILS_CONFIG; the flag wins);")
+	fmt.Println("                      its drop-ins are read from <file>.d")
+	fmt.Println("  --config-dir <dir>  Read drop-in *.conf files from this dir instead")
 	fmt.Println()
 	fmt.Println("Logging Configuration (in config file):")
 	fmt.Println("  LOG_FILE  = /var/log/global-sys-utils/global-logrotate.log")
 	fmt.Println("  LOG_LEVEL = info  # error, info, or debug")
+	fmt.Println("  LOG_MAX_SIZE_MB = 0  # rotate LOG_FILE past this size; 0 = never")
+	fmt.Println("  LOG_BACKUPS = 5      # rotated copies kept (.1, .2, ...)")
+	fmt.Println("  LOG_COMPRESS = false # gzip copies from .2 on; .1 stays plain")
+	fmt.Println()
+	fmt.Println("Exit status:")
+	fmt.Println("  0  every file was rotated or skipped (dry runs included)")
+	fmt.Println("  1  usage or configuration error")
+	fmt.Println("  2  one or more files failed to rotate; the rest were still rotated")
+	fmt.Println("  3  another run holds the lock (--lock-file)")
+	fmt.Println("  --fsck and --verify report with their own codes, given above")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  global-logrotate -D -p /var/log/myapp                    # Basic rotation")
@@ -1356,23 +2539,168 @@ func loadExcludePatterns(excludeFile string) []string {
 	}
 	defer file.Close()
 
-	fmt.Printf("Excluding patterns from: %s\n", excludeFile)
+	if !plainOutput {
+		fmt.Printf("Excluding patterns from: %s\n", excludeFile)
+	}
 	logInfo("Loading exclude patterns from: %s", excludeFile)
 	var patterns []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" && !strings.HasPrefix(line, "#") {
-			fmt.Printf("  - %s\n", line)
+			if !plainOutput {
+				fmt.Printf("  - %s\n", line)
+			}
 			logDebug("Exclude pattern: %s", line)
 			patterns = append(patterns, line)
 		}
 	}
+	if plainOutput {
+		fmt.Printf("Excluding patterns from %s: %s\n", excludeFile, strings.Join(patterns, ", "))
+	}
+	return patterns
+}
+
+// dirExcludeFile names the per-directory exclude list. Its patterns apply only
+// within the directory that holds it (and below), on top of --exclude-from.
+const dirExcludeFile = ".logrotate-exclude"
+
+// loadDirExcludes reads dir's .logrotate-exclude, if any. Unlike --exclude-from,
+// a missing or unreadable file is not an error.
+func loadDirExcludes(dir string) []string {
+	file, err := os.Open(filepath.Join(dir, dirExcludeFile))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	logDebug("Loaded %d exclude pattern(s) from %s", len(patterns), filepath.Join(dir, dirExcludeFile))
 	return patterns
 }
 
-func findLogFiles(logDir, pattern string, excludePatterns []string) []fileInfo {
+// dirExcluded reports whether path is excluded by a .logrotate-exclude in any
+// directory between root and the file. Patterns match the file name or the
+// path relative to the directory that declared them.
+func dirExcluded(path, root string, dirExcludes map[string][]string) bool {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if patterns := dirExcludes[dir]; len(patterns) > 0 {
+			rel, _ := filepath.Rel(dir, path)
+			for _, p := range patterns {
+				if m, _ := filepath.Match(p, rel); m {
+					logDebug("Excluding file (%s in %s): %s", p, dir, path)
+					return true
+				}
+				if m, _ := filepath.Match(p, filepath.Base(path)); m {
+					logDebug("Excluding file (%s in %s): %s", p, dir, path)
+					return true
+				}
+			}
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+// minAgeDuration is MIN_AGE as a duration, 0 when unset or invalid.
+func minAgeDuration(cfg *Config) time.Duration {
+	if cfg.MinAge == "" {
+		return 0
+	}
+	d, _ := parseInterval(cfg.MinAge)
+	return d
+}
+
+// walkScope bounds the search findLogFiles makes below the log dir and says
+// how it matches names.
+type walkScope struct {
+	maxDepth   int            // directory levels searched, 1 being the log dir itself (0 = no limit)
+	skipDirs   []os.FileInfo  // directories left out with everything below them
+	skipOldLog bool           // also leave out every directory named old_logs
+	nameRegex  *regexp.Regexp // with PATTERN_REGEX, the compiled pattern; nil matches it as a glob
+}
+
+// walkScopeFor is cfg's search scope: MAX_DEPTH (or --no-recurse), and the
+// directories archives are written to (backup roots and STAGING_DIR), so
+// archives are never taken for logs and large archive trees are never walked.
+// Those directories are recognised by identity rather than by name, so one
+// reached through a symlink or a relative path is left out all the same;
+// those outside LogDir are simply never reached.
+func walkScopeFor(cfg *Config) walkScope {
+	scope := walkScope{maxDepth: cfg.MaxDepth, skipOldLog: cfg.OldLogsDir == ""}
+	if cfg.NoRecurse {
+		scope.maxDepth = 1
+	}
+	if cfg.PatternRegex {
+		scope.nameRegex, _ = compilePattern(cfg.Pattern) // validated in parseFlags
+	}
+	roots := []string{cfg.OldLogsDir, cfg.StagingDir}
+	if rules, err := parseNameRules(cfg.RouteRules); err == nil {
+		for _, r := range rules {
+			roots = append(roots, r.value)
+		}
+	}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			scope.skipDirs = append(scope.skipDirs, info)
+		}
+	}
+	return scope
+}
+
+// skips reports whether findLogFiles leaves out the directory d, depth levels
+// below the log dir.
+func (s walkScope) skips(d os.DirEntry, depth int) bool {
+	if s.maxDepth > 0 && depth >= s.maxDepth {
+		return true
+	}
+	if s.skipOldLog && d.Name() == "old_logs" {
+		return true
+	}
+	if len(s.skipDirs) == 0 {
+		return false
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	for _, dir := range s.skipDirs {
+		if os.SameFile(info, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePattern compiles a PATTERN_REGEX pattern, anchored so that like a
+// glob it must match the whole base name.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// findLogFiles returns the files under logDir matching pattern, smallest
+// first, leaving out excluded ones and, when minAge > 0, those modified within
+// the last minAge. scope limits how deep it looks and which directories it
+// leaves out.
+func findLogFiles(logDir, pattern string, excludePatterns []string, minAge time.Duration, scope walkScope) []fileInfo {
 	var files []fileInfo
+	dirExcludes := make(map[string][]string)
+	cutoff := time.Now().Add(-minAge)
 
 	logDebug("Searching for files in %s with pattern %s", logDir, pattern)
 
@@ -1381,12 +2709,28 @@ func findLogFiles(logDir, pattern string, excludePatterns []string) []fileInfo {
 			logInfo("Skipping inaccessible path %s: %v", path, err)
 			return nil
 		}
+		if d.Name() == treePolicyFile || d.Name() == dirExcludeFile {
+			return nil // our own control files are never rotated
+		}
 		if d.IsDir() {
+			if rel, err := filepath.Rel(logDir, path); err == nil && rel != "." {
+				if depth := strings.Count(rel, string(filepath.Separator)) + 1; scope.skips(d, depth) {
+					logDebug("Not searching %s", path)
+					return filepath.SkipDir
+				}
+			}
+			// Loaded lazily as the walk descends; only consulted for files below.
+			if patterns := loadDirExcludes(path); len(patterns) > 0 {
+				dirExcludes[filepath.Clean(path)] = patterns
+			}
 			return nil
 		}
 
-		matched, err := filepath.Match(pattern, d.Name())
-		if err != nil || !matched {
+		if scope.nameRegex != nil {
+			if !scope.nameRegex.MatchString(d.Name()) {
+				return nil
+			}
+		} else if matched, err := filepath.Match(pattern, d.Name()); err != nil || !matched {
 			return nil
 		}
 
@@ -1400,14 +2744,21 @@ func findLogFiles(logDir, pattern string, excludePatterns []string) []fileInfo {
 				return nil
 			}
 		}
+		if dirExcluded(path, logDir, dirExcludes) {
+			return nil
+		}
 
 		info, err := d.Info()
 		if err != nil {
 			return nil
 		}
+		if minAge > 0 && info.ModTime().After(cutoff) {
+			logDebug("Skipping %s: modified %s, newer than MIN_AGE %s", path, info.ModTime().Format(time.RFC3339), minAge)
+			return nil
+		}
 
 		logDebug("Found file: %s (size: %d)", path, info.Size())
-		files = append(files, fileInfo{path: path, size: info.Size()})
+		files = append(files, fileInfo{path: path, size: info.Size(), mtime: info.ModTime()})
 		return nil
 	})
 
@@ -1423,296 +2774,1329 @@ func findLogFiles(logDir, pattern string, excludePatterns []string) []fileInfo {
 	return files
 }
 
+// ORDER values: the order files are handed to the workers in.
+const (
+	orderSize    = "size"    // smallest first
+	orderLargest = "largest" // biggest first, so with --parallel the longest file isn't left running alone at the end
+	orderAge     = "age"     // oldest mtime first
+	orderName    = "name"    // lexicographic by path
+)
+
+// orderLogFiles re-sorts files found by findLogFiles (which returns them
+// smallest first) for ORDER. Ties keep their size order.
+func orderLogFiles(files []fileInfo, order string) {
+	switch order {
+	case orderLargest:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].size > files[j].size
+		})
+	case orderAge:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].mtime.Before(files[j].mtime)
+		})
+	case orderName:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].path < files[j].path
+		})
+	}
+}
+
 type fileInfo struct {
-	path string
-	size int64
+	path  string
+	size  int64
+	mtime time.Time
 }
 
-func rotateSequential(files []fileInfo, cfg *Config) {
-	for _, f := range files {
-		rotateLogFile(f.path, cfg)
+// HARDLINK_POLICY values.
+const (
+	hardlinkWarn   = "warn"
+	hardlinkSkip   = "skip"
+	hardlinkRotate = "rotate"
+)
+
+// Rotation outcomes reported in FileResult.Status.
+const (
+	statusRotated = "rotated"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+	statusDryRun  = "dry-run"
+	// statusVanished means the source was deleted while we were rotating it,
+	// usually by the application itself; Reason names the stage.
+	statusVanished = "disappeared"
+	// statusUnchanged is a SKIP_UNCHANGED skip: nothing was written since the
+	// file was last rotated.
+	statusUnchanged = "unchanged"
+)
+
+// rotateStageHook, when set, is called as rotateFile enters each stage that
+// touches the source file. Tests use it to delete the file mid-rotation.
+var rotateStageHook func(stage, path string)
+
+// FileResult describes what happened to a single file during a run.
+type FileResult struct {
+	Path         string
+	Archive      string
+	Status       string
+	Reason       string // why a file was skipped
+	OriginalSize int64
+	ArchiveSize  int64
+	Encrypted    bool
+	Duration     time.Duration // wall time spent on the file (monotonic clock)
+	Err          error
+	Uploaded     bool  // the archive reached UPLOAD
+	UploadErr    error // why it didn't; the rotation itself still succeeded
+}
+
+// RotationHooks lets embedders observe a run without parsing log output.
+// Every callback is optional and runs under recover, so a misbehaving hook
+// can't abort a rotation that is otherwise healthy.
+type RotationHooks struct {
+	OnFileStart func(path string)
+	OnFileDone  func(res FileResult)
+	OnError     func(path string, err error)
+}
+
+// callHook runs fn and swallows any panic it raises, logging it instead.
+func callHook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logError("panic in %s hook: %v", name, r)
+		}
+	}()
+	fn()
+}
+
+func (h *RotationHooks) fileStart(path string) {
+	if h != nil && h.OnFileStart != nil {
+		callHook("OnFileStart", func() { h.OnFileStart(path) })
 	}
 }
 
-func rotateParallel(files []fileInfo, cfg *Config) {
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, cfg.ParallelJobs)
+func (h *RotationHooks) fileDone(res FileResult) {
+	if h != nil && h.OnFileDone != nil {
+		callHook("OnFileDone", func() { h.OnFileDone(res) })
+	}
+}
+
+func (h *RotationHooks) fileError(path string, err error) {
+	if h != nil && h.OnError != nil {
+		callHook("OnError", func() { h.OnError(path, err) })
+	}
+}
 
+func rotateSequential(files []fileInfo, cfg *Config) []FileResult {
+	results := make([]FileResult, 0, len(files))
 	for _, f := range files {
+		results = append(results, rotateLogFile(f.path, cfg))
+	}
+	return results
+}
+
+func rotateParallel(files []fileInfo, cfg *Config) []FileResult {
+	var wg sync.WaitGroup
+	ioN, cpuN := fdSafePoolSizes(cfg)
+	pools := &workerPools{io: make(chan struct{}, ioN), cpu: make(chan struct{}, cpuN)}
+	// Admit enough files to keep both pools busy at once; the rest queue here.
+	sem := make(chan struct{}, ioN+cpuN)
+	results := make([]FileResult, len(files))
+	runCfg := *cfg
+	runCfg.pools = pools
+	cfg = &runCfg
+
+	for i, f := range files {
 		wg.Add(1)
 		sem <- struct{}{}
-		go func(path string) {
+		go func(i int, path string) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Fprintf(os.Stderr, "panic processing %s: %v\n", path, r)
-					logError("panic processing %s: %v", path, r)
-				}
-			}()
-			rotateLogFile(path, cfg)
-		}(f.path)
+			// rotateLogFile recovers a panic itself (rotateFileRecovering).
+			results[i] = rotateLogFile(path, cfg)
+		}(i, f.path)
 	}
 	wg.Wait()
+	return results
+}
+
+// workerPools splits rotation concurrency into IO slots (writing the archive,
+// renaming, truncating the source) and CPU slots (compressing and encrypting), so
+// slow disks and slow codecs can be tuned independently.
+type workerPools struct {
+	io  chan struct{}
+	cpu chan struct{}
+}
+
+// poolSizes returns the IO and CPU slot counts for cfg; each defaults to PARALLEL_JOBS.
+func poolSizes(cfg *Config) (ioN, cpuN int) {
+	ioN, cpuN = cfg.IOThreads, cfg.CPUThreads
+	if ioN <= 0 {
+		ioN = cfg.ParallelJobs
+	}
+	if cpuN <= 0 {
+		cpuN = cfg.ParallelJobs
+	}
+	return max(ioN, 1), max(cpuN, 1)
+}
+
+// acquireIO and acquireCPU block for a slot and return its release func. A nil
+// pool (sequential rotation) never blocks; its release is a no-op.
+func (p *workerPools) acquireIO() func() {
+	if p == nil {
+		return func() {}
+	}
+	return acquireSlot(p.io)
+}
+
+func (p *workerPools) acquireCPU() func() {
+	if p == nil {
+		return func() {}
+	}
+	return acquireSlot(p.cpu)
+}
+
+// acquireSlot takes a slot from slots. The returned release is safe to call more
+// than once, so callers can both release early and defer it.
+func acquireSlot(slots chan struct{}) func() {
+	slots <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }
+}
+
+// durationPercentile returns the p-th percentile (0-100) of the rotation
+// durations in results using the nearest-rank method.
+func durationPercentile(results []FileResult, p float64) time.Duration {
+	if len(results) == 0 {
+		return 0
+	}
+	ds := make([]time.Duration, len(results))
+	for i, r := range results {
+		ds[i] = r.Duration
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	rank := int(p/100*float64(len(ds))+0.5) - 1
+	return ds[min(max(rank, 0), len(ds)-1)]
+}
+
+// exitFailures is the exit status of a run in which any file failed to rotate,
+// so cron and monitoring see it; the run still went through every other file.
+const exitFailures = 2
+
+// failedCount returns how many files in results failed.
+func failedCount(results []FileResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == statusFailed {
+			n++
+		}
+	}
+	return n
 }
 
-func rotateLogFile(logFile string, cfg *Config) {
+// logStatusSummary logs how many files ended in each status, e.g.
+// "Summary: 12 rotated, 1 disappeared".
+func logStatusSummary(results []FileResult) {
+	if len(results) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	var parts []string
+	for _, st := range []string{statusRotated, statusDryRun, statusSkipped, statusUnchanged, statusVanished, statusFailed} {
+		if counts[st] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[st], st))
+		}
+	}
+	var in, out int64
+	for _, r := range results {
+		if r.Status == statusRotated {
+			in += r.OriginalSize
+			out += r.ArchiveSize
+		}
+	}
+	if in > 0 {
+		logInfo("Summary: %s; archived %s -> %s (%s)", strings.Join(parts, ", "), formatSize(in), formatSize(out), sizeChange(in, out))
+		return
+	}
+	logInfo("Summary: %s", strings.Join(parts, ", "))
+}
+
+// logTimingSummary logs p50/p95 rotation times and the slowest file so that
+// pathological files (huge, incompressible, on slow disks) stand out.
+func logTimingSummary(results []FileResult) {
+	if len(results) == 0 {
+		return
+	}
+	slowest := results[0]
+	for _, r := range results[1:] {
+		if r.Duration > slowest.Duration {
+			slowest = r
+		}
+	}
+	logInfo("Timing: %d file(s), p50=%s p95=%s, slowest %s (%s)",
+		len(results), durationPercentile(results, 50), durationPercentile(results, 95),
+		slowest.Path, slowest.Duration)
+}
+
+// blankCheckMax is the largest file SKIP_WHITESPACE_ONLY reads; anything bigger
+// is assumed to hold real content.
+const blankCheckMax = 4096
+
+// blankFile reports whether path holds only whitespace. Read errors count as
+// content, so the file is rotated as usual.
+func blankFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// The file may have grown since it was stat'ed; never read more than the cap.
+	data, err := io.ReadAll(io.LimitReader(f, blankCheckMax+1))
+	if err != nil || int64(len(data)) > blankCheckMax {
+		return false
+	}
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// rotateLogFile rotates a single file and reports the outcome to cfg.Hooks
+// and EVENT_SOCKET.
+func rotateLogFile(logFile string, cfg *Config) FileResult {
+	cfg.Hooks.fileStart(logFile)
+	start := time.Now()
+	res := postRotateFileHook(cfg, uploadArchive(cfg, rotateFileRecovering(logFile, cfg)))
+	res.Duration = time.Since(start)
+	logDebug("Finished %s in %s (%s)", logFile, res.Duration, res.Status)
+	if res.Err != nil {
+		cfg.Hooks.fileError(logFile, res.Err)
+	}
+	cfg.Hooks.fileDone(res)
+	cfg.events.fileEvent(res)
+	return res
+}
+
+// rotateFileRecovering is rotateFile with a panic turned into a failed
+// result, so that one bad file fails alone, reported like any other failure,
+// and the rest of the run carries on. The pool slots rotateFile holds are
+// released by its defers as the panic unwinds.
+func rotateFileRecovering(logFile string, cfg *Config) (res FileResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "panic processing %s: %v\n", logFile, r)
+			logError("panic processing %s: %v\n%s", logFile, r, debug.Stack())
+			res = FileResult{Path: logFile, Status: statusFailed, Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	return rotateFile(logFile, cfg)
+}
+
+func rotateFile(logFile string, cfg *Config) FileResult {
 	logDebug("Processing file: %s", logFile)
+	res := FileResult{Path: logFile}
+	skip := func(reason string) FileResult {
+		res.Status = statusSkipped
+		res.Reason = reason
+		return res
+	}
+	fail := func(err error) FileResult {
+		res.Status = statusFailed
+		res.Err = err
+		return res
+	}
+	// vanished aborts a file whose source was deleted under us. It is not an
+	// error: the application removed its own log, and there is nothing to rotate.
+	vanished := func(stage string) FileResult {
+		fmt.Printf("%s: File disappeared during rotation (%s), skipping: %s\n", timestamp(), stage, logFile)
+		logInfo("File disappeared during rotation (%s): %s", stage, logFile)
+		res.Status = statusVanished
+		res.Reason = stage
+		return res
+	}
+	stage := func(name string) {
+		if rotateStageHook != nil {
+			rotateStageHook(name, logFile)
+		}
+	}
 
+	stage("stat")
 	info, err := os.Stat(logFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return vanished("stat")
+	}
 	if err != nil {
-		fmt.Printf("%s: Skipping missing file: %s\n", timestamp(), logFile)
-		logError("Skipping missing file: %s", logFile)
-		return
+		fmt.Printf("%s: Skipping unreadable file: %s\n", timestamp(), logFile)
+		logError("Skipping unreadable file %s: %v", logFile, err)
+		return fail(err)
+	}
+	if cfg.state.unchanged(logFile, info) {
+		fmt.Printf("%s: Skipping unchanged file: %s\n", timestamp(), logFile)
+		logDebug("Skipping %s: unchanged since its last rotation", logFile)
+		res.Status = statusUnchanged
+		return res
 	}
 	if info.Size() == 0 {
 		fmt.Printf("%s: Skipping empty file: %s\n", timestamp(), logFile)
 		logDebug("Skipping empty file: %s", logFile)
-		return
+		return skip("empty")
+	}
+	if minSize, err := parseSize(cfg.MinSize); cfg.MinSize != "" && err == nil && info.Size() < minSize {
+		fmt.Printf("%s: Skipping small file: %s (%s, MIN_SIZE %s)\n", timestamp(), logFile, formatSize(info.Size()), cfg.MinSize)
+		logDebug("Skipping %s: %d bytes is below MIN_SIZE %s", logFile, info.Size(), cfg.MinSize)
+		return skip("below MIN_SIZE")
+	}
+	if cfg.SkipBlank && info.Size() <= blankCheckMax && blankFile(logFile) {
+		fmt.Printf("%s: Skipping whitespace-only file: %s\n", timestamp(), logFile)
+		logDebug("Skipping whitespace-only file: %s", logFile)
+		return skip("whitespace only")
+	}
+
+	// Append-only and immutable files can't be truncated. Say so up front
+	// instead of failing after the archive has been written.
+	attrs, err := fileAttrFlags(logFile)
+	if err != nil {
+		logDebug("Could not read inode flags of %s: %v", logFile, err)
+	}
+	if attrs != 0 && !cfg.AppendOnly {
+		fmt.Printf("%s: Skipping %s file: %s (set HANDLE_APPEND_ONLY=true to rotate it)\n", timestamp(), describeAttrs(attrs), logFile)
+		logInfo("Skipping %s file %s; HANDLE_APPEND_ONLY is off", describeAttrs(attrs), logFile)
+		return skip(describeAttrs(attrs))
 	}
 
 	originalSize := info.Size()
+	res.OriginalSize = originalSize
+	encrypt := encryptFor(logFile, cfg)
+	res.Encrypted = encrypt
 
 	// Get file ownership and permissions
-	stat := info.Sys().(*syscall.Stat_t)
-	uid := int(stat.Uid)
-	gid := int(stat.Gid)
+	owner := ownerOf(info)
 	mode := info.Mode()
 
-	logDir := filepath.Dir(logFile)
-	logName := filepath.Base(logFile)
-	rotatedBasename := fmt.Sprintf("%s.%s", logName, cfg.DateSuffix)
-
-	var backupRoot string
-	if cfg.OldLogsDir != "" {
-		backupRoot = cfg.OldLogsDir
-	} else {
-		backupRoot = filepath.Join(logDir, "old_logs")
+	// Truncating a file truncates every hard link to it, which surprises anyone
+	// keeping a "current" link elsewhere.
+	if links := linkCount(info); links > 1 {
+		switch cfg.HardlinkPolicy {
+		case hardlinkSkip:
+			fmt.Printf("%s: Skipping hardlinked file (%d links): %s\n", timestamp(), links, logFile)
+			logInfo("Skipping %s: %d hard links and HARDLINK_POLICY=skip", logFile, links)
+			return skip("hardlinked")
+		case hardlinkWarn:
+			fmt.Fprintf(os.Stderr, "Warning: %s has %d hard links; truncating it empties all of them\n", logFile, links)
+			logInfo("Rotating %s with %d hard links; all links will be truncated", logFile, links)
+		}
 	}
 
+	backupRoot, _ := backupRootFor(logFile, cfg)
 	backupDir := filepath.Join(backupRoot, cfg.BackupDate)
 
-	// Determine final file extension
-	var archivedFile string
-	if cfg.Encrypt {
-		archivedFile = filepath.Join(backupDir, rotatedBasename+".gz.enc")
-	} else {
-		archivedFile = filepath.Join(backupDir, rotatedBasename+".gz")
+	c, err := lookupCodec(cfg.Compress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		logError("%v", err)
+		return fail(err)
+	}
+	c = c.withLevel(cfg.CompressLevel)
+
+	// Determine final file name and extension, hashing names the filesystem would reject.
+	ext := c.suffix()
+	if encrypt {
+		ext += encryptExt(cfg)
+	}
+	tail := "." + cfg.DateSuffix + ext
+	baseName := archiveBaseName(logFile, cfg)
+	shortBase, shortened := shortenArchiveBase(baseName, tail)
+	if shortened {
+		fmt.Fprintf(os.Stderr, "Warning: archive name for %s is too long, using %s\n", logFile, shortBase+tail)
+		logInfo("Archive name for %s is too long, shortened to %s (mapping in %s)",
+			logFile, shortBase+tail, filepath.Join(backupRoot, archiveNameManifest))
+	}
+	archivedFile := filepath.Join(backupDir, shortBase+tail)
+	res.Archive = archivedFile
+
+	if owner, ok := claimArchive(archivedFile, logFile); !ok {
+		fmt.Fprintf(os.Stderr, "Error: archive name collision: %s and %s both map to %s\n", owner, logFile, archivedFile)
+		logError("Archive name collision: %s and %s both map to %s — skipping %s", owner, logFile, archivedFile, logFile)
+		return fail(fmt.Errorf("archive name collision with %s", owner))
+	}
+	defer releaseArchive(archivedFile)
+
+	// --force rotates again over an existing archive; the new one still only
+	// replaces it by rename once it is complete. INCREMENT_SUFFIX numbers the
+	// day's repeat rotations instead: name.DATE.1.gz, name.DATE.2.gz, ...
+	overwrite := false
+	if archiveExists(archivedFile) {
+		switch {
+		case cfg.Force:
+			if !cfg.DryRun {
+				fmt.Printf("%s: Already rotated, overwriting (--force): %s\n", timestamp(), logFile)
+			}
+			overwrite = true
+		case cfg.IncrementSuffix:
+			first := archivedFile
+			for n := 1; archiveExists(archivedFile); n++ {
+				archivedFile = filepath.Join(backupDir, fmt.Sprintf("%s.%s.%d%s", shortBase, cfg.DateSuffix, n, ext))
+			}
+			res.Archive = archivedFile
+			fmt.Printf("%s: Already rotated today, writing %s: %s\n", timestamp(), filepath.Base(archivedFile), logFile)
+			logInfo("%s exists, rotating %s to %s (INCREMENT_SUFFIX)", first, logFile, archivedFile)
+		default:
+			fmt.Printf("%s: Already rotated, skipping: %s\n", timestamp(), logFile)
+			logInfo("Already rotated, skipping: %s", logFile)
+			return skip("already rotated")
+		}
 	}
 
-	if _, err := os.Stat(archivedFile); err == nil {
-		fmt.Printf("%s: Already rotated, skipping: %s\n", timestamp(), logFile)
-		logInfo("Already rotated, skipping: %s", logFile)
-		return
+	if cfg.SkipIfArchived {
+		if reason, archive := archivedAlready(logFile, info, backupRoot, unescapeName(baseName), cfg); reason != "" {
+			fmt.Printf("%s: Skipping %s: %s %s\n", timestamp(), logFile, reason, archive)
+			logInfo("Skipping %s: %s %s (SKIP_IF_ARCHIVED)", logFile, reason, archive)
+			return skip(reason)
+		}
 	}
 
 	if cfg.DryRun {
 		encStatus := ""
-		if cfg.Encrypt {
+		if encrypt {
 			encStatus = " [ENCRYPTED]"
 		}
-		fmt.Printf("[DRY-RUN] Would Rotate: %s (%s) -> %s%s\n", logFile, formatSize(originalSize), archivedFile, encStatus)
-		logInfo("[DRY-RUN] Would rotate: %s -> %s", logFile, archivedFile)
-		return
+		if overwrite {
+			encStatus += " over the existing archive (--force)"
+			notePlanned(planOverwrite)
+		}
+		plan(planRotate, "Would Rotate: %s (%s) -> %s%s", logFile, formatSize(originalSize), archivedFile, encStatus)
+		res.Status = statusDryRun
+		return res
 	}
 
-	// Create backup directory
-	if err := os.MkdirAll(backupDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating backup dir: %v\n", err)
-		logError("Error creating backup dir %s: %v", backupDir, err)
-		return
+	// Create backup directory (with --stream-archive nothing is written locally)
+	if !cfg.StreamArchive {
+		if err := os.MkdirAll(backupDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating backup dir: %v\n", err)
+			logError("Error creating backup dir %s: %v", backupDir, err)
+			return fail(err)
+		}
+		if shortened {
+			if err := recordArchiveName(backupRoot, shortBase, baseName); err != nil {
+				fmt.Fprintf(os.Stderr, "Error recording archive name: %v\n", err)
+				logError("Error recording %s -> %s in %s: %v", shortBase, baseName, archiveNameManifest, err)
+				return fail(err)
+			}
+		}
 	}
 
-	// Stream the file through gzip — avoids holding both original and compressed bytes in memory.
+	// CPU phase: compress (reading the source as we go) and encrypt.
+	releaseCPU := cfg.pools.acquireCPU()
+	defer releaseCPU()
+
+	// Once open, our descriptor keeps the data readable even if the file is unlinked.
+	stage("open")
 	f, err := os.Open(logFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return vanished("open")
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
 		logError("Error reading file %s: %v", logFile, err)
-		return
-	}
-	compressedData, err := compressGzip(f)
-	f.Close()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error compressing file: %v\n", err)
-		logError("Error compressing file %s: %v", logFile, err)
-		return
+		return fail(err)
 	}
+	defer f.Close()
+	// Sizes are counted as the data goes through, so the numbers reported are
+	// what was archived even if the file grew after it was stat'ed.
+	src := &countingReader{r: withProgress(throttle(f, cfg.limiter), logFile, info.Size())}
 
-	logDebug("Compressed to %d bytes", len(compressedData))
+	// IO phase: write the archive as it is produced, then truncate the source.
+	releaseIO := cfg.pools.acquireIO()
+	defer releaseIO()
 
-	// Encrypt if enabled
-	var finalData []byte
-	if cfg.Encrypt {
-		password := getEncryptionPassword(cfg)
-		if password == "" {
-			fmt.Fprintf(os.Stderr, "Error: No encryption password configured\n")
-			logError("No encryption password configured for %s", logFile)
-			return
+	// Strip setuid/setgid/execute bits from the archive — a compressed log file
+	// has no business being executable, and inheriting setuid from the source
+	// would be a privilege-escalation risk.
+	archiveMode := mode &^ (os.ModeSetuid | os.ModeSetgid) & 0666
+
+	// Disk space guard: ensure the backup directory has enough room for this archive.
+	// If the disk is too full to write even the compressed bytes, skip this file
+	// rather than filling the disk entirely and crashing the host. The archive's
+	// size is only known once it is written, so it is checked again then.
+	diskFull := func(need int64) error {
+		if cfg.DiskMinFreeMB <= 0 || cfg.StreamArchive {
+			return nil
+		}
+		_, freeMB, _, diskErr := diskStats(backupDir)
+		if diskErr != nil {
+			return nil
+		}
+		if freeMB-need/(1024*1024) >= cfg.DiskMinFreeMB {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "SKIP (disk full): %s — only %d MB free, need %d MB buffer\n",
+			logFile, freeMB, cfg.DiskMinFreeMB)
+		logError("Skipping archive for %s: %d MB free < %d MB minimum", logFile, freeMB, cfg.DiskMinFreeMB)
+		return fmt.Errorf("disk full: %d MB free < %d MB minimum", freeMB, cfg.DiskMinFreeMB)
+	}
+	if err := diskFull(1024 * 1024); err != nil {
+		return fail(err)
+	}
+
+	// The archive is compressed, and encrypted when enabled, on its way out, so
+	// memory use stays flat however large the log is. Sizes and the archive's
+	// checksum are counted as it is written.
+	var compressedSize, archiveSize int64
+	var archiveSum []byte
+	write := func(w io.Writer) error {
+		var err error
+		compressedSize, archiveSize, archiveSum, err = compressArchive(w, c, src, encrypt, cfg)
+		releaseCPU()
+		if err != nil {
+			return err
+		}
+		logDebug("Compressed to %d bytes", compressedSize)
+		if encrypt {
+			logDebug("Encrypted to %d bytes", archiveSize)
+		}
+		return nil
+	}
+	// checkArchive runs once the archive is written, before anything is done
+	// with it. A non-empty source never shrinks to next to nothing; if it seems
+	// to, the pipeline is broken, and truncating against that archive would
+	// lose the log.
+	checkArchive := func() error {
+		if archiveSize < cfg.MinArchiveBytes {
+			fmt.Fprintf(os.Stderr, "Error: archive for %s is only %d bytes (MIN_ARCHIVE_BYTES=%d), leaving the source untouched\n",
+				logFile, archiveSize, cfg.MinArchiveBytes)
+			logError("Archive for %s (%d bytes from %d) is below MIN_ARCHIVE_BYTES=%d; not rotating",
+				logFile, archiveSize, src.n, cfg.MinArchiveBytes)
+			return fmt.Errorf("archive of %d bytes is below MIN_ARCHIVE_BYTES (%d)", archiveSize, cfg.MinArchiveBytes)
 		}
+		return diskFull(0)
+	}
 
-		finalData, err = encryptData(compressedData, password)
+	// With STAGING_DIR the archive is written and verified on fast local storage
+	// first; the source is truncated against that copy, and only then is the
+	// archive moved to its backup directory, which may be a slow remote mount.
+	writeTarget := archivedFile
+	staged := ""
+	if cfg.StagingDir != "" && !cfg.StreamArchive {
+		staged, err = stagingPath(cfg.StagingDir, archivedFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error encrypting file: %v\n", err)
-			logError("Error encrypting file %s: %v", logFile, err)
-			return
+			fmt.Fprintf(os.Stderr, "Error preparing staging dir: %v\n", err)
+			logError("Error preparing staging dir %s: %v", cfg.StagingDir, err)
+			return fail(err)
+		}
+		writeTarget = staged
+	}
+
+	// With SPLIT_SIZE_MB a larger archive is written as parts instead, all of
+	// them or none, so the source is only truncated once every part is on disk.
+	published := []string{archivedFile}
+	shownArchive := archivedFile
+	if cfg.StreamArchive {
+		// --stream-archive: the archive goes to stdout as a frame instead. The
+		// frame header needs its length, so it is spooled to a temp file first;
+		// the source is truncated only once the whole frame is written.
+		stage("stream")
+		name := path.Join(cfg.BackupDate, filepath.Base(archivedFile))
+		spool, err := newSpool()
+		if err == nil {
+			defer spool.Close()
+			err = write(spool)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+			logError("Error writing archive of %s to the stream spool: %v", logFile, err)
+			return fail(err)
+		}
+		if err := checkArchive(); err != nil {
+			return fail(err)
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err == nil {
+			err = writeStreamFrame(streamOut, name, spool, archiveSize)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error streaming archive: %v\n", err)
+			logError("Error streaming archive of %s: %v; source untouched", logFile, err)
+			return fail(err)
+		}
+		published = nil
+		shownArchive = "stdout (" + name + ")"
+		res.Archive = name
+	} else if partSize := cfg.SplitSizeMB << 20; partSize > 0 {
+		sw := newSplitWriter(archivedFile, partSize, archiveMode, cfg.Fsync)
+		err := write(sw)
+		if err == nil {
+			if err := checkArchive(); err != nil {
+				sw.abort()
+				return fail(err)
+			}
+			published, err = sw.finish()
+		}
+		if err != nil {
+			sw.abort()
+			fmt.Fprintf(os.Stderr, "Error writing split archive, no parts kept: %v\n", err)
+			logError("Error writing split archive %s: %v; removed the parts written, source untouched", archivedFile, err)
+			return fail(err)
+		}
+		if len(published) > 1 {
+			shownArchive = fmt.Sprintf("%s.part{001..%03d}", archivedFile, len(published))
 		}
-		logDebug("Encrypted to %d bytes", len(finalData))
 	} else {
-		finalData = compressedData
+		// Write to a temp file first. os.Rename is atomic on the same filesystem,
+		// so a crash between write and rename leaves the original file intact.
+		tmpFile := writeTarget + ".tmp"
+		if err := createArchiveFile(tmpFile, archiveMode, cfg.Fsync, write); err != nil {
+			os.Remove(tmpFile) // clean up partial write
+			fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+			logError("Error writing archive %s: %v", tmpFile, err)
+			return fail(err)
+		}
+		if err := checkArchive(); err != nil {
+			os.Remove(tmpFile)
+			return fail(err)
+		}
+
+		if err := os.Rename(tmpFile, writeTarget); err != nil {
+			os.Remove(tmpFile)
+			fmt.Fprintf(os.Stderr, "Error finalizing archive: %v\n", err)
+			logError("Error finalizing archive %s: %v", writeTarget, err)
+			return fail(err)
+		}
 	}
+	originalSize = src.n
+	res.OriginalSize = originalSize
 
-	// Strip setuid/setgid/execute bits from the archive — a compressed log file
-	// has no business being executable, and inheriting setuid from the source
-	// would be a privilege-escalation risk.
-	archiveMode := mode &^ (os.ModeSetuid | os.ModeSetgid) & 0666
+	// publish puts the finished archive at archivedFile, moving it out of the
+	// staging dir first, and restores its ownership and permissions; those are
+	// non-fatal but surfaced at INFO so operators running as non-root notice.
+	publish := func() error {
+		if staged != "" {
+			if err := moveFile(staged, archivedFile, archiveMode, cfg.Fsync); err != nil {
+				return err
+			}
+		}
+		for _, p := range published {
+			if err := owner.restore(p); err != nil {
+				logInfo("Could not restore ownership on %s: %v", p, err)
+			}
+			if err := os.Chmod(p, archiveMode); err != nil {
+				logInfo("Could not restore permissions on %s: %v", p, err)
+			}
+		}
+		if overwrite {
+			parts := 0
+			if published[0] != archivedFile {
+				parts = len(published)
+			}
+			removeReplacedParts(archivedFile, parts)
+			if !cfg.Checksum {
+				os.Remove(checksumSidecar(archivedFile)) // would no longer match
+			}
+			logInfo("Force-overwrote existing archive %s with %s", archivedFile, logFile)
+		}
+		// CHECKSUM: the archive is complete, so a missing sidecar is only logged.
+		if cfg.Checksum {
+			if err := writeChecksumSidecar(archivedFile, archiveSum, archiveMode, cfg.Fsync); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing checksum for %s: %v\n", archivedFile, err)
+				logError("Error writing checksum sidecar for %s: %v", archivedFile, err)
+			} else {
+				owner.restore(checksumSidecar(archivedFile))
+			}
+		}
+		// FSYNC: a staged archive's directory was synced before the truncate;
+		// the move out of it needs its destination synced too. The source is
+		// already empty by then, so a failure is only logged.
+		if cfg.Fsync && staged != "" {
+			if err := syncDir(backupDir); err != nil {
+				logError("fsync of directory %s failed: %v", backupDir, err)
+			}
+		}
+		return nil
+	}
+	// failPublish reports a staged archive that couldn't be moved into place.
+	// The source is already truncated, so the staged copy is the only one left.
+	failPublish := func(err error) FileResult {
+		fmt.Fprintf(os.Stderr, "Error moving staged archive to %s: %v (archive kept at %s)\n", archivedFile, err, staged)
+		logError("Error moving staged archive %s to %s: %v; archive kept in staging", staged, archivedFile, err)
+		return fail(fmt.Errorf("moving staged archive: %w (kept at %s)", err, staged))
+	}
 
-	// Disk space guard: ensure the backup directory has enough room for this archive.
-	// If the disk is too full to write even the compressed bytes, skip this file
-	// rather than filling the disk entirely and crashing the host.
-	if cfg.DiskMinFreeMB > 0 {
-		if _, freeMB, _, diskErr := diskStats(backupDir); diskErr == nil {
-			needMB := int64(len(finalData))/(1024*1024) + 1
-			if freeMB-needMB < cfg.DiskMinFreeMB {
-				fmt.Fprintf(os.Stderr, "SKIP (disk full): %s — only %d MB free, need %d MB buffer\n",
-					logFile, freeMB, cfg.DiskMinFreeMB)
-				logError("Skipping archive for %s: %d MB free < %d MB minimum", logFile, freeMB, cfg.DiskMinFreeMB)
-				return
+	if staged != "" {
+		if err := verifyArchiveSum(staged, archiveSize, archiveSum); err != nil {
+			os.Remove(staged)
+			fmt.Fprintf(os.Stderr, "Error verifying staged archive: %v\n", err)
+			logError("Error verifying staged archive %s: %v", staged, err)
+			return fail(err)
+		}
+	} else if !cfg.StreamArchive {
+		if err := publish(); err != nil {
+			return fail(err)
+		}
+	}
+
+	// FSYNC: the archive's bytes were synced before its rename; sync the
+	// directory too, so the rename itself survives a power loss before the
+	// source is emptied.
+	if cfg.Fsync && !cfg.StreamArchive {
+		dir := backupDir
+		if staged != "" {
+			dir = filepath.Dir(staged)
+		}
+		if err := syncDir(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error syncing %s, not truncating %s: %v\n", dir, logFile, err)
+			logError("fsync of directory %s failed, leaving %s untruncated: %v", dir, logFile, err)
+			return fail(err)
+		}
+	}
+
+	// Truncate original only after archive is safely on disk. truncateSource never
+	// creates the file, so a source deleted meanwhile is not resurrected empty.
+	// The archive already holds everything the file had, so it is kept.
+	stage("truncate")
+	if attrs != 0 {
+		// HANDLE_APPEND_ONLY: lift the flags just for the truncate and put them back.
+		if err := setFileAttrFlags(logFile, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "Error clearing %s flag on %s (needs CAP_LINUX_IMMUTABLE): %v\n", describeAttrs(attrs), logFile, err)
+			logError("Error clearing %s flag on %s: %v", describeAttrs(attrs), logFile, err)
+			return fail(err)
+		}
+		defer func() {
+			if err := setFileAttrFlags(logFile, attrs); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "Error restoring %s flag on %s: %v\n", describeAttrs(attrs), logFile, err)
+				logError("Error restoring %s flag on %s: %v", describeAttrs(attrs), logFile, err)
+			}
+		}()
+	}
+	if err := truncateSource(logFile, cfg); errors.Is(err, fs.ErrNotExist) {
+		if staged != "" {
+			if err := publish(); err != nil {
+				return failPublish(err)
 			}
 		}
+		res.ArchiveSize = archiveSize
+		logInfo("Keeping complete archive %s of vanished %s", archivedFile, logFile)
+		return vanished("truncate")
+	} else if err != nil {
+		if staged != "" {
+			os.Remove(staged) // the source still has everything
+		}
+		fmt.Fprintf(os.Stderr, "Error truncating file: %v\n", err)
+		logError("Error truncating file %s: %v", logFile, err)
+		return fail(err)
+	}
+	cfg.state.record(logFile)
+
+	if staged != "" {
+		if err := publish(); err != nil {
+			return failPublish(err)
+		}
+	}
+
+	// Report compression and encryption separately: compressedSize is what the
+	// codec produced, archiveSize what went to disk.
+	stats := sizeStats(originalSize, compressedSize, archiveSize)
+
+	encStatus := ""
+	if encrypt {
+		encStatus = " [ENCRYPTED]"
+	}
+
+	if plainOutput {
+		fmt.Printf("%s: Rotated: %s -> %s%s size %s\n", timestamp(), logFile, shownArchive, encStatus, stats)
+	} else {
+		fmt.Printf("%s: Rotated: %s -> %s%s\n", timestamp(), logFile, shownArchive, encStatus)
+		fmt.Printf("           Size: %s\n", stats)
+	}
+
+	logInfo("Rotated: %s -> %s (size: %d -> %d, compressed: %d)",
+		logFile, shownArchive, originalSize, archiveSize, compressedSize)
+
+	res.Status = statusRotated
+	res.ArchiveSize = archiveSize
+	return res
+}
+
+// backupRootFor returns the directory archives of logFile are written under: the
+// first ROUTE_RULES target whose glob matches its base name, else OLD_LOGS_DIR,
+// else old_logs next to the file. shared reports whether that root collects
+// archives from more than one source directory.
+func backupRootFor(logFile string, cfg *Config) (root string, shared bool) {
+	if cfg.RouteRules != "" {
+		rules, _ := parseNameRules(cfg.RouteRules) // validated in parseFlags
+		if dir, ok := matchNameRule(rules, filepath.Base(logFile)); ok {
+			return dir, true
+		}
+	}
+	if cfg.OldLogsDir != "" {
+		return cfg.OldLogsDir, true
+	}
+	return filepath.Join(filepath.Dir(logFile), "old_logs"), false
+}
+
+// parseEncryptRules parses ENCRYPT_RULES, e.g. "auth*.log:on, access*.log:off",
+// into glob rules whose value is "on" or "off".
+func parseEncryptRules(s string) ([]nameRule, error) {
+	rules, err := parseNameRules(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		switch strings.ToLower(r.value) {
+		case "on", "true", "yes", "1":
+			rules[i].value = "on"
+		case "off", "false", "no", "0":
+			rules[i].value = "off"
+		default:
+			return nil, fmt.Errorf("invalid value %q for %s (want on or off)", r.value, r.pattern)
+		}
+	}
+	return rules, nil
+}
+
+// encryptFor reports whether logFile's archive is encrypted: the first
+// ENCRYPT_RULES glob matching its base name decides, else ENCRYPT does.
+func encryptFor(logFile string, cfg *Config) bool {
+	if cfg.EncryptRules != "" {
+		rules, _ := parseEncryptRules(cfg.EncryptRules) // validated in parseFlags
+		if v, ok := matchNameRule(rules, filepath.Base(logFile)); ok {
+			return v == "on"
+		}
+	}
+	return cfg.Encrypt
+}
+
+// encryptionUsed reports whether any file could be encrypted this run, so the
+// password or recipient must be configured even when ENCRYPT itself is off.
+func encryptionUsed(cfg *Config) bool {
+	if cfg.Encrypt {
+		return true
+	}
+	rules, _ := parseEncryptRules(cfg.EncryptRules)
+	for _, r := range rules {
+		if r.value == "on" {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingPath returns a unique path in stagingDir for archivedFile's staged
+// copy. Archives from different backup roots can share a base name, so the name
+// carries a hash of the full destination.
+func stagingPath(stagingDir, archivedFile string) (string, error) {
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(archivedFile))
+	return filepath.Join(stagingDir, hex.EncodeToString(sum[:4])+"-"+filepath.Base(archivedFile)), nil
+}
+
+// verifyArchiveFile re-reads path and checks it holds exactly want.
+func verifyArchiveFile(path string, want []byte) error {
+	return verifyArchiveSum(path, int64(len(want)), sha256Sum(want))
+}
+
+// verifyArchiveSum re-reads path and checks it is size bytes with SHA-256 sum.
+func verifyArchiveSum(path string, size int64, sum []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if n != size || !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("%s: read back %d bytes that don't match the %d written", path, n, size)
+	}
+	return nil
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// moveFile moves src to dst. Within a filesystem that is a rename; across
+// filesystems src is copied to dst+".tmp", optionally fsynced, renamed into
+// place and only then removed.
+func moveFile(src, dst string, perm os.FileMode, fsync bool) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if fsync {
+		if err := out.Sync(); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// writeArchiveFile writes data to path like os.WriteFile, additionally fsyncing
+// the file before closing it when fsync is set, so the bytes are on disk before
+// the caller renames it into place.
+func writeArchiveFile(path string, data []byte, perm os.FileMode, fsync bool) error {
+	return createArchiveFile(path, perm, fsync, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// createArchiveFile creates path and has write fill it, fsyncing it before
+// closing it when fsync is set, like writeArchiveFile.
+func createArchiveFile(path string, perm os.FileMode, fsync bool, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs a directory so that entries created or renamed in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	// Write to a temp file first. os.Rename is atomic on the same filesystem,
-	// so a crash between write and rename leaves the original file intact.
-	tmpFile := archivedFile + ".tmp"
-	if err := os.WriteFile(tmpFile, finalData, archiveMode); err != nil {
-		os.Remove(tmpFile) // clean up partial write
-		fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
-		logError("Error writing archive %s: %v", tmpFile, err)
-		return
+// archiveBaseName returns the name an archive for logFile is built from. Files directly
+// in LogDir keep their plain name. When a shared backup root (OLD_LOGS_DIR or a route
+// target) collects archives from nested directories, the path relative to LogDir is
+// escaped into the name so that a/app.log and b/app.log can't resolve to the same archive.
+func archiveBaseName(logFile string, cfg *Config) string {
+	name := filepath.Base(logFile)
+	if _, shared := backupRootFor(logFile, cfg); !shared {
+		return name
 	}
-
-	if err := os.Rename(tmpFile, archivedFile); err != nil {
-		os.Remove(tmpFile)
-		fmt.Fprintf(os.Stderr, "Error finalizing archive: %v\n", err)
-		logError("Error finalizing archive %s: %v", archivedFile, err)
-		return
+	rel, err := filepath.Rel(cfg.LogDir, logFile)
+	if err != nil || rel == name || strings.HasPrefix(rel, "..") {
+		return name
 	}
+	return url.PathEscape(filepath.ToSlash(rel))
+}
 
-	// Truncate original only after archive is safely on disk.
-	if err := os.Truncate(logFile, 0); err != nil {
-		fmt.Fprintf(os.Stderr, "Error truncating file: %v\n", err)
-		logError("Error truncating file %s: %v", logFile, err)
-		return
+// claimArchive reserves archivePath for source for the duration of its rotation.
+// It returns the current owner and false if another source already holds it.
+func claimArchive(archivePath, source string) (string, bool) {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	if owner, busy := inFlightArchives[archivePath]; busy {
+		return owner, false
 	}
+	inFlightArchives[archivePath] = source
+	return source, true
+}
 
-	// Restore ownership and permissions; non-fatal but surfaced at INFO so
-	// operators running as non-root notice the degraded ownership.
-	if err := os.Chown(archivedFile, uid, gid); err != nil {
-		logInfo("Could not restore ownership on %s: %v", archivedFile, err)
-	}
-	if err := os.Chmod(archivedFile, archiveMode); err != nil {
-		logInfo("Could not restore permissions on %s: %v", archivedFile, err)
-	}
+// releaseArchive drops the in-flight reservation taken by claimArchive.
+func releaseArchive(archivePath string) {
+	inFlightMu.Lock()
+	delete(inFlightArchives, archivePath)
+	inFlightMu.Unlock()
+}
+
+// compressGzip reads from r and returns gzip-compressed bytes.
+// Uses io.Reader so callers can stream directly from a file without loading the full content.
+func compressGzip(r io.Reader) ([]byte, error) {
+	return compressWith(codecs["gzip"], r)
+}
 
-	// Get compressed/encrypted file size and calculate compression stats
-	compressedSize := int64(len(finalData))
+// codec is a compression format an archive can be written in.
+type codec struct {
+	name      string
+	ext       string // archive extension, without the leading dot
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.Reader, error)
+}
 
-	compressionRatio := float64(0)
-	if originalSize > 0 {
-		compressionRatio = max((1-float64(compressedSize)/float64(originalSize))*100, 0)
+// suffix is what c appends to an archive name: "." plus its extension, or
+// nothing for "none".
+func (c codec) suffix() string {
+	if c.ext == "" {
+		return ""
 	}
+	return "." + c.ext
+}
 
-	saved := max(originalSize-compressedSize, 0)
+// nopWriteCloser passes writes through to w untouched.
+type nopWriteCloser struct{ io.Writer }
 
-	encStatus := ""
-	if cfg.Encrypt {
-		encStatus = " [ENCRYPTED]"
+func (nopWriteCloser) Close() error { return nil }
+
+// defaultCompressLevel leaves the level to the codec: 6 for gzip, zstd's
+// default (about 3).
+const defaultCompressLevel = -1
+
+// validCompressLevel reports whether level is one COMPRESS_LEVEL accepts.
+func validCompressLevel(level int) bool {
+	return level == defaultCompressLevel || (level >= 0 && level <= 9)
+}
+
+// withLevel returns c writing at COMPRESS_LEVEL level. gzip takes it as is,
+// with 0 meaning stored blocks; zstd maps it onto its own speed presets. xz
+// and none have no level to set.
+func (c codec) withLevel(level int) codec {
+	if level == defaultCompressLevel || !validCompressLevel(level) {
+		return c
+	}
+	switch c.name {
+	case "gzip":
+		c.newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level) // 0 is gzip.NoCompression
+		}
+	case "zstd":
+		c.newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1),
+				zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
 	}
+	return c
+}
+
+// codecs lists the COMPRESS values we accept. xz trades a lot of CPU time for
+// a noticeably better ratio, so it suits cold archives rather than busy hosts;
+// zstd compresses about as well as gzip's best at several times its speed.
+// "none" stores the log as it is (still encrypted when ENCRYPT is on), for
+// data that is already compressed.
+var codecs = map[string]codec{
+	"gzip": {
+		name:      "gzip",
+		ext:       "gz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	},
+	"xz": {
+		name:      "xz",
+		ext:       "xz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+		newReader: func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+	},
+	"zstd": {
+		name: "zstd",
+		ext:  "zst",
+		// One encoder goroutine per file: parallelism comes from the CPU pool.
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+		newReader: newZstdReader,
+	},
+	"none": {
+		name:      "none",
+		ext:       "",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+		newReader: func(r io.Reader) (io.Reader, error) { return r, nil },
+	},
+}
 
-	fmt.Printf("%s: Rotated: %s -> %s%s\n", timestamp(), logFile, archivedFile, encStatus)
-	fmt.Printf("           Size: %s -> %s (%.1f%% compression, saved %s)\n",
-		formatSize(originalSize), formatSize(compressedSize), compressionRatio, formatSize(saved))
+// lookupCodec returns the codec registered under name.
+func lookupCodec(name string) (codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		names := make([]string, 0, len(codecs))
+		for n := range codecs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return codec{}, fmt.Errorf("unknown compression %q (must be one of: %s)", name, strings.Join(names, ", "))
+	}
+	return c, nil
+}
 
-	logInfo("Rotated: %s -> %s (size: %d -> %d, ratio: %.1f%%)",
-		logFile, archivedFile, originalSize, compressedSize, compressionRatio)
+// codecForPath returns the codec matching the extension of path, if any.
+// Archives stored with "none" have no extension and never match.
+func codecForPath(path string) (codec, bool) {
+	for _, c := range codecs {
+		if c.ext != "" && strings.HasSuffix(path, "."+c.ext) {
+			return c, true
+		}
+	}
+	return codec{}, false
 }
 
-// compressGzip reads from r and returns gzip-compressed bytes.
-// Uses io.Reader so callers can stream directly from a file without loading the full content.
-func compressGzip(r io.Reader) ([]byte, error) {
+// compressWith reads from r and returns bytes compressed with c.
+func compressWith(c codec, r io.Reader) ([]byte, error) {
 	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
+	w, err := c.newWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s writer: %w", c.name, err)
+	}
 	if _, err := io.Copy(w, r); err != nil {
 		w.Close()
 		return nil, fmt.Errorf("compressing: %w", err)
 	}
 	if err := w.Close(); err != nil {
-		return nil, fmt.Errorf("finalizing gzip stream: %w", err)
+		return nil, fmt.Errorf("finalizing %s stream: %w", c.name, err)
 	}
 	return buf.Bytes(), nil
 }
 
-// decompressGzip decompresses gzip-compressed bytes.
-func decompressGzip(data []byte) ([]byte, error) {
-	r, err := gzip.NewReader(bytes.NewReader(data))
+// compressArchive compresses r with c into w and, when encrypt is set, encrypts
+// it on the way with the configured backend: a chunk at a time for the
+// built-in format, which also records the log's SHA-256, or through gpg's
+// stdin. Neither the log nor the archive is ever held in memory whole. It
+// returns the compressed size, and the archive's size and SHA-256, counted as
+// they are written.
+func compressArchive(w io.Writer, c codec, r io.Reader, encrypt bool, cfg *Config) (compressed, size int64, sum []byte, err error) {
+	logHash := sha256.New()
+	r = io.TeeReader(r, logHash)
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	bw := bufio.NewWriterSize(cw, 256<<10) // codecs write in small pieces
+	var out io.Writer = bw
+	finish := func() error { return nil }
+	switch {
+	case !encrypt:
+	case cfg.EncryptBackend == backendGPG:
+		g, err := newGPGWriter(bw, cfg)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("encrypting with gpg: %w", err)
+		}
+		defer g.kill() // a no-op once gpg has finished
+		out, finish = g, g.Close
+	default:
+		var password string
+		if archivePublicKey == nil {
+			if password = getEncryptionPassword(cfg); password == "" {
+				return 0, 0, nil, fmt.Errorf("no encryption password configured")
+			}
+		}
+		ew, err := newEncryptWriter(bw, password)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("encrypting: %w", err)
+		}
+		out, finish = ew, func() error { return ew.finish(logHash.Sum(nil)) }
+	}
+	cc := &countingWriter{w: out}
+	zw, err := c.newWriter(cc)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("creating %s writer: %w", c.name, err)
+	}
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return 0, 0, nil, fmt.Errorf("compressing: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, 0, nil, fmt.Errorf("finalizing %s stream: %w", c.name, err)
+	}
+	if err := finish(); err != nil {
+		return 0, 0, nil, fmt.Errorf("encrypting: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, 0, nil, err
+	}
+	return cc.n, cw.n, h.Sum(nil), nil
+}
+
+// decompressWith decompresses data that was compressed with c.
+func decompressWith(c codec, data []byte) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(data))
 	if err != nil {
-		return nil, fmt.Errorf("creating gzip reader: %w", err)
+		return nil, fmt.Errorf("creating %s reader: %w", c.name, err)
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
 	}
-	defer r.Close()
 	return io.ReadAll(r)
 }
 
-// deriveKey derives an AES-256 key from password using PBKDF2
-func deriveKey(password string, salt []byte) []byte {
-	return pbkdf2.Key([]byte(password), salt, iterations, keySize, sha256.New)
+// decompressGzip decompresses gzip-compressed bytes.
+func decompressGzip(data []byte) ([]byte, error) {
+	return decompressWith(codecs["gzip"], data)
 }
 
-// encryptData encrypts plaintext with AES-256-GCM using a PBKDF2-derived key.
-// Output format: MAGIC(4) + SALT(32) + NONCE(12) + CIPHERTEXT+TAG
-func encryptData(plaintext []byte, password string) ([]byte, error) {
-	salt := make([]byte, saltSize)
-	if _, err := rand.Read(salt); err != nil {
-		return nil, fmt.Errorf("generating salt: %w", err)
+// deriveKey derives an AES-256 key from password with the KDF in p, which for
+// format 5 archives comes from their header (see kdf.go).
+func deriveKey(password string, salt []byte, p kdfParams) []byte {
+	if p.ID == kdfArgon2id {
+		return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, keySize)
 	}
+	return pbkdf2.Key([]byte(password), salt, int(p.Time), keySize, sha256.New)
+}
 
-	key := deriveKey(password, salt)
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("creating cipher: %w", err)
-	}
+// encryptData encrypts payload with AES-256-GCM as a format 5 archive: a
+// random data key encrypts the payload in chunks and the key derived with
+// ENCRYPT_KDF only wraps the data key. logSum, the SHA-256 of the log before
+// compression, is recorded after the last chunk; pass nil when it isn't known.
+// See chunked.go for the layout. With ENCRYPT_PUBLIC_KEY the data key is
+// wrapped with the public key instead and password is unused (format 6, see
+// pubkey.go).
+func encryptData(payload []byte, password string, logSum []byte) ([]byte, error) {
+	return sealChunked(payload, password, logSum)
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("creating GCM: %w", err)
+// setArchiveMagic sets the header magic written and required by encryptData and
+// decryptData. It must be exactly four bytes so the header layout is unchanged.
+func setArchiveMagic(magic string) error {
+	if len(magic) != 4 {
+		return fmt.Errorf("magic %q must be exactly 4 bytes", magic)
 	}
+	encryptMagic = []byte(magic)
+	return nil
+}
 
-	nonce := make([]byte, nonceSize)
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, fmt.Errorf("generating nonce: %w", err)
+// hasArchiveMagic reports whether data starts with the magic of an archive
+// we read: encryptMagic or, under the default magic, legacyMagic.
+func hasArchiveMagic(data []byte) bool {
+	if bytes.HasPrefix(data, encryptMagic) {
+		return true
 	}
-
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
-
-	result := make([]byte, 0, len(encryptMagic)+saltSize+nonceSize+len(ciphertext))
-	result = append(result, encryptMagic...)
-	result = append(result, salt...)
-	result = append(result, nonce...)
-	result = append(result, ciphertext...)
-
-	return result, nil
+	return string(encryptMagic) == defaultMagic && bytes.HasPrefix(data, []byte(legacyMagic))
 }
 
-// decryptData decrypts AES-256-GCM data produced by encryptData.
-// Format: MAGIC(4) + SALT(32) + NONCE(12) + CIPHERTEXT+TAG
+// decryptData decrypts an AES-256-GCM archive in any format: formats 3 to 5
+// (chunked, see chunked.go), format 6 with archivePrivateKey (pubkey.go),
+// format 2 (envelope, see envelope.go) or format 1, which earlier releases
+// wrote: MAGIC(4) + SALT(32) + NONCE(12) + CIPHERTEXT+TAG, keyed straight
+// from the password. Archives with legacyMagic are read too.
 func decryptData(data []byte, password string) ([]byte, error) {
 	minLen := len(encryptMagic) + saltSize + nonceSize + 16 // 16 = GCM tag
 	if len(data) < minLen {
 		return nil, fmt.Errorf("encrypted data too short (%d bytes)", len(data))
 	}
 
-	if !bytes.Equal(data[:len(encryptMagic)], encryptMagic) {
-		return nil, fmt.Errorf("invalid encrypted file format: bad magic bytes")
+	if !hasArchiveMagic(data) {
+		return nil, fmt.Errorf("not a %s archive: magic %q, expected %q", archiveBrand, data[:len(encryptMagic)], encryptMagic)
+	}
+	if isChunked(data) {
+		return openChunked(data, password)
+	}
+	if isEnvelope(data) {
+		return openEnvelope(data, password)
 	}
 
 	offset := len(encryptMagic)
@@ -1722,7 +4106,7 @@ func decryptData(data []byte, password string) ([]byte, error) {
 	offset += nonceSize
 	ciphertext := data[offset:]
 
-	key := deriveKey(password, salt)
+	key := deriveKey(password, salt, legacyKDF)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -1747,6 +4131,30 @@ func matchesHash(password, wantHex string) bool {
 	return hex.EncodeToString(h[:]) == wantHex
 }
 
+// passwordFingerprint is a short, non-secret check value for password: the
+// first 8 hex digits of sha256("global-logrotate fingerprint:" + password).
+// It is too short to verify a password on its own, or to recover one, but
+// catches a mistyped or wrongly provisioned LOGROTATE_PASSWORD. Shell
+// equivalent:
+//
+//	printf 'global-logrotate fingerprint:%s' "$LOGROTATE_PASSWORD" | sha256sum | cut -c1-8
+func passwordFingerprint(password string) string {
+	h := sha256.Sum256([]byte("global-logrotate fingerprint:" + password))
+	return hex.EncodeToString(h[:4])
+}
+
+// envPasswordMatches reports whether the LOGROTATE_PASSWORD value envPass
+// passes ENCRYPT_PASSWORD_FINGERPRINT, warning when it doesn't. Without a
+// fingerprint every value passes.
+func envPasswordMatches(envPass string, cfg *Config) bool {
+	if cfg.EncryptPassFP == "" || passwordFingerprint(envPass) == cfg.EncryptPassFP {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "Warning: LOGROTATE_PASSWORD does not match ENCRYPT_PASSWORD_FINGERPRINT (its fingerprint is %s)\n", passwordFingerprint(envPass))
+	logError("LOGROTATE_PASSWORD environment variable does not match ENCRYPT_PASSWORD_FINGERPRINT")
+	return false
+}
+
 func getEncryptionPassword(cfg *Config) string {
 	passwordMu.Lock()
 	defer passwordMu.Unlock()
@@ -1755,6 +4163,12 @@ func getEncryptionPassword(cfg *Config) string {
 		return cachedPassword
 	}
 
+	// A keyfile replaces the password lookup entirely.
+	if cfg.EncryptKeyfile != "" {
+		cachedPassword = keyfileSecret(cfg)
+		return cachedPassword
+	}
+
 	if cfg.EncryptPassword != "" {
 		cachedPassword = cfg.EncryptPassword
 		return cachedPassword
@@ -1788,6 +4202,12 @@ func getEncryptionPassword(cfg *Config) string {
 			}
 			fmt.Fprintf(os.Stderr, "Warning: LOGROTATE_PASSWORD does not match configured hash\n")
 			logError("LOGROTATE_PASSWORD environment variable does not match configured hash")
+		} else if cfg.EncryptPassFP != "" {
+			if envPasswordMatches(envPass, cfg) {
+				cachedPassword = envPass
+				logDebug("Password loaded from environment variable (fingerprint verified)")
+				return cachedPassword
+			}
 		} else {
 			// No hash — don't cache, same reasoning as credentials file path.
 			logDebug("Password loaded from environment variable (no hash verification)")
@@ -1796,86 +4216,384 @@ func getEncryptionPassword(cfg *Config) string {
 	}
 
 	if cfg.EncryptPassHash != "" {
-		password, err := readPassword("Enter encryption password: ")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-			return ""
-		}
-		if matchesHash(password, cfg.EncryptPassHash) {
+		// Prompt at most once per process. Parallel workers arriving here wait on
+		// passwordMu and then reuse the outcome, including a wrong entry, instead of
+		// each taking a turn at the terminal.
+		passwordPromptOnce.Do(func() {
+			password, err := readPassword("Enter encryption password: ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
+				return
+			}
+			if !matchesHash(password, cfg.EncryptPassHash) {
+				fmt.Fprintf(os.Stderr, "Error: Password does not match configured hash\n")
+				logError("Entered password does not match configured hash")
+				return
+			}
 			cachedPassword = password
-			return cachedPassword
-		}
-		fmt.Fprintf(os.Stderr, "Error: Password does not match configured hash\n")
-		logError("Entered password does not match configured hash")
-		return ""
+		})
+		return cachedPassword
 	}
 
 	return ""
 }
 
+// resolveEncryptionPassword looks the password up once, through the same chain
+// as getEncryptionPassword, and seeds cachedPassword with it so every worker
+// uses it without prompting or re-reading the credentials file.
+func resolveEncryptionPassword(cfg *Config) error {
+	password := getEncryptionPassword(cfg)
+	if password == "" {
+		if cfg.EncryptKeyfile != "" {
+			return fmt.Errorf("ENCRYPT_KEYFILE %s cannot be used", cfg.EncryptKeyfile)
+		}
+		if cfg.EncryptPassHash != "" {
+			return fmt.Errorf("no password matching ENCRYPT_PASSWORD_HASH is available (checked ENCRYPT_PASSWORD, the credentials file, LOGROTATE_PASSWORD and the terminal)")
+		}
+		if cfg.EncryptPassFP != "" && os.Getenv("LOGROTATE_PASSWORD") != "" {
+			return fmt.Errorf("LOGROTATE_PASSWORD does not match ENCRYPT_PASSWORD_FINGERPRINT %s; refusing to encrypt with it", cfg.EncryptPassFP)
+		}
+		return fmt.Errorf("no encryption password is available")
+	}
+	passwordMu.Lock()
+	cachedPassword = password
+	passwordMu.Unlock()
+	logDebug("Encryption password resolved before rotation")
+	return nil
+}
+
+// readLogFile writes the content of a rotated file, or of every archive in a
+// directory, to stdout, or with --out to that file.
 func readLogFile(filePath string, cfg *Config) error {
-	if _, err := os.Stat(filePath); err != nil {
+	if cfg.Out != "" {
+		return decryptArchiveTo(filePath, cfg.Out, cfg)
+	}
+	return streamRead(os.Stdout, filePath, cfg)
+}
+
+// streamRead writes what --read shows of filePath to w: its whole content, or
+// with --tail only its last cfg.Tail lines.
+func streamRead(w io.Writer, filePath string, cfg *Config) error {
+	if cfg.Tail <= 0 {
+		return streamLogFile(w, filePath, cfg)
+	}
+	t := newTailWriter(cfg.Tail)
+	if err := streamLogFile(t, filePath, cfg); err != nil {
+		return err
+	}
+	return t.writeTo(w)
+}
+
+// streamLogFile writes the decrypted, decompressed content of a rotated file to w.
+// Plain, compressed-only and chunked (formats 3 to 5) encrypted archives are
+// streamed straight from disk; older encrypted formats and gpg archives have to
+// be authenticated as a whole first, so their decrypted (still compressed)
+// payload is held in memory.
+func streamLogFile(w io.Writer, filePath string, cfg *Config) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if splitPartsExist(filePath) {
+			return streamSplitArchive(w, filePath, cfg)
+		}
 		return fmt.Errorf("file not found: %s", filePath)
 	}
+	if info.IsDir() {
+		return streamLogDir(w, filePath, cfg)
+	}
+	if _, ok := splitArchiveOf(filePath); ok {
+		return streamSplitArchive(w, filePath, cfg)
+	}
+	if cfg.Member != "" {
+		return streamBundleMember(w, filePath, cfg.Member, cfg)
+	}
 
-	data, err := os.ReadFile(filePath)
+	f, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+	return streamArchive(w, filePath, f, cfg)
+}
 
-	var content []byte
+// streamArchive writes the decrypted, decompressed content of src to w, picking
+// the decryption from name's extension and the codec by sniffing the content.
+// When the archive records the log's checksum (formats 4 and 5), the output is
+// checked against it and a mismatch is warned about loudly.
+func streamArchive(w io.Writer, name string, src io.Reader, cfg *Config) error {
+	inner := name
+	if strings.HasSuffix(name, ".gpg") || strings.HasSuffix(name, ".enc") {
+		var err error
+		if src, err = decryptStream(name, src, cfg); err != nil {
+			return err
+		}
+		inner = name[:strings.LastIndex(name, ".")]
+	}
 
-	if strings.HasSuffix(filePath, ".gz.enc") {
-		// Encrypted and compressed file (new format)
-		content, err = readEncryptedGzFile(data, cfg)
-	} else if strings.HasSuffix(filePath, ".enc") {
-		// Encrypted only
-		content, err = readEncryptedFile(data, cfg)
-	} else if strings.HasSuffix(filePath, ".gz.gpg") {
-		// Legacy GPG encrypted file
-		return fmt.Errorf("legacy GPG format (.gz.gpg) is no longer supported. Please use gpg command directly to decrypt")
-	} else if strings.HasSuffix(filePath, ".gz") {
-		// Compressed only
-		content, err = decompressGzip(data)
+	// Decompress whatever the content's magic number says it is (ours or
+	// another tool's); plain text otherwise.
+	r, err := decompressReader(inner, src)
+	if err != nil {
+		return err
+	}
+	d, checked := src.(*decryptReader)
+	if !checked {
+		_, err = io.Copy(w, r)
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return err
+	}
+	// The checksum follows the last chunk, which the codec may not have
+	// needed to read.
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		return err
+	}
+	logSum := d.logSum()
+	if logSum == nil {
+		return nil
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, logSum) {
+		fmt.Fprintf(os.Stderr, "WARNING: %s did NOT decompress to the original log: SHA-256 %x, recorded at rotation %x\n", name, got, logSum)
+		logError("Checksum mismatch reading %s: got %x, recorded %x", name, got, logSum)
 	} else {
-		// Plain text
-		content = data
+		logDebug("%s matches the checksum recorded at rotation", name)
+	}
+	return nil
+}
+
+// decryptStream returns the decrypted payload of src, the encrypted archive
+// name. Chunked (formats 3 to 5) archives are decrypted a chunk at a time as
+// they are read, by a *decryptReader; older formats and gpg archives are
+// authenticated as a whole, so their payload is read into memory first.
+func decryptStream(name string, src io.Reader, cfg *Config) (io.Reader, error) {
+	var content []byte
+	if strings.HasSuffix(name, ".gpg") {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+		// GPG encrypted (ENCRYPT_BACKEND=gpg), decrypted with the caller's keyring
+		if content, err = gpgDecrypt(data, cfg); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(content), nil
 	}
 
+	br := bufio.NewReader(src)
+	if head, _ := br.Peek(len(encryptMagic) + len(chunkedMarker)); isChunked(head) {
+		var password string
+		if !isPublicKeyArchive(head) {
+			if password = getDecryptionPassword(cfg); password == "" {
+				return nil, fmt.Errorf("no password provided for decryption")
+			}
+		}
+		d, err := newDecryptReader(br, password)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	data, err := io.ReadAll(br)
 	if err != nil {
+		return nil, err
+	}
+	if content, err = readEncryptedFile(data, cfg); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// streamToFIFO feeds the content of a rotated file into the named pipe at
+// fifoPath, creating it (0600) when it doesn't exist and removing it again
+// afterwards. Opening blocks until a reader attaches, so plaintext never lands on
+// disk. A reader that goes away early is reported instead of killing us with SIGPIPE.
+func streamToFIFO(fifoPath, filePath string, cfg *Config) error {
+	if info, err := os.Stat(fifoPath); err == nil {
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("%s exists and is not a named pipe", fifoPath)
+		}
+	} else if os.IsNotExist(err) {
+		if err := makeFIFO(fifoPath, 0600); err != nil {
+			return fmt.Errorf("creating FIFO %s: %w", fifoPath, err)
+		}
+		defer os.Remove(fifoPath)
+	} else {
 		return err
 	}
 
-	fmt.Print(string(content))
+	logInfo("Waiting for a reader on FIFO %s", fifoPath)
+	fifo, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("opening FIFO %s: %w", fifoPath, err)
+	}
+	defer fifo.Close()
+
+	cw := &countingWriter{w: fifo}
+	if err := streamLogFile(cw, filePath, cfg); err != nil {
+		if errors.Is(err, syscall.EPIPE) {
+			logError("FIFO reader on %s went away after %d bytes of %s", fifoPath, cw.n, filePath)
+			return fmt.Errorf("reader closed %s after %s — output incomplete", fifoPath, formatSize(cw.n))
+		}
+		return err
+	}
+	logInfo("Streamed %s (%s) into FIFO %s", filePath, formatSize(cw.n), fifoPath)
 	return nil
 }
 
-func readEncryptedFile(data []byte, cfg *Config) ([]byte, error) {
-	password := getDecryptionPassword(cfg)
-	if password == "" {
-		return nil, fmt.Errorf("no password provided for decryption")
-	}
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	return decryptData(data, password)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func readEncryptedGzFile(data []byte, cfg *Config) ([]byte, error) {
-	password := getDecryptionPassword(cfg)
-	if password == "" {
-		return nil, fmt.Errorf("no password provided for decryption")
+// countingWriter counts the bytes successfully written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// repairedPath returns the path a salvaged copy of archive is written to:
+// app.log.20240115.gz.enc becomes app.log.20240115.repaired.gz.enc.
+func repairedPath(archive string) string {
+	base, suffix := archive, ""
+	if strings.HasSuffix(base, ".enc") {
+		base, suffix = strings.TrimSuffix(base, ".enc"), ".enc"
+	}
+	if c, ok := codecForPath(base); ok {
+		base, suffix = strings.TrimSuffix(base, "."+c.ext), "."+c.ext+suffix
 	}
+	return base + ".repaired" + suffix
+}
 
-	// Decrypt first
-	compressed, err := decryptData(data, password)
+// salvageStream decompresses as much of data as c can decode. It returns the
+// recovered bytes together with the error that stopped decoding, which is nil
+// when the stream turned out to be intact.
+func salvageStream(c codec, data []byte) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
+	var out bytes.Buffer
+	_, err = io.Copy(&out, r)
+	return out.Bytes(), err
+}
+
+// repairArchive salvages a partially written archive (e.g. left behind by a crash
+// before atomic writes) into a new file next to it. The damaged original is never
+// modified. Compressed streams are decoded up to the first corrupt byte and
+// re-written cleanly; encrypted archives are checked for an intact header, but
+// AES-GCM authenticates the payload as a whole, so a truncated ciphertext can't
+// release any plaintext.
+func repairArchive(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out := repairedPath(path)
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists — remove it first", out)
+	}
+
+	encrypted := strings.HasSuffix(path, ".enc")
+	c, compressed := codecForPath(strings.TrimSuffix(path, ".enc"))
+	if !compressed {
+		return fmt.Errorf("%s is not a compressed archive (.gz, .xz)", path)
+	}
+
+	payload := data
+	var password string
+	if encrypted {
+		fmt.Printf("Archive:    %s (%d bytes)\n", path, len(data))
+		minLen := encryptedHeaderLen(data) + 16
+		switch {
+		case len(data) < len(encryptMagic) || !hasArchiveMagic(data):
+			fmt.Println("Header:     damaged (bad magic bytes)")
+			return fmt.Errorf("encrypted header is not intact — nothing recoverable")
+		case len(data) < minLen:
+			fmt.Println("Header:     truncated (salt/nonce incomplete)")
+			return fmt.Errorf("encrypted header is not intact — nothing recoverable")
+		}
+		fmt.Println("Header:     intact")
+		fmt.Printf("Ciphertext: %d bytes\n", len(data)-encryptedHeaderLen(data))
+
+		if !isPublicKeyArchive(data) {
+			if password = getDecryptionPassword(cfg); password == "" {
+				return fmt.Errorf("no password provided for decryption")
+			}
+		}
+		payload, err = decryptData(data, password)
+		if err != nil {
+			fmt.Println("Payload:    authentication failed - 0 bytes recoverable")
+			return fmt.Errorf("encrypted payload is truncated or corrupt: %w", err)
+		}
+		fmt.Println("Payload:    authenticated")
+	}
+
+	recovered, streamErr := salvageStream(c, payload)
+	if streamErr == nil {
+		fmt.Printf("%s: archive is intact (%s), nothing to repair\n", path, formatSize(int64(len(recovered))))
+		return nil
+	}
+	if len(recovered) == 0 {
+		return fmt.Errorf("no data recoverable: %w", streamErr)
+	}
+
+	repaired, err := compressWith(c, bytes.NewReader(recovered))
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		sum := sha256.Sum256(recovered)
+		if isPublicKeyArchive(data) {
+			// Sealed again to the key the archive was, not to ENCRYPT_PUBLIC_KEY or
+			// a password: the private key that opened it is that key's pair.
+			repaired, err = sealPublic(repaired, &archivePrivateKey.PublicKey, sum[:])
+		} else {
+			repaired, err = encryptData(repaired, password, sum[:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(out, repaired, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: recovered %s before %v\n", path, formatSize(int64(len(recovered))), streamErr)
+	fmt.Printf("Repaired archive written to %s\n", out)
+	logInfo("Repaired %s -> %s (%d bytes recovered, stopped at: %v)", path, out, len(recovered), streamErr)
+	return nil
+}
+
+func readEncryptedFile(data []byte, cfg *Config) ([]byte, error) {
+	if isPublicKeyArchive(data) {
+		return decryptData(data, "")
+	}
+	password := getDecryptionPassword(cfg)
+	if password == "" {
+		return nil, fmt.Errorf("no password provided for decryption")
+	}
 
-	// Then decompress
-	return decompressGzip(compressed)
+	return decryptData(data, password)
 }
 
 func getDecryptionPassword(cfg *Config) string {
+	if cfg.EncryptKeyfile != "" {
+		return keyfileSecret(cfg)
+	}
 	if cfg.EncryptPassword != "" {
 		return cfg.EncryptPassword
 	}
@@ -1898,7 +4616,7 @@ func getDecryptionPassword(cfg *Config) string {
 				return envPass
 			}
 			fmt.Fprintf(os.Stderr, "Warning: LOGROTATE_PASSWORD does not match configured hash\n")
-		} else {
+		} else if envPasswordMatches(envPass, cfg) {
 			return envPass
 		}
 	}
@@ -1917,27 +4635,73 @@ func getDecryptionPassword(cfg *Config) string {
 	return password
 }
 
-func formatSize(bytes int64) string {
-	const (
-		B  = 1
-		KB = 1024 * B
-		MB = 1024 * KB
-		GB = 1024 * MB
-		TB = 1024 * GB
-	)
-
+// sizeStats describes how a source of original bytes became an archive of final
+// bytes, compressed bytes of which came out of the codec. Incompressible input
+// and encryption overhead are shown as the growth they are rather than as
+// "0% compression, saved 0 B".
+func sizeStats(original, compressed, final int64) string {
+	var parts []string
 	switch {
-	case bytes >= TB:
-		return fmt.Sprintf("%.2f TB", float64(bytes)/float64(TB))
-	case bytes >= GB:
-		return fmt.Sprintf("%.2f GB", float64(bytes)/float64(GB))
-	case bytes >= MB:
-		return fmt.Sprintf("%.2f MB", float64(bytes)/float64(MB))
-	case bytes >= KB:
-		return fmt.Sprintf("%.2f KB", float64(bytes)/float64(KB))
+	case compressed == original:
+		parts = append(parts, "stored (no compression)")
+	case compressed < original:
+		parts = append(parts, fmt.Sprintf("%.1f%% compression", (1-float64(compressed)/float64(original))*100))
 	default:
-		return fmt.Sprintf("%d B", bytes)
+		parts = append(parts, fmt.Sprintf("incompressible, codec added %s", formatSize(compressed-original)))
+	}
+	if final != compressed {
+		parts = append(parts, fmt.Sprintf("encryption added %s", formatSize(final-compressed)))
+	}
+	parts = append(parts, sizeChange(original, final))
+	return fmt.Sprintf("%s -> %s (%s)", formatSize(original), formatSize(final), strings.Join(parts, ", "))
+}
+
+// sizeChange says how much smaller or larger after is than before.
+func sizeChange(before, after int64) string {
+	if after > before {
+		return "grew by " + formatSize(after-before)
+	}
+	return "saved " + formatSize(before-after)
+}
+
+func formatSize(bytes int64) string {
+	for _, u := range sizeUnits {
+		if bytes >= u.size {
+			return fmt.Sprintf("%.2f %s", float64(bytes)/float64(u.size), u.name)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// sizeUnits are the binary units formatSize writes and parseSize reads,
+// largest first.
+var sizeUnits = []struct {
+	name string
+	size int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+}
+
+// parseSize reads a size the way formatSize writes one, with the unit's first
+// letter enough and the space optional: "500M", "10G", "1.5 GB", "64k". A bare
+// number is bytes.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(num, u.name[:1]) {
+			num, mult = strings.TrimSuffix(num, u.name[:1]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 500M or 10G)", s)
 	}
+	return int64(n * float64(mult)), nil
 }
 
 func timestamp() string {
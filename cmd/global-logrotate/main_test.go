@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -250,7 +255,7 @@ func TestEncryptDecryptRoundtrip(t *testing.T) {
 	plaintext := []byte("sensitive log content 1234567890")
 	password := "test-password-xyz"
 
-	ct, err := encryptData(plaintext, password)
+	ct, err := encryptData(plaintext, password, nil)
 	if err != nil {
 		t.Fatalf("encryptData: %v", err)
 	}
@@ -268,15 +273,15 @@ func TestEncryptDecryptRoundtrip(t *testing.T) {
 }
 
 func TestEncryptOutputNondeterministic(t *testing.T) {
-	ct1, _ := encryptData([]byte("same data"), "pw")
-	ct2, _ := encryptData([]byte("same data"), "pw")
+	ct1, _ := encryptData([]byte("same data"), "pw", nil)
+	ct2, _ := encryptData([]byte("same data"), "pw", nil)
 	if bytes.Equal(ct1, ct2) {
 		t.Error("two encryptions of same plaintext are identical — salt/nonce not random")
 	}
 }
 
 func TestDecryptWrongPassword(t *testing.T) {
-	ct, _ := encryptData([]byte("data"), "correct")
+	ct, _ := encryptData([]byte("data"), "correct", nil)
 	if _, err := decryptData(ct, "wrong"); err == nil {
 		t.Error("expected error for wrong password")
 	}
@@ -290,6 +295,43 @@ func TestDecryptBadMagic(t *testing.T) {
 	}
 }
 
+func TestArchiveMagicIsolation(t *testing.T) {
+	defer setArchiveMagic(encryptMagicStr)
+
+	if err := setArchiveMagic("ACME"); err != nil {
+		t.Fatal(err)
+	}
+	acme, _ := encryptData([]byte("fork data"), "pw", nil)
+	if !bytes.HasPrefix(acme, []byte("ACME")) {
+		t.Fatalf("header = %q, want ACME magic", acme[:4])
+	}
+
+	setArchiveMagic(encryptMagicStr)
+	_, err := decryptData(acme, "pw")
+	if err == nil || !strings.Contains(err.Error(), "not a "+archiveBrand+" archive") {
+		t.Errorf("err = %v, want brand mismatch", err)
+	}
+
+	// Archives from before format 4 carry the old default magic: readable
+	// under the default magic, and never under another one.
+	setArchiveMagic(legacyMagic)
+	legacy, _ := encryptData([]byte("old default"), "pw", nil)
+	setArchiveMagic(defaultMagic)
+	if got, err := decryptData(legacy, "pw"); err != nil || string(got) != "old default" {
+		t.Errorf("%s archive under %s: %q, %v", legacyMagic, defaultMagic, got, err)
+	}
+	setArchiveMagic("ACME")
+	if _, err := decryptData(legacy, "pw"); err == nil {
+		t.Errorf("%s archive decrypted under ACME", legacyMagic)
+	}
+
+	for _, bad := range []string{"", "AB", "TOOLONG"} {
+		if err := setArchiveMagic(bad); err == nil {
+			t.Errorf("setArchiveMagic(%q) should fail", bad)
+		}
+	}
+}
+
 func TestDecryptTooShort(t *testing.T) {
 	if _, err := decryptData([]byte("short"), "pw"); err == nil {
 		t.Error("expected error for data too short")
@@ -303,7 +345,7 @@ func TestCompressEncryptRoundtrip(t *testing.T) {
 	if err != nil {
 		t.Fatalf("compress: %v", err)
 	}
-	encrypted, err := encryptData(compressed, "pw")
+	encrypted, err := encryptData(compressed, "pw", nil)
 	if err != nil {
 		t.Fatalf("encrypt: %v", err)
 	}
@@ -346,6 +388,36 @@ func TestFormatSize(t *testing.T) {
 	}
 }
 
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"2048", 2048},
+		{"500M", 500 << 20},
+		{"10G", 10 << 30},
+		{"64k", 64 << 10},
+		{"1.50 KB", 1536},
+		{"1TB", 1 << 40},
+		{" 3 mb ", 3 << 20},
+	}
+	for _, tt := range tests {
+		if got, err := parseSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "G", "ten", "-1M", "5X"} {
+		if _, err := parseSize(bad); err == nil {
+			t.Errorf("parseSize(%q) should fail", bad)
+		}
+	}
+	for _, n := range []int64{512, 1 << 20, 3 << 30} {
+		if got, _ := parseSize(formatSize(n)); got != n {
+			t.Errorf("parseSize(formatSize(%d)) = %d", n, got)
+		}
+	}
+}
+
 func TestMatchesHash(t *testing.T) {
 	// sha256("password")
 	hash := "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
@@ -374,6 +446,29 @@ func TestParseLogLevel(t *testing.T) {
 	}
 }
 
+func TestVerboseMirrorsEveryLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "global-logrotate.log")
+	if err := initLogger(path, LogLevelError); err != nil {
+		t.Fatal(err)
+	}
+	var mirror bytes.Buffer
+	verboseOut = &mirror
+	defer func() { closeLogger(); logger, verboseOut = nil, nil }()
+
+	logError("disk full")
+	logDebug("looking at app.log")
+	for _, want := range []string{"[ERROR] disk full", "[DEBUG] looking at app.log"} {
+		if !strings.Contains(mirror.String(), want) {
+			t.Errorf("--verbose output lacks %q:\n%s", want, mirror.String())
+		}
+	}
+	// The file still keeps to LOG_LEVEL.
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "disk full") || strings.Contains(string(data), "app.log") {
+		t.Errorf("log file at level error holds:\n%s", data)
+	}
+}
+
 func TestMaskPassword(t *testing.T) {
 	tests := []struct {
 		in, want string
@@ -575,6 +670,40 @@ func TestDiskStatsBadPath(t *testing.T) {
 	}
 }
 
+func TestParsePercent(t *testing.T) {
+	for in, want := range map[string]float64{"85%": 85, "85": 85, " 92.5% ": 92.5, "100%": 100} {
+		if got, err := parsePercent(in); err != nil || got != want {
+			t.Errorf("parsePercent(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "0%", "101%", "-5", "lots"} {
+		if _, err := parsePercent(bad); err == nil {
+			t.Errorf("parsePercent(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBelowFSThreshold(t *testing.T) {
+	cfg := &Config{LogDir: t.TempDir()}
+	if below, err := belowFSThreshold(cfg); err != nil || below {
+		t.Errorf("no threshold: below=%v err=%v, want false, nil", below, err)
+	}
+	_, _, pct, err := diskStats(cfg.LogDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pct < 100 {
+		cfg.FSThreshold = "100%"
+		if below, err := belowFSThreshold(cfg); err != nil || !below {
+			t.Errorf("100%% threshold: below=%v err=%v, want true", below, err)
+		}
+	}
+	cfg.LogDir = "/nonexistent/path/xyz"
+	if _, err := belowFSThreshold(cfg); err == nil {
+		t.Error("expected statfs error for nonexistent path")
+	}
+}
+
 // ============================================================
 // File discovery
 // ============================================================
@@ -584,7 +713,7 @@ func TestFindLogFiles(t *testing.T) {
 	for _, name := range []string{"app.log", "access.log", "error.log", "other.txt", "debug.log"} {
 		os.WriteFile(filepath.Join(dir, name), []byte("content"), 0644)
 	}
-	files := findLogFiles(dir, "*.log", nil)
+	files := findLogFiles(dir, "*.log", nil, 0, walkScope{})
 	if len(files) != 4 {
 		t.Errorf("found %d files, want 4", len(files))
 	}
@@ -595,7 +724,7 @@ func TestFindLogFilesExclude(t *testing.T) {
 	for _, name := range []string{"app.log", "access.log", "debug.log"} {
 		os.WriteFile(filepath.Join(dir, name), []byte("content"), 0644)
 	}
-	files := findLogFiles(dir, "*.log", []string{"debug.log"})
+	files := findLogFiles(dir, "*.log", []string{"debug.log"}, 0, walkScope{})
 	if len(files) != 2 {
 		t.Errorf("found %d files, want 2 (debug.log excluded)", len(files))
 	}
@@ -609,7 +738,7 @@ func TestFindLogFilesExclude(t *testing.T) {
 func TestFindLogFilesNoMatch(t *testing.T) {
 	dir := t.TempDir()
 	os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644)
-	files := findLogFiles(dir, "*.log", nil)
+	files := findLogFiles(dir, "*.log", nil, 0, walkScope{})
 	if len(files) != 0 {
 		t.Errorf("expected 0 files, got %d", len(files))
 	}
@@ -622,7 +751,7 @@ func TestFindLogFilesSortedBySize(t *testing.T) {
 	for i, sz := range sizes {
 		os.WriteFile(filepath.Join(dir, fmt.Sprintf("app%d.log", i)), bytes.Repeat([]byte("x"), sz), 0644)
 	}
-	files := findLogFiles(dir, "*.log", nil)
+	files := findLogFiles(dir, "*.log", nil, 0, walkScope{})
 	for i := 1; i < len(files); i++ {
 		if files[i].size < files[i-1].size {
 			t.Errorf("files not sorted by size: [%d]=%d > [%d]=%d", i-1, files[i-1].size, i, files[i].size)
@@ -630,6 +759,113 @@ func TestFindLogFilesSortedBySize(t *testing.T) {
 	}
 }
 
+func TestFindLogFilesMinAge(t *testing.T) {
+	dir := t.TempDir()
+	oldLog := filepath.Join(dir, "old.log")
+	os.WriteFile(oldLog, []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "fresh.log"), []byte("x"), 0644)
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(oldLog, twoDaysAgo, twoDaysAgo)
+
+	d, err := parseInterval("1d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := findLogFiles(dir, "*.log", nil, d, walkScope{})
+	if len(files) != 1 || files[0].path != oldLog {
+		t.Errorf("MIN_AGE 1d found %v, want only old.log", files)
+	}
+	if files := findLogFiles(dir, "*.log", nil, 72*time.Hour, walkScope{}); len(files) != 0 {
+		t.Errorf("MIN_AGE 72h found %v, want nothing", files)
+	}
+}
+
+func TestFindLogFilesRegex(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app-2024.log", "app-test.log", "app-2024.log.1", "xapp-2024.log"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	files := findLogFiles(dir, `app-\d{4}\.log`, nil, 0, walkScopeFor(&Config{Pattern: `app-\d{4}\.log`, PatternRegex: true}))
+	if len(files) != 1 || filepath.Base(files[0].path) != "app-2024.log" {
+		t.Errorf("regex found %v, want app-2024.log only (the whole name must match)", files)
+	}
+	if _, err := compilePattern("*.log"); err == nil {
+		t.Error("compilePattern accepted an invalid expression")
+	}
+}
+
+func TestFindLogFilesDepth(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"top.log", "a/mid.log", "a/b/deep.log"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	count := func(cfg *Config) int { return len(findLogFiles(dir, "*.log", nil, 0, walkScopeFor(cfg))) }
+	for _, tc := range []struct {
+		cfg  Config
+		want int
+	}{
+		{Config{}, 3},
+		{Config{MaxDepth: 1}, 1},
+		{Config{MaxDepth: 2}, 2},
+		{Config{MaxDepth: 3}, 3},
+		{Config{NoRecurse: true, MaxDepth: 3}, 1},
+	} {
+		if got := count(&tc.cfg); got != tc.want {
+			t.Errorf("MaxDepth %d, NoRecurse %v: found %d files, want %d", tc.cfg.MaxDepth, tc.cfg.NoRecurse, got, tc.want)
+		}
+	}
+}
+
+func TestFindLogFilesSkipsBackupRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.log", "old_logs/20240115/app.log", "archive/20240115/app.log", "routed/20240115/auth.log"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	files := findLogFiles(dir, "*.log", nil, 0, walkScopeFor(&Config{}))
+	if len(files) != 3 {
+		t.Errorf("default scope found %v, want everything but old_logs", files)
+	}
+	cfg := &Config{OldLogsDir: filepath.Join(dir, "archive"), RouteRules: "auth*:" + filepath.Join(dir, "routed")}
+	files = findLogFiles(dir, "*.log", nil, 0, walkScopeFor(cfg))
+	if len(files) != 2 {
+		t.Errorf("with OLD_LOGS_DIR and ROUTE_RULES found %v, want app.log and old_logs/ only", files)
+	}
+}
+
+func TestFindLogFilesPrunesLargeArchiveTree(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.log"), []byte("x"), 0644)
+	// A year of archives for a few logs, all matching the broad pattern.
+	for day := 0; day < 365; day++ {
+		date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, day).Format("20060102")
+		sub := filepath.Join(dir, "old_logs", date)
+		os.MkdirAll(sub, 0755)
+		for _, name := range []string{"app.log", "error.log", "access.log"} {
+			os.WriteFile(filepath.Join(sub, name+"."+date+".gz"), nil, 0644)
+		}
+	}
+	files := findLogFiles(dir, "*", nil, 0, walkScopeFor(&Config{}))
+	if len(files) != 1 || filepath.Base(files[0].path) != "app.log" {
+		t.Fatalf("found %d files, want app.log only", len(files))
+	}
+
+	// OLD_LOGS_DIR named through a symlink is still recognised inside LOG_DIR,
+	// as is STAGING_DIR.
+	os.Rename(filepath.Join(dir, "old_logs"), filepath.Join(dir, "backups"))
+	os.MkdirAll(filepath.Join(dir, "staging"), 0755)
+	os.WriteFile(filepath.Join(dir, "staging", "app.log.20240115.gz"), nil, 0644)
+	link := filepath.Join(t.TempDir(), "backups")
+	if err := os.Symlink(filepath.Join(dir, "backups"), link); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	cfg := &Config{OldLogsDir: link, StagingDir: filepath.Join(dir, "staging")}
+	if files := findLogFiles(dir, "*", nil, 0, walkScopeFor(cfg)); len(files) != 1 {
+		t.Errorf("found %d files with OLD_LOGS_DIR through a symlink, want app.log only", len(files))
+	}
+}
+
 // ============================================================
 // Rotation integration tests
 // ============================================================
@@ -716,155 +952,1624 @@ func TestRotateLogFileEncrypted(t *testing.T) {
 	if !bytes.Equal(recovered, content) {
 		t.Error("encrypted roundtrip failed")
 	}
-}
-
-func TestRotateLogFileSkipsEmpty(t *testing.T) {
-	dir := t.TempDir()
-	logPath := filepath.Join(dir, "empty.log")
-	os.WriteFile(logPath, []byte{}, 0644)
-
-	rotateLogFile(logPath, makeTestCfg(t, dir))
-
-	archiveDir := filepath.Join(dir, "old")
-	if _, err := os.Stat(archiveDir); !os.IsNotExist(err) {
-		t.Error("archive dir should not exist for empty file")
+	if sum := sha256.Sum256(content); !bytes.Equal(archiveLogSum(data), sum[:]) {
+		t.Errorf("archive records checksum %x, want the log's %x", archiveLogSum(data), sum)
 	}
 }
 
-func TestRotateLogFileDryRun(t *testing.T) {
+func TestEncryptRulesPerFile(t *testing.T) {
 	dir := t.TempDir()
-	logPath := filepath.Join(dir, "test.log")
-	content := []byte("log content")
-	os.WriteFile(logPath, content, 0644)
+	os.WriteFile(filepath.Join(dir, "auth.log"), []byte("login ok\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "access.log"), []byte("GET /\n"), 0644)
 
 	cfg := makeTestCfg(t, dir)
-	cfg.DryRun = true
-	rotateLogFile(logPath, cfg)
+	cfg.EncryptRules = "auth*.log:on, *:off"
+	cfg.EncryptPassword = "rules-pw"
+	passwordMu.Lock()
+	cachedPassword = ""
+	passwordMu.Unlock()
 
-	// File must be unmodified
-	info, _ := os.Stat(logPath)
-	if info.Size() == 0 {
-		t.Error("dry-run must not truncate original")
+	if res := rotateLogFile(filepath.Join(dir, "auth.log"), cfg); !res.Encrypted || !strings.HasSuffix(res.Archive, ".gz.enc") {
+		t.Errorf("auth.log: encrypted=%v archive=%s, want .gz.enc", res.Encrypted, res.Archive)
 	}
-	// No archive
-	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
-		t.Error("dry-run must not create archive")
+	cfg.Encrypt = true // a matching off rule beats ENCRYPT
+	if res := rotateLogFile(filepath.Join(dir, "access.log"), cfg); res.Encrypted || !strings.HasSuffix(res.Archive, ".gz") {
+		t.Errorf("access.log: encrypted=%v archive=%s, want plain .gz", res.Encrypted, res.Archive)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "old", "20240115", "access.log.20240115.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := decompressGzip(data); string(got) != "GET /\n" {
+		t.Errorf("access.log archive = %q", got)
+	}
+
+	if !encryptionUsed(&Config{EncryptRules: "auth*.log:on"}) || encryptionUsed(&Config{EncryptRules: "*.log:off"}) {
+		t.Error("encryptionUsed should follow on rules")
+	}
+	if _, err := parseEncryptRules("auth.log:maybe"); err == nil {
+		t.Error("invalid toggle value should fail")
 	}
 }
 
-func TestRotateLogFileAlreadyRotated(t *testing.T) {
-	dir := t.TempDir()
-	logPath := filepath.Join(dir, "app.log")
-	content := []byte("log content")
-	os.WriteFile(logPath, content, 0644)
+// A failed archive write must leave neither an archive nor its temp file, and
+// the source as it was: only complete archives ever appear under their name.
+func TestRotateLogFileWriteFailureLeavesNoArchive(t *testing.T) {
+	t.Setenv("LOGROTATE_PASSWORD", "pw")
+	cachedPassword = ""
+	t.Cleanup(func() { cachedPassword = "" })
+	for _, encrypt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypt=%v", encrypt), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := makeTestCfg(t, dir)
+			cfg.Encrypt = encrypt
+			logPath := filepath.Join(dir, "app.log")
+			content := []byte("2024-01-15 INFO keep me\n")
+			os.WriteFile(logPath, content, 0644)
+			archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+			if encrypt {
+				archive += ".enc"
+			}
+			// A directory in the temp file's place makes the write fail.
+			os.MkdirAll(archive+".tmp", 0755)
 
-	cfg := makeTestCfg(t, dir)
+			var res FileResult
+			captureStderr(t, func() { captureStdout(t, func() { res = rotateLogFile(logPath, cfg) }) })
+			if res.Status != statusFailed {
+				t.Fatalf("status %s, want %s", res.Status, statusFailed)
+			}
+			if _, err := os.Stat(archive); !os.IsNotExist(err) {
+				t.Error("archive exists after a failed write")
+			}
+			if _, err := os.Stat(archive + ".tmp"); !os.IsNotExist(err) {
+				t.Error("temp file left behind after a failed write")
+			}
+			if got, _ := os.ReadFile(logPath); !bytes.Equal(got, content) {
+				t.Errorf("source = %q after a failed write, want it untouched", got)
+			}
+		})
+	}
+}
 
-	// First rotation
-	rotateLogFile(logPath, cfg)
+func TestRotateLogFileVanishes(t *testing.T) {
+	for _, stage := range []string{"stat", "open", "truncate"} {
+		t.Run(stage, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, "app.log")
+			os.WriteFile(logPath, []byte("short-lived\n"), 0644)
+			cfg := makeTestCfg(t, dir)
+
+			rotateStageHook = func(s, path string) {
+				if s == stage {
+					os.Remove(path)
+				}
+			}
+			defer func() { rotateStageHook = nil }()
 
-	// Re-fill the file
-	os.WriteFile(logPath, content, 0644)
+			var errs int
+			cfg.Hooks = &RotationHooks{OnError: func(string, error) { errs++ }}
+			res := rotateLogFile(logPath, cfg)
+			if res.Status != statusVanished || res.Reason != stage || res.Err != nil || errs != 0 {
+				t.Fatalf("res = %+v (errors reported: %d), want %s at %s", res, errs, statusVanished, stage)
+			}
+			if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+				t.Error("vanished source was recreated")
+			}
 
-	// Second rotation should skip (archive already exists)
-	rotateLogFile(logPath, cfg)
+			archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+			leftovers, _ := filepath.Glob(filepath.Join(dir, "old", "*", "*.tmp"))
+			if len(leftovers) != 0 {
+				t.Errorf("partial archives left behind: %v", leftovers)
+			}
+			_, err := os.Stat(archive)
+			if stage == "truncate" {
+				// The archive is complete; it is the only copy of the data left.
+				if err != nil {
+					t.Errorf("complete archive removed: %v", err)
+				}
+			} else if !os.IsNotExist(err) {
+				t.Errorf("archive written for a file that vanished at %s", stage)
+			}
+		})
+	}
+}
 
-	// Original should still have content (second rotation skipped)
-	info, _ := os.Stat(logPath)
-	if info.Size() == 0 {
-		t.Error("second rotation should be skipped when archive exists")
+func TestHardlinkPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		want   string
+	}{
+		{hardlinkWarn, statusRotated},
+		{hardlinkRotate, statusRotated},
+		{hardlinkSkip, statusSkipped},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, "app.log")
+			os.WriteFile(logPath, []byte("linked\n"), 0644)
+			if err := os.Link(logPath, filepath.Join(dir, "current")); err != nil {
+				t.Skipf("hard links unsupported: %v", err)
+			}
+			cfg := makeTestCfg(t, dir)
+			cfg.HardlinkPolicy = tt.policy
+
+			res := rotateLogFile(logPath, cfg)
+			if res.Status != tt.want {
+				t.Fatalf("status = %s, want %s (err=%v)", res.Status, tt.want, res.Err)
+			}
+			data, _ := os.ReadFile(filepath.Join(dir, "current"))
+			if tt.want == statusSkipped && string(data) != "linked\n" {
+				t.Errorf("skipped file's other link changed: %q", data)
+			}
+		})
 	}
 }
 
-func TestRotateLogFileDiskGuard(t *testing.T) {
-	dir := t.TempDir()
-	logPath := filepath.Join(dir, "app.log")
-	os.WriteFile(logPath, []byte("data to rotate"), 0644)
+func TestResolveEncryptionPassword(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())                                              // no credentials file
+	hash := "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8" // sha256("password")
 
-	cfg := makeTestCfg(t, dir)
-	cfg.DiskMinFreeMB = 999_999_999 // impossibly large — always triggers skip
+	// A matching environment password is verified once and seeds the cache.
+	resetPasswordState()
+	t.Setenv("LOGROTATE_PASSWORD", "password")
+	if err := resolveEncryptionPassword(&Config{EncryptPassHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOGROTATE_PASSWORD", "")
+	if got := getEncryptionPassword(&Config{EncryptPassHash: hash}); got != "password" {
+		t.Errorf("workers got %q, want the seeded password", got)
+	}
+
+	// Nothing matching the hash (and nothing typed at the prompt) fails up front.
+	resetPasswordState()
+	t.Setenv("LOGROTATE_PASSWORD", "wrong")
+	r, w, _ := os.Pipe()
+	w.Close()
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin; r.Close(); resetPasswordState() }()
+	captureStdout(t, func() {
+		if err := resolveEncryptionPassword(&Config{EncryptPassHash: hash}); err == nil || !strings.Contains(err.Error(), "ENCRYPT_PASSWORD_HASH") {
+			t.Errorf("err = %v, want a hash mismatch error", err)
+		}
+	})
+}
 
-	rotateLogFile(logPath, cfg)
+func TestEnvPasswordFingerprint(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no credentials file
+	fp := "f8272e40"              // the documented shell pipeline, for "password"
+	if got := passwordFingerprint("password"); got != fp {
+		t.Fatalf("passwordFingerprint = %q, want %q", got, fp)
+	}
+	defer resetPasswordState()
 
-	// Original must NOT be truncated — disk guard returned early
-	info, _ := os.Stat(logPath)
-	if info.Size() == 0 {
-		t.Error("disk guard should prevent truncation when free space < DiskMinFreeMB")
+	resetPasswordState()
+	t.Setenv("LOGROTATE_PASSWORD", "password")
+	if err := resolveEncryptionPassword(&Config{EncryptPassFP: fp}); err != nil {
+		t.Fatal(err)
 	}
-	// No archive
-	archivePath := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
-	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
-		t.Error("disk guard should prevent archive creation")
+
+	resetPasswordState()
+	t.Setenv("LOGROTATE_PASSWORD", "passw0rd")
+	var err error
+	captureStdout(t, func() { err = resolveEncryptionPassword(&Config{EncryptPassFP: fp}) })
+	if err == nil || !strings.Contains(err.Error(), "ENCRYPT_PASSWORD_FINGERPRINT") {
+		t.Errorf("err = %v, want a fingerprint mismatch", err)
 	}
-}
 
-func TestRotateParallelMultipleFiles(t *testing.T) {
-	dir := t.TempDir()
-	var files []fileInfo
-	for i := range 5 {
-		name := fmt.Sprintf("app%d.log", i)
-		path := filepath.Join(dir, name)
-		content := bytes.Repeat([]byte("x"), 100+i*50)
-		os.WriteFile(path, content, 0644)
-		info, _ := os.Stat(path)
-		files = append(files, fileInfo{path: path, size: info.Size()})
+	// Without a fingerprint the variable is taken as it is.
+	resetPasswordState()
+	if got := getEncryptionPassword(&Config{}); got != "passw0rd" {
+		t.Errorf("got %q without a fingerprint", got)
 	}
+}
 
-	cfg := makeTestCfg(t, dir)
-	cfg.ParallelJobs = 3
-	cfg.Parallel = true
+// resetPasswordState forgets the cached password and re-arms the one-shot prompt.
+func resetPasswordState() {
+	passwordMu.Lock()
+	cachedPassword = ""
+	passwordPromptOnce = sync.Once{}
+	passwordMu.Unlock()
+}
 
-	rotateParallel(files, cfg)
+func TestPasswordPromptedOnceAcrossWorkers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("LOGROTATE_PASSWORD", "")
+	cfg := &Config{EncryptPassHash: "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"}
+
+	for _, typed := range []string{"password", "wrong"} {
+		resetPasswordState()
+		r, w, _ := os.Pipe()
+		w.WriteString(typed + "\n" + typed + "\n" + typed + "\n")
+		w.Close()
+		oldStdin := os.Stdin
+		os.Stdin = r
+
+		got := make([]string, 8)
+		out := captureStdout(t, func() {
+			var wg sync.WaitGroup
+			for i := range got {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got[i] = getEncryptionPassword(cfg)
+				}()
+			}
+			wg.Wait()
+		})
+		os.Stdin = oldStdin
+		r.Close()
 
-	for _, f := range files {
-		info, err := os.Stat(f.path)
-		if err != nil {
-			t.Errorf("file %s missing: %v", f.path, err)
-			continue
+		if n := strings.Count(out, "Enter encryption password"); n != 1 {
+			t.Errorf("%s: prompted %d times, want once", typed, n)
 		}
-		if info.Size() != 0 {
-			t.Errorf("file %s not truncated after parallel rotation", f.path)
+		want := ""
+		if typed == "password" {
+			want = "password"
+		}
+		for i, p := range got {
+			if p != want {
+				t.Errorf("%s: worker %d got %q, want %q", typed, i, p, want)
+			}
 		}
 	}
+	resetPasswordState()
 }
 
-func TestRotateSequential(t *testing.T) {
+func TestRotateWithStagingDir(t *testing.T) {
 	dir := t.TempDir()
-	var files []fileInfo
-	for i := range 3 {
-		path := filepath.Join(dir, fmt.Sprintf("s%d.log", i))
-		os.WriteFile(path, []byte("seq content"), 0644)
-		info, _ := os.Stat(path)
-		files = append(files, fileInfo{path: path, size: info.Size()})
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("staged line\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.StagingDir = filepath.Join(t.TempDir(), "staging")
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status = %s (err=%v)", res.Status, res.Err)
 	}
-	rotateSequential(files, makeTestCfg(t, dir))
-	for _, f := range files {
-		info, _ := os.Stat(f.path)
-		if info.Size() != 0 {
-			t.Errorf("file %s not truncated", f.path)
-		}
+	data, err := os.ReadFile(filepath.Join(dir, "old", "20240115", "app.log.20240115.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := decompressGzip(data); string(got) != "staged line\n" {
+		t.Errorf("archive = %q", got)
+	}
+	if left, _ := os.ReadDir(cfg.StagingDir); len(left) != 0 {
+		t.Errorf("staging dir not emptied: %v", left)
 	}
 }
 
-func TestRotateLogFileArchivePermissions(t *testing.T) {
+func TestStagingKeepsArchiveWhenMoveFails(t *testing.T) {
 	dir := t.TempDir()
-	logPath := filepath.Join(dir, "perm.log")
-	// Write with setuid bit to verify it's stripped in the archive
-	os.WriteFile(logPath, []byte("perm test"), 0644)
-	// Set file permissions including execute bit
-	os.Chmod(logPath, 0755)
-
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("must not be lost\n"), 0644)
 	cfg := makeTestCfg(t, dir)
-	rotateLogFile(logPath, cfg)
+	cfg.StagingDir = filepath.Join(t.TempDir(), "staging")
+
+	// Make the backup directory unusable once the archive has been staged.
+	backupDir := filepath.Join(dir, "old", "20240115")
+	rotateStageHook = func(stage, _ string) {
+		if stage == "truncate" {
+			os.RemoveAll(backupDir)
+			os.WriteFile(backupDir, nil, 0644)
+		}
+	}
+	defer func() { rotateStageHook = nil }()
 
-	archivePath := filepath.Join(dir, "old", "20240115", "perm.log.20240115.gz")
-	info, err := os.Stat(archivePath)
-	if err != nil {
-		t.Fatalf("archive not found: %v", err)
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusFailed || !strings.Contains(res.Err.Error(), "kept at") {
+		t.Fatalf("res = %+v, want a failed move that names the staged copy", res)
 	}
-	mode := info.Mode()
-	// Execute bit must be stripped from archive
+	staged, _ := filepath.Glob(filepath.Join(cfg.StagingDir, "*-app.log.20240115.gz"))
+	if len(staged) != 1 {
+		t.Fatalf("staged archive missing: %v", staged)
+	}
+	data, _ := os.ReadFile(staged[0])
+	if got, _ := decompressGzip(data); string(got) != "must not be lost\n" {
+		t.Errorf("staged archive = %q", got)
+	}
+}
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	other, err := os.MkdirTemp("/dev/shm", "glr-move-")
+	if err != nil {
+		t.Skip("no /dev/shm")
+	}
+	defer os.RemoveAll(other)
+	src := filepath.Join(other, "a.gz")
+	os.WriteFile(src, []byte("payload"), 0600)
+	dst := filepath.Join(t.TempDir(), "a.gz")
+
+	if err := moveFile(src, dst, 0640, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "payload" {
+		t.Errorf("dst = %q", data)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source left behind after move")
+	}
+}
+
+func TestMinArchiveBytes(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("tiny\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.MinArchiveBytes = 4096
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusFailed {
+		t.Fatalf("status = %s, want %s", res.Status, statusFailed)
+	}
+	if data, _ := os.ReadFile(logPath); string(data) != "tiny\n" {
+		t.Errorf("source changed: %q", data)
+	}
+	if archives, _ := filepath.Glob(filepath.Join(cfg.OldLogsDir, "*", "*")); len(archives) != 0 {
+		t.Errorf("archive written despite MIN_ARCHIVE_BYTES: %v", archives)
+	}
+
+	cfg.MinArchiveBytes = 16
+	if res := rotateLogFile(logPath, cfg); res.Status != statusRotated {
+		t.Fatalf("status = %s, want %s (err=%v)", res.Status, statusRotated, res.Err)
+	}
+}
+
+func TestSizeStats(t *testing.T) {
+	for _, tt := range []struct {
+		original, compressed, final int64
+		want                        string
+	}{
+		{4096, 1024, 1024, "4.00 KB -> 1.00 KB (75.0% compression, saved 3.00 KB)"},
+		{4096, 1024, 1068, "4.00 KB -> 1.04 KB (75.0% compression, encryption added 44 B, saved 2.96 KB)"},
+		{1000, 1030, 1030, "1000 B -> 1.01 KB (incompressible, codec added 30 B, grew by 30 B)"},
+		{1000, 1030, 1074, "1000 B -> 1.05 KB (incompressible, codec added 30 B, encryption added 44 B, grew by 74 B)"},
+	} {
+		if got := sizeStats(tt.original, tt.compressed, tt.final); got != tt.want {
+			t.Errorf("sizeStats(%d, %d, %d) = %q, want %q", tt.original, tt.compressed, tt.final, got, tt.want)
+		}
+	}
+}
+
+func TestSkipWhitespaceOnly(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		on      bool
+		want    string
+	}{
+		{"\n\n \t\r\n", true, statusSkipped},
+		{"\n\n \t\r\n", false, statusRotated},
+		{"\n  x\n", true, statusRotated},
+		{strings.Repeat(" ", blankCheckMax+1), true, statusRotated},
+	} {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "app.log")
+		os.WriteFile(logPath, []byte(tt.content), 0644)
+		cfg := makeTestCfg(t, dir)
+		cfg.SkipBlank = tt.on
+
+		res := rotateLogFile(logPath, cfg)
+		if res.Status != tt.want {
+			t.Errorf("%.10q (on=%v): status = %s, want %s", tt.content, tt.on, res.Status, tt.want)
+		}
+		if res.Status == statusSkipped && res.Reason != "whitespace only" {
+			t.Errorf("reason = %q", res.Reason)
+		}
+	}
+}
+
+func TestOrderLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"b.log", 30, 2 * time.Hour},
+		{"a.log", 20, time.Hour},
+		{"c.log", 10, 3 * time.Hour},
+	} {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for order, want := range map[string]string{
+		orderSize:    "c.log a.log b.log",
+		orderLargest: "b.log a.log c.log",
+		orderAge:     "c.log b.log a.log",
+		orderName:    "a.log b.log c.log",
+	} {
+		files := findLogFiles(dir, "*.log", nil, 0, walkScope{})
+		orderLogFiles(files, order)
+		var names []string
+		for _, f := range files {
+			names = append(names, filepath.Base(f.path))
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("order %s: got %q, want %q", order, got, want)
+		}
+	}
+}
+
+func TestRotateLogFileZstdAndNone(t *testing.T) {
+	content := []byte(strings.Repeat("2024-01-15 10:00:00 INFO codec test\n", 200))
+	for _, tc := range []struct {
+		codec, name string
+		encrypt     bool
+	}{
+		{"zstd", "app.log.20240115.zst", false},
+		{"zstd", "app.log.20240115.zst.enc", true},
+		{"none", "app.log.20240115", false},
+		{"none", "app.log.20240115.enc", true},
+	} {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "app.log")
+		os.WriteFile(logPath, content, 0644)
+		cachedPassword = ""
+		cfg := makeTestCfg(t, dir)
+		cfg.Compress = tc.codec
+		cfg.Encrypt = tc.encrypt
+		cfg.EncryptPassword = "pw"
+
+		res := rotateLogFile(logPath, cfg)
+		if res.Status != statusRotated || filepath.Base(res.Archive) != tc.name {
+			t.Errorf("%s (encrypt=%v): status %s, archive %s, want %s", tc.codec, tc.encrypt, res.Status, res.Archive, tc.name)
+			continue
+		}
+		var out bytes.Buffer
+		if err := streamLogFile(&out, res.Archive, cfg); err != nil || !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("reading %s: %v", tc.name, err)
+		}
+		if logName, date, ok := parseArchiveName(tc.name); !ok || logName != "app.log" || date != "20240115" {
+			t.Errorf("parseArchiveName(%q) = %q, %q, %v", tc.name, logName, date, ok)
+		}
+	}
+	cachedPassword = ""
+	if _, _, ok := parseArchiveName("app.log.1"); ok {
+		t.Error("a numbered log is not a stored archive")
+	}
+	if got := sizeStats(100, 100, 100); !strings.Contains(got, "stored (no compression)") {
+		t.Errorf("sizeStats for a stored archive = %q", got)
+	}
+}
+
+func TestCompressArchive(t *testing.T) {
+	original := []byte(strings.Repeat("2024-01-15 INFO straight to disk\n", 5000))
+	cfg := &Config{EncryptPassword: "pw"}
+	for _, name := range []string{"gzip", "zstd", "none"} {
+		for _, encrypt := range []bool{false, true} {
+			var out bytes.Buffer
+			src := &countingReader{r: bytes.NewReader(original)}
+			compressed, size, sum, err := compressArchive(&out, codecs[name], src, encrypt, cfg)
+			if err != nil {
+				t.Fatalf("%s encrypt=%v: %v", name, encrypt, err)
+			}
+			want := sha256.Sum256(out.Bytes())
+			if src.n != int64(len(original)) || size != int64(out.Len()) || !bytes.Equal(sum, want[:]) {
+				t.Errorf("%s encrypt=%v: counted %d read, %d written, want %d and %d", name, encrypt, src.n, size, len(original), out.Len())
+			}
+			payload := out.Bytes()
+			if encrypt {
+				if size != chunkedSize(compressed) {
+					t.Errorf("%s: %d compressed bytes sealed to %d", name, compressed, size)
+				}
+				if payload, err = decryptData(payload, "pw"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, err := decompressWith(codecs[name], payload); err != nil || !bytes.Equal(got, original) {
+				t.Errorf("%s encrypt=%v: roundtrip failed: %v", name, encrypt, err)
+			}
+		}
+	}
+}
+
+func TestRotateLogFileMemoryDoesNotGrowWithSize(t *testing.T) {
+	bin, _ := writeGPGStub(t)
+	for name, setup := range map[string]func(*Config){
+		"plain":   func(cfg *Config) {},
+		"encrypt": func(cfg *Config) { cfg.Encrypt, cfg.EncryptPassword = true, "pw" },
+		"gpg": func(cfg *Config) {
+			cfg.Encrypt, cfg.EncryptBackend, cfg.GPGRecipient, cfg.GPGBinary = true, backendGPG, "ops@example.com", bin
+		},
+		"split":  func(cfg *Config) { cfg.SplitSizeMB = 4 },
+		"stream": func(cfg *Config) { cfg.StreamArchive = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, "big.log")
+			// Hex-encoded random data only halves, so an in-memory archive would be big.
+			f, _ := os.Create(logPath)
+			w := hex.NewEncoder(f)
+			for range 16 {
+				w.Write(randomBytes(t, 1<<20))
+			}
+			f.Close()
+			cfg := makeTestCfg(t, dir)
+			setup(cfg)
+			streamed := &countingWriter{w: io.Discard}
+			useStreamOut(t, streamed)
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			var res FileResult
+			captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+			runtime.ReadMemStats(&after)
+
+			if res.Status != statusRotated {
+				t.Fatalf("status %s: %v", res.Status, res.Err)
+			}
+			if res.OriginalSize != 32<<20 {
+				t.Errorf("original size %d, want the bytes read", res.OriginalSize)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8<<20 {
+				t.Errorf("rotating 32 MB allocated %d MB; the archive should be streamed out", alloc>>20)
+			}
+			if cfg.StreamArchive {
+				if streamed.n < res.ArchiveSize {
+					t.Errorf("streamed %d bytes for a %d byte archive", streamed.n, res.ArchiveSize)
+				}
+				return
+			}
+			if _, err := os.Stat(partPath(res.Archive, 2)); cfg.SplitSizeMB > 0 && err != nil {
+				t.Errorf("archive was not split: %v", err)
+			}
+			var out bytes.Buffer
+			if err := streamLogFile(&out, res.Archive, cfg); err != nil || int64(out.Len()) != res.OriginalSize {
+				t.Errorf("archive reads back %d bytes (err %v), want %d", out.Len(), err, res.OriginalSize)
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			check := fsckArchive(res.Archive, cfg, "pw", true)
+			runtime.ReadMemStats(&after)
+			if check.Status != fsckHealthy {
+				t.Errorf("fsck: %s (%s)", check.Status, check.Detail)
+			}
+			// gpg archives are still checked whole.
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8<<20 && cfg.EncryptBackend != backendGPG {
+				t.Errorf("checking 32 MB allocated %d MB; the archive should be streamed from disk", alloc>>20)
+			}
+		})
+	}
+}
+
+func TestRotateLogFileSkipsEmpty(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "empty.log")
+	os.WriteFile(logPath, []byte{}, 0644)
+
+	rotateLogFile(logPath, makeTestCfg(t, dir))
+
+	archiveDir := filepath.Join(dir, "old")
+	if _, err := os.Stat(archiveDir); !os.IsNotExist(err) {
+		t.Error("archive dir should not exist for empty file")
+	}
+}
+
+func TestRotateLogFileMinSize(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "heartbeat.log")
+	big := filepath.Join(dir, "app.log")
+	os.WriteFile(small, []byte("2024-01-15 alive\n"), 0644)
+	os.WriteFile(big, bytes.Repeat([]byte("2024-01-15 INFO request\n"), 100), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.MinSize = "1K"
+
+	var smallRes, bigRes FileResult
+	captureStdout(t, func() {
+		smallRes = rotateLogFile(small, cfg)
+		bigRes = rotateLogFile(big, cfg)
+	})
+	if smallRes.Status != statusSkipped || smallRes.Reason != "below MIN_SIZE" {
+		t.Errorf("small file: %s (%s), want skipped below MIN_SIZE", smallRes.Status, smallRes.Reason)
+	}
+	if info, _ := os.Stat(small); info.Size() == 0 {
+		t.Error("skipped file must not be truncated")
+	}
+	if bigRes.Status != statusRotated {
+		t.Errorf("file over MIN_SIZE: %s, want rotated", bigRes.Status)
+	}
+}
+
+func TestRotateLogFileDryRun(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "test.log")
+	content := []byte("log content")
+	os.WriteFile(logPath, content, 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.DryRun = true
+	rotateLogFile(logPath, cfg)
+
+	// File must be unmodified
+	info, _ := os.Stat(logPath)
+	if info.Size() == 0 {
+		t.Error("dry-run must not truncate original")
+	}
+	// No archive
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Error("dry-run must not create archive")
+	}
+}
+
+func TestRotateLogFileAlreadyRotated(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := []byte("log content")
+	os.WriteFile(logPath, content, 0644)
+
+	cfg := makeTestCfg(t, dir)
+
+	// First rotation
+	rotateLogFile(logPath, cfg)
+
+	// Re-fill the file
+	os.WriteFile(logPath, content, 0644)
+
+	// Second rotation should skip (archive already exists)
+	rotateLogFile(logPath, cfg)
+
+	// Original should still have content (second rotation skipped)
+	info, _ := os.Stat(logPath)
+	if info.Size() == 0 {
+		t.Error("second rotation should be skipped when archive exists")
+	}
+}
+
+func TestRotateLogFileForce(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("first\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.Checksum = true
+	captureStdout(t, func() { rotateLogFile(logPath, cfg) })
+	archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+	// A part left from an earlier split archive of the same name.
+	os.WriteFile(partPath(archive, 1), []byte("stale"), 0644)
+
+	os.WriteFile(logPath, []byte("second\n"), 0644)
+	cfg.Checksum = false
+	cfg.Force = true
+	var res FileResult
+	out := captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated || !strings.Contains(out, "overwriting (--force)") {
+		t.Fatalf("status %s (%v), output:\n%s", res.Status, res.Err, out)
+	}
+	var got bytes.Buffer
+	if err := streamLogFile(&got, archive, &Config{}); err != nil || got.String() != "second\n" {
+		t.Errorf("archive holds %q, %v; want the second rotation", got.String(), err)
+	}
+	for _, stale := range []string{partPath(archive, 1), checksumSidecar(archive), archive + ".tmp"} {
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("%s left next to the new archive", filepath.Base(stale))
+		}
+	}
+}
+
+func TestRotateLogFileIncrementSuffix(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	cfg := makeTestCfg(t, dir)
+	cfg.IncrementSuffix = true
+	backupDir := filepath.Join(dir, "old", "20240115")
+	want := []string{"app.log.20240115.gz", "app.log.20240115.1.gz", "app.log.20240115.2.gz"}
+	for i, name := range want {
+		os.WriteFile(logPath, []byte(fmt.Sprintf("rotation %d\n", i)), 0644)
+		var res FileResult
+		captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+		if res.Status != statusRotated || res.Archive != filepath.Join(backupDir, name) {
+			t.Fatalf("rotation %d: %s to %s (%v), want %s", i, res.Status, res.Archive, res.Err, name)
+		}
+	}
+
+	// Read back in rotation order, and retention counts the numbered ones as newer.
+	entries, err := dirArchives(backupDir, "")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("dirArchives: %v, %v", entries, err)
+	}
+	for i, a := range entries {
+		if filepath.Base(a.path) != want[i] {
+			t.Errorf("archive %d is %s, want %s", i, filepath.Base(a.path), want[i])
+		}
+	}
+	if excess := excessArchives(entries, 2); len(excess) != 1 || filepath.Base(excess[0].path) != want[0] {
+		t.Errorf("beyond the 2 newest: %v, want %s", excess, want[0])
+	}
+}
+
+func TestRotateLogFileDiskGuard(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("data to rotate"), 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.DiskMinFreeMB = 999_999_999 // impossibly large — always triggers skip
+
+	rotateLogFile(logPath, cfg)
+
+	// Original must NOT be truncated — disk guard returned early
+	info, _ := os.Stat(logPath)
+	if info.Size() == 0 {
+		t.Error("disk guard should prevent truncation when free space < DiskMinFreeMB")
+	}
+	// No archive
+	archivePath := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Error("disk guard should prevent archive creation")
+	}
+}
+
+func TestRotateParallelMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []fileInfo
+	for i := range 5 {
+		name := fmt.Sprintf("app%d.log", i)
+		path := filepath.Join(dir, name)
+		content := bytes.Repeat([]byte("x"), 100+i*50)
+		os.WriteFile(path, content, 0644)
+		info, _ := os.Stat(path)
+		files = append(files, fileInfo{path: path, size: info.Size()})
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.ParallelJobs = 3
+	cfg.Parallel = true
+
+	rotateParallel(files, cfg)
+
+	for _, f := range files {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			t.Errorf("file %s missing: %v", f.path, err)
+			continue
+		}
+		if info.Size() != 0 {
+			t.Errorf("file %s not truncated after parallel rotation", f.path)
+		}
+	}
+}
+
+// A panic rotating one file fails that file only: the others still rotate and
+// the pool slots it held are given back.
+func TestRotateParallelRecoversPanic(t *testing.T) {
+	dir := t.TempDir()
+	var files []fileInfo
+	for i := range 4 {
+		path := filepath.Join(dir, fmt.Sprintf("app%d.log", i))
+		os.WriteFile(path, []byte("line\n"), 0644)
+		files = append(files, fileInfo{path: path, size: 5})
+	}
+	bad := files[1].path
+	rotateStageHook = func(stage, path string) {
+		if stage == "open" && path == bad {
+			panic("injected")
+		}
+	}
+	defer func() { rotateStageHook = nil }()
+
+	cfg := makeTestCfg(t, dir)
+	cfg.ParallelJobs, cfg.IOThreads, cfg.CPUThreads = 2, 1, 1
+	var errored []string
+	cfg.Hooks = &RotationHooks{OnError: func(path string, err error) { errored = append(errored, path) }}
+	var results []FileResult
+	stderr := captureStderr(t, func() { captureStdout(t, func() { results = rotateParallel(files, cfg) }) })
+	if n := strings.Count(stderr, "panic processing"); n != 1 {
+		t.Errorf("panic reported %d times, want once:\n%s", n, stderr)
+	}
+	if len(errored) != 1 || errored[0] != bad {
+		t.Errorf("OnError called for %v, want only %s", errored, bad)
+	}
+	for _, res := range results {
+		if res.Path == bad {
+			if res.Status != statusFailed || res.Err == nil || !strings.Contains(res.Err.Error(), "injected") {
+				t.Errorf("%s: status %s (%v), want failed with the panic", res.Path, res.Status, res.Err)
+			}
+			continue
+		}
+		if res.Status != statusRotated {
+			t.Errorf("%s: status %s (%v), want rotated despite the panic", res.Path, res.Status, res.Err)
+		}
+	}
+	if got, _ := os.ReadFile(bad); string(got) != "line\n" {
+		t.Errorf("panicked file = %q, want it untouched", got)
+	}
+}
+
+func TestPoolSizes(t *testing.T) {
+	cfg := &Config{ParallelJobs: 4}
+	if io, cpu := poolSizes(cfg); io != 4 || cpu != 4 {
+		t.Errorf("defaults = %d/%d, want 4/4", io, cpu)
+	}
+	cfg.IOThreads, cfg.CPUThreads = 2, 8
+	if io, cpu := poolSizes(cfg); io != 2 || cpu != 8 {
+		t.Errorf("explicit = %d/%d, want 2/8", io, cpu)
+	}
+}
+
+func TestWorkerPoolsBoundConcurrency(t *testing.T) {
+	pools := &workerPools{io: make(chan struct{}, 1), cpu: make(chan struct{}, 2)}
+	var mu sync.Mutex
+	var active, peak int
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := pools.acquireCPU()
+			defer release()
+			mu.Lock()
+			active++
+			peak = max(peak, active)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			release() // early release followed by the deferred one must not double-free
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("peak CPU concurrency = %d, want <= 2", peak)
+	}
+	if len(pools.cpu) != 0 {
+		t.Errorf("%d CPU slots leaked", len(pools.cpu))
+	}
+
+	var nilPools *workerPools
+	nilPools.acquireIO()() // sequential rotation: no pool, never blocks
+}
+
+func TestRotateParallelSplitPools(t *testing.T) {
+	dir := t.TempDir()
+	var files []fileInfo
+	for i := range 6 {
+		path := filepath.Join(dir, fmt.Sprintf("app%d.log", i))
+		os.WriteFile(path, bytes.Repeat([]byte("y"), 200), 0644)
+		files = append(files, fileInfo{path: path, size: 200})
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.ParallelJobs, cfg.IOThreads, cfg.CPUThreads = 4, 1, 3
+
+	for _, res := range rotateParallel(files, cfg) {
+		if res.Status != statusRotated {
+			t.Errorf("%s: status %s (%v)", res.Path, res.Status, res.Err)
+		}
+	}
+	if cfg.pools != nil {
+		t.Error("rotateParallel must not leave pools on the caller's config")
+	}
+}
+
+func TestRotateSequential(t *testing.T) {
+	dir := t.TempDir()
+	var files []fileInfo
+	for i := range 3 {
+		path := filepath.Join(dir, fmt.Sprintf("s%d.log", i))
+		os.WriteFile(path, []byte("seq content"), 0644)
+		info, _ := os.Stat(path)
+		files = append(files, fileInfo{path: path, size: info.Size()})
+	}
+	rotateSequential(files, makeTestCfg(t, dir))
+	for _, f := range files {
+		info, _ := os.Stat(f.path)
+		if info.Size() != 0 {
+			t.Errorf("file %s not truncated", f.path)
+		}
+	}
+}
+
+func TestRotateLogFileArchivePermissions(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "perm.log")
+	// Write with setuid bit to verify it's stripped in the archive
+	os.WriteFile(logPath, []byte("perm test"), 0644)
+	// Set file permissions including execute bit
+	os.Chmod(logPath, 0755)
+
+	cfg := makeTestCfg(t, dir)
+	rotateLogFile(logPath, cfg)
+
+	archivePath := filepath.Join(dir, "old", "20240115", "perm.log.20240115.gz")
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("archive not found: %v", err)
+	}
+	mode := info.Mode()
+	// Execute bit must be stripped from archive
 	if mode&0111 != 0 {
 		t.Errorf("archive has execute bits set: %v — should be stripped", mode)
 	}
 }
+
+func TestArchiveBaseName(t *testing.T) {
+	cfg := buildConfig(map[string]string{"LOG_DIR": "/var/log/apps"})
+	if got := archiveBaseName("/var/log/apps/a/app.log", cfg); got != "app.log" {
+		t.Errorf("without OLD_LOGS_DIR: got %q, want app.log", got)
+	}
+
+	cfg.OldLogsDir = "/mnt/archive"
+	tests := []struct{ in, want string }{
+		{"/var/log/apps/app.log", "app.log"},
+		{"/var/log/apps/a/app.log", "a%2Fapp.log"},
+		{"/var/log/apps/a/b/app.log", "a%2Fb%2Fapp.log"},
+	}
+	for _, tt := range tests {
+		if got := archiveBaseName(tt.in, cfg); got != tt.want {
+			t.Errorf("archiveBaseName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	dir := t.TempDir()
+	secure, cheap := filepath.Join(dir, "secure"), filepath.Join(dir, "cheap")
+	for _, name := range []string{"auth.log", "access.log", "app.log"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("line for "+name+"\n"), 0644)
+	}
+	os.MkdirAll(filepath.Join(dir, "svc"), 0755)
+	os.WriteFile(filepath.Join(dir, "svc", "auth.log"), []byte("nested auth\n"), 0644)
+
+	cfg := buildConfig(map[string]string{
+		"LOG_DIR":     dir,
+		"ROUTE_RULES": "auth*.log:" + secure + ", access*.log:" + cheap,
+	})
+	cfg.DateSuffix, cfg.BackupDate, cfg.DiskMinFreeMB = "20240115", "20240115", 0
+
+	for _, name := range []string{"auth.log", "access.log", "app.log", "svc/auth.log"} {
+		if res := rotateLogFile(filepath.Join(dir, name), cfg); res.Status != statusRotated {
+			t.Fatalf("%s: status %s (%v)", name, res.Status, res.Err)
+		}
+	}
+	for _, want := range []string{
+		filepath.Join(secure, "20240115", "auth.log.20240115.gz"),
+		filepath.Join(secure, "20240115", "svc%2Fauth.log.20240115.gz"),
+		filepath.Join(cheap, "20240115", "access.log.20240115.gz"),
+		filepath.Join(dir, "old_logs", "20240115", "app.log.20240115.gz"),
+	} {
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("expected archive %s: %v", want, err)
+		}
+	}
+
+	roots := backupRoots(cfg)
+	for _, want := range []string{secure, cheap, filepath.Join(dir, "old_logs")} {
+		found := false
+		for _, r := range roots {
+			found = found || r == want
+		}
+		if !found {
+			t.Errorf("backupRoots = %v, missing %s", roots, want)
+		}
+	}
+}
+
+func TestClaimArchive(t *testing.T) {
+	if _, ok := claimArchive("/x/app.log.gz", "/a/app.log"); !ok {
+		t.Fatal("first claim should succeed")
+	}
+	owner, ok := claimArchive("/x/app.log.gz", "/b/app.log")
+	if ok || owner != "/a/app.log" {
+		t.Errorf("second claim = (%q, %v), want (/a/app.log, false)", owner, ok)
+	}
+	releaseArchive("/x/app.log.gz")
+	if _, ok := claimArchive("/x/app.log.gz", "/b/app.log"); !ok {
+		t.Error("claim after release should succeed")
+	}
+	releaseArchive("/x/app.log.gz")
+}
+
+func TestRotateParallelSameBasenameSharedRoot(t *testing.T) {
+	dir := t.TempDir()
+	var files []fileInfo
+	for _, sub := range []string{"a", "b", "c"} {
+		os.MkdirAll(filepath.Join(dir, sub), 0755)
+		path := filepath.Join(dir, sub, "app.log")
+		os.WriteFile(path, []byte("from "+sub+"\n"), 0644)
+		files = append(files, fileInfo{path: path, size: 7})
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.ParallelJobs = 3
+	cfg.Parallel = true
+	rotateParallel(files, cfg)
+
+	for _, sub := range []string{"a", "b", "c"} {
+		archivePath := filepath.Join(dir, "old", "20240115", sub+"%2Fapp.log.20240115.gz")
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatalf("archive for %s missing: %v", sub, err)
+		}
+		got, err := decompressGzip(data)
+		if err != nil {
+			t.Fatalf("decompress %s: %v", sub, err)
+		}
+		if string(got) != "from "+sub+"\n" {
+			t.Errorf("archive %s holds %q — clobbered by another source", sub, got)
+		}
+	}
+}
+
+func TestRotationHooks(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("hook test\n"), 0644)
+
+	var started []string
+	var done []FileResult
+	cfg := makeTestCfg(t, dir)
+	cfg.Hooks = &RotationHooks{
+		OnFileStart: func(path string) { started = append(started, path) },
+		OnFileDone:  func(res FileResult) { done = append(done, res) },
+	}
+	res := rotateLogFile(logPath, cfg)
+
+	if res.Status != statusRotated {
+		t.Fatalf("status = %q, want %q (err=%v)", res.Status, statusRotated, res.Err)
+	}
+	if len(started) != 1 || started[0] != logPath {
+		t.Errorf("OnFileStart calls = %v", started)
+	}
+	if len(done) != 1 || done[0].Archive != res.Archive || done[0].OriginalSize != 10 {
+		t.Errorf("OnFileDone calls = %+v", done)
+	}
+}
+
+func TestRotationHooksPanicSafe(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("hook panic\n"), 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.Hooks = &RotationHooks{
+		OnFileStart: func(string) { panic("boom") },
+		OnFileDone:  func(FileResult) { panic("boom") },
+	}
+	if res := rotateLogFile(logPath, cfg); res.Status != statusRotated {
+		t.Errorf("panicking hook aborted rotation: status=%q err=%v", res.Status, res.Err)
+	}
+}
+
+func TestRotationHooksOnError(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("data to rotate"), 0644)
+
+	var gotErr error
+	cfg := makeTestCfg(t, dir)
+	cfg.DiskMinFreeMB = 999_999_999
+	cfg.Hooks = &RotationHooks{OnError: func(_ string, err error) { gotErr = err }}
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusFailed || gotErr == nil {
+		t.Errorf("status=%q hookErr=%v, want failed with OnError called", res.Status, gotErr)
+	}
+}
+
+func TestCodecRoundtrip(t *testing.T) {
+	original := []byte(strings.Repeat("2024-01-15 INFO codec roundtrip\n", 300))
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			compressed, err := compressWith(c, bytes.NewReader(original))
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			if c.ext != "" && len(compressed) >= len(original) {
+				t.Errorf("%s did not shrink repetitive input: %d >= %d", name, len(compressed), len(original))
+			}
+			got, err := decompressWith(c, compressed)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(got, original) {
+				t.Error("roundtrip mismatch")
+			}
+		})
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	if c, err := lookupCodec("xz"); err != nil || c.ext != "xz" {
+		t.Errorf("lookupCodec(xz) = %+v, %v", c, err)
+	}
+	if _, err := lookupCodec("lz4"); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
+
+func TestCodecWithLevel(t *testing.T) {
+	var lines strings.Builder
+	for i := range 2000 {
+		fmt.Fprintf(&lines, "2024-01-15 12:%02d:%02d INFO request %d served in %dms\n", i/60%60, i%60, i*7919%10007, i%97)
+	}
+	original := []byte(lines.String())
+	size := func(c codec) int {
+		t.Helper()
+		compressed, err := compressWith(c, bytes.NewReader(original))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got, err := decompressWith(c, compressed)
+		if err != nil || !bytes.Equal(got, original) {
+			t.Fatalf("%s: roundtrip failed: %v", c.name, err)
+		}
+		return len(compressed)
+	}
+
+	if fast, best := size(codecs["gzip"].withLevel(1)), size(codecs["gzip"].withLevel(9)); best >= fast {
+		t.Errorf("gzip level 9 (%d bytes) should beat level 1 (%d bytes)", best, fast)
+	}
+	size(codecs["zstd"].withLevel(1))
+	size(codecs["zstd"].withLevel(9))
+	if stored := size(codecs["gzip"].withLevel(0)); stored <= len(original) {
+		t.Errorf("gzip level 0 should store uncompressed, got %d bytes from %d", stored, len(original))
+	}
+	if a, b := size(codecs["gzip"].withLevel(42)), size(codecs["gzip"]); a != b {
+		t.Errorf("out-of-range level should use the default: %d bytes, want %d", a, b)
+	}
+	for _, level := range []int{defaultCompressLevel, 0, 9} {
+		if !validCompressLevel(level) {
+			t.Errorf("validCompressLevel(%d) = false", level)
+		}
+	}
+	for _, level := range []int{-2, 10} {
+		if validCompressLevel(level) {
+			t.Errorf("validCompressLevel(%d) = true", level)
+		}
+	}
+}
+
+func TestCodecForPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"app.log.20240115.gz", "gzip"},
+		{"app.log.20240115.xz", "xz"},
+		{"app.log.20240115", ""},
+	}
+	for _, tt := range tests {
+		c, ok := codecForPath(tt.path)
+		if (tt.want == "") == ok || (ok && c.name != tt.want) {
+			t.Errorf("codecForPath(%q) = %q, %v; want %q", tt.path, c.name, ok, tt.want)
+		}
+	}
+}
+
+func TestRotateLogFileXZEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "cold.log")
+	content := []byte(strings.Repeat("cold archive line\n", 50))
+	os.WriteFile(logPath, content, 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.Compress = "xz"
+	cfg.Encrypt = true
+	cfg.EncryptPassword = "xz-pw"
+	passwordMu.Lock()
+	cachedPassword = ""
+	passwordMu.Unlock()
+
+	rotateLogFile(logPath, cfg)
+
+	data, err := os.ReadFile(filepath.Join(dir, "old", "20240115", "cold.log.20240115.xz.enc"))
+	if err != nil {
+		t.Fatalf("xz archive not found: %v", err)
+	}
+	compressed, err := decryptData(data, "xz-pw")
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	recovered, err := decompressWith(codecs["xz"], compressed)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(recovered, content) {
+		t.Error("xz+encrypt roundtrip failed")
+	}
+}
+
+func TestRepairedPath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/x/app.log.20240115.gz", "/x/app.log.20240115.repaired.gz"},
+		{"/x/app.log.20240115.xz.enc", "/x/app.log.20240115.repaired.xz.enc"},
+	}
+	for _, tt := range tests {
+		if got := repairedPath(tt.in); got != tt.want {
+			t.Errorf("repairedPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRepairArchiveTruncatedGzip(t *testing.T) {
+	dir := t.TempDir()
+	// Incompressible-ish content so truncation lands mid-stream, not in the trailer.
+	var content []byte
+	for i := range 4000 {
+		content = append(content, fmt.Sprintf("line %d %x\n", i, i*7919)...)
+	}
+	compressed, _ := compressGzip(bytes.NewReader(content))
+	damaged := filepath.Join(dir, "app.log.20240115.gz")
+	os.WriteFile(damaged, compressed[:len(compressed)/2], 0644)
+
+	if err := repairArchive(damaged, makeTestCfg(t, dir)); err != nil {
+		t.Fatalf("repairArchive: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "app.log.20240115.repaired.gz"))
+	if err != nil {
+		t.Fatalf("repaired archive missing: %v", err)
+	}
+	recovered, err := decompressGzip(data)
+	if err != nil {
+		t.Fatalf("repaired archive is not clean gzip: %v", err)
+	}
+	if len(recovered) == 0 || !bytes.HasPrefix(content, recovered) {
+		t.Errorf("recovered %d bytes that are not a prefix of the original", len(recovered))
+	}
+}
+
+func TestRepairArchiveTruncatedEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	compressed, _ := compressGzip(strings.NewReader(strings.Repeat("secret\n", 100)))
+	encrypted, _ := encryptData(compressed, "pw", nil)
+	damaged := filepath.Join(dir, "app.log.20240115.gz.enc")
+	os.WriteFile(damaged, encrypted[:len(encrypted)-10], 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.EncryptPassword = "pw"
+	if err := repairArchive(damaged, cfg); err == nil {
+		t.Error("expected error: truncated GCM payload is unrecoverable")
+	}
+	if _, err := os.Stat(repairedPath(damaged)); !os.IsNotExist(err) {
+		t.Error("no repaired file should be written when nothing is recoverable")
+	}
+}
+
+func TestRepairArchivePublicKeyKeepsRecipient(t *testing.T) {
+	dir := t.TempDir()
+	pubPath, privPath := writeRSAKeyPair(t, dir, "archive")
+	useKeyPair(t, pubPath, privPath)
+	var content []byte
+	for i := range 4000 {
+		content = append(content, fmt.Sprintf("line %d %x\n", i, i*7919)...)
+	}
+	compressed, _ := compressGzip(bytes.NewReader(content))
+	sealed, err := sealPublic(compressed[:len(compressed)/2], archivePublicKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := filepath.Join(dir, "app.log.20240115.gz.enc")
+	os.WriteFile(damaged, sealed, 0600)
+
+	// Only the private key is at hand, as on a host that reads archives.
+	archivePublicKey = nil
+	if err := repairArchive(damaged, makeTestCfg(t, dir)); err != nil {
+		t.Fatalf("repairArchive: %v", err)
+	}
+	repaired, err := os.ReadFile(repairedPath(damaged))
+	if err != nil {
+		t.Fatalf("repaired archive missing: %v", err)
+	}
+	if !isPublicKeyArchive(repaired) || !bytes.Equal(archiveKeyID(repaired), archiveKeyID(sealed)) {
+		t.Fatal("repaired archive is not sealed to the original archive's key")
+	}
+	if _, err := decryptData(repaired, ""); err != nil {
+		t.Errorf("repaired archive does not open with the private key: %v", err)
+	}
+}
+
+func TestDurationPercentile(t *testing.T) {
+	var results []FileResult
+	for i := 1; i <= 20; i++ {
+		results = append(results, FileResult{Duration: time.Duration(i) * time.Millisecond})
+	}
+	if got := durationPercentile(results, 50); got != 10*time.Millisecond {
+		t.Errorf("p50 = %s, want 10ms", got)
+	}
+	if got := durationPercentile(results, 95); got != 19*time.Millisecond {
+		t.Errorf("p95 = %s, want 19ms", got)
+	}
+	if got := durationPercentile(nil, 95); got != 0 {
+		t.Errorf("empty p95 = %s, want 0", got)
+	}
+}
+
+func TestRotateLogFileRecordsDuration(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("timed\n"), 0644)
+	if res := rotateLogFile(logPath, makeTestCfg(t, dir)); res.Duration <= 0 {
+		t.Errorf("Duration = %s, want > 0", res.Duration)
+	}
+}
+
+func TestFindLogFilesDirExcludes(t *testing.T) {
+	dir := t.TempDir()
+	for _, rel := range []string{"app.log", "debug.log", "svc/app.log", "svc/debug.log", "svc/sub/trace.log", "other/trace.log"} {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("content"), 0644)
+	}
+	// Applies only inside svc/ (and below), not to the top-level debug.log.
+	os.WriteFile(filepath.Join(dir, "svc", dirExcludeFile), []byte("# svc excludes\ndebug.log\nsub/*.log\n"), 0644)
+
+	got := make(map[string]bool)
+	for _, f := range findLogFiles(dir, "*.log", nil, 0, walkScope{}) {
+		rel, _ := filepath.Rel(dir, f.path)
+		got[rel] = true
+	}
+	for _, want := range []string{"app.log", "debug.log", "svc/app.log", "other/trace.log"} {
+		if !got[want] {
+			t.Errorf("%s should be found", want)
+		}
+	}
+	for _, excluded := range []string{"svc/debug.log", "svc/sub/trace.log"} {
+		if got[excluded] {
+			t.Errorf("%s should be excluded by svc/%s", excluded, dirExcludeFile)
+		}
+	}
+}
+
+// captureStdout runs fn with os.Stdout redirected and returns what it printed.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	w.Close()
+	os.Stdout = orig
+	return string(<-done)
+}
+
+// captureStderr is captureStdout for os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	w.Close()
+	os.Stderr = orig
+	return string(<-done)
+}
+
+func TestPlainOutputRotation(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte(strings.Repeat("plain output line\n", 20)), 0644)
+
+	cfg := makeTestCfg(t, dir)
+	plainOutput = true
+	defer func() { plainOutput = false }()
+
+	out := captureStdout(t, func() { rotateLogFile(logPath, cfg) })
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("plain output should be one line, got %d: %q", len(lines), out)
+	}
+	if !strings.Contains(lines[0], "Rotated: "+logPath) || !strings.Contains(lines[0], "compression") {
+		t.Errorf("unexpected plain line: %q", lines[0])
+	}
+	for _, r := range out {
+		if r > 127 {
+			t.Fatalf("plain output contains non-ASCII %q: %q", r, out)
+		}
+	}
+}
+
+func TestStreamLogFile(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("streamed line\n", 100))
+	cfg := &Config{EncryptPassword: "stream-pw"}
+
+	gz, _ := compressGzip(bytes.NewReader(content))
+	xzData, _ := compressWith(codecs["xz"], bytes.NewReader(content))
+	encXZ, _ := encryptData(xzData, "stream-pw", nil)
+	files := map[string][]byte{
+		"app.log":                 content,
+		"app.log.20240115.gz":     gz,
+		"app.log.20240115.xz.enc": encXZ,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		var out bytes.Buffer
+		if err := streamLogFile(&out, path, cfg); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("%s: content mismatch", name)
+		}
+	}
+}
+
+func TestStreamToFIFO(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("siem line\n", 1000))
+	gz, _ := compressGzip(bytes.NewReader(content))
+	archive := filepath.Join(dir, "app.log.20240115.gz")
+	os.WriteFile(archive, gz, 0600)
+	fifo := filepath.Join(dir, "ingest.fifo")
+
+	done := make(chan error, 1)
+	go func() { done <- streamToFIFO(fifo, archive, &Config{}) }()
+
+	// Wait for streamToFIFO to create the pipe, then drain it like a SIEM would.
+	var r *os.File
+	for i := 0; i < 200; i++ {
+		if info, err := os.Stat(fifo); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+			r, _ = os.Open(fifo)
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r == nil {
+		t.Fatal("FIFO was never created")
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("streamToFIFO: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("FIFO content mismatch")
+	}
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Error("FIFO created by streamToFIFO should be removed afterwards")
+	}
+}
+
+func TestStreamToFIFOReaderGone(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "big.log")
+	os.WriteFile(archive, bytes.Repeat([]byte("x"), 1<<20), 0600)
+	fifo := filepath.Join(dir, "ingest.fifo")
+	if err := makeFIFO(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- streamToFIFO(fifo, archive, &Config{}) }()
+	r, err := os.Open(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Read(make([]byte, 16))
+	r.Close()
+
+	err = <-done
+	if err == nil || !strings.Contains(err.Error(), "output incomplete") {
+		t.Errorf("err = %v, want reader-closed error", err)
+	}
+	if _, err := os.Stat(fifo); err != nil {
+		t.Error("pre-existing FIFO must not be removed")
+	}
+}
+
+func TestStreamToFIFORefusesRegularFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "not-a-pipe")
+	os.WriteFile(target, nil, 0600)
+	if err := streamToFIFO(target, filepath.Join(dir, "x.log"), &Config{}); err == nil {
+		t.Error("expected refusal to write plaintext into a regular file")
+	}
+}
+
+func TestFsyncDefault(t *testing.T) {
+	if !buildConfig(map[string]string{}).Fsync {
+		t.Error("FSYNC should default to true")
+	}
+	if buildConfig(map[string]string{"FSYNC": "false"}).Fsync {
+		t.Error("FSYNC = false ignored")
+	}
+}
+
+func TestFsyncRotates(t *testing.T) {
+	for _, staged := range []bool{false, true} {
+		dir := t.TempDir()
+		cfg := makeTestCfg(t, dir)
+		cfg.Fsync = true
+		if staged {
+			cfg.StagingDir = filepath.Join(t.TempDir(), "staging")
+		}
+		path := filepath.Join(dir, "app.log")
+		os.WriteFile(path, []byte("durable\n"), 0644)
+		if res := rotateLogFile(path, cfg); res.Status != statusRotated {
+			t.Fatalf("staged=%v: %s (%v)", staged, res.Status, res.Err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")); err != nil {
+			t.Errorf("staged=%v: %v", staged, err)
+		}
+		if info, _ := os.Stat(path); info.Size() != 0 {
+			t.Errorf("staged=%v: source not truncated", staged)
+		}
+	}
+}
+
+func TestWriteArchiveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.gz.tmp")
+	if err := writeArchiveFile(path, []byte("payload"), 0640, true); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 7 || info.Mode().Perm() != 0640 {
+		t.Errorf("size=%d mode=%v, want 7 and 0640", info.Size(), info.Mode().Perm())
+	}
+}
+
+func TestFailedCount(t *testing.T) {
+	results := []FileResult{
+		{Status: statusRotated}, {Status: statusSkipped}, {Status: statusFailed},
+		{Status: statusVanished}, {Status: statusUnchanged}, {Status: statusFailed},
+	}
+	if n := failedCount(results); n != 2 {
+		t.Errorf("failedCount = %d, want 2", n)
+	}
+	if n := failedCount(results[:2]); n != 0 {
+		t.Errorf("failedCount of rotated and skipped files = %d, want 0", n)
+	}
+}
+
+func TestSetConfigPaths(t *testing.T) {
+	file, dir := mainConfigFile, configDropinDir
+	t.Cleanup(func() { mainConfigFile, configDropinDir = file, dir })
+	tmp := t.TempDir()
+	conf := filepath.Join(tmp, "ci.conf")
+	os.WriteFile(conf, []byte("PATTERN = *.txt\nRETENTION_DAYS = 3\n"), 0644)
+	os.Mkdir(conf+".d", 0755)
+	os.WriteFile(filepath.Join(conf+".d", "job.conf"), []byte("RETENTION_DAYS = 9\n"), 0644)
+	other := filepath.Join(tmp, "drop-ins")
+	os.Mkdir(other, 0755)
+	envConf := filepath.Join(tmp, "env.conf")
+	os.WriteFile(envConf, []byte("PATTERN = *.out\n"), 0644)
+
+	t.Setenv("GLOBAL_SYS_UTILS_CONFIG", envConf)
+	if err := setConfigPaths([]string{"-p", "/var/log/x"}); err != nil || mainConfigFile != envConf {
+		t.Errorf("GLOBAL_SYS_UTILS_CONFIG: %s, %v", mainConfigFile, err)
+	}
+	if err := setConfigPaths([]string{"-p", "/var/log/x", "--config", conf}); err != nil || mainConfigFile != conf || configDropinDir != conf+".d" {
+		t.Fatalf("--config over the env: %s, %s, %v", mainConfigFile, configDropinDir, err)
+	}
+	fc := loadConfigFiles()
+	if fc["PATTERN"] != "*.txt" || fc["RETENTION_DAYS"] != "9" {
+		t.Errorf("loaded %v, want --config and its drop-in", fc)
+	}
+	if err := setConfigPaths([]string{"-config=" + conf, "-config-dir", other}); err != nil || configDropinDir != other {
+		t.Errorf("--config-dir: %s, %v", configDropinDir, err)
+	}
+	if err := setConfigPaths([]string{"--config", filepath.Join(tmp, "missing.conf")}); err == nil {
+		t.Error("a missing --config file was accepted")
+	}
+	if err := setConfigPaths([]string{"--config-dir", conf}); err == nil {
+		t.Error("a file was accepted as --config-dir")
+	}
+}
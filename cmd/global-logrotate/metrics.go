@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Prometheus textfile metrics (--metrics-file)
+// ============================================================
+
+// Metric names written to METRICS_FILE.
+const (
+	metricRotated = "logrotate_files_rotated_total"
+	metricSaved   = "logrotate_bytes_saved_total"
+	metricErrors  = "logrotate_errors_total"
+	metricLastRun = "logrotate_last_run_timestamp_seconds"
+)
+
+// runMetrics are the values of one METRICS_FILE.
+type runMetrics struct {
+	rotated, saved, errors int64
+	lastRun                time.Time
+}
+
+// readMetricsFile returns the counters a previous run left in path, so each
+// run adds to them rather than resetting them. A missing or foreign file
+// counts as zero.
+func readMetricsFile(path string) runMetrics {
+	var m runMetrics
+	f, err := os.Open(path)
+	if err != nil {
+		return m
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case metricRotated:
+			m.rotated = n
+		case metricSaved:
+			m.saved = n
+		case metricErrors:
+			m.errors = n
+		}
+	}
+	return m
+}
+
+// addResults counts a run's results into m.
+func (m *runMetrics) addResults(results []FileResult, finished time.Time) {
+	var saved int64
+	for _, r := range results {
+		switch r.Status {
+		case statusRotated:
+			m.rotated++
+			saved += r.OriginalSize - r.ArchiveSize
+		case statusFailed:
+			m.errors++
+		}
+	}
+	m.saved += max(saved, 0) // a counter never goes down, even when archives grew
+	m.lastRun = finished
+}
+
+// format renders m in the Prometheus text exposition format.
+func (m runMetrics) format() string {
+	var b strings.Builder
+	for _, metric := range []struct {
+		name, typ, help string
+		value           int64
+	}{
+		{metricRotated, "counter", "Files rotated by global-logrotate.", m.rotated},
+		{metricSaved, "counter", "Bytes saved by compressing rotated files.", m.saved},
+		{metricErrors, "counter", "Files global-logrotate failed to rotate.", m.errors},
+		{metricLastRun, "gauge", "Unix time the last global-logrotate run finished.", m.lastRun.Unix()},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.typ, metric.name, metric.value)
+	}
+	return b.String()
+}
+
+// writeMetricsFile adds results to the counters in path and rewrites it
+// atomically (temp file, rename), so the textfile collector never reads half
+// a file. The temp name doesn't end in .prom, so the collector ignores it.
+func writeMetricsFile(path string, results []FileResult, finished time.Time) error {
+	m := readMetricsFile(path)
+	m.addResults(results, finished)
+	tmp := path + ".tmp"
+	if err := writeArchiveFile(tmp, []byte(m.format()), 0644, true); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// saveMetrics updates METRICS_FILE after a run. Dry runs leave it alone.
+// Failing to write it is logged but doesn't fail the rotation.
+func saveMetrics(cfg *Config, results []FileResult) {
+	if cfg.MetricsFile == "" || cfg.DryRun {
+		return
+	}
+	if err := writeMetricsFile(cfg.MetricsFile, results, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing metrics file: %v\n", err)
+		logError("Error writing metrics to %s: %v", cfg.MetricsFile, err)
+		return
+	}
+	logDebug("Metrics written to %s", cfg.MetricsFile)
+}
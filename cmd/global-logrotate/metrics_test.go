@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logrotate.prom")
+	first := []FileResult{
+		{Status: statusRotated, OriginalSize: 1000, ArchiveSize: 100},
+		{Status: statusRotated, OriginalSize: 10, ArchiveSize: 40}, // grew
+		{Status: statusFailed, Err: errors.New("disk full")},
+		{Status: statusSkipped},
+	}
+	if err := writeMetricsFile(path, first, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	second := []FileResult{{Status: statusRotated, OriginalSize: 500, ArchiveSize: 200}}
+	if err := writeMetricsFile(path, second, time.Unix(1700003600, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	text := string(data)
+	for _, want := range []string{
+		"# TYPE logrotate_files_rotated_total counter\nlogrotate_files_rotated_total 3\n",
+		"logrotate_bytes_saved_total 1170\n",
+		"logrotate_errors_total 1\n",
+		"# TYPE logrotate_last_run_timestamp_seconds gauge\nlogrotate_last_run_timestamp_seconds 1700003600\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics file lacks %q:\n%s", want, text)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temp file left behind")
+	}
+}
+
+func TestSaveMetricsSkipsDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logrotate.prom")
+	saveMetrics(&Config{MetricsFile: path, DryRun: true}, []FileResult{{Status: statusDryRun}})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", path)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============================================================
+// Bulk archive operations (--migrate, --rekey, --reencrypt, --encrypt-existing)
+// ============================================================
+
+// errAlreadyCurrent marks an archive a bulk operation had nothing to do for.
+var errAlreadyCurrent = errors.New("already current")
+
+// encArchives returns path itself when it is a file, or every .enc archive
+// under it, sorted.
+func encArchives(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var out []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logDebug("Skipping inaccessible path %s: %v", p, err)
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".enc") {
+			out = append(out, p)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out, err
+}
+
+// migrateArchive rewrites a format 1 archive in the current format. The new
+// archive is decrypted and compared with the old payload before it atomically
+// replaces the original, keeping its mode and owner.
+func migrateArchive(path, password string, fsync bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isEnvelope(data) || isPublicKeyArchive(data) {
+		return errAlreadyCurrent
+	}
+	payload, err := decryptData(data, password)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptData(payload, password, nil)
+	if err != nil {
+		return err
+	}
+	check, err := decryptData(sealed, password)
+	if err != nil || sha256.Sum256(check) != sha256.Sum256(payload) {
+		return fmt.Errorf("re-encrypted archive did not verify: %v", err)
+	}
+	return replaceArchive(path, sealed, fsync)
+}
+
+// replaceArchive atomically replaces the archive at path with data through a
+// temporary file, keeping the original's mode and owner.
+func replaceArchive(path string, data []byte, fsync bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := writeArchiveFile(tmp, data, info.Mode().Perm(), fsync); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := ownerOf(info).restore(tmp); err != nil {
+		logInfo("Could not restore ownership on %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// reencryptArchive decrypts an archive of any format with oldPassword and
+// encrypts its payload again with newPassword in the current format, keeping
+// the log checksum it recorded. The new archive is verified before it
+// atomically replaces the original. An archive newPassword already opens is
+// left alone.
+func reencryptArchive(path, oldPassword, newPassword string, fsync bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isPublicKeyArchive(data) {
+		return errAlreadyCurrent // sealed to a public key; no password to change
+	}
+	if _, err := decryptData(data, newPassword); err == nil {
+		return errAlreadyCurrent
+	}
+	payload, err := decryptData(data, oldPassword)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptData(payload, newPassword, archiveLogSum(data))
+	if err != nil {
+		return err
+	}
+	check, err := decryptData(sealed, newPassword)
+	if err != nil || !bytes.Equal(check, payload) {
+		return fmt.Errorf("re-encrypted archive did not verify: %v", err)
+	}
+	return replaceArchive(path, sealed, fsync)
+}
+
+// rekeyArchive rewraps a format 2 to 5 archive's data key from oldPassword to
+// newPassword, rewriting only its header in place. The old header is saved to
+// <path>.rekey first and removed once the new one is synced, so a crash in
+// between leaves a way back.
+func rekeyArchive(path, oldPassword, newPassword string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, maxKeyHeaderLen)
+	n, err := f.ReadAt(header, 0)
+	if n < envelopeHeaderSize {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	header = header[:n]
+	if !hasArchiveMagic(header) {
+		return fmt.Errorf("not a %s archive", archiveBrand)
+	}
+	if isPublicKeyArchive(header) {
+		return errAlreadyCurrent // sealed to a public key; no password to change
+	}
+	if !isEnvelope(header) {
+		return fmt.Errorf("format 1 archive, run --migrate on it first")
+	}
+	size := keyHeaderLen(envelopeMarkerOf(header))
+	if len(header) < size {
+		return fmt.Errorf("truncated header")
+	}
+	header = header[:size]
+	newHeader, err := rekeyHeader(header, oldPassword, newPassword)
+	if err != nil {
+		if _, newErr := unwrapKey(header, newPassword); newErr == nil {
+			return errAlreadyCurrent
+		}
+		return err
+	}
+
+	backup := path + ".rekey"
+	if err := writeArchiveFile(backup, header, 0600, true); err != nil {
+		os.Remove(backup)
+		return fmt.Errorf("saving old header: %w", err)
+	}
+	if _, err := f.WriteAt(newHeader, 0); err != nil {
+		return fmt.Errorf("writing header (old one kept in %s): %w", backup, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing header (old one kept in %s): %w", backup, err)
+	}
+	return os.Remove(backup)
+}
+
+// runArchiveBulk applies fn to archives (found under root), checkpointing
+// finished ones under op so --resume can continue an interrupted run. It
+// returns how many archives failed.
+func runArchiveBulk(op, root string, archives []string, cfg *Config, fn func(path string) error) (int, error) {
+	target, _ := filepath.Abs(root)
+	ckpt, err := openCheckpoint(cfg.CheckpointDir, op, target, cfg.Resume)
+	if err != nil {
+		if cfg.Resume {
+			return 0, fmt.Errorf("cannot resume: %w", err)
+		}
+		logInfo("Running --%s on %s without a checkpoint: %v", op, root, err)
+	}
+	if n := ckpt.count(); n > 0 {
+		fmt.Printf("Resuming: skipping %d archive(s) already done\n", n)
+	}
+
+	var done, current, failed int
+	for _, path := range archives {
+		if ckpt.isDone(path) {
+			continue
+		}
+		if cfg.DryRun {
+			fmt.Printf("[DRY-RUN] Would %s %s\n", op, path)
+			continue
+		}
+		switch err := fn(path); {
+		case errors.Is(err, errAlreadyCurrent):
+			current++
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %s %s: %v\n", op, path, err)
+			logError("--%s of %s failed: %v", op, path, err)
+			failed++
+			continue
+		default:
+			done++
+			logInfo("--%s: %s", op, path)
+		}
+		if err := ckpt.markDone(path); err != nil {
+			logError("Could not update checkpoint for %s: %v", root, err)
+		}
+	}
+	fmt.Printf("%s: %d archive(s) updated, %d already current, %d failed\n", op, done, current, failed)
+	if failed > 0 || cfg.DryRun {
+		ckpt.keep()
+	} else {
+		ckpt.finish()
+	}
+	return failed, nil
+}
+
+// runMigrate converts every format 1 archive under root to the current format.
+func runMigrate(root string, cfg *Config) (int, error) {
+	password := getDecryptionPassword(cfg)
+	if password == "" {
+		return 0, fmt.Errorf("no password provided for decryption")
+	}
+	archives, err := encArchives(root)
+	if err != nil {
+		return 0, err
+	}
+	return runArchiveBulk("migrate", root, archives, cfg, func(path string) error {
+		return migrateArchive(path, password, cfg.Fsync)
+	})
+}
+
+// runRekey moves every format 2 to 5 archive under root from the old password
+// (LOGROTATE_OLD_PASSWORD, or prompted) to the current one.
+func runRekey(root string, cfg *Config) (int, error) {
+	oldPassword, newPassword, err := passwordChange(cfg)
+	if err != nil {
+		return 0, err
+	}
+	archives, err := encArchives(root)
+	if err != nil {
+		return 0, err
+	}
+	return runArchiveBulk("rekey", root, archives, cfg, func(path string) error {
+		return rekeyArchive(path, oldPassword, newPassword)
+	})
+}
+
+// runReencrypt moves every .enc archive under root from the old password
+// (LOGROTATE_OLD_PASSWORD, or prompted) to the current one by re-encrypting
+// it whole.
+func runReencrypt(root string, cfg *Config) (int, error) {
+	oldPassword, newPassword, err := passwordChange(cfg)
+	if err != nil {
+		return 0, err
+	}
+	archives, err := encArchives(root)
+	if err != nil {
+		return 0, err
+	}
+	return runArchiveBulk("reencrypt", root, archives, cfg, func(path string) error {
+		return reencryptArchive(path, oldPassword, newPassword, cfg.Fsync)
+	})
+}
+
+// passwordChange returns the old password, from LOGROTATE_OLD_PASSWORD or
+// prompted, and the current one.
+func passwordChange(cfg *Config) (oldPassword, newPassword string, err error) {
+	oldPassword = os.Getenv("LOGROTATE_OLD_PASSWORD")
+	if oldPassword == "" {
+		if oldPassword, err = readPassword("Enter the OLD encryption password: "); err != nil {
+			return "", "", fmt.Errorf("reading old password: %w", err)
+		}
+	}
+	newPassword = getDecryptionPassword(cfg)
+	if oldPassword == "" || newPassword == "" {
+		return "", "", fmt.Errorf("both the old and the current password are needed")
+	}
+	return oldPassword, newPassword, nil
+}
+
+// plainArchives returns path itself when it is an unencrypted archive, or every
+// unencrypted archive under it, oldest first.
+func plainArchives(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if _, ok := codecForPath(path); !ok || isEncryptedArchive(path) {
+			return nil, fmt.Errorf("%s is not an unencrypted archive", path)
+		}
+		return []string{path}, nil
+	}
+	entries, err := dirArchives(path, readPlain)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(entries))
+	for i, a := range entries {
+		out[i] = a.path
+	}
+	return out, nil
+}
+
+// encryptExistingArchive encrypts the compressed archive at path as it is,
+// without recompressing, to path plus ".enc" (or ".gpg"). The new file is
+// read back, and for the built-in backend decrypted, and compared with the
+// original before it is renamed into place with the original's mode and owner.
+// With removePlain the original is deleted afterwards.
+func encryptExistingArchive(path string, cfg *Config, password string, removePlain bool) error {
+	dst := path + encryptExt(cfg)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sealed []byte
+	if cfg.EncryptBackend == backendGPG {
+		sealed, err = gpgEncrypt(data, cfg)
+	} else {
+		// No log checksum: the only source of the log is this archive itself.
+		sealed, err = encryptData(data, password, nil)
+		// An archive sealed to a public key can't be opened here; the read-back
+		// below still checks what reached the disk.
+		if err == nil && archivePublicKey == nil {
+			check, decErr := decryptData(sealed, password)
+			if decErr != nil || !bytes.Equal(check, data) {
+				err = fmt.Errorf("encrypted archive did not verify: %v", decErr)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := writeArchiveFile(tmp, sealed, info.Mode().Perm(), cfg.Fsync); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := verifyArchiveFile(tmp, sealed); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := ownerOf(info).restore(tmp); err != nil {
+		logInfo("Could not restore ownership on %s: %v", dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if cfg.Fsync {
+		if err := syncDir(filepath.Dir(dst)); err != nil {
+			logDebug("Could not fsync %s: %v", filepath.Dir(dst), err)
+		}
+	}
+	if removePlain {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("encrypted to %s but could not remove the original: %w", dst, err)
+		}
+	}
+	return nil
+}
+
+// runEncryptExisting encrypts every unencrypted archive under root with the
+// configured backend.
+func runEncryptExisting(root string, cfg *Config) (int, error) {
+	var password string
+	if cfg.EncryptBackend == backendGPG {
+		if strings.TrimSpace(cfg.GPGRecipient) == "" {
+			return 0, fmt.Errorf("ENCRYPT_BACKEND=gpg requires GPG_RECIPIENT")
+		}
+	} else if archivePublicKey != nil {
+		logDebug("Encrypting to public key %x", publicKeyID(archivePublicKey))
+	} else if password = getEncryptionPassword(cfg); password == "" {
+		return 0, fmt.Errorf("no encryption password configured (run --pass-gen first)")
+	}
+	archives, err := plainArchives(root)
+	if err != nil {
+		return 0, err
+	}
+	return runArchiveBulk("encrypt-existing", root, archives, cfg, func(path string) error {
+		return encryptExistingArchive(path, cfg, password, cfg.RemovePlain)
+	})
+}
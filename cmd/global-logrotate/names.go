@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ============================================================
+// Long archive names
+// ============================================================
+
+// maxArchiveNameLen is the longest archive file name we write, leaving room for
+// the ".tmp" it is staged under. Most Linux filesystems reject names over 255
+// bytes, and escaped nested paths get there quickly under a shared backup root.
+const maxArchiveNameLen = 255 - len(".tmp")
+
+// archiveNameManifest lives in each backup root and maps the hashed tokens of
+// shortened archive names back to the escaped name they replace, one
+// "token<TAB>name" line per entry.
+const archiveNameManifest = ".archive-names"
+
+// archiveHashLen is how many hex digits of the SHA-256 of a name its token keeps.
+const archiveHashLen = 16
+
+var manifestMu sync.Mutex
+
+// shortenArchiveBase returns base unchanged when base plus tail (".<date>.gz…")
+// fits in maxArchiveNameLen. Otherwise it returns a stable token: a hash of base
+// followed by as much of the end of base as fits, so the log's own file name
+// usually survives, e.g. "3f2a9c0d1e4b5a67~app.log".
+func shortenArchiveBase(base, tail string) (string, bool) {
+	if len(base)+len(tail) <= maxArchiveNameLen {
+		return base, false
+	}
+	sum := sha256.Sum256([]byte(base))
+	token := hex.EncodeToString(sum[:])[:archiveHashLen] + "~"
+	room := maxArchiveNameLen - len(tail) - len(token)
+	if room <= 0 {
+		return strings.TrimSuffix(token, "~"), true
+	}
+	i := len(base) - room
+	// Don't start in the middle of a UTF-8 sequence or a %XX escape.
+	for i < len(base) && (!utf8.RuneStart(base[i]) || base[i-1] == '%' || (i >= 2 && base[i-2] == '%')) {
+		i++
+	}
+	return token + base[i:], true
+}
+
+// recordArchiveName appends token -> base to root's manifest unless it is
+// already there.
+func recordArchiveName(root, token, base string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if loadArchiveNames(root)[token] != "" {
+		return nil
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(root, archiveNameManifest), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\t%s\n", token, base); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadArchiveNames reads root's manifest into a token -> escaped name map. A
+// missing manifest yields an empty map.
+func loadArchiveNames(root string) map[string]string {
+	names := make(map[string]string)
+	f, err := os.Open(filepath.Join(root, archiveNameManifest))
+	if err != nil {
+		return names
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if token, base, ok := strings.Cut(scanner.Text(), "\t"); ok {
+			names[token] = base
+		}
+	}
+	return names
+}
+
+// archiveNameIndex maps the log names parseArchiveName yields for root's
+// shortened archives back to the original names. Both sides are unescaped, as
+// parseArchiveName's results are.
+func archiveNameIndex(root string) map[string]string {
+	index := make(map[string]string)
+	for token, base := range loadArchiveNames(root) {
+		index[unescapeName(token)] = unescapeName(base)
+	}
+	return index
+}
+
+func unescapeName(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
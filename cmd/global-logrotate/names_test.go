@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShortenArchiveBase(t *testing.T) {
+	tail := ".20240115.gz.enc"
+	if got, short := shortenArchiveBase("app.log", tail); short || got != "app.log" {
+		t.Errorf("short name changed: %q", got)
+	}
+
+	long := strings.Repeat("deep%2F", 40) + "app.log"
+	got, short := shortenArchiveBase(long, tail)
+	if !short || len(got+tail) > maxArchiveNameLen {
+		t.Fatalf("got %q (%d bytes), want <= %d", got, len(got+tail), maxArchiveNameLen)
+	}
+	if !strings.HasSuffix(got, "app.log") {
+		t.Errorf("shortened name lost the file name: %q", got)
+	}
+	if _, rest, _ := strings.Cut(got, "~"); strings.HasPrefix(rest, "2F") || strings.HasPrefix(rest, "F") {
+		t.Errorf("shortened name starts inside an escape: %q", got)
+	}
+	if again, _ := shortenArchiveBase(long, tail); again != got {
+		t.Errorf("token not stable: %q vs %q", again, got)
+	}
+	if other, _ := shortenArchiveBase("x"+long, tail); other == got {
+		t.Error("different names share a token")
+	}
+}
+
+func TestRotateLongNestedName(t *testing.T) {
+	dir := t.TempDir()
+	nested := dir
+	for range 6 {
+		nested = filepath.Join(nested, strings.Repeat("n", 40))
+	}
+	os.MkdirAll(nested, 0755)
+	logPath := filepath.Join(nested, "app.log")
+	os.WriteFile(logPath, []byte("deep\n"), 0644)
+
+	cfg := makeTestCfg(t, dir)
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status = %s (err=%v)", res.Status, res.Err)
+	}
+	if n := len(filepath.Base(res.Archive)); n > maxArchiveNameLen {
+		t.Errorf("archive name is %d bytes", n)
+	}
+
+	root := filepath.Join(dir, "old")
+	names := loadArchiveNames(root)
+	if len(names) != 1 {
+		t.Fatalf("manifest = %v, want one entry", names)
+	}
+	entries := scanArchives(root)
+	rel, _ := filepath.Rel(dir, logPath)
+	if len(entries) != 1 || entries[0].logName != rel {
+		t.Errorf("scanArchives = %+v, want logName %q", entries, rel)
+	}
+
+	// Rotating again the same day reuses the token without duplicating the entry.
+	for token, base := range names {
+		recordArchiveName(root, token, base)
+	}
+	if len(loadArchiveNames(root)) != 1 {
+		t.Error("manifest entry duplicated")
+	}
+}
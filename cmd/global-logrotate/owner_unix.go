@@ -0,0 +1,52 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ============================================================
+// File ownership and identity (Unix)
+// ============================================================
+
+// fileOwner is the uid and gid a file was found with, to be given to what is
+// written in its place.
+type fileOwner struct {
+	uid, gid int
+}
+
+// ownerOf returns info's owner, or nil where the platform doesn't report one.
+func ownerOf(info os.FileInfo) *fileOwner {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwner{uid: int(st.Uid), gid: int(st.Gid)}
+}
+
+// restore gives path o's owner. A nil o, from a file whose owner wasn't
+// known, leaves path alone.
+func (o *fileOwner) restore(path string) error {
+	if o == nil {
+		return nil
+	}
+	return os.Chown(path, o.uid, o.gid)
+}
+
+// linkCount is the number of hard links to info's file, 1 when unknown.
+func linkCount(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// fileInode is info's inode number, 0 when unknown.
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
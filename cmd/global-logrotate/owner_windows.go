@@ -0,0 +1,30 @@
+package main
+
+import "os"
+
+// ============================================================
+// File ownership and identity (Windows)
+// ============================================================
+
+// Windows files have no uid and gid; archives and rewritten files get the
+// default ACL of the directory they are created in.
+
+// fileOwner is a file's owner; on Windows ownerOf never returns one.
+type fileOwner struct {
+	uid, gid int
+}
+
+// ownerOf returns nil: the platform reports no uid or gid.
+func ownerOf(info os.FileInfo) *fileOwner { return nil }
+
+// restore does nothing but note that ownership isn't carried over.
+func (o *fileOwner) restore(path string) error {
+	logDebug("Not restoring ownership on %s: no uid/gid on this platform", path)
+	return nil
+}
+
+// linkCount is 1: hard links aren't counted here.
+func linkCount(info os.FileInfo) uint64 { return 1 }
+
+// fileInode is 0: os.FileInfo carries no file index on Windows.
+func fileInode(info os.FileInfo) uint64 { return 0 }
@@ -0,0 +1,93 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ============================================================
+// Platform primitives (Unix)
+// ============================================================
+
+// The reload signals --signal accepts beyond those every platform defines.
+const (
+	sigUSR1 = syscall.SIGUSR1
+	sigUSR2 = syscall.SIGUSR2
+)
+
+// lockFile takes an exclusive flock on f without waiting; held reports that
+// another process already has it.
+func lockFile(f *os.File) (held bool, err error) {
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	return errors.Is(err, syscall.EWOULDBLOCK), err
+}
+
+// unlockFile drops the lock lockFile took.
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// setProcessGroup makes cmd, once started, the leader of its own process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// stopProcessGroup sends SIGTERM, or SIGKILL with force, to the process group
+// cmd leads.
+func stopProcessGroup(cmd *exec.Cmd, force bool) {
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// sendSignal sends sig to pid; signal 0 only checks that pid is alive.
+func sendSignal(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// diskSpace returns the size of the filesystem holding path and the bytes
+// available on it to unprivileged users.
+func diskSpace(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Blocks) * uint64(st.Bsize), uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// makeFIFO creates a named pipe at path.
+func makeFIFO(path string, mode uint32) error {
+	return syscall.Mkfifo(path, mode)
+}
+
+// openFileLimit returns the soft RLIMIT_NOFILE, or 0 when it is unknown or
+// unlimited.
+func openFileLimit() uint64 {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		logDebug("Could not read RLIMIT_NOFILE: %v", err)
+		return 0
+	}
+	cur := uint64(rl.Cur)  // int64 on some BSDs
+	if cur == ^uint64(0) { // RLIM_INFINITY
+		return 0
+	}
+	return cur
+}
+
+// processCPU returns the CPU time this process has used and its peak
+// resident set in bytes, zero where unknown.
+func processCPU() (user, sys time.Duration, maxRSS int64) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, 0
+	}
+	return time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano()), int64(ru.Maxrss) * 1024 // kilobytes on Linux
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ============================================================
+// Platform primitives (Windows)
+// ============================================================
+
+// Windows has no SIGUSR1 or SIGUSR2. These are the Linux numbers, so that
+// --signal parses alike everywhere; sendSignal refuses them anyway.
+const (
+	sigUSR1 = syscall.Signal(0xa)
+	sigUSR2 = syscall.Signal(0xc)
+)
+
+var errNoSignals = errors.New("sending signals to other processes is not supported on Windows")
+
+// lockFile takes an exclusive lock on the first byte of f without waiting;
+// held reports that another process already has it.
+func lockFile(f *os.File) (held bool, err error) {
+	ol := new(windows.Overlapped)
+	err = windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	return errors.Is(err, windows.ERROR_LOCK_VIOLATION), err
+}
+
+// unlockFile drops the lock lockFile took.
+func unlockFile(f *os.File) {
+	windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
+
+// setProcessGroup starts cmd in a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// stopProcessGroup terminates cmd. Windows has no SIGTERM to ask politely
+// with, so force or not it is killed outright; its children are not.
+func stopProcessGroup(cmd *exec.Cmd, force bool) {
+	cmd.Process.Kill()
+}
+
+// sendSignal fails: there is no kill(2) on Windows.
+func sendSignal(pid int, sig syscall.Signal) error {
+	return errNoSignals
+}
+
+// diskSpace returns the size of the volume holding path and the bytes
+// available on it to the current user.
+func diskSpace(path string) (total, free uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = windows.GetDiskFreeSpaceEx(p, &free, &total, nil)
+	return total, free, err
+}
+
+// makeFIFO fails: Windows named pipes don't live in the filesystem.
+func makeFIFO(path string, mode uint32) error {
+	return errors.New("FIFOs are not supported on Windows")
+}
+
+// openFileLimit returns 0: Windows sets no per-process descriptor limit that
+// the worker pools could run into.
+func openFileLimit() uint64 { return 0 }
+
+// processCPU returns the CPU time this process has used and its peak
+// working set in bytes, zero where unknown.
+func processCPU() (user, sys time.Duration, maxRSS int64) {
+	var creation, exit, kernel, usr windows.Filetime
+	if err := windows.GetProcessTimes(windows.CurrentProcess(), &creation, &exit, &kernel, &usr); err != nil {
+		return 0, 0, 0
+	}
+	ticks := func(ft windows.Filetime) time.Duration { // 100 ns units
+		return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+	}
+	return ticks(usr), ticks(kernel), 0
+}
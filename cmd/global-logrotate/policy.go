@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================
+// Per-tree policy file (.logrotaterc)
+// ============================================================
+
+// treePolicyFile is a policy file at the root of a log tree. It uses the
+// global.conf syntax and, when LogDir contains it, overrides the global config
+// for that tree. Command-line flags still win over it.
+const treePolicyFile = ".logrotaterc"
+
+// treePolicyKeys are the keys a .logrotaterc may set. A policy file lives next
+// to the logs, often writable by the application, so anything that names a
+// secret, a binary to execute, or where we log is deliberately left out.
+var treePolicyKeys = map[string]bool{
+	"PATTERN":          true,
+	"EXCLUDE_FILE":     true,
+	"EXCLUDE_PATTERNS": true,
+	"RETENTION_RULES":  true,
+	"RETENTION_DAYS":   true,
+	"MAX_ARCHIVES":     true,
+	"MAX_TOTAL_SIZE":   true,
+	"ROUTE_RULES":      true,
+	"OLD_LOGS_DIR":     true,
+	"DATE_FORMAT":      true,
+	"DATE_PATTERN":     true,
+	"COMPRESS":         true,
+	"ENCRYPT":          true,
+	"ENCRYPT_RULES":    true,
+	"ENCRYPT_BACKEND":  true,
+	"GPG_RECIPIENT":    true,
+}
+
+// loadTreePolicy reads logDir's .logrotaterc. It returns the accepted keys and
+// the file's path, or a nil map when there is no usable policy file. Relative
+// EXCLUDE_FILE and OLD_LOGS_DIR values are resolved against logDir. Files that
+// another user could have planted or edited are ignored with a warning.
+func loadTreePolicy(logDir string) (map[string]string, string) {
+	path := filepath.Join(logDir, treePolicyFile)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, ""
+	}
+	if err := checkPolicyOwner(info); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %s: %v\n", path, err)
+		logError("Ignoring %s: %v", path, err)
+		return nil, ""
+	}
+
+	raw := make(map[string]string)
+	loadConfigFile(path, raw)
+	policy := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if !treePolicyKeys[key] {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s cannot be set in a %s, ignored\n", path, key, treePolicyFile)
+			logError("%s: key %s not allowed in %s, ignored", path, key, treePolicyFile)
+			continue
+		}
+		if (key == "EXCLUDE_FILE" || key == "OLD_LOGS_DIR") && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(logDir, value)
+		}
+		policy[key] = value
+	}
+	logInfo("Loaded tree policy %s (%d key(s))", path, len(policy))
+	return policy, path
+}
+
+// checkPolicyOwner accepts a policy file only if root or the current user owns
+// it and it is not writable by group or others.
+func checkPolicyOwner(info os.FileInfo) error {
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("writable by group or others (mode %04o)", info.Mode().Perm())
+	}
+	if o := ownerOf(info); o != nil && o.uid != 0 && o.uid != os.Geteuid() {
+		return fmt.Errorf("owned by uid %d, not root or the current user", o.uid)
+	}
+	return nil
+}
+
+// mergeConfig returns base overlaid with over, leaving both untouched.
+func mergeConfig(base, over map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// splitPatternList splits a comma-separated EXCLUDE_PATTERNS value.
+func splitPatternList(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
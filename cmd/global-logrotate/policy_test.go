@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTreePolicy(t *testing.T) {
+	dir := t.TempDir()
+	rc := "# per-tree policy\n" +
+		"PATTERN = *.txt\n" +
+		"OLD_LOGS_DIR = archive\n" +
+		"EXCLUDE_PATTERNS = debug*.txt, tmp/*\n" +
+		"ENCRYPT_PASSWORD = planted\n" +
+		"GPG_BINARY = /tmp/evil\n"
+	os.WriteFile(filepath.Join(dir, treePolicyFile), []byte(rc), 0600)
+
+	policy, path := loadTreePolicy(dir)
+	if path != filepath.Join(dir, treePolicyFile) {
+		t.Fatalf("path = %q", path)
+	}
+	want := map[string]string{
+		"PATTERN":          "*.txt",
+		"OLD_LOGS_DIR":     filepath.Join(dir, "archive"),
+		"EXCLUDE_PATTERNS": "debug*.txt, tmp/*",
+	}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("policy = %v, want %v", policy, want)
+	}
+
+	cfg := buildConfig(mergeConfig(map[string]string{"PATTERN": "*.log", "COMPRESS": "xz"}, policy))
+	if cfg.Pattern != "*.txt" || cfg.Compress != "xz" {
+		t.Errorf("merged Pattern=%q Compress=%q, want policy to override only what it sets", cfg.Pattern, cfg.Compress)
+	}
+}
+
+func TestLoadTreePolicyRejectsWritableFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, treePolicyFile)
+	os.WriteFile(path, []byte("PATTERN = *\n"), 0600)
+	os.Chmod(path, 0666)
+	if policy, _ := loadTreePolicy(dir); policy != nil {
+		t.Errorf("world-writable policy should be ignored, got %v", policy)
+	}
+	if policy, p := loadTreePolicy(t.TempDir()); policy != nil || p != "" {
+		t.Error("missing policy file should yield nothing")
+	}
+}
+
+func TestFindLogFilesSkipsControlFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, treePolicyFile), []byte("PATTERN = *\n"), 0600)
+	os.WriteFile(filepath.Join(dir, dirExcludeFile), []byte("nothing\n"), 0644)
+
+	files := findLogFiles(dir, "*", nil, 0, walkScope{})
+	if len(files) != 1 || filepath.Base(files[0].path) != "app" {
+		t.Errorf("found %v, want only app", files)
+	}
+}
+
+func TestSplitPatternList(t *testing.T) {
+	got := splitPatternList(" a*.log, ,tmp/* ,")
+	if !reflect.DeepEqual(got, []string{"a*.log", "tmp/*"}) {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ============================================================
+// Progress of long rotations on stderr
+// ============================================================
+
+// progressOut receives a line of progress, at most once a second, for each file
+// still being read after a second. main sets it to stderr when someone is
+// watching a terminal; under --format json, --stream-archive or without a
+// terminal it stays nil and nothing is reported.
+var progressOut io.Writer
+
+const progressInterval = time.Second
+
+// progressReader reports how far through its source a rotation is.
+type progressReader struct {
+	r     io.Reader
+	out   io.Writer
+	name  string
+	total int64 // the size at stat; the file may have grown since
+	done  int64
+	next  time.Time
+}
+
+// withProgress wraps r, the source of logFile, to report progress to
+// progressOut, if set.
+func withProgress(r io.Reader, logFile string, total int64) io.Reader {
+	if progressOut == nil {
+		return r
+	}
+	return &progressReader{r: r, out: progressOut, name: logFile, total: total, next: time.Now().Add(progressInterval)}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if now := time.Now(); n > 0 && !now.Before(p.next) {
+		p.next = now.Add(progressInterval)
+		fmt.Fprintf(p.out, "%s: Rotating %s: %d%% (%s of %s)\n",
+			timestamp(), p.name, progressPercent(p.done, p.total), formatSize(p.done), formatSize(p.total))
+	}
+	return n, err
+}
+
+// progressPercent is done as a percentage of total, held at 99 until the read
+// is over because a growing file can outrun its stat'ed size.
+func progressPercent(done, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+	return min(done*100/total, 99)
+}
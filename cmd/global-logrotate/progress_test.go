@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReader(t *testing.T) {
+	var out bytes.Buffer
+	p := &progressReader{r: bytes.NewReader(make([]byte, 4096)), out: &out, name: "/var/log/app.log", total: 4096}
+	buf := make([]byte, 1024)
+	p.Read(buf)
+	if !strings.Contains(out.String(), "/var/log/app.log: 25% (1.00 KB of 4.00 KB)") {
+		t.Errorf("first report: %q", out.String())
+	}
+	// Further reads within the second stay quiet.
+	io.Copy(io.Discard, p)
+	if n := strings.Count(out.String(), "\n"); n != 1 {
+		t.Errorf("%d reports within a second:\n%s", n, out.String())
+	}
+	if p.next.Sub(time.Now()) <= 0 {
+		t.Error("next report not pushed a second ahead")
+	}
+}
+
+func TestWithProgressOff(t *testing.T) {
+	r := bytes.NewReader(nil)
+	if withProgress(r, "app.log", 10) != io.Reader(r) {
+		t.Error("source wrapped with progressOut unset")
+	}
+	defer func() { progressOut = nil }()
+	progressOut = io.Discard
+	if _, ok := withProgress(r, "app.log", 10).(*progressReader); !ok {
+		t.Error("source not wrapped with progressOut set")
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	for _, c := range []struct{ done, total, want int64 }{
+		{0, 100, 0}, {50, 100, 50}, {100, 100, 99}, {150, 100, 99}, {10, 0, 0},
+	} {
+		if got := progressPercent(c.done, c.total); got != c.want {
+			t.Errorf("progressPercent(%d, %d) = %d, want %d", c.done, c.total, got, c.want)
+		}
+	}
+}
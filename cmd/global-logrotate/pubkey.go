@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ============================================================
+// Public-key archives (ENCRYPT_PUBLIC_KEY, archive format 6)
+// ============================================================
+//
+// With ENCRYPT_PUBLIC_KEY set, the random data key is wrapped with an RSA
+// public key (RSA-OAEP, SHA-256) instead of a password-derived key, so the
+// host that writes archives holds nothing that decrypts them:
+//
+//	MAGIC(4) | "GLRKEY6\0"(8) | KEY_ID(8) | WRAPPED_LEN(2) | WRAPPED_KEY | NONCE(12) | CHUNK... | LOG_SHA256(32)
+//
+// KEY_ID is the start of the SHA-256 of the public key, to tell which private
+// key an archive needs. WRAPPED_LEN is big-endian and is the key's modulus
+// size. The payload is sealed in chunks as in format 5 (see chunked.go), and
+// the wrapped key is bound to MAGIC and the marker through the OAEP label.
+
+// publicKeyMarker follows the magic in format 6 archives.
+const publicKeyMarker = "GLRKEY6\x00"
+
+const (
+	keyIDSize        = 8
+	minRSAKeyBits    = 2048
+	publicHeaderBase = 4 + len(publicKeyMarker) + keyIDSize + 2
+)
+
+// archivePublicKey, when set, is what encryptData seals new archives to
+// (ENCRYPT_PUBLIC_KEY); archivePrivateKey opens them (--private-key).
+var (
+	archivePublicKey  *rsa.PublicKey
+	archivePrivateKey *rsa.PrivateKey
+)
+
+// isPublicKeyArchive reports whether data is a format 6 archive.
+func isPublicKeyArchive(data []byte) bool {
+	m := len(encryptMagic)
+	return len(data) >= m+len(publicKeyMarker) && string(data[m:m+len(publicKeyMarker)]) == publicKeyMarker
+}
+
+// publicKeyID identifies pub in archive headers and messages.
+func publicKeyID(pub *rsa.PublicKey) []byte {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(pub))
+	return sum[:keyIDSize]
+}
+
+// archiveKeyID is the KEY_ID of a format 6 archive.
+func archiveKeyID(data []byte) []byte {
+	off := len(encryptMagic) + len(publicKeyMarker)
+	return data[off : off+keyIDSize]
+}
+
+// publicHeaderLen is the length of a format 6 archive's key header, up to
+// NONCE, as its WRAPPED_LEN declares.
+func publicHeaderLen(data []byte) int {
+	if len(data) < publicHeaderBase {
+		return publicHeaderBase
+	}
+	return publicHeaderBase + int(binary.BigEndian.Uint16(data[publicHeaderBase-2:]))
+}
+
+// readPEMBlock returns the first PEM block in path. Files holding private keys
+// (private set) must not be world-readable.
+func readPEMBlock(path string, private bool) (*pem.Block, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if private && info.Mode().Perm()&0004 != 0 {
+		return nil, fmt.Errorf("private key %s is world-readable (mode %04o); chmod o-r it first", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM data", path)
+	}
+	return block, nil
+}
+
+// loadPublicKey reads an RSA public key in PEM: "PUBLIC KEY" (PKIX) or
+// "RSA PUBLIC KEY" (PKCS #1).
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEMBlock(path, false)
+	if err != nil {
+		return nil, err
+	}
+	var pub *rsa.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		var ok bool
+		if pub, ok = key.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("%s: not an RSA public key", path)
+		}
+	case "RSA PUBLIC KEY":
+		if pub, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q, want a public key", path, block.Type)
+	}
+	if pub.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("%s: RSA key of %d bits is too small (minimum %d)", path, pub.N.BitLen(), minRSAKeyBits)
+	}
+	return pub, nil
+}
+
+// loadPrivateKey reads an unencrypted RSA private key in PEM: "PRIVATE KEY"
+// (PKCS #8) or "RSA PRIVATE KEY" (PKCS #1).
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEMBlock(path, true)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA private key", path)
+		}
+		return priv, nil
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return priv, nil
+	}
+	return nil, fmt.Errorf("%s: unexpected PEM block %q, want an unencrypted private key", path, block.Type)
+}
+
+// newPublicWriter writes the header of a new format 6 archive, sealed to pub,
+// to w.
+func newPublicWriter(w io.Writer, pub *rsa.PublicKey) (*encryptWriter, error) {
+	dataKey, err := cryptoRandom(keySize)
+	if err != nil {
+		return nil, err
+	}
+	aad := envelopeAAD(publicKeyMarker)
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, dataKey, aad)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+	header := make([]byte, 0, publicHeaderBase+len(wrapped))
+	header = append(header, aad...)
+	header = append(header, publicKeyID(pub)...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	return startChunks(w, publicKeyMarker, header, dataKey)
+}
+
+// sealPublic encrypts payload, already in memory, as a format 6 archive to
+// pub, recording logSum when it isn't nil.
+func sealPublic(payload []byte, pub *rsa.PublicKey, logSum []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	e, err := newPublicWriter(&buf, pub)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := e.finish(logSum); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unwrapPublicKey returns the data key in a format 6 key header, unwrapped
+// with archivePrivateKey.
+func unwrapPublicKey(header []byte) ([]byte, error) {
+	if len(header) < publicHeaderLen(header) {
+		return nil, errChunkedTruncated
+	}
+	keyID := archiveKeyID(header)
+	priv := archivePrivateKey
+	if priv == nil {
+		return nil, fmt.Errorf("archive is sealed to public key %x; pass its private key with --private-key", keyID)
+	}
+	if !bytes.Equal(publicKeyID(&priv.PublicKey), keyID) {
+		return nil, fmt.Errorf("archive is sealed to public key %x, not to --private-key's %x", keyID, publicKeyID(&priv.PublicKey))
+	}
+	wrapped := header[publicHeaderBase:publicHeaderLen(header)]
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, priv, wrapped, headerAAD(header))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key (corrupted header): %w", err)
+	}
+	return dataKey, nil
+}
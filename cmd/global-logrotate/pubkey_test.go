@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRSAKeyPair writes a fresh RSA key pair under dir as PKIX and PKCS #8
+// PEM files and returns their paths.
+func writeRSAKeyPair(t *testing.T, dir, name string) (pubPath, privPath string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPath = filepath.Join(dir, name+".pub.pem")
+	privPath = filepath.Join(dir, name+".pem")
+	os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644)
+	os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600)
+	return pubPath, privPath
+}
+
+// useKeyPair loads the pair as ENCRYPT_PUBLIC_KEY and --private-key for the
+// rest of the test.
+func useKeyPair(t *testing.T, pubPath, privPath string) {
+	t.Helper()
+	pub, err := loadPublicKey(pubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := loadPrivateKey(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePublicKey, archivePrivateKey = pub, priv
+	t.Cleanup(func() { archivePublicKey, archivePrivateKey = nil, nil })
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	pubPath, privPath := writeRSAKeyPair(t, dir, "archive")
+	useKeyPair(t, pubPath, privPath)
+
+	payload := []byte("compressed bytes")
+	sum := sha256.Sum256([]byte("the log"))
+	sealed, err := encryptData(payload, "", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPublicKeyArchive(sealed) || isEnvelope(sealed) {
+		t.Fatal("encryptData with a public key did not write format 6")
+	}
+	if got := archiveLogSum(sealed); !bytes.Equal(got, sum[:]) {
+		t.Errorf("log checksum %x, want %x", got, sum)
+	}
+	got, err := decryptData(sealed, "")
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("decryptData = %q, %v", got, err)
+	}
+
+	// The header and the payload are both authenticated.
+	for _, off := range []int{publicHeaderBase + 5, len(sealed) - 1} {
+		bad := bytes.Clone(sealed)
+		bad[off] ^= 1
+		if _, err := decryptData(bad, ""); err == nil {
+			t.Errorf("flipping byte %d went unnoticed", off)
+		}
+	}
+
+	// Without the private key, or with another one, it doesn't open.
+	priv := archivePrivateKey
+	archivePrivateKey = nil
+	if _, err := decryptData(sealed, ""); err == nil || !strings.Contains(err.Error(), "--private-key") {
+		t.Errorf("no private key: err = %v", err)
+	}
+	_, otherPriv := writeRSAKeyPair(t, dir, "other")
+	if archivePrivateKey, err = loadPrivateKey(otherPriv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptData(sealed, ""); err == nil || !strings.Contains(err.Error(), "sealed to public key") {
+		t.Errorf("wrong private key: err = %v", err)
+	}
+	archivePrivateKey = priv
+}
+
+func TestLoadKeysRefusesBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	pubPath, privPath := writeRSAKeyPair(t, dir, "archive")
+	os.Chmod(privPath, 0644)
+	if _, err := loadPrivateKey(privPath); err == nil || !strings.Contains(err.Error(), "world-readable") {
+		t.Errorf("world-readable private key: err = %v", err)
+	}
+	if _, err := loadPublicKey(privPath); err == nil {
+		t.Error("private key accepted as ENCRYPT_PUBLIC_KEY")
+	}
+	if _, err := loadPrivateKey(pubPath); err == nil {
+		t.Error("public key accepted as --private-key")
+	}
+	junk := filepath.Join(dir, "junk.pem")
+	os.WriteFile(junk, []byte("not pem"), 0600)
+	if _, err := loadPublicKey(junk); err == nil {
+		t.Error("non-PEM file accepted")
+	}
+}
+
+func TestRotatePublicKeyWithoutPassword(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LOGROTATE_PASSWORD", "")
+	pubPath, privPath := writeRSAKeyPair(t, dir, "archive")
+	useKeyPair(t, pubPath, privPath)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.Encrypt = true
+	logPath := filepath.Join(dir, "app.log")
+	content := []byte("2024-01-15 INFO sealed to a key\n")
+	os.WriteFile(logPath, content, 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated {
+		t.Fatalf("status %s: %v", res.Status, res.Err)
+	}
+
+	var out bytes.Buffer
+	if err := streamLogFile(&out, res.Archive, &Config{}); err != nil || !bytes.Equal(out.Bytes(), content) {
+		t.Fatalf("read with the private key: %q, %v", out.String(), err)
+	}
+	archivePrivateKey = nil
+	if err := streamLogFile(&out, res.Archive, &Config{}); err == nil {
+		t.Error("archive read without the private key")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ============================================================
+// Read throttling (RATE_LIMIT)
+// ============================================================
+
+// rateLimiter is a token bucket shared by every worker of a run, so that
+// RATE_LIMIT caps what the whole run reads from its sources, not each file.
+// Workers reserve the bytes they just read and sleep off any debt, which
+// keeps them fair to each other without a goroutine of its own.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64 // may go negative: bytes read ahead of the rate
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait blocks until reading n more bytes keeps within the rate. Unused
+// allowance builds up to at most one second's worth.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+}
+
+// throttledReader reads through a shared rateLimiter.
+type throttledReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.l.wait(n)
+	}
+	return n, err
+}
+
+// throttle wraps r in the run's rate limit, if it has one.
+func throttle(r io.Reader, l *rateLimiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{r: r, l: l}
+}
+
+// startRateLimit sets up the limiter cfg's run reads its sources through.
+// RATE_LIMIT is validated in parseFlags and, for daemon jobs, at job start.
+func startRateLimit(cfg *Config) {
+	cfg.limiter = nil
+	if cfg.RateLimit == "" {
+		return
+	}
+	if n, err := parseSize(cfg.RateLimit); err == nil && n > 0 {
+		cfg.limiter = newRateLimiter(n)
+		logDebug("Reading sources at up to %s/s", formatSize(n))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitIsSharedAcrossReaders(t *testing.T) {
+	// Two readers of 256 KiB each share 1 MiB/s, so together they need at
+	// least half a second; each alone would pass in a quarter.
+	l := newRateLimiter(1 << 20)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := io.Copy(io.Discard, throttle(bytes.NewReader(make([]byte, 256<<10)), l))
+			if n != 256<<10 || err != nil {
+				t.Errorf("copied %d bytes, err %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if took := time.Since(start); took < 400*time.Millisecond {
+		t.Errorf("512 KiB at 1 MiB/s took %v", took)
+	}
+}
+
+func TestStartRateLimit(t *testing.T) {
+	cfg := &Config{}
+	startRateLimit(cfg)
+	if cfg.limiter != nil {
+		t.Error("limiter set without RATE_LIMIT")
+	}
+	r := bytes.NewReader(nil)
+	if throttle(r, cfg.limiter) != io.Reader(r) {
+		t.Error("throttle wrapped a reader without a limiter")
+	}
+	cfg.RateLimit = "50M"
+	startRateLimit(cfg)
+	if cfg.limiter == nil || cfg.limiter.rate != 50<<20 {
+		t.Errorf("RATE_LIMIT 50M: limiter %+v", cfg.limiter)
+	}
+}
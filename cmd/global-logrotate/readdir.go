@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============================================================
+// Directory reads (--read <dir>)
+// ============================================================
+
+// Values of Config.ReadFilter.
+const (
+	readAll       = ""
+	readEncrypted = "encrypted"
+	readPlain     = "plain"
+)
+
+// isEncryptedArchive reports whether name is an encrypted archive (.enc or .gpg).
+func isEncryptedArchive(name string) bool {
+	return strings.HasSuffix(name, ".enc") || strings.HasSuffix(name, ".gpg")
+}
+
+// dirArchives lists the archives under dir that pass filter, oldest first: by
+// the date in their name, then by their number that day, then by path.
+func dirArchives(dir, filter string) ([]archiveEntry, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	var out []archiveEntry
+	for _, a := range scanArchives(dir) {
+		if isLaterPart(a.path) {
+			continue // read along with the first part
+		}
+		archive, _ := splitArchiveOf(a.path)
+		enc := isEncryptedArchive(archive)
+		if (filter == readEncrypted && !enc) || (filter == readPlain && enc) {
+			continue
+		}
+		out = append(out, a)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].date.Equal(out[j].date) {
+			return out[i].date.Before(out[j].date)
+		}
+		if out[i].seq != out[j].seq {
+			return out[i].seq < out[j].seq
+		}
+		return out[i].path < out[j].path
+	})
+	return out, nil
+}
+
+// streamLogDir streams every archive under dir that passes cfg.ReadFilter to w,
+// oldest first. A "==> path <==" header per archive goes to stderr so w carries
+// only log content. An archive that fails to read is reported and skipped; the
+// returned error says how many did.
+//
+// Finished archives are checkpointed, so after an interruption --resume skips
+// them; an archive cut off midway is streamed again from its start.
+func streamLogDir(w io.Writer, dir string, cfg *Config) error {
+	archives, err := dirArchives(dir, cfg.ReadFilter)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no matching archives under %s", dir)
+	}
+
+	target, _ := filepath.Abs(dir)
+	ckpt, err := openCheckpoint(cfg.CheckpointDir, "read", target+" "+cfg.ReadFilter, cfg.Resume)
+	if err != nil {
+		if cfg.Resume {
+			return fmt.Errorf("cannot resume: %w", err)
+		}
+		logDebug("Reading %s without a checkpoint: %v", dir, err)
+	}
+	if n := ckpt.count(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming: skipping %d archive(s) already read\n", n)
+	}
+
+	var failed int
+	for _, a := range archives {
+		if ckpt.isDone(a.path) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "==> %s <==\n", a.path)
+		f, err := os.Open(a.path)
+		if err == nil {
+			err = streamArchive(w, filepath.Base(a.path), f, cfg)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", a.path, err)
+			failed++
+			continue
+		}
+		if err := ckpt.markDone(a.path); err != nil {
+			logError("Could not update checkpoint for %s: %v", dir, err)
+		}
+	}
+	if failed > 0 {
+		ckpt.keep()
+		return fmt.Errorf("%d of %d archive(s) could not be read", failed, len(archives))
+	}
+	ckpt.finish()
+	return nil
+}
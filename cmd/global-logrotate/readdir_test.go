@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamLogDirFilters(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel string, data []byte) {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gz := func(s string) []byte {
+		out, err := compressGzip(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	enc, err := encryptData(gz("secret-16\n"), "dir-pw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("20240116/app.log.20240116.gz.enc", enc)
+	write("20240115/app.log.20240115.gz", gz("plain-15\n"))
+	write("20240117/db.log.20240117.gz", gz("plain-17\n"))
+	write("notes.txt", []byte("not an archive\n"))
+	cfg := &Config{EncryptPassword: "dir-pw"}
+
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{readAll, "plain-15\nsecret-16\nplain-17\n"},
+		{readEncrypted, "secret-16\n"},
+		{readPlain, "plain-15\nplain-17\n"},
+	} {
+		cfg.ReadFilter = tc.filter
+		var buf bytes.Buffer
+		if err := streamLogFile(&buf, dir, cfg); err != nil {
+			t.Fatalf("filter %q: %v", tc.filter, err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("filter %q: got %q, want %q", tc.filter, buf.String(), tc.want)
+		}
+	}
+
+	empty := t.TempDir()
+	os.WriteFile(filepath.Join(empty, "app.log.20240115.gz"), gz("x\n"), 0644)
+	cfg.ReadFilter = readEncrypted
+	if err := streamLogFile(&bytes.Buffer{}, empty, cfg); err == nil {
+		t.Error("expected an error when no archive matches the filter")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Run reports (--report-dir)
+// ============================================================
+
+const defaultReportKeep = 30
+
+// runReport is the JSON document written for each run under REPORT_DIR.
+type runReport struct {
+	RunID    string         `json:"run_id"`
+	Job      string         `json:"job,omitempty"`
+	Host     string         `json:"host"`
+	Version  string         `json:"version"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	LogDir   string         `json:"log_dir"`
+	DryRun   bool           `json:"dry_run"`
+	Counts   map[string]int `json:"counts"`
+	Files    []reportFile   `json:"files,omitempty"`
+	Usage    *runUsage      `json:"usage,omitempty"`
+}
+
+type reportFile struct {
+	Path         string  `json:"path"`
+	Archive      string  `json:"archive,omitempty"`
+	Status       string  `json:"status"`
+	Reason       string  `json:"reason,omitempty"`
+	OriginalSize int64   `json:"original_size"`
+	ArchiveSize  int64   `json:"archive_size"`
+	Encrypted    bool    `json:"encrypted"`
+	DurationMS   float64 `json:"duration_ms"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// newRunReport summarises results for a run that started at started.
+func newRunReport(cfg *Config, started time.Time, results []FileResult) *runReport {
+	host, _ := os.Hostname()
+	r := &runReport{
+		RunID:    runID(started, cfg.JobName),
+		Job:      cfg.JobName,
+		Host:     host,
+		Version:  version,
+		Started:  started,
+		Finished: time.Now(),
+		LogDir:   cfg.LogDir,
+		DryRun:   cfg.DryRun,
+		Counts:   make(map[string]int),
+		Files:    make([]reportFile, 0, len(results)),
+		Usage:    cfg.usage.finish(),
+	}
+	for _, res := range results {
+		r.Counts[res.Status]++
+		r.Files = append(r.Files, newReportFile(res))
+	}
+	return r
+}
+
+// newReportFile is the report entry for one file's result.
+func newReportFile(res FileResult) reportFile {
+	f := reportFile{
+		Path:         res.Path,
+		Archive:      res.Archive,
+		Status:       res.Status,
+		Reason:       res.Reason,
+		OriginalSize: res.OriginalSize,
+		ArchiveSize:  res.ArchiveSize,
+		Encrypted:    res.Encrypted,
+		DurationMS:   float64(res.Duration.Microseconds()) / 1000,
+	}
+	if res.Err != nil {
+		f.Error = res.Err.Error()
+	}
+	return f
+}
+
+// runID names a run: its UTC start time (to the microsecond, so names sort by
+// age), the PID, and the daemon job when there is one.
+func runID(started time.Time, job string) string {
+	id := fmt.Sprintf("%s-%d", started.UTC().Format("20060102T150405.000000Z"), os.Getpid())
+	if job != "" {
+		id += "-" + url.PathEscape(job)
+	}
+	return id
+}
+
+// writeRunReport writes report-<runid>.json into dir atomically (temp file,
+// fsync, rename), then deletes all but the newest keep reports.
+func writeRunReport(dir string, keep int, r *runReport) (string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "report-"+r.RunID+".json")
+	tmp := path + ".tmp"
+	if err := writeArchiveFile(tmp, append(data, '\n'), 0640, true); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := syncDir(dir); err != nil {
+		logDebug("Could not fsync report dir %s: %v", dir, err)
+	}
+	pruneReports(dir, keep)
+	return path, nil
+}
+
+// pruneReports removes the oldest report-*.json files in dir beyond keep. Run
+// IDs start with a UTC timestamp, so name order is age order.
+func pruneReports(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var reports []string
+	for _, e := range entries {
+		if name := e.Name(); strings.HasPrefix(name, "report-") && strings.HasSuffix(name, ".json") {
+			reports = append(reports, name)
+		}
+	}
+	sort.Strings(reports)
+	for _, name := range reports[:max(len(reports)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			logError("Could not prune report %s: %v", name, err)
+			continue
+		}
+		logDebug("Pruned old report %s", name)
+	}
+}
+
+// saveRunReport writes the run's report when REPORT_DIR is set. Failing to
+// write it is logged but doesn't fail the rotation that already happened.
+func saveRunReport(cfg *Config, started time.Time, results []FileResult) {
+	if cfg.ReportDir == "" {
+		return
+	}
+	path, err := writeRunReport(cfg.ReportDir, cfg.ReportKeep, newRunReport(cfg, started, results))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing run report: %v\n", err)
+		logError("Error writing run report to %s: %v", cfg.ReportDir, err)
+		return
+	}
+	logInfo("Run report written to %s", path)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRunReport(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{LogDir: "/var/log/apps", JobName: "nginx"}
+	results := []FileResult{
+		{Path: "/var/log/apps/a.log", Archive: "/old/a.log.gz", Status: statusRotated, OriginalSize: 100, ArchiveSize: 20, Duration: 1500 * time.Microsecond},
+		{Path: "/var/log/apps/b.log", Status: statusFailed, Err: errors.New("disk full")},
+	}
+
+	path, err := writeRunReport(dir, 5, newRunReport(cfg, time.Now(), results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "report-") || !strings.HasSuffix(path, "-nginx.json") {
+		t.Errorf("report name = %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	var r runReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if r.Counts[statusRotated] != 1 || r.Counts[statusFailed] != 1 || r.Files[1].Error != "disk full" || r.Files[0].DurationMS != 1.5 {
+		t.Errorf("report = %+v", r)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) != 0 {
+		t.Errorf("temp files left: %v", tmps)
+	}
+}
+
+func TestPruneReports(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 5 {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("report-2024011%dT000000.000000Z-1.json", i)), []byte("{}"), 0640)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0640)
+
+	pruneReports(dir, 2)
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"notes.txt", "report-20240113T000000.000000Z-1.json", "report-20240114T000000.000000Z-1.json"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("left %v, want %v", names, want)
+	}
+}
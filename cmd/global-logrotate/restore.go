@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+)
+
+// ============================================================
+// Restore safety (SKIP_IF_ARCHIVED)
+// ============================================================
+
+// Skip reasons reported by archivedAlready.
+const (
+	skipPredatesArchive  = "predates archive"
+	skipDuplicateArchive = "duplicate of archive"
+)
+
+// errLargerThanSource stops sameContent as soon as an archive turns out to hold
+// more than the source, without decompressing the rest.
+var errLargerThanSource = errors.New("archive content larger than source")
+
+// newestArchive returns the most recent archive of logName under root: latest
+// date suffix first, then latest mtime.
+func newestArchive(root, logName string) (archiveEntry, bool) {
+	var best archiveEntry
+	var bestInfo os.FileInfo
+	for _, a := range scanArchives(root) {
+		if a.logName != logName {
+			continue
+		}
+		info, err := os.Stat(a.path)
+		if err != nil {
+			continue
+		}
+		if bestInfo == nil || a.date.After(best.date) ||
+			(a.date.Equal(best.date) && info.ModTime().After(bestInfo.ModTime())) {
+			best, bestInfo = a, info
+		}
+	}
+	return best, bestInfo != nil
+}
+
+// archivedAlready reports why logFile should not be rotated under
+// SKIP_IF_ARCHIVED, or "" when it should. A file restored from backup either
+// predates the newest archive of its name, or holds exactly what that archive
+// holds; rotating it again would only produce a second copy.
+func archivedAlready(logFile string, info os.FileInfo, root, logName string, cfg *Config) (reason, archive string) {
+	a, ok := newestArchive(root, logName)
+	if !ok {
+		return "", ""
+	}
+	if info.ModTime().Before(a.date) {
+		return skipPredatesArchive, a.path
+	}
+	same, err := sameContent(logFile, info.Size(), a.path, cfg)
+	if err != nil {
+		logDebug("SKIP_IF_ARCHIVED: could not compare %s with %s: %v", logFile, a.path, err)
+		return "", ""
+	}
+	if same {
+		return skipDuplicateArchive, a.path
+	}
+	return "", ""
+}
+
+// sameContent reports whether archive decompresses (and decrypts) to exactly
+// the size bytes in path, comparing SHA-256 digests.
+func sameContent(path string, size int64, archive string, cfg *Config) (bool, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	want := sha256.New()
+	if _, err := io.Copy(want, io.LimitReader(src, size)); err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	got := sha256.New()
+	lw := &limitedWriter{w: got, n: size}
+	if err := streamArchive(lw, archive, f, cfg); err != nil {
+		if errors.Is(err, errLargerThanSource) {
+			return false, nil
+		}
+		return false, err
+	}
+	return lw.written == size && bytes.Equal(got.Sum(nil), want.Sum(nil)), nil
+}
+
+// limitedWriter passes at most n bytes to w and fails with errLargerThanSource
+// once more arrive.
+type limitedWriter struct {
+	w       io.Writer
+	n       int64
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.n {
+		return 0, errLargerThanSource
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSkipIfArchived(t *testing.T) {
+	content := []byte("restored line\n")
+	for _, tt := range []struct {
+		name    string
+		source  []byte
+		mtime   time.Time
+		want    string
+		wantWhy string
+	}{
+		{"predates", []byte("older content\n"), time.Date(2024, 1, 5, 12, 0, 0, 0, time.Local), statusSkipped, skipPredatesArchive},
+		{"duplicate", content, time.Now(), statusSkipped, skipDuplicateArchive},
+		{"new content", []byte("restored line\nand more\n"), time.Now(), statusRotated, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := makeTestCfg(t, dir)
+			cfg.SkipIfArchived = true
+
+			gz, err := compressGzip(bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.MkdirAll(filepath.Join(cfg.OldLogsDir, "20240110"), 0755)
+			os.WriteFile(filepath.Join(cfg.OldLogsDir, "20240110", "app.log.20240110.gz"), gz, 0644)
+
+			logPath := filepath.Join(dir, "app.log")
+			os.WriteFile(logPath, tt.source, 0644)
+			os.Chtimes(logPath, tt.mtime, tt.mtime)
+
+			res := rotateLogFile(logPath, cfg)
+			if res.Status != tt.want || res.Reason != tt.wantWhy {
+				t.Fatalf("got %s (%q), want %s (%q), err=%v", res.Status, res.Reason, tt.want, tt.wantWhy, res.Err)
+			}
+			if data, _ := os.ReadFile(logPath); tt.want == statusSkipped && string(data) != string(tt.source) {
+				t.Errorf("skipped source changed: %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Name rules — "glob:value" lists keyed on the original log name
+// ============================================================
+
+// nameRule maps a glob on a log's base name to a policy value.
+type nameRule struct {
+	pattern string
+	value   string
+}
+
+// parseNameRules parses a comma-separated list of "glob:value" pairs, e.g.
+// "audit*.log:365d, debug*.log:7d". Order is preserved; the first match wins.
+func parseNameRules(s string) ([]nameRule, error) {
+	var rules []nameRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(part, ":")
+		pattern, value = strings.TrimSpace(pattern), strings.TrimSpace(value)
+		if !ok || pattern == "" || value == "" {
+			return nil, fmt.Errorf("invalid rule %q (want glob:value)", part)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid rule glob %q: %w", pattern, err)
+		}
+		rules = append(rules, nameRule{pattern: pattern, value: value})
+	}
+	return rules, nil
+}
+
+// matchNameRule returns the value of the first rule whose glob matches name.
+func matchNameRule(rules []nameRule, name string) (string, bool) {
+	for _, r := range rules {
+		if ok, _ := filepath.Match(r.pattern, name); ok {
+			return r.value, true
+		}
+	}
+	return "", false
+}
+
+// ============================================================
+// Archive name parsing
+// ============================================================
+
+// parseArchiveName splits an archive file name such as
+// "app.log.20240115.gz.enc" (or ".gz.gpg", or "app.log.20240115" when stored
+// uncompressed) into the original log name ("app.log") and its date
+// suffix ("20240115"). A repeat rotation's number stays with the date, so
+// "app.log.20240115.2.gz" gives "20240115.2". Parts of a split archive
+// (".part002") parse as the archive they belong to. Names escaped by
+// archiveBaseName are unescaped, so a nested source comes back as
+// "nginx/access.log".
+func parseArchiveName(name string) (logName, dateSuffix string, ok bool) {
+	name, _ = splitArchiveOf(name)
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".enc"), ".gpg")
+	c, compressed := codecForPath(base)
+	base = strings.TrimSuffix(base, c.suffix())
+	idx := strings.LastIndex(base, ".")
+	if idx <= 0 || idx == len(base)-1 {
+		return "", "", false
+	}
+	logName, dateSuffix = base[:idx], base[idx+1:]
+	if isRotationSeq(dateSuffix) {
+		if j := strings.LastIndex(logName, "."); j > 0 && isDateSuffix(logName[j+1:]) {
+			logName, dateSuffix = logName[:j], logName[j+1:]+"."+dateSuffix
+		}
+	}
+	// Stored (COMPRESS=none) archives have no codec extension; only a real
+	// date suffix tells them from any other file.
+	if !compressed && !isDateSuffix(dateSuffix) {
+		return "", "", false
+	}
+	if unescaped, err := url.PathUnescape(logName); err == nil {
+		logName = unescaped
+	}
+	return logName, dateSuffix, true
+}
+
+// isDateSuffix reports whether s starts with a YYYYMMDD date, as both
+// DATE_FORMAT styles do.
+func isDateSuffix(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+	_, err := time.Parse("20060102", s[:8])
+	return err == nil
+}
+
+// isRotationSeq reports whether s is the number INCREMENT_SUFFIX gives a
+// repeat rotation on the same day: 1 to 4 digits, without a leading zero.
+func isRotationSeq(s string) bool {
+	if len(s) == 0 || len(s) > 4 || s[0] == '0' {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// rotationSeq returns the repeat number in a date suffix from
+// parseArchiveName, 0 for the day's first rotation.
+func rotationSeq(dateSuffix string) int {
+	i := strings.LastIndex(dateSuffix, ".")
+	if i < 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(dateSuffix[i+1:])
+	return n
+}
+
+// archiveTime returns when an archive was rotated: the date embedded in its
+// suffix when it parses, otherwise the file's mtime.
+func archiveTime(dateSuffix string, info os.FileInfo) time.Time {
+	if len(dateSuffix) >= 8 {
+		if t, err := time.ParseInLocation("20060102", dateSuffix[:8], time.Local); err == nil {
+			return t
+		}
+	}
+	return info.ModTime()
+}
+
+// ============================================================
+// Retention
+// ============================================================
+
+// archiveEntry is an archive found under a backup root.
+type archiveEntry struct {
+	path    string
+	logName string
+	date    time.Time
+	seq     int // repeat rotation on that date (INCREMENT_SUFFIX), 0 for the first
+	size    int64
+}
+
+// backupRoots returns the directories archives for cfg are written to: every
+// ROUTE_RULES target, plus the configured OLD_LOGS_DIR or, without one, every
+// old_logs directory under LogDir.
+func backupRoots(cfg *Config) []string {
+	var roots []string
+	if rules, err := parseNameRules(cfg.RouteRules); err == nil {
+		for _, r := range rules {
+			if !slices.Contains(roots, r.value) {
+				roots = append(roots, r.value)
+			}
+		}
+	}
+	if cfg.OldLogsDir != "" {
+		if !slices.Contains(roots, cfg.OldLogsDir) {
+			roots = append(roots, cfg.OldLogsDir)
+		}
+		return roots
+	}
+	filepath.WalkDir(cfg.LogDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == "old_logs" {
+			roots = append(roots, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return roots
+}
+
+// scanArchives lists every recognisable archive under root. Archives whose names
+// were shortened are reported under their original log name.
+func scanArchives(root string) []archiveEntry {
+	var entries []archiveEntry
+	shortened := archiveNameIndex(root)
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			logDebug("Retention: skipping inaccessible path %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		logName, dateSuffix, ok := parseArchiveName(d.Name())
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if original, ok := shortened[logName]; ok {
+			logName = original
+		}
+		entries = append(entries, archiveEntry{
+			path:    path,
+			logName: logName,
+			date:    archiveTime(dateSuffix, info),
+			seq:     rotationSeq(dateSuffix),
+			size:    info.Size(),
+		})
+		return nil
+	})
+	return entries
+}
+
+// retentionFor returns how long archives of logName are kept under rules.
+// keep is false when no rule applies or the matching rule says to keep forever.
+func retentionFor(rules []nameRule, logName string) (maxAge time.Duration, keep bool, err error) {
+	value, ok := matchNameRule(rules, filepath.Base(logName))
+	if !ok {
+		return 0, false, nil
+	}
+	switch strings.ToLower(value) {
+	case "forever", "keep", "0":
+		return 0, false, nil
+	}
+	d, err := parseInterval(value)
+	if err != nil {
+		return 0, false, err
+	}
+	return d, true, nil
+}
+
+// excessArchives returns the archives in entries beyond the n newest of each
+// log, by the date in their names. The parts of a split archive count as one
+// archive and are returned together.
+func excessArchives(entries []archiveEntry, n int) []archiveEntry {
+	if n <= 0 {
+		return nil
+	}
+	type rotation struct {
+		key   string // the archive's name without any part suffix
+		date  time.Time
+		seq   int
+		parts []archiveEntry
+	}
+	byLog := make(map[string]map[string]*rotation)
+	for _, a := range entries {
+		key, _ := splitArchiveOf(a.path)
+		if byLog[a.logName] == nil {
+			byLog[a.logName] = make(map[string]*rotation)
+		}
+		r := byLog[a.logName][key]
+		if r == nil {
+			r = &rotation{key: key, date: a.date, seq: a.seq}
+			byLog[a.logName][key] = r
+		}
+		r.parts = append(r.parts, a)
+	}
+
+	var excess []archiveEntry
+	for _, rotations := range byLog {
+		if len(rotations) <= n {
+			continue
+		}
+		list := make([]*rotation, 0, len(rotations))
+		for _, r := range rotations {
+			list = append(list, r)
+		}
+		// Newest first; same-day archives by their number, then (-H names)
+		// by their full name.
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].date.Equal(list[j].date) {
+				return list[i].date.After(list[j].date)
+			}
+			if list[i].seq != list[j].seq {
+				return list[i].seq > list[j].seq
+			}
+			return list[i].key > list[j].key
+		})
+		for _, r := range list[n:] {
+			excess = append(excess, r.parts...)
+		}
+	}
+	sort.Slice(excess, func(i, j int) bool { return excess[i].path < excess[j].path })
+	return excess
+}
+
+// expiredArchive is an archive retention is about to delete, and why.
+type expiredArchive struct {
+	archiveEntry
+	root   string
+	reason string
+}
+
+// treeSize is the total size of the regular files under root.
+func treeSize(root string) int64 {
+	var total int64
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// overSizeCap returns the oldest of archives, by the date in their names, that
+// must go to bring total down to limit. A split archive goes as a whole.
+func overSizeCap(archives []expiredArchive, total, limit int64) []expiredArchive {
+	sorted := slices.Clone(archives)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].date.Equal(sorted[j].date) {
+			return sorted[i].date.Before(sorted[j].date)
+		}
+		return sorted[i].path < sorted[j].path // keeps the parts of a split archive together
+	})
+	var evict []expiredArchive
+	for i := 0; i < len(sorted) && total > limit; {
+		key, _ := splitArchiveOf(sorted[i].path)
+		for ; i < len(sorted); i++ {
+			if k, _ := splitArchiveOf(sorted[i].path); k != key {
+				break
+			}
+			evict = append(evict, sorted[i])
+			total -= sorted[i].size
+		}
+	}
+	return evict
+}
+
+// applyRetention deletes archives that have outlived their retention policy.
+// RETENTION_RULES maps name globs to ages ("audit*.log:365d, debug*.log:7d");
+// the first matching rule wins. Archives no rule matches are kept, or with
+// RETENTION_DAYS deleted once older than that many days. MAX_ARCHIVES then
+// keeps only that many of the remaining archives of each log, and
+// MAX_TOTAL_SIZE deletes the oldest archives left until everything under the
+// backup roots fits in it. Emptied dated directories are removed as well. Honors dry-run; deleting CONFIRM_THRESHOLD
+// or more archives needs confirmation (see confirmBatch).
+func applyRetention(cfg *Config) {
+	if cfg.RetentionRules == "" && cfg.RetentionDays <= 0 && cfg.MaxArchives <= 0 && cfg.MaxTotalSize == "" {
+		return
+	}
+	rules, err := parseNameRules(cfg.RetentionRules)
+	if err != nil {
+		logError("Retention disabled: RETENTION_RULES: %v", err)
+		return
+	}
+	if cfg.RetentionDays > 0 {
+		rules = append(rules, nameRule{pattern: "*", value: fmt.Sprintf("%dd", cfg.RetentionDays)})
+	}
+	var maxTotal int64
+	if cfg.MaxTotalSize != "" {
+		if maxTotal, err = parseSize(cfg.MaxTotalSize); err != nil {
+			logError("Retention disabled: MAX_TOTAL_SIZE: %v", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	var expired []expiredArchive
+	var expiredSize int64
+	var survivors []expiredArchive // what the age and count limits keep
+	var footprint int64
+	for _, root := range backupRoots(cfg) {
+		if maxTotal > 0 {
+			footprint += treeSize(root)
+		}
+		var kept []archiveEntry
+		for _, a := range scanArchives(root) {
+			maxAge, ok, err := retentionFor(rules, a.logName)
+			if err != nil {
+				logError("Retention rule for %s: %v", a.logName, err)
+			}
+			if err != nil || !ok || !a.date.Before(now.Add(-maxAge)) {
+				kept = append(kept, a)
+				continue
+			}
+			expired = append(expired, expiredArchive{a, root, fmt.Sprintf("older than %s", maxAge)})
+			expiredSize += a.size
+		}
+		excess := make(map[string]bool)
+		for _, a := range excessArchives(kept, cfg.MaxArchives) {
+			expired = append(expired, expiredArchive{a, root, fmt.Sprintf("beyond the %d newest of %s", cfg.MaxArchives, a.logName)})
+			expiredSize += a.size
+			excess[a.path] = true
+		}
+		for _, a := range kept {
+			if !excess[a.path] {
+				survivors = append(survivors, expiredArchive{a, root, ""})
+			}
+		}
+	}
+	if maxTotal > 0 && footprint-expiredSize > maxTotal {
+		logInfo("Retention: backup roots hold %s after age and count limits, over MAX_TOTAL_SIZE %s; evicting oldest archives",
+			formatSize(footprint-expiredSize), formatSize(maxTotal))
+		for _, a := range overSizeCap(survivors, footprint-expiredSize, maxTotal) {
+			a.reason = fmt.Sprintf("backup roots over MAX_TOTAL_SIZE %s", formatSize(maxTotal))
+			expired = append(expired, a)
+			expiredSize += a.size
+		}
+	}
+	if !confirmBatch(cfg, "Retention will delete", len(expired), expiredSize) {
+		return
+	}
+
+	var removed int
+	var freed int64
+	dirs := make(map[string]map[string]bool)
+	for _, a := range expired {
+		if cfg.DryRun {
+			plan(planDelete, "Would delete (%s): %s", a.reason, a.path)
+			continue
+		}
+		if err := os.Remove(a.path); err != nil {
+			logError("Retention: could not delete %s: %v", a.path, err)
+			continue
+		}
+		if archive, _ := splitArchiveOf(a.path); !isLaterPart(a.path) {
+			os.Remove(checksumSidecar(archive)) // CHECKSUM's sidecar goes with it
+		}
+		logInfo("Retention: deleted %s (%s)", a.path, a.reason)
+		removed++
+		freed += a.size
+		if dirs[a.root] == nil {
+			dirs[a.root] = make(map[string]bool)
+		}
+		dirs[a.root][filepath.Dir(a.path)] = true
+	}
+	for root, d := range dirs {
+		removeEmptyDirs(root, d)
+	}
+	if cfg.DryRun && len(expired) > 0 {
+		fmt.Printf("[DRY-RUN] Retention would delete %d archive(s), freeing %s\n", len(expired), formatSize(expiredSize))
+	}
+	if removed > 0 {
+		fmt.Printf("%s: Retention removed %d archive(s), freed %s\n", timestamp(), removed, formatSize(freed))
+		logInfo("Retention removed %d archive(s), freed %s", removed, formatSize(freed))
+	}
+}
+
+// removeEmptyDirs removes each of dirs (and then its parents, up to but not
+// including root) once they no longer contain anything.
+func removeEmptyDirs(root string, dirs map[string]bool) {
+	paths := make([]string, 0, len(dirs))
+	for d := range dirs {
+		paths = append(paths, d)
+	}
+	// Deepest first so a parent is only considered after its children.
+	sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	root = filepath.Clean(root)
+	for _, d := range paths {
+		for d = filepath.Clean(d); d != root && strings.HasPrefix(d, root); d = filepath.Dir(d) {
+			if err := os.Remove(d); err != nil {
+				break // not empty (or already gone)
+			}
+			logDebug("Retention: removed empty directory %s", d)
+		}
+	}
+}
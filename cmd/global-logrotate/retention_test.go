@@ -0,0 +1,233 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNameRules(t *testing.T) {
+	rules, err := parseNameRules("audit*.log:365d, debug*.log : 7d,,*:30d")
+	if err != nil {
+		t.Fatalf("parseNameRules: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(rules))
+	}
+	if rules[1].pattern != "debug*.log" || rules[1].value != "7d" {
+		t.Errorf("rule[1] = %+v", rules[1])
+	}
+	for _, bad := range []string{"nocolon", ":7d", "*.log:", "[:7d"} {
+		if _, err := parseNameRules(bad); err == nil {
+			t.Errorf("parseNameRules(%q) expected error", bad)
+		}
+	}
+}
+
+func TestMatchNameRuleFirstWins(t *testing.T) {
+	rules, _ := parseNameRules("audit*.log:365d, *.log:7d")
+	if v, _ := matchNameRule(rules, "audit.log"); v != "365d" {
+		t.Errorf("audit.log -> %q, want 365d", v)
+	}
+	if v, _ := matchNameRule(rules, "app.log"); v != "7d" {
+		t.Errorf("app.log -> %q, want 7d", v)
+	}
+	if _, ok := matchNameRule(rules, "app.txt"); ok {
+		t.Error("app.txt should not match")
+	}
+}
+
+func TestParseArchiveName(t *testing.T) {
+	tests := []struct {
+		in, name, date string
+		ok             bool
+	}{
+		{"app.log.20240115.gz", "app.log", "20240115", true},
+		{"app.log.20240115.xz.enc", "app.log", "20240115", true},
+		{"app.log.20240115.gz.gpg", "app.log", "20240115", true},
+		{"app.log.20240115T10:30:00.gz", "app.log", "20240115T10:30:00", true},
+		{"nginx%2Faccess.log.20240115.gz", "nginx/access.log", "20240115", true},
+		{"app.log.20240115.2.gz", "app.log", "20240115.2", true},
+		{"app.log.20240115.12.gz.enc.part002", "app.log", "20240115.12", true},
+		{"app.log.1.20240115.gz", "app.log.1", "20240115", true},
+		{"app.log", "", "", false},
+		{"notes.txt.enc", "", "", false},
+	}
+	for _, tt := range tests {
+		name, date, ok := parseArchiveName(tt.in)
+		if ok != tt.ok || name != tt.name || date != tt.date {
+			t.Errorf("parseArchiveName(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tt.in, name, date, ok, tt.name, tt.date, tt.ok)
+		}
+	}
+}
+
+// writeArchive creates an empty archive file named for logName rotated on day.
+func writeArchive(t *testing.T, root, logName string, day time.Time) string {
+	t.Helper()
+	date := day.Format("20060102")
+	dir := filepath.Join(root, date)
+	os.MkdirAll(dir, 0755)
+	path := filepath.Join(dir, logName+"."+date+".gz")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyRetentionRules(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	now := time.Now()
+	oldAudit := writeArchive(t, root, "audit.log", now.AddDate(0, 0, -30))
+	oldDebug := writeArchive(t, root, "debug.log", now.AddDate(0, 0, -30))
+	newDebug := writeArchive(t, root, "debug.log", now.AddDate(0, 0, -1))
+	oldOther := writeArchive(t, root, "other.log", now.AddDate(0, 0, -400))
+
+	cfg := makeTestCfg(t, dir)
+	cfg.RetentionRules = "audit*.log:365d, debug*.log:7d"
+	applyRetention(cfg)
+
+	for _, keep := range []string{oldAudit, newDebug, oldOther} {
+		if _, err := os.Stat(keep); err != nil {
+			t.Errorf("%s should be kept: %v", keep, err)
+		}
+	}
+	if _, err := os.Stat(oldDebug); !os.IsNotExist(err) {
+		t.Errorf("%s should have been deleted", oldDebug)
+	}
+}
+
+func TestApplyRetentionRemovesEmptyDatedDir(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	expired := writeArchive(t, root, "app.log", time.Now().AddDate(0, 0, -10))
+
+	cfg := makeTestCfg(t, dir)
+	cfg.RetentionRules = "*:7d"
+	applyRetention(cfg)
+
+	if _, err := os.Stat(filepath.Dir(expired)); !os.IsNotExist(err) {
+		t.Error("emptied dated directory should be removed")
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Error("backup root itself must be kept")
+	}
+}
+
+func TestApplyRetentionDryRun(t *testing.T) {
+	dir := t.TempDir()
+	expired := writeArchive(t, filepath.Join(dir, "old"), "app.log", time.Now().AddDate(0, 0, -10))
+
+	cfg := makeTestCfg(t, dir)
+	cfg.RetentionRules = "*:7d"
+	cfg.DryRun = true
+	applyRetention(cfg)
+
+	if _, err := os.Stat(expired); err != nil {
+		t.Error("dry-run must not delete archives")
+	}
+}
+
+func TestApplyRetentionDays(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	now := time.Now()
+	oldApp := writeArchive(t, root, "app.log", now.AddDate(0, 0, -40))
+	newApp := writeArchive(t, root, "app.log", now.AddDate(0, 0, -5))
+	oldAudit := writeArchive(t, root, "audit.log", now.AddDate(0, 0, -41))
+
+	cfg := makeTestCfg(t, dir)
+	cfg.RetentionDays = 0
+	applyRetention(cfg)
+	if _, err := os.Stat(oldApp); err != nil {
+		t.Fatal("RETENTION_DAYS=0 must keep everything")
+	}
+
+	cfg.RetentionDays = 30
+	cfg.RetentionRules = "audit*.log:forever"
+	applyRetention(cfg)
+	for _, keep := range []string{newApp, oldAudit} {
+		if _, err := os.Stat(keep); err != nil {
+			t.Errorf("%s should be kept: %v", keep, err)
+		}
+	}
+	if _, err := os.Stat(oldApp); !os.IsNotExist(err) {
+		t.Errorf("%s is past RETENTION_DAYS and should be deleted", oldApp)
+	}
+	if _, err := os.Stat(filepath.Dir(oldApp)); !os.IsNotExist(err) {
+		t.Error("its emptied dated directory should be removed")
+	}
+}
+
+func TestApplyRetentionMaxArchives(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	now := time.Now()
+	var app []string
+	for i := range 4 {
+		app = append(app, writeArchive(t, root, "app.log", now.AddDate(0, 0, -i)))
+	}
+	other := writeArchive(t, root, "other.log", now.AddDate(0, 0, -100))
+	// A split archive, the oldest of app.log, counts as one and goes as a whole.
+	oldSplit := filepath.Join(root, "20200101", "app.log.20200101.gz")
+	os.MkdirAll(filepath.Dir(oldSplit), 0755)
+	for i := 1; i <= 2; i++ {
+		os.WriteFile(partPath(oldSplit, i), []byte("x"), 0644)
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.MaxArchives = 2
+	cfg.DryRun = true
+	captureStdout(t, func() { applyRetention(cfg) })
+	if _, err := os.Stat(app[3]); err != nil {
+		t.Fatal("dry-run must not delete archives")
+	}
+
+	cfg.DryRun = false
+	applyRetention(cfg)
+	for _, keep := range []string{app[0], app[1], other} {
+		if _, err := os.Stat(keep); err != nil {
+			t.Errorf("%s should be kept: %v", keep, err)
+		}
+	}
+	for _, gone := range []string{app[2], app[3], partPath(oldSplit, 1), partPath(oldSplit, 2)} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("%s is beyond the 2 newest and should be deleted", gone)
+		}
+	}
+}
+
+func TestApplyRetentionMaxTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "old")
+	now := time.Now()
+	var archives []string
+	for i := range 4 { // newest first, 1 KB each
+		p := writeArchive(t, root, "app.log", now.AddDate(0, 0, -i))
+		os.WriteFile(p, make([]byte, 1024), 0644)
+		archives = append(archives, p)
+	}
+
+	cfg := makeTestCfg(t, dir)
+	cfg.MaxTotalSize = "2.5K"
+	cfg.DryRun = true
+	out := captureStdout(t, func() { applyRetention(cfg) })
+	if !strings.Contains(out, "would delete 2 archive(s), freeing 2.00 KB") {
+		t.Errorf("dry-run summary: %q", out)
+	}
+	if _, err := os.Stat(archives[3]); err != nil {
+		t.Fatal("dry-run must not delete archives")
+	}
+
+	cfg.DryRun = false
+	applyRetention(cfg)
+	for i, p := range archives {
+		_, err := os.Stat(p)
+		if kept := err == nil; kept != (i < 2) {
+			t.Errorf("archive %d days old: kept=%v", i, kept)
+		}
+	}
+}
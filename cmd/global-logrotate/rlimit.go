@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ============================================================
+// Open-file limit (RLIMIT_NOFILE)
+// ============================================================
+
+const (
+	defaultFDFraction = 0.5
+
+	// fdReserve is kept back for descriptors that don't scale with the worker
+	// count: stdio, our log, the state file, sockets, gpg pipes.
+	fdReserve = 32
+
+	// fdsPerFile is what one file in flight can hold at once, e.g. the source
+	// and the archive being copied out of STAGING_DIR.
+	fdsPerFile = 2
+)
+
+// fitPoolsToLimit shrinks the IO and CPU pools, which between them admit
+// ioN+cpuN files at once, until those files need no more than fraction of
+// limit descriptors. Both pools shrink in proportion and keep at least one
+// slot. limit 0 means no limit.
+func fitPoolsToLimit(ioN, cpuN int, limit uint64, fraction float64) (int, int, bool) {
+	if limit == 0 {
+		return ioN, cpuN, false
+	}
+	admit := max((int(float64(limit)*fraction)-fdReserve)/fdsPerFile, 2)
+	if ioN+cpuN <= admit {
+		return ioN, cpuN, false
+	}
+	newIO := max(ioN*admit/(ioN+cpuN), 1)
+	newCPU := max(admit-newIO, 1)
+	return newIO, newCPU, true
+}
+
+// fdSafePoolSizes is poolSizes clamped to FD_SAFETY_FRACTION of the open-file
+// limit, warning when the configured parallelism had to come down.
+func fdSafePoolSizes(cfg *Config) (ioN, cpuN int) {
+	ioN, cpuN = poolSizes(cfg)
+	fraction := cfg.FDFraction
+	if fraction <= 0 {
+		fraction = defaultFDFraction
+	}
+	limit := openFileLimit()
+	newIO, newCPU, clamped := fitPoolsToLimit(ioN, cpuN, limit, fraction)
+	if clamped {
+		fmt.Fprintf(os.Stderr, "Warning: open-file limit %d is too low for %d IO + %d CPU workers; using %d + %d (raise ulimit -n or FD_SAFETY_FRACTION)\n",
+			limit, ioN, cpuN, newIO, newCPU)
+		logInfo("RLIMIT_NOFILE=%d with FD_SAFETY_FRACTION=%.2f: workers reduced from %d IO/%d CPU to %d/%d",
+			limit, fraction, ioN, cpuN, newIO, newCPU)
+	}
+	return newIO, newCPU
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestFitPoolsToLimit(t *testing.T) {
+	for _, tt := range []struct {
+		ioN, cpuN       int
+		limit           uint64
+		fraction        float64
+		wantIO, wantCPU int
+		clamped         bool
+	}{
+		{4, 4, 0, 0.5, 4, 4, false},
+		{4, 4, 1024, 0.5, 4, 4, false},
+		{64, 64, 1024, 0.5, 64, 64, false},
+		{64, 64, 256, 0.5, 24, 24, true},
+		{8, 24, 256, 0.5, 8, 24, false},
+		{16, 48, 256, 0.5, 12, 36, true},
+		{16, 16, 64, 0.5, 1, 1, true},
+		{16, 16, 256, 1, 16, 16, false},
+	} {
+		io, cpu, clamped := fitPoolsToLimit(tt.ioN, tt.cpuN, tt.limit, tt.fraction)
+		if io != tt.wantIO || cpu != tt.wantCPU || clamped != tt.clamped {
+			t.Errorf("fitPoolsToLimit(%d, %d, %d, %g) = %d, %d, %v", tt.ioN, tt.cpuN, tt.limit, tt.fraction, io, cpu, clamped)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roundTripSizes straddles the interesting boundaries: empty input, the 16-byte
+// GCM tag, and buffers larger than the codecs' internal blocks.
+var roundTripSizes = []int{0, 1, 15, 16, 17, 4096, 1 << 20}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for name, c := range codecs {
+		for _, n := range roundTripSizes {
+			t.Run(fmt.Sprintf("%s/%d", name, n), func(t *testing.T) {
+				data := randomBytes(t, n)
+				packed, err := compressWith(c, bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := decompressWith(c, packed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("round trip changed %d bytes of data", n)
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, n := range roundTripSizes {
+		data := randomBytes(t, n)
+		sealed, err := encryptData(data, "round-trip", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := chunkedSize(int64(n)); int64(len(sealed)) != want {
+			t.Errorf("%d bytes sealed to %d, want %d", n, len(sealed), want)
+		}
+		got, err := decryptData(sealed, "round-trip")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: round trip changed the data", n)
+		}
+	}
+}
+
+func TestDecryptRejectsBadInput(t *testing.T) {
+	sealed, _ := encryptData([]byte("secret log line\n"), "right", nil)
+
+	if _, err := decryptData(sealed, "wrong"); err == nil {
+		t.Error("wrong password decrypted")
+	}
+	if _, err := decryptData(sealed[:chunkedSize(0)-1], "right"); err == nil || !strings.Contains(err.Error(), "too short") {
+		t.Errorf("truncated header: err = %v", err)
+	}
+	if _, err := decryptData(sealed[:len(sealed)-1], "right"); err == nil {
+		t.Error("truncated tag accepted")
+	}
+
+	badMagic := bytes.Clone(sealed)
+	copy(badMagic, "XXXX")
+	if _, err := decryptData(badMagic, "right"); err == nil || !strings.Contains(err.Error(), "magic") {
+		t.Errorf("bad magic: err = %v", err)
+	}
+	for _, off := range []int{len(encryptMagic), len(encryptMagic) + saltSize, len(sealed) - 1} {
+		corrupt := bytes.Clone(sealed)
+		corrupt[off] ^= 0xff
+		if _, err := decryptData(corrupt, "right"); err == nil {
+			t.Errorf("corruption at byte %d went unnoticed", off)
+		}
+	}
+}
+
+// TestStreamLogFileFormats reads one file per branch of streamLogFile: plain,
+// every codec on its own, and each codec under both encryption backends.
+func TestStreamLogFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("every format\n", 500))
+	bin, _ := writeGPGStub(t)
+	cfg := &Config{EncryptPassword: "formats", GPGBinary: bin}
+
+	files := map[string][]byte{"app.log": content}
+	sealedPlain, _ := encryptData(content, "formats", nil)
+	files["app.log.20240115.enc"] = sealedPlain
+	for _, c := range codecs {
+		packed, err := compressWith(c, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, _ := encryptData(packed, "formats", nil)
+		files["app.log.20240115."+c.ext] = packed
+		files["app.log.20240115."+c.ext+".enc"] = sealed
+		files["app.log.20240115."+c.ext+".gpg"] = append([]byte("GPGSTUB:"), packed...)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		var out bytes.Buffer
+		if err := streamLogFile(&out, path, cfg); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("%s: content mismatch", name)
+		}
+	}
+}
+
+func TestStreamLogFileConcatenatedGzip(t *testing.T) {
+	// Tools like `cat a.gz b.gz` (and some appenders) produce multi-member gzip.
+	first, _ := compressGzip(strings.NewReader("first member\n"))
+	second, _ := compressGzip(strings.NewReader("second member\n"))
+	path := filepath.Join(t.TempDir(), "app.log.20240115.gz")
+	os.WriteFile(path, append(first, second...), 0600)
+
+	var out bytes.Buffer
+	if err := streamLogFile(&out, path, &Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "first member\nsecond member\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+func TestStreamLogFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LOGROTATE_PASSWORD", "")
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		return path
+	}
+	sealed, _ := encryptData([]byte("x"), "pw", nil)
+
+	for name, tc := range map[string]struct {
+		path string
+		cfg  *Config
+	}{
+		"missing":      {filepath.Join(dir, "nope.log"), &Config{}},
+		"no password":  {write("a.log.20240115.gz.enc", sealed), &Config{EncryptPassHash: "unmatched"}},
+		"bad password": {write("b.log.20240115.gz.enc", sealed), &Config{EncryptPassword: "other"}},
+		"not gzip":     {write("c.log.20240115.gz", []byte("plain text, not gzip")), &Config{}},
+		"not xz":       {write("d.log.20240115.xz", []byte("plain text, not xz")), &Config{}},
+	} {
+		if err := streamLogFile(&bytes.Buffer{}, tc.path, tc.cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// ============================================================
+// Rotating our own log (LOG_MAX_SIZE_MB, LOG_BACKUPS, LOG_COMPRESS)
+// ============================================================
+
+const defaultLogBackups = 5
+
+// logRotation is how the Logger rotates its own file: past maxSize bytes the
+// file becomes .1, .1 becomes .2 and so on, keeping backups of them. With
+// compress, backups from .2 on are gzipped; .1 stays plain so anyone tailing
+// the log across a rotation can still read it.
+type logRotation struct {
+	maxSize  int64
+	backups  int
+	compress bool
+}
+
+// setLogRotation turns on self-rotation for the global logger. maxMB <= 0
+// leaves it off.
+func setLogRotation(maxMB, backups int, compress bool) {
+	if logger == nil || maxMB <= 0 {
+		return
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.rotation = logRotation{maxSize: int64(maxMB) * 1024 * 1024, backups: max(backups, 1), compress: compress}
+	if info, err := logger.file.Stat(); err == nil {
+		logger.size = info.Size()
+	}
+}
+
+// backupPath is the name of the n-th backup of the log.
+func (l *Logger) backupPath(n int) string {
+	path := fmt.Sprintf("%s.%d", l.filePath, n)
+	if l.rotation.compress && n > 1 {
+		path += ".gz"
+	}
+	return path
+}
+
+// rotateSelf shifts the backups along and starts a new log file. The caller
+// holds l.mu. Failures go to stderr, since the log itself is what broke.
+func (l *Logger) rotateSelf() {
+	r := l.rotation
+	os.Remove(l.backupPath(r.backups))
+	for n := r.backups - 1; n >= 1; n-- {
+		from, to := l.backupPath(n), l.backupPath(n+1)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if n == 1 && r.compress {
+			if err := gzipFile(from, to); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not compress %s: %v\n", from, err)
+			}
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not rotate %s: %v\n", from, err)
+		}
+	}
+
+	l.file.Close()
+	if err := os.Rename(l.filePath, l.backupPath(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not rotate %s: %v\n", l.filePath, err)
+	}
+	file, err := os.OpenFile(l.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not reopen %s: %v\n", l.filePath, err)
+		file = os.Stderr
+	}
+	l.file = file
+	l.size = 0
+}
+
+// gzipFile writes a gzipped copy of src to dst and removes src. dst appears
+// only once complete.
+func gzipFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	gz, err := compressGzip(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := writeArchiveFile(tmp, gz, 0644, false); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRotatesItself(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "global-logrotate.log")
+		if err := initLogger(path, LogLevelInfo); err != nil {
+			t.Fatal(err)
+		}
+		setLogRotation(1, 3, compress)
+		logger.rotation.maxSize = 1000 // well under the 1 MB minimum LOG_MAX_SIZE_MB allows
+
+		line := strings.Repeat("x", 90)
+		for range 60 {
+			logInfo("%s", line)
+		}
+		closeLogger()
+		logger = nil
+
+		matches, _ := filepath.Glob(path + "*")
+		if len(matches) != 4 {
+			t.Errorf("compress=%v: files = %v, want the log and 3 backups", compress, matches)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() >= 1000 {
+			t.Errorf("compress=%v: active log = %v, %v", compress, info, err)
+		}
+		if data, err := os.ReadFile(path + ".1"); err != nil || !bytes.Contains(data, []byte(line)) {
+			t.Errorf("compress=%v: .1 should be plain text: %v", compress, err)
+		}
+		second := path + ".2"
+		if compress {
+			second += ".gz"
+		}
+		data, err := os.ReadFile(second)
+		if err != nil {
+			t.Fatalf("compress=%v: %v", compress, err)
+		}
+		if isGzip := bytes.HasPrefix(data, []byte{0x1f, 0x8b}); isGzip != compress {
+			t.Errorf("compress=%v: %s gzipped = %v", compress, second, isGzip)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Self-test (--self-test)
+// ============================================================
+
+// selfTestSize is how much data the self-test pushes through the pipeline.
+const selfTestSize = 1 << 20
+
+// selfTestStep is one check of --self-test. run returns a detail for the
+// report, or why the check failed.
+type selfTestStep struct {
+	name string
+	run  func() (string, error)
+}
+
+// selfTestSteps checks that the KDF configured for new archives (ENCRYPT_KDF,
+// ENCRYPT_ITERATIONS) is usable and that data survives compressGzip,
+// encryptData, decryptData and decompressGzip under a throwaway password, and
+// that tampering is caught.
+func selfTestSteps() []selfTestStep {
+	var payload, compressed, sealed []byte
+	password := generateRandomPassword(24)
+	return []selfTestStep{
+		{"KDF " + encryptKDF.String(), func() (string, error) {
+			p, err := parseKDF(encodeKDF(encryptKDF))
+			if err != nil {
+				return "", fmt.Errorf("archives written with it could not be read: %w", err)
+			}
+			if p != encryptKDF {
+				return "", fmt.Errorf("header records %v", p)
+			}
+			salt := make([]byte, saltSize)
+			if _, err := rand.Read(salt); err != nil {
+				return "", err
+			}
+			if len(deriveKey(password, salt, p)) != keySize {
+				return "", fmt.Errorf("derived key is not %d bytes", keySize)
+			}
+			return "one key derived", nil
+		}},
+		{"gzip compress", func() (string, error) {
+			// Half random, half repeated text: both ends of what logs hold.
+			payload = make([]byte, selfTestSize/2, selfTestSize)
+			if _, err := rand.Read(payload); err != nil {
+				return "", err
+			}
+			line := "2024-01-15 12:00:00 INFO self-test line\n"
+			payload = append(payload, strings.Repeat(line, selfTestSize/2/len(line)+1)...)[:selfTestSize]
+			var err error
+			compressed, err = compressGzip(bytes.NewReader(payload))
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s -> %s", formatSize(int64(len(payload))), formatSize(int64(len(compressed)))), nil
+		}},
+		{"encrypt", func() (string, error) {
+			var err error
+			sealed, err = encryptData(compressed, password, nil)
+			if err != nil {
+				return "", err
+			}
+			return formatSize(int64(len(sealed))), nil
+		}},
+		{"decrypt and decompress", func() (string, error) {
+			opened, err := decryptData(sealed, password)
+			if err != nil {
+				return "", err
+			}
+			plain, err := decompressGzip(opened)
+			if err != nil {
+				return "", err
+			}
+			if !bytes.Equal(plain, payload) {
+				return "", fmt.Errorf("round trip changed the data")
+			}
+			return "bytes match", nil
+		}},
+		{"tampering detected", func() (string, error) {
+			tampered := bytes.Clone(sealed)
+			tampered[len(tampered)-1] ^= 1
+			if _, err := decryptData(tampered, password); err == nil {
+				return "", fmt.Errorf("a modified archive decrypted")
+			}
+			if _, err := decryptData(sealed, password+"x"); err == nil {
+				return "", fmt.Errorf("the wrong password decrypted")
+			}
+			return "modified archive and wrong password refused", nil
+		}},
+	}
+}
+
+// runSelfTest runs the self-test, reporting PASS or FAIL and the time taken
+// for each step to w, and returns the exit status: 0 when every step passed.
+// A step after a failure is not run, as it builds on what failed.
+func runSelfTest(w io.Writer) int {
+	// The throwaway password stands in for whatever key is configured, so an
+	// ENCRYPT_PUBLIC_KEY must not take over encryptData for the run.
+	pub := archivePublicKey
+	defer func() { archivePublicKey = pub }()
+	archivePublicKey = nil
+
+	start := time.Now()
+	steps := selfTestSteps()
+	passed := 0
+	for _, s := range steps {
+		t := time.Now()
+		detail, err := s.run()
+		took := time.Since(t).Round(time.Millisecond)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL  %s: %v (%v)\n", s.name, err, took)
+			break
+		}
+		fmt.Fprintf(w, "PASS  %s: %s (%v)\n", s.name, detail, took)
+		passed++
+	}
+	took := time.Since(start).Round(time.Millisecond)
+	if passed < len(steps) {
+		fmt.Fprintf(w, "Self-test FAILED: %d of %d steps passed (%v)\n", passed, len(steps), took)
+		return 1
+	}
+	fmt.Fprintf(w, "Self-test passed: %d steps (%v)\n", passed, took)
+	return 0
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	var out bytes.Buffer
+	if code := runSelfTest(&out); code != 0 {
+		t.Fatalf("self-test failed (exit %d):\n%s", code, out.String())
+	}
+	for _, want := range []string{"PASS  KDF pbkdf2", "PASS  decrypt and decompress: bytes match", "Self-test passed: 5 steps"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunSelfTestFailure(t *testing.T) {
+	saved := encryptKDF
+	defer func() { encryptKDF = saved }()
+	encryptKDF = kdfParams{ID: kdfPBKDF2, Time: 10} // below what archives may record
+
+	var out bytes.Buffer
+	if code := runSelfTest(&out); code != 1 {
+		t.Errorf("exit %d with an unusable KDF, want 1", code)
+	}
+	if !strings.Contains(out.String(), "FAIL  KDF") || !strings.Contains(out.String(), "0 of 5 steps passed") {
+		t.Errorf("output:\n%s", out.String())
+	}
+}
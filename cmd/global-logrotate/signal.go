@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ============================================================
+// Signalling the log's writer after rotation (--signal-pidfile)
+// ============================================================
+
+const defaultReloadSignal = "HUP"
+
+// reloadSignals are the signals --signal accepts, by name without "SIG".
+var reloadSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"USR1": sigUSR1,
+	"USR2": sigUSR2,
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"QUIT": syscall.SIGQUIT,
+}
+
+// parseSignal accepts "HUP", "SIGHUP", "hup" or a number.
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "SIG")
+	if sig, ok := reloadSignals[name]; ok {
+		return sig, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 && n < 65 {
+		return syscall.Signal(n), nil
+	}
+	return 0, fmt.Errorf("unknown signal %q (use HUP, USR1, USR2, INT, TERM, QUIT or a number)", s)
+}
+
+// readPIDFile returns the PID stored in path.
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s does not hold a PID", path)
+	}
+	return pid, nil
+}
+
+// signalPIDFile sends sig once to the process named in pidFile, after checking
+// it is alive. Signalling a PID that has since been reused by another process
+// would be worse than not signalling at all, so a dead PID is an error.
+func signalPIDFile(pidFile string, sig syscall.Signal) (int, error) {
+	pid, err := readPIDFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	if err := sendSignal(pid, 0); err != nil {
+		return pid, fmt.Errorf("process %d from %s is not running: %w", pid, pidFile, err)
+	}
+	if err := sendSignal(pid, sig); err != nil {
+		return pid, fmt.Errorf("signalling process %d: %w", pid, err)
+	}
+	return pid, nil
+}
+
+// signalAfterRotation sends SIGNAL to the process in SIGNAL_PIDFILE once every
+// file of the run is done, so a daemon like rsyslog reopens all its logs in one
+// go. Nothing is sent when no file was rotated.
+func signalAfterRotation(cfg *Config, results []FileResult) {
+	if cfg.SignalPIDFile == "" {
+		return
+	}
+	sig, err := parseSignal(cfg.Signal)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		logError("Not signalling %s: %v", cfg.SignalPIDFile, err)
+		return
+	}
+	label := "SIG" + strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(cfg.Signal)), "SIG")
+	rotated := 0
+	for _, r := range results {
+		if r.Status == statusRotated {
+			rotated++
+		}
+	}
+	if cfg.DryRun {
+		plan(planSignal, "Would send %s to the process in %s", label, cfg.SignalPIDFile)
+		return
+	}
+	if rotated == 0 {
+		logInfo("Nothing rotated, not signalling the process in %s", cfg.SignalPIDFile)
+		return
+	}
+	pid, err := signalPIDFile(cfg.SignalPIDFile, sig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not signal the process in %s: %v\n", cfg.SignalPIDFile, err)
+		logError("Could not send %s to the process in %s: %v", label, cfg.SignalPIDFile, err)
+		return
+	}
+	fmt.Printf("%s: Sent %s to process %d (%s) after rotating %d file(s)\n", timestamp(), label, pid, cfg.SignalPIDFile, rotated)
+	logInfo("Sent %s to process %d from %s after rotating %d file(s)", label, pid, cfg.SignalPIDFile, rotated)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	for in, want := range map[string]syscall.Signal{"HUP": syscall.SIGHUP, "sigusr1": sigUSR1, " SIGTERM ": syscall.SIGTERM, "10": syscall.Signal(10)} {
+		if got, err := parseSignal(in); err != nil || got != want {
+			t.Errorf("parseSignal(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "RELOAD", "0", "99"} {
+		if _, err := parseSignal(bad); err == nil {
+			t.Errorf("parseSignal(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSignalAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "hupped")
+	cmd := exec.Command("sh", "-c", fmt.Sprintf(`trap 'touch %s; exit 0' HUP; while :; do sleep 0.05; done`, marker))
+	if err := cmd.Start(); err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	defer cmd.Process.Kill()
+	time.Sleep(200 * time.Millisecond) // let the trap install
+
+	pidFile := filepath.Join(dir, "daemon.pid")
+	os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644)
+	cfg := &Config{SignalPIDFile: pidFile, Signal: "HUP"}
+
+	signalAfterRotation(cfg, []FileResult{{Status: statusSkipped}})
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("signalled although nothing was rotated")
+	}
+
+	signalAfterRotation(cfg, []FileResult{{Status: statusRotated}, {Status: statusRotated}})
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process still running 5s after SIGHUP")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("process did not get SIGHUP: %v", err)
+	}
+
+	// The process is gone now; its PID must not be signalled again.
+	if _, err := signalPIDFile(pidFile, syscall.SIGHUP); err == nil {
+		t.Error("signalPIDFile succeeded for a dead process")
+	}
+}
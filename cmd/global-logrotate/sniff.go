@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ============================================================
+// Reading other tools' compressed files (--read)
+// ============================================================
+
+// readFormat is a compressed format --read recognises by magic number or by
+// extension. newReader is nil for formats we recognise but can't decompress.
+type readFormat struct {
+	name      string
+	exts      []string
+	magic     []byte
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+// readFormats covers what we write plus what other rotation tools commonly do.
+var readFormats = []readFormat{
+	{"gzip", []string{".gz"}, []byte{0x1f, 0x8b}, codecs["gzip"].newReader},
+	{"xz", []string{".xz"}, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, codecs["xz"].newReader},
+	{"bzip2", []string{".bz2"}, []byte("BZh"), func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+	{"zstd", []string{".zst", ".zstd"}, []byte{0x28, 0xb5, 0x2f, 0xfd}, codecs["zstd"].newReader},
+	{"lz4", []string{".lz4"}, []byte{0x04, 0x22, 0x4d, 0x18}, nil},
+	{"lzip", []string{".lz"}, []byte("LZIP"), nil},
+	{"lzop", []string{".lzo"}, []byte{0x89, 'L', 'Z', 'O'}, nil},
+	{"compress (.Z)", []string{".Z"}, []byte{0x1f, 0x9d}, nil},
+	{"zip", []string{".zip"}, []byte("PK\x03\x04"), nil},
+	{"7z", []string{".7z"}, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, nil},
+	{"brotli", []string{".br"}, nil, nil},
+}
+
+// newZstdReader decodes a zstd stream. The decoder's goroutines are released
+// once the stream is drained.
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdStream{d}, nil
+}
+
+type zstdStream struct{ d *zstd.Decoder }
+
+func (z *zstdStream) Read(p []byte) (int, error) {
+	n, err := z.d.Read(p)
+	if err != nil {
+		z.d.Close()
+	}
+	return n, err
+}
+
+// sniffFormat identifies head (the first bytes of a file) by its magic number,
+// falling back to name's extension for formats without one.
+func sniffFormat(name string, head []byte) (readFormat, bool) {
+	for _, f := range readFormats {
+		if f.magic == nil || !bytes.HasPrefix(head, f.magic) {
+			continue
+		}
+		// "BZh" alone is too likely in text; bzip2 follows it with the block size.
+		if f.name == "bzip2" && (len(head) < 4 || head[3] < '1' || head[3] > '9') {
+			continue
+		}
+		return f, true
+	}
+	for _, f := range readFormats {
+		for _, ext := range f.exts {
+			if strings.HasSuffix(name, ext) {
+				return f, true
+			}
+		}
+	}
+	return readFormat{}, false
+}
+
+// decompressReader returns src decompressed according to its magic number, so
+// a file compressed by another tool reads the same as one of ours whatever its
+// name. Content without a known magic number is returned as plain text, unless
+// name claims a compressed format.
+func decompressReader(name string, src io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(8)
+	f, ok := sniffFormat(name, head)
+	if !ok {
+		return br, nil
+	}
+	if f.magic != nil && !bytes.HasPrefix(head, f.magic) {
+		return nil, fmt.Errorf("%s: not %s data despite its extension", name, f.name)
+	}
+	if f.newReader == nil {
+		return nil, fmt.Errorf("%s: %s compression is not supported (supported: gzip, xz, bzip2, zstd)", name, f.name)
+	}
+	r, err := f.newReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("%s decompression failed: %w", f.name, err)
+	}
+	return r, nil
+}
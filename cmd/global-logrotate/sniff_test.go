@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2 of "from bzip2\n"; the standard library can only read bzip2.
+const bzip2Sample = "425a6839314159265359cf83f99500000259800010400010001122d010200022000f508069a687bd993803c5dc914e142433e0fe6540"
+
+func TestReadForeignFormats(t *testing.T) {
+	dir := t.TempDir()
+	bz, _ := hex.DecodeString(bzip2Sample)
+	var zst bytes.Buffer
+	zw, _ := zstd.NewWriter(&zst)
+	zw.Write([]byte("from zstd\n"))
+	zw.Close()
+	gz, _ := compressGzip(strings.NewReader("gzip, misnamed\n"))
+
+	for name, tc := range map[string]struct {
+		data []byte
+		want string
+	}{
+		"syslog.1.bz2": {bz, "from bzip2\n"},
+		"app.log.zst":  {zst.Bytes(), "from zstd\n"},
+		"app.log.old":  {gz, "gzip, misnamed\n"},
+		"notes.txt":    {[]byte("BZh is just text here\n"), "BZh is just text here\n"},
+		"empty.log":    {nil, ""},
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, tc.data, 0644)
+		var out bytes.Buffer
+		if err := streamLogFile(&out, path, &Config{}); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if out.String() != tc.want {
+			t.Errorf("%s: got %q, want %q", name, out.String(), tc.want)
+		}
+	}
+}
+
+func TestReadUnsupportedFormat(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"app.log.lz4": {0x04, 0x22, 0x4d, 0x18, 0x64, 0x40, 0xa7},
+		"app.log.br":  {0x1b, 0x00, 0x00},
+		"app.log.gz":  []byte("plain text behind a .gz name\n"),
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+		if err := streamLogFile(&bytes.Buffer{}, path, &Config{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+)
+
+// ============================================================
+// Split archives (SPLIT_SIZE_MB)
+// ============================================================
+
+// partRe matches the suffix of one part of a split archive.
+var partRe = regexp.MustCompile(`\.part(\d{3})$`)
+
+// partPath names the i-th (1-based) part of archive, e.g. "app.log.20240115.gz.part002".
+func partPath(archive string, i int) string {
+	return fmt.Sprintf("%s.part%03d", archive, i)
+}
+
+// splitArchiveOf returns the archive a part belongs to.
+func splitArchiveOf(path string) (string, bool) {
+	if loc := partRe.FindStringIndex(path); loc != nil {
+		return path[:loc[0]], true
+	}
+	return path, false
+}
+
+// splitPartsExist reports whether archive was written as parts.
+func splitPartsExist(archive string) bool {
+	_, err := os.Stat(partPath(archive, 1))
+	return err == nil
+}
+
+// archiveExists reports whether archive was written, whole or as parts.
+func archiveExists(archive string) bool {
+	_, err := os.Stat(archive)
+	return err == nil || splitPartsExist(archive)
+}
+
+// removeReplacedParts deletes what an earlier archive at the same name left
+// behind once a new one of parts parts (0 for a single file) has replaced it:
+// the single file when the new one is split, and any parts beyond the new
+// ones.
+func removeReplacedParts(archive string, parts int) {
+	if parts > 0 {
+		os.Remove(archive)
+	}
+	for i := parts + 1; ; i++ {
+		if err := os.Remove(partPath(archive, i)); err != nil {
+			return
+		}
+	}
+}
+
+// splitError says which part of a split write failed.
+type splitError struct {
+	part int
+	path string
+	err  error
+}
+
+func (e *splitError) Error() string {
+	return fmt.Sprintf("part %d (%s): %v", e.part, e.path, e.err)
+}
+
+func (e *splitError) Unwrap() error { return e.err }
+
+// splitWriter writes an archive as consecutive parts of partSize bytes next
+// to archive, starting the next part as each fills up, so the archive never
+// has to be in memory whole. Parts are written as .tmp files, synced when
+// fsync is set; finish reads every one of them back before renaming any into
+// place, and abort removes whatever was written, so the parts are kept all or
+// none. Concatenating the parts in order gives back what was written.
+type splitWriter struct {
+	archive  string
+	partSize int64
+	mode     os.FileMode
+	fsync    bool
+
+	f     *os.File  // part being written
+	h     hash.Hash // of the part being written
+	n     int64     // bytes in the part being written
+	tmps  []string
+	sizes []int64
+	sums  [][]byte
+	parts []string // renamed into place
+}
+
+func newSplitWriter(archive string, partSize int64, mode os.FileMode, fsync bool) *splitWriter {
+	return &splitWriter{archive: archive, partSize: partSize, mode: mode, fsync: fsync}
+}
+
+func (s *splitWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if s.f == nil || s.n == s.partSize {
+			if err := s.nextPart(); err != nil {
+				return written, err
+			}
+		}
+		k := min(int64(len(p)), s.partSize-s.n)
+		n, err := s.f.Write(p[:k])
+		s.h.Write(p[:n])
+		s.n += int64(n)
+		written += n
+		if err != nil {
+			return written, s.partError(err)
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// partError wraps err as a failure of the part last started.
+func (s *splitWriter) partError(err error) error {
+	return &splitError{part: len(s.tmps), path: s.tmps[len(s.tmps)-1], err: err}
+}
+
+// nextPart closes the part being written and starts the next one.
+func (s *splitWriter) nextPart() error {
+	if err := s.closePart(); err != nil {
+		return err
+	}
+	tmp := partPath(s.archive, len(s.tmps)+1) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.mode)
+	if err != nil {
+		return &splitError{part: len(s.tmps) + 1, path: tmp, err: err}
+	}
+	s.tmps = append(s.tmps, tmp)
+	s.f, s.h, s.n = f, sha256.New(), 0
+	return nil
+}
+
+// closePart syncs and closes the part being written, if any.
+func (s *splitWriter) closePart() error {
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f = nil
+	s.sizes = append(s.sizes, s.n)
+	s.sums = append(s.sums, s.h.Sum(nil))
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return s.partError(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return s.partError(err)
+	}
+	return nil
+}
+
+// finish checks every part and renames them into place, returning their
+// paths. An archive that fit in one part is kept under archive's own name, as
+// an ordinary archive. On error the caller still has to abort.
+func (s *splitWriter) finish() ([]string, error) {
+	if s.tmps == nil {
+		if err := s.nextPart(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.closePart(); err != nil {
+		return nil, err
+	}
+	for i, tmp := range s.tmps {
+		if err := verifyArchiveSum(tmp, s.sizes[i], s.sums[i]); err != nil {
+			return nil, &splitError{part: i + 1, path: tmp, err: err}
+		}
+	}
+	if len(s.tmps) == 1 {
+		if err := os.Rename(s.tmps[0], s.archive); err != nil {
+			return nil, err
+		}
+		s.parts = append(s.parts, s.archive)
+		return s.parts, nil
+	}
+	for i, tmp := range s.tmps {
+		part := partPath(s.archive, i+1)
+		if err := os.Rename(tmp, part); err != nil {
+			return nil, &splitError{part: i + 1, path: part, err: err}
+		}
+		s.parts = append(s.parts, part)
+	}
+	return s.parts, nil
+}
+
+// abort removes every part written so far, renamed into place or not.
+func (s *splitWriter) abort() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	for _, p := range append(s.tmps, s.parts...) {
+		os.Remove(p)
+	}
+}
+
+// openSplitArchive opens every part of archive in order as one stream. It
+// fails if there is no first part or the numbering has a gap (a later part
+// present after a missing one).
+func openSplitArchive(archive string) (io.Reader, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for i := 1; ; i++ {
+		f, err := os.Open(partPath(archive, i))
+		if os.IsNotExist(err) {
+			if i == 1 {
+				return nil, nil, fmt.Errorf("%s: no parts found", archive)
+			}
+			if _, err := os.Stat(partPath(archive, i+1)); err == nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("%s: part %d is missing", archive, i)
+			}
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = f
+	}
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+// streamSplitArchive writes the content of the split archive path (any of its
+// parts, or the name the parts share) to w.
+func streamSplitArchive(w io.Writer, path string, cfg *Config) error {
+	archive, _ := splitArchiveOf(path)
+	r, closeParts, err := openSplitArchive(archive)
+	if err != nil {
+		return err
+	}
+	defer closeParts()
+	return streamArchive(w, archive, r, cfg)
+}
+
+// isLaterPart reports whether path is a part of a split archive other than the
+// first, which directory reads skip since the first part reads the whole set.
+func isLaterPart(path string) bool {
+	m := partRe.FindStringSubmatch(path)
+	return m != nil && m[1] != "001"
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateSplitArchive(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := randomBytes(t, 2<<20+1000) // incompressible, so three 1 MB parts
+	os.WriteFile(logPath, content, 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.SplitSizeMB = 1
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status %s: %v", res.Status, res.Err)
+	}
+	if _, err := os.Stat(res.Archive); !os.IsNotExist(err) {
+		t.Errorf("unsplit archive %s should not exist", res.Archive)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := os.Stat(partPath(res.Archive, i)); err != nil {
+			t.Errorf("part %d: %v", i, err)
+		}
+	}
+	if info, _ := os.Stat(logPath); info.Size() != 0 {
+		t.Error("source should be truncated once all parts are written")
+	}
+
+	for _, name := range []string{res.Archive, partPath(res.Archive, 2)} {
+		var out bytes.Buffer
+		if err := streamLogFile(&out, name, cfg); err != nil || !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("reading %s: %d bytes, err %v", name, out.Len(), err)
+		}
+	}
+	if logName, date, ok := parseArchiveName(filepath.Base(partPath(res.Archive, 3))); !ok || logName != "app.log" || date != "20240115" {
+		t.Errorf("parts should parse as their archive, got %q %q %v", logName, date, ok)
+	}
+
+	// A second rotation the same day sees the parts as the existing archive.
+	os.WriteFile(logPath, []byte("more\n"), 0644)
+	if res := rotateLogFile(logPath, cfg); res.Status != statusSkipped {
+		t.Errorf("second rotation: status %s, want skipped", res.Status)
+	}
+}
+
+func TestSplitWriteIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := randomBytes(t, 3<<20)
+	os.WriteFile(logPath, content, 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.SplitSizeMB = 1
+
+	// Make the second part unwritable: its temp name is taken by a directory.
+	archive := filepath.Join(dir, "old", "20240115", "app.log.20240115.gz")
+	os.MkdirAll(partPath(archive, 2)+".tmp", 0755)
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusFailed {
+		t.Fatalf("status %s, want failed", res.Status)
+	}
+	var se *splitError
+	if !errors.As(res.Err, &se) || se.part != 2 {
+		t.Errorf("error should name part 2, got %v", res.Err)
+	}
+	for _, p := range []string{partPath(archive, 1), partPath(archive, 1) + ".tmp", partPath(archive, 3) + ".tmp"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should have been cleaned up", p)
+		}
+	}
+	if got, _ := os.ReadFile(logPath); !bytes.Equal(got, content) {
+		t.Error("source must be untouched when a part fails")
+	}
+}
+
+func TestSplitSmallArchiveStaysWhole(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("well under a part\n"), 0644)
+	cfg := makeTestCfg(t, dir)
+	cfg.SplitSizeMB = 1
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status %s: %v", res.Status, res.Err)
+	}
+	if _, err := os.Stat(res.Archive); err != nil {
+		t.Errorf("archive under SPLIT_SIZE_MB should be written whole: %v", err)
+	}
+	if matches, _ := filepath.Glob(res.Archive + ".part*"); len(matches) != 0 {
+		t.Errorf("unexpected parts %v", matches)
+	}
+}
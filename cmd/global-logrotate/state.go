@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ============================================================
+// Rotation state (SKIP_UNCHANGED)
+// ============================================================
+
+const defaultStateFile = "/var/lib/global-sys-utils/rotate-state.json"
+
+// sourceState is what a source looked like right after we last rotated it.
+type sourceState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Inode   uint64    `json:"inode"`
+}
+
+// rotationState is the STATE_FILE: one sourceState per rotated path. Methods
+// are safe for concurrent use and treat a nil state as "nothing recorded".
+type rotationState struct {
+	mu    sync.Mutex
+	path  string
+	files map[string]sourceState
+	dirty bool
+}
+
+// loadRotationState reads the state file at path; a missing file is an empty state.
+func loadRotationState(path string) (*rotationState, error) {
+	s := &rotationState{path: path, files: make(map[string]sourceState)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.files); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func statOf(info os.FileInfo) sourceState {
+	st := sourceState{Size: info.Size(), ModTime: info.ModTime().UTC()}
+	st.Inode = fileInode(info)
+	return st
+}
+
+// unchanged reports whether path still looks exactly as it did after its last
+// rotation: same inode, size and mtime, i.e. nothing was written since.
+func (s *rotationState) unchanged(path string, info os.FileInfo) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.files[path]
+	return ok && prev == statOf(info)
+}
+
+// record stores path's current stat as its post-rotation state.
+func (s *rotationState) record(path string) {
+	if s == nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.files[path] = statOf(info)
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// save writes the state back atomically if anything was recorded.
+func (s *rotationState) save() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := writeArchiveFile(tmp, data, 0640, true); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// openRotationState loads STATE_FILE into cfg when SKIP_UNCHANGED is on. An
+// unreadable state file is reported and the run goes on rotating everything.
+func openRotationState(cfg *Config) {
+	if !cfg.SkipUnchanged {
+		return
+	}
+	s, err := loadRotationState(cfg.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring state file %s: %v\n", cfg.StateFile, err)
+		logError("Ignoring state file %s: %v", cfg.StateFile, err)
+		s = &rotationState{path: cfg.StateFile, files: make(map[string]sourceState)}
+	}
+	cfg.state = s
+}
+
+// saveRotationState writes cfg's state back after a run, unless it is a dry run.
+func saveRotationState(cfg *Config) {
+	if cfg.DryRun {
+		return
+	}
+	if err := cfg.state.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving state file: %v\n", err)
+		logError("Error saving state file %s: %v", cfg.StateFile, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "quiet.log")
+	os.WriteFile(logPath, []byte("first\n"), 0644)
+
+	cfg := makeTestCfg(t, dir)
+	cfg.SkipUnchanged = true
+	cfg.StateFile = filepath.Join(dir, "state", "rotate-state.json")
+	openRotationState(cfg)
+
+	if res := rotateLogFile(logPath, cfg); res.Status != statusRotated {
+		t.Fatalf("first run: %s (err=%v)", res.Status, res.Err)
+	}
+	saveRotationState(cfg)
+
+	// A fresh run loads the saved state and leaves the untouched file alone.
+	cfg.state = nil
+	openRotationState(cfg)
+	if res := rotateLogFile(logPath, cfg); res.Status != statusUnchanged {
+		t.Errorf("untouched file: status %s, want %s", res.Status, statusUnchanged)
+	}
+
+	os.WriteFile(logPath, []byte("second\n"), 0644)
+	cfg.BackupDate, cfg.DateSuffix = "20240116", "20240116"
+	if res := rotateLogFile(logPath, cfg); res.Status != statusRotated {
+		t.Errorf("written-to file: status %s, want %s", res.Status, statusRotated)
+	}
+}
+
+func TestRotationStateCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	os.WriteFile(path, []byte("{not json"), 0640)
+	if _, err := loadRotationState(path); err == nil {
+		t.Error("corrupt state should fail to load")
+	}
+	cfg := &Config{SkipUnchanged: true, StateFile: path}
+	openRotationState(cfg)
+	if cfg.state == nil || len(cfg.state.files) != 0 {
+		t.Error("a corrupt state file should fall back to an empty state")
+	}
+}
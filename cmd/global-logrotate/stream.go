@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ============================================================
+// Streaming archives to stdout (--stream-archive)
+// ============================================================
+
+// streamMagic starts every frame --stream-archive writes. A frame is one text
+// header line followed by the archive bytes:
+//
+//	GLRS1 <length> <name>\n
+//	<length bytes: the archive, compressed and encrypted as it would be on disk>
+//
+// name is the archive's path under its backup root, slash-separated, e.g.
+// "20240115/app.log.20240115.gz.enc". Frames follow each other directly;
+// end of input between frames ends the stream, anywhere else it was cut short.
+const streamMagic = "GLRS1"
+
+var (
+	// streamOut is where frames go: the real stdout, which main hands over to
+	// --stream-archive before pointing os.Stdout at stderr for everything else.
+	streamOut io.Writer = os.Stdout
+	// streamMu keeps frames from parallel workers whole.
+	streamMu sync.Mutex
+	// streamErr is the first failed write. A frame cut short leaves the
+	// stream unparseable, so nothing is written after it.
+	streamErr error
+)
+
+// writeStreamFrame copies the size bytes of an archive from r to w as one
+// frame named name and returns once all of them have been handed to w. After
+// a failed write it fails every call.
+func writeStreamFrame(w io.Writer, name string, r io.Reader, size int64) error {
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("archive name %q can't be framed: it contains a line break", name)
+	}
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if streamErr != nil {
+		return fmt.Errorf("not writing frame %s: the stream broke earlier: %w", name, streamErr)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %d %s\n", streamMagic, size, name)
+	_, err := io.CopyN(bw, r, size)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// Part of the frame may be out already, so the stream can't go on.
+		streamErr = err
+		return fmt.Errorf("writing frame %s: %w", name, err)
+	}
+	return nil
+}
+
+// newSpool returns a temp file to build one frame's archive in before its
+// length is known. It is unlinked at once, so it goes away when closed, even
+// if we don't get to clean up.
+func newSpool() (*os.File, error) {
+	f, err := os.CreateTemp("", "global-logrotate-stream-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating stream spool: %w", err)
+	}
+	os.Remove(f.Name())
+	return f, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readStreamFrame reads one --stream-archive frame the way a consumer would.
+func readStreamFrame(r *bufio.Reader) (name string, data []byte, err error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	var magic string
+	var n int
+	if _, err := fmt.Sscanf(header, "%s %d ", &magic, &n); err != nil || magic != streamMagic {
+		return "", nil, fmt.Errorf("bad frame header %q", header)
+	}
+	_, name, _ = strings.Cut(strings.TrimSuffix(header, "\n"), fmt.Sprintf("%s %d ", magic, n))
+	data = make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return name, data, err
+}
+
+// useStreamOut points frames at w for the test.
+func useStreamOut(t *testing.T, w io.Writer) {
+	t.Helper()
+	old := streamOut
+	streamOut, streamErr = w, nil
+	t.Cleanup(func() { streamOut, streamErr = old, nil })
+}
+
+func TestRotateStreamArchive(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.StreamArchive = true
+	var out bytes.Buffer
+	useStreamOut(t, &out)
+
+	contents := map[string]string{
+		"app.log":   strings.Repeat("2024-01-15 INFO streamed\n", 200),
+		"error.log": "2024-01-15 ERROR once\n",
+	}
+	for _, name := range []string{"app.log", "error.log"} {
+		logPath := filepath.Join(dir, name)
+		os.WriteFile(logPath, []byte(contents[name]), 0644)
+		res := captureStdout(t, func() { rotateLogFile(logPath, cfg) })
+		if !strings.Contains(res, "stdout (20240115/"+name+".20240115.gz)") {
+			t.Errorf("progress for %s: %q", name, res)
+		}
+		if info, _ := os.Stat(logPath); info.Size() != 0 {
+			t.Errorf("%s should be truncated once its frame is written", name)
+		}
+	}
+	if _, err := os.Stat(cfg.OldLogsDir); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written under %s", cfg.OldLogsDir)
+	}
+
+	r := bufio.NewReader(&out)
+	for _, name := range []string{"app.log", "error.log"} {
+		frameName, data, err := readStreamFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "20240115/" + name + ".20240115.gz"; frameName != want {
+			t.Errorf("frame name %q, want %q", frameName, want)
+		}
+		plain, err := decompressGzip(data)
+		if err != nil || string(plain) != contents[name] {
+			t.Errorf("frame %s does not hold the log: %v", frameName, err)
+		}
+	}
+	if _, _, err := readStreamFrame(r); err != io.EOF {
+		t.Errorf("after the last frame: %v, want EOF", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestRotateStreamArchiveWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.StreamArchive = true
+	useStreamOut(t, failingWriter{})
+
+	logPath := filepath.Join(dir, "app.log")
+	content := []byte("2024-01-15 INFO kept\n")
+	os.WriteFile(logPath, content, 0644)
+	if res := rotateLogFile(logPath, cfg); res.Status != statusFailed {
+		t.Fatalf("status %s, want failed", res.Status)
+	}
+	if data, _ := os.ReadFile(logPath); !bytes.Equal(data, content) {
+		t.Error("source must be untouched when its frame could not be written")
+	}
+
+	// Once the stream is broken, later frames are refused even if writes would work.
+	streamOut = io.Discard
+	if err := writeStreamFrame(streamOut, "20240115/b.log.20240115.gz", strings.NewReader("x"), 1); err == nil {
+		t.Error("expected frames after a failed write to be refused")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// ============================================================
+// JSON run summary on stdout (--format json)
+// ============================================================
+
+// --format values.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// jsonOut is where --format json writes its summary: the real stdout, which
+// main hands over before pointing os.Stdout at stderr for the progress lines.
+var jsonOut io.Writer = os.Stdout
+
+// summaryFile is one file's entry in the --format json summary. Ratio is the
+// archive's size as a fraction of the original (0 when nothing was archived).
+type summaryFile struct {
+	reportFile
+	Ratio float64 `json:"ratio"`
+}
+
+// summaryTotals are the run's aggregates over rotated files.
+type summaryTotals struct {
+	Files        int     `json:"files"`
+	Rotated      int     `json:"rotated"`
+	Errors       int     `json:"errors"`
+	OriginalSize int64   `json:"original_size"`
+	ArchiveSize  int64   `json:"archive_size"`
+	BytesSaved   int64   `json:"bytes_saved"`
+	Ratio        float64 `json:"ratio"`
+}
+
+// runSummary is the single JSON document --format json prints.
+type runSummary struct {
+	Host       string         `json:"host"`
+	Version    string         `json:"version"`
+	LogDir     string         `json:"log_dir"`
+	DryRun     bool           `json:"dry_run"`
+	DurationMS float64        `json:"duration_ms"`
+	Counts     map[string]int `json:"counts"`
+	Totals     summaryTotals  `json:"totals"`
+	Files      []summaryFile  `json:"files"`
+}
+
+// sizeRatio is archived/original, rounded to four places.
+func sizeRatio(original, archived int64) float64 {
+	if original <= 0 {
+		return 0
+	}
+	return float64(archived*10000/original) / 10000
+}
+
+// newRunSummary summarises results for a run that started at started.
+func newRunSummary(cfg *Config, started time.Time, results []FileResult) runSummary {
+	host, _ := os.Hostname()
+	s := runSummary{
+		Host:       host,
+		Version:    version,
+		LogDir:     cfg.LogDir,
+		DryRun:     cfg.DryRun,
+		DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+		Counts:     make(map[string]int),
+		Files:      make([]summaryFile, 0, len(results)),
+	}
+	t := &s.Totals
+	for _, res := range results {
+		s.Counts[res.Status]++
+		f := summaryFile{reportFile: newReportFile(res)}
+		if res.Status == statusRotated {
+			f.Ratio = sizeRatio(res.OriginalSize, res.ArchiveSize)
+			t.Rotated++
+			t.OriginalSize += res.OriginalSize
+			t.ArchiveSize += res.ArchiveSize
+		}
+		if res.Status == statusFailed {
+			t.Errors++
+		}
+		s.Files = append(s.Files, f)
+	}
+	t.Files = len(results)
+	t.BytesSaved = t.OriginalSize - t.ArchiveSize
+	t.Ratio = sizeRatio(t.OriginalSize, t.ArchiveSize)
+	return s
+}
+
+// printRunSummary writes the --format json document for the run to jsonOut.
+func printRunSummary(cfg *Config, started time.Time, results []FileResult) {
+	if cfg.Format != formatJSON {
+		return
+	}
+	enc := json.NewEncoder(jsonOut)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newRunSummary(cfg, started, results)); err != nil {
+		logError("Writing the JSON summary failed: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintRunSummary(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.Format = formatJSON
+	var out bytes.Buffer
+	old := jsonOut
+	jsonOut = &out
+	defer func() { jsonOut = old }()
+
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte(strings.Repeat("2024-01-15 INFO summarised\n", 100)), 0644)
+	var results []FileResult
+	captureStdout(t, func() { results = append(results, rotateLogFile(logPath, cfg)) })
+	results = append(results, FileResult{Path: filepath.Join(dir, "bad.log"), Status: statusFailed, Err: errors.New("disk full")})
+	printRunSummary(cfg, time.Now(), results)
+
+	var got runSummary
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("summary is not one JSON document: %v\n%s", err, out.String())
+	}
+	if len(got.Files) != 2 || got.Counts[statusRotated] != 1 || got.Counts[statusFailed] != 1 {
+		t.Fatalf("summary %+v", got)
+	}
+	rotated, failed := got.Files[0], got.Files[1]
+	if rotated.OriginalSize != results[0].OriginalSize || rotated.ArchiveSize != results[0].ArchiveSize ||
+		rotated.Ratio <= 0 || rotated.Ratio >= 1 || rotated.Encrypted {
+		t.Errorf("rotated entry %+v", rotated)
+	}
+	if failed.Error != "disk full" || failed.Ratio != 0 {
+		t.Errorf("failed entry %+v", failed)
+	}
+	if tot := got.Totals; tot.Files != 2 || tot.Rotated != 1 || tot.Errors != 1 ||
+		tot.BytesSaved != rotated.OriginalSize-rotated.ArchiveSize || tot.Ratio != rotated.Ratio {
+		t.Errorf("totals %+v", tot)
+	}
+
+	// Text mode prints nothing here.
+	out.Reset()
+	cfg.Format = formatText
+	printRunSummary(cfg, time.Now(), results)
+	if out.Len() != 0 {
+		t.Errorf("text mode wrote %q", out.String())
+	}
+}
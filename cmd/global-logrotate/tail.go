@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ============================================================
+// Keeping the tail of the source (KEEP_TAIL_LINES)
+// ============================================================
+
+// tailChunk is how much lastLines reads per step backwards from the end.
+const tailChunk = 64 * 1024
+
+// lastLines returns the last n lines of r, which is size bytes long, reading
+// backwards so a large log isn't read in full. A final line without a newline
+// counts as a line.
+func lastLines(r io.ReaderAt, size int64, n int) ([]byte, error) {
+	if n <= 0 || size == 0 {
+		return nil, nil
+	}
+	var buf []byte
+	end := size
+	for end > 0 {
+		start := max(end-tailChunk, 0)
+		chunk := make([]byte, end-start)
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+		end = start
+
+		// Line ends before the last byte; a trailing newline closes the last line
+		// rather than starting another one.
+		seen := 0
+		for i := len(buf) - 2; i >= 0; i-- {
+			if buf[i] == '\n' {
+				if seen++; seen == n {
+					return buf[i+1:], nil
+				}
+			}
+		}
+	}
+	return buf, nil
+}
+
+// keepTail replaces path with a file holding only its last n lines, keeping its
+// owner and mode. The new content is written to a temp file in the same
+// directory and renamed over path, so a crash leaves either the old file or the
+// new one. A missing path is reported as fs.ErrNotExist and never created.
+func keepTail(path string, n int, fsync bool) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tail, err := lastLines(src, info.Size(), n)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := io.Copy(tmp, bytes.NewReader(tail)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if fsync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := ownerOf(info).restore(tmp.Name()); err != nil {
+		logInfo("Could not restore ownership on %s: %v", path, err)
+	}
+	// Fail like os.Truncate if the source went away while we were reading it.
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// truncateSource empties logFile once it has been archived or, with
+// KEEP_TAIL_LINES, cuts it down to its last lines.
+func truncateSource(logFile string, cfg *Config) error {
+	if cfg.KeepTailLines > 0 {
+		return keepTail(logFile, cfg.KeepTailLines, cfg.Fsync)
+	}
+	return os.Truncate(logFile, 0)
+}
+
+// ============================================================
+// Tail of an archive (--read --tail N)
+// ============================================================
+
+// tailWriter keeps the last n lines written to it in a ring, so the tail of an
+// archive of any size is found in one pass holding only those lines. As with
+// lastLines, a final line without a newline counts as a line.
+type tailWriter struct {
+	lines   [][]byte // ring of complete lines, each ending in '\n'
+	next    int      // slot the next complete line goes in
+	full    bool     // every slot holds a line
+	partial []byte   // a line still waiting for its '\n'
+}
+
+func newTailWriter(n int) *tailWriter {
+	return &tailWriter{lines: make([][]byte, n)}
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.partial = append(t.partial, p...)
+			break
+		}
+		// Reuse the buffer of the line this one pushes out of the ring.
+		line := append(t.lines[t.next][:0], t.partial...)
+		t.lines[t.next] = append(line, p[:i+1]...)
+		t.partial = t.partial[:0]
+		if t.next++; t.next == len(t.lines) {
+			t.next, t.full = 0, true
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// writeTo writes the lines kept, oldest first, to w.
+func (t *tailWriter) writeTo(w io.Writer) error {
+	lines := t.lines[:t.next]
+	if t.full {
+		lines = append(append([][]byte{}, t.lines[t.next:]...), t.lines[:t.next]...)
+	}
+	if len(t.partial) > 0 {
+		if len(lines) == len(t.lines) {
+			lines = lines[1:]
+		}
+		lines = append(lines[:len(lines):len(lines)], t.partial)
+	}
+	for _, line := range lines {
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLastLines(t *testing.T) {
+	long := strings.Repeat("x", tailChunk) + "\n"
+	for _, tt := range []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\n", 5, "a\nb\n"},
+		{"a\n\n\nb\n", 3, "\n\nb\n"},
+		{"a\nb\n", 0, ""},
+		{"", 3, ""},
+		{"first\n" + long + long + "last\n", 2, long + "last\n"},
+	} {
+		r := strings.NewReader(tt.in)
+		got, err := lastLines(r, int64(len(tt.in)), tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("lastLines(%.20q, %d) = %.40q, want %.40q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRotateKeepsTailLines(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	content := "one\ntwo\nthree\nfour\n"
+	os.WriteFile(logPath, []byte(content), 0640)
+	cfg := makeTestCfg(t, dir)
+	cfg.KeepTailLines = 2
+
+	res := rotateLogFile(logPath, cfg)
+	if res.Status != statusRotated {
+		t.Fatalf("status = %s, want %s (err=%v)", res.Status, statusRotated, res.Err)
+	}
+	if data, _ := os.ReadFile(logPath); string(data) != "three\nfour\n" {
+		t.Errorf("source = %q, want the last two lines", data)
+	}
+	if info, _ := os.Stat(logPath); info.Mode().Perm() != 0640 {
+		t.Errorf("source mode = %v, want 0640", info.Mode().Perm())
+	}
+	var archived bytes.Buffer
+	if err := streamLogFile(&archived, res.Archive, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if archived.String() != content {
+		t.Errorf("archive = %q, want the full content", archived.String())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".app.log.tail-*")); len(leftovers) != 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	for _, tt := range []struct {
+		writes []string
+		n      int
+		want   string
+	}{
+		{[]string{"a\nb\nc\n"}, 2, "b\nc\n"},
+		{[]string{"a\nb\nc"}, 2, "b\nc"},
+		{[]string{"a\n", "b\n"}, 5, "a\nb\n"},
+		{[]string{"a\n\n", "\nb\n"}, 3, "\n\nb\n"},
+		{[]string{"on", "e\ntw", "o\nthr", "ee\nfour\n"}, 3, "two\nthree\nfour\n"},
+		{[]string{"1\n2\n3\n4\n5\n6\n7"}, 3, "5\n6\n7"},
+		{nil, 3, ""},
+	} {
+		tw := newTailWriter(tt.n)
+		for _, w := range tt.writes {
+			tw.Write([]byte(w))
+		}
+		var out bytes.Buffer
+		if err := tw.writeTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("tail %d of %q = %q, want %q", tt.n, tt.writes, out.String(), tt.want)
+		}
+	}
+}
+
+func TestReadTail(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "app.log.20240115.gz")
+	var log strings.Builder
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&log, "line %d\n", i)
+	}
+	data, _ := compressGzip(strings.NewReader(log.String()))
+	os.WriteFile(archive, data, 0644)
+
+	var out bytes.Buffer
+	if err := streamRead(&out, archive, &Config{Tail: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "line 999\nline 1000\n" {
+		t.Errorf("--tail 2 = %q", out.String())
+	}
+}
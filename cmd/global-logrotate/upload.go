@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ============================================================
+// Uploading archives to S3 (--upload)
+// ============================================================
+
+// archiveUploader puts one local file at bucket/key.
+type archiveUploader interface {
+	upload(ctx context.Context, bucket, key, file string) error
+}
+
+// s3Uploader uploads through the SDK's upload manager, which switches to a
+// multipart upload (in parallel 5 MiB parts) for files larger than one part.
+type s3Uploader struct{ u *manager.Uploader }
+
+func (s s3Uploader) upload(ctx context.Context, bucket, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = s.u.Upload(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: f})
+	return err
+}
+
+// newUploader builds the uploader from the usual AWS credential chain, with
+// UPLOAD_REGION and UPLOAD_PROFILE overriding the environment's. Tests swap it.
+var newUploader = func(cfg *Config) (archiveUploader, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.UploadRegion != "" {
+		opts = append(opts, config.WithRegion(cfg.UploadRegion))
+	}
+	if cfg.UploadProfile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.UploadProfile))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s3Uploader{manager.NewUploader(s3.NewFromConfig(awsCfg))}, nil
+}
+
+var (
+	uploaderOnce sync.Once
+	uploader     archiveUploader
+	uploaderErr  error
+)
+
+// getUploader returns the run's uploader, creating it on first use so runs
+// without --upload never load AWS configuration.
+func getUploader(cfg *Config) (archiveUploader, error) {
+	uploaderOnce.Do(func() { uploader, uploaderErr = newUploader(cfg) })
+	return uploader, uploaderErr
+}
+
+// parseS3URL splits "s3://bucket/prefix" into the bucket and the key prefix,
+// without surrounding slashes. The prefix may be empty.
+func parseS3URL(s string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/prefix URL", s)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q names no bucket", s)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// uploadKey is where archive goes under prefix: its dated directory and name,
+// the same layout as under the backup root, e.g. "prefix/20240115/app.log.20240115.gz".
+func uploadKey(prefix, date, archive string) string {
+	return path.Join(prefix, date, filepath.Base(archive))
+}
+
+// archiveFiles lists the files an archive was written as: its parts when it
+// was split, otherwise the archive itself, followed by its checksum sidecar
+// when there is one.
+func archiveFiles(archive string) []string {
+	files := []string{archive}
+	if splitPartsExist(archive) {
+		files = nil
+		for i := 1; ; i++ {
+			p := partPath(archive, i)
+			if _, err := os.Stat(p); err != nil {
+				break
+			}
+			files = append(files, p)
+		}
+	}
+	if _, err := os.Stat(checksumSidecar(archive)); err == nil {
+		files = append(files, checksumSidecar(archive))
+	}
+	return files
+}
+
+// uploadArchive uploads the archive of a rotated file to UPLOAD and, with
+// UPLOAD_DELETE_LOCAL, removes the local copy once every file of it is up. A
+// failed upload is recorded in res.UploadErr; the rotation itself stands and
+// the archive stays on disk.
+func uploadArchive(cfg *Config, res FileResult) FileResult {
+	if cfg.Upload == "" || (res.Status != statusRotated && res.Status != statusDryRun) {
+		return res
+	}
+	bucket, prefix, err := parseS3URL(cfg.Upload)
+	if err != nil {
+		res.UploadErr = err
+		return res
+	}
+	if res.Status == statusDryRun {
+		key := uploadKey(prefix, cfg.BackupDate, res.Archive)
+		then := ""
+		if cfg.UploadDelete {
+			then = ", then delete the local copy"
+		}
+		plan(planUpload, "Would upload %s -> s3://%s/%s%s", res.Archive, bucket, key, then)
+		return res
+	}
+
+	up, err := getUploader(cfg)
+	if err != nil {
+		res.UploadErr = fmt.Errorf("loading AWS configuration: %w", err)
+		fmt.Fprintf(os.Stderr, "Error: upload of %s: %v\n", res.Archive, res.UploadErr)
+		logError("Not uploading %s: %v", res.Archive, res.UploadErr)
+		return res
+	}
+	files := archiveFiles(res.Archive)
+	for _, f := range files {
+		key := uploadKey(prefix, cfg.BackupDate, f)
+		if err := up.upload(context.Background(), bucket, key, f); err != nil {
+			res.UploadErr = fmt.Errorf("uploading %s to s3://%s/%s: %w", f, bucket, key, err)
+			fmt.Fprintf(os.Stderr, "Error: %v; archive kept locally\n", res.UploadErr)
+			logError("Upload failed, keeping %s locally: %v", res.Archive, res.UploadErr)
+			return res
+		}
+		fmt.Printf("%s: Uploaded: %s -> s3://%s/%s\n", timestamp(), f, bucket, key)
+		logInfo("Uploaded %s to s3://%s/%s", f, bucket, key)
+	}
+	res.Uploaded = true
+
+	if cfg.UploadDelete {
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				logError("Uploaded %s but could not remove the local copy: %v", f, err)
+				continue
+			}
+			logDebug("Removed local copy %s after upload", f)
+		}
+	}
+	return res
+}
+
+// logUploadSummary logs how many archives of the run reached UPLOAD.
+func logUploadSummary(cfg *Config, results []FileResult) {
+	if cfg.Upload == "" || cfg.DryRun {
+		return
+	}
+	uploaded, failed := 0, 0
+	for _, r := range results {
+		switch {
+		case r.Uploaded:
+			uploaded++
+		case r.UploadErr != nil:
+			failed++
+		}
+	}
+	if uploaded+failed == 0 {
+		return
+	}
+	msg := fmt.Sprintf("Uploaded %d archive(s) to %s, %d failed", uploaded, cfg.Upload, failed)
+	if failed > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: "+msg)
+		logError("%s", msg)
+		return
+	}
+	logInfo("%s", msg)
+}
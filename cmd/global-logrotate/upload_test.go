@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseS3URL(t *testing.T) {
+	for in, want := range map[string][2]string{
+		"s3://logs":              {"logs", ""},
+		"s3://logs/":             {"logs", ""},
+		"s3://logs/hosts/web1/":  {"logs", "hosts/web1"},
+		"s3://logs//hosts/web1/": {"logs", "hosts/web1"},
+	} {
+		bucket, prefix, err := parseS3URL(in)
+		if err != nil || bucket != want[0] || prefix != want[1] {
+			t.Errorf("parseS3URL(%q) = %q, %q, %v; want %q, %q", in, bucket, prefix, err, want[0], want[1])
+		}
+	}
+	for _, bad := range []string{"", "logs/prefix", "s3://", "s3:///prefix", "https://logs.s3.amazonaws.com/x"} {
+		if _, _, err := parseS3URL(bad); err == nil {
+			t.Errorf("parseS3URL(%q) succeeded", bad)
+		}
+	}
+}
+
+// fakeUploader records uploads, copying each file's content, and fails keys
+// containing fail.
+type fakeUploader struct {
+	mu   sync.Mutex
+	got  map[string]string
+	fail string
+}
+
+func (f *fakeUploader) upload(_ context.Context, bucket, key, file string) error {
+	if f.fail != "" && strings.Contains(key, f.fail) {
+		return errors.New("access denied")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.got[bucket+"/"+key] = string(data)
+	return nil
+}
+
+// useFakeUploader routes --upload to a fakeUploader for the test.
+func useFakeUploader(t *testing.T) *fakeUploader {
+	t.Helper()
+	f := &fakeUploader{got: make(map[string]string)}
+	old := newUploader
+	newUploader = func(*Config) (archiveUploader, error) { return f, nil }
+	uploaderOnce = sync.Once{}
+	t.Cleanup(func() { newUploader, uploaderOnce = old, sync.Once{} })
+	return f
+}
+
+func TestRotateUpload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.Upload = "s3://logs/web1/"
+	up := useFakeUploader(t)
+
+	logPath := filepath.Join(dir, "app.log")
+	os.WriteFile(logPath, []byte("2024-01-15 INFO uploaded\n"), 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if res.Status != statusRotated || !res.Uploaded || res.UploadErr != nil {
+		t.Fatalf("status %s, uploaded %v (%v)", res.Status, res.Uploaded, res.UploadErr)
+	}
+	archive, _ := os.ReadFile(res.Archive)
+	if got, ok := up.got["logs/web1/20240115/app.log.20240115.gz"]; !ok || got != string(archive) {
+		t.Errorf("archive not uploaded as web1/20240115/app.log.20240115.gz (%d object(s) uploaded)", len(up.got))
+	}
+
+	// A failed upload keeps the archive and does not fail the rotation.
+	up.fail = "error.log"
+	cfg.UploadDelete = true
+	errPath := filepath.Join(dir, "error.log")
+	os.WriteFile(errPath, []byte("2024-01-15 ERROR kept\n"), 0644)
+	captureStdout(t, func() { res = rotateLogFile(errPath, cfg) })
+	if res.Status != statusRotated || res.Uploaded || res.UploadErr == nil {
+		t.Errorf("failed upload: status %s, uploaded %v, err %v", res.Status, res.Uploaded, res.UploadErr)
+	}
+	if _, err := os.Stat(res.Archive); err != nil {
+		t.Errorf("archive of a failed upload must stay: %v", err)
+	}
+
+	// UPLOAD_DELETE_LOCAL removes it once it is up.
+	dbPath := filepath.Join(dir, "db.log")
+	os.WriteFile(dbPath, []byte("2024-01-15 INFO gone\n"), 0644)
+	captureStdout(t, func() { res = rotateLogFile(dbPath, cfg) })
+	if !res.Uploaded {
+		t.Fatalf("db.log not uploaded: %v", res.UploadErr)
+	}
+	if _, err := os.Stat(res.Archive); !os.IsNotExist(err) {
+		t.Errorf("local copy should be removed after upload: %v", err)
+	}
+}
+
+func TestRotateUploadSplitAndDryRun(t *testing.T) {
+	dir := t.TempDir()
+	cfg := makeTestCfg(t, dir)
+	cfg.Upload = "s3://logs"
+	cfg.SplitSizeMB = 1
+	up := useFakeUploader(t)
+
+	logPath := filepath.Join(dir, "big.log")
+	os.WriteFile(logPath, randomBytes(t, 3<<20), 0644)
+	var res FileResult
+	captureStdout(t, func() { res = rotateLogFile(logPath, cfg) })
+	if !res.Uploaded {
+		t.Fatalf("split archive not uploaded: %s %v", res.Status, res.UploadErr)
+	}
+	parts := archiveFiles(res.Archive)
+	if len(parts) < 3 || len(up.got) != len(parts) {
+		t.Fatalf("uploaded %d object(s) for %d part(s)", len(up.got), len(parts))
+	}
+	for _, part := range parts {
+		if _, ok := up.got["logs/20240115/"+filepath.Base(part)]; !ok {
+			t.Errorf("%s not uploaded", filepath.Base(part))
+		}
+	}
+
+	cfg.DryRun = true
+	dryPath := filepath.Join(dir, "dry.log")
+	os.WriteFile(dryPath, []byte("2024-01-15 INFO dry\n"), 0644)
+	out := captureStdout(t, func() { rotateLogFile(dryPath, cfg) })
+	if !strings.Contains(out, "Would upload") || !strings.Contains(out, "s3://logs/20240115/dry.log.20240115.gz") {
+		t.Errorf("dry-run output %q does not name the key", out)
+	}
+	if len(up.got) != len(parts) {
+		t.Errorf("dry run uploaded %d more object(s)", len(up.got)-len(parts))
+	}
+}
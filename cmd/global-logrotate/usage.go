@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Per-run resource usage
+// ============================================================
+
+// runUsage is what a run cost the host. Figures are for the whole process, so
+// in the daemon they include anything else it did while the job ran.
+type runUsage struct {
+	CPUUserMS  float64 `json:"cpu_user_ms"`
+	CPUSysMS   float64 `json:"cpu_sys_ms"`
+	MaxRSS     int64   `json:"max_rss_bytes"` // peak resident set of the process so far
+	GoSysBytes uint64  `json:"go_sys_bytes"`  // memory the Go runtime holds from the OS
+	ReadBytes  int64   `json:"read_bytes"`    // -1 where /proc/self/io is unavailable
+	WriteBytes int64   `json:"written_bytes"` // likewise
+}
+
+// usageSample is a point-in-time reading of the counters runUsage is built from.
+type usageSample struct {
+	user, sys time.Duration
+	maxRSS    int64
+	rchar     int64
+	wchar     int64
+}
+
+// usageMeter measures a run from startUsage on; finish reads it once.
+type usageMeter struct {
+	start  usageSample
+	result *runUsage
+}
+
+func sampleUsage() usageSample {
+	var s usageSample
+	s.user, s.sys, s.maxRSS = processCPU()
+	s.rchar, s.wchar = procIO()
+	return s
+}
+
+// procIO returns the bytes this process has read and written through any
+// syscall, from /proc/self/io, or -1, -1 where that isn't available.
+func procIO() (rchar, wchar int64) {
+	rchar, wchar = -1, -1
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "rchar":
+			rchar = n
+		case "wchar":
+			wchar = n
+		}
+	}
+	return
+}
+
+// startUsage starts measuring cfg's run.
+func startUsage(cfg *Config) {
+	cfg.usage = &usageMeter{start: sampleUsage()}
+}
+
+// finish returns the usage since startUsage, computed on the first call so the
+// log line, report and event summary agree. A nil meter yields nil.
+func (m *usageMeter) finish() *runUsage {
+	if m == nil {
+		return nil
+	}
+	if m.result != nil {
+		return m.result
+	}
+	end := sampleUsage()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	u := &runUsage{
+		CPUUserMS:  float64((end.user - m.start.user).Microseconds()) / 1000,
+		CPUSysMS:   float64((end.sys - m.start.sys).Microseconds()) / 1000,
+		MaxRSS:     end.maxRSS,
+		GoSysBytes: ms.Sys,
+		ReadBytes:  -1,
+		WriteBytes: -1,
+	}
+	if end.rchar >= 0 && m.start.rchar >= 0 {
+		u.ReadBytes = end.rchar - m.start.rchar
+		u.WriteBytes = end.wchar - m.start.wchar
+	}
+	m.result = u
+	return u
+}
+
+// logResourceUsage logs what cfg's run cost, e.g. "Resources: CPU 1.2s user +
+// 0.3s sys, peak RSS 42.0 MB (Go runtime 30.1 MB), read 1.2 GB, wrote 310.4 MB".
+func logResourceUsage(cfg *Config) {
+	u := cfg.usage.finish()
+	if u == nil {
+		return
+	}
+	line := fmt.Sprintf("Resources: CPU %.1fs user + %.1fs sys, peak RSS %s (Go runtime %s)",
+		u.CPUUserMS/1000, u.CPUSysMS/1000, formatSize(u.MaxRSS), formatSize(int64(u.GoSysBytes)))
+	if u.ReadBytes >= 0 {
+		line += fmt.Sprintf(", read %s, wrote %s", formatSize(u.ReadBytes), formatSize(u.WriteBytes))
+	}
+	logInfo("%s", line)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageMeter(t *testing.T) {
+	cfg := &Config{}
+	if cfg.usage.finish() != nil {
+		t.Fatal("an unstarted meter should report nothing")
+	}
+	startUsage(cfg)
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	if err := os.WriteFile(filepath.Join(t.TempDir(), "out"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(20 * time.Millisecond); time.Now().Before(deadline); {
+	}
+
+	u := cfg.usage.finish()
+	if u.CPUUserMS+u.CPUSysMS <= 0 {
+		t.Errorf("no CPU time recorded: %+v", u)
+	}
+	if u.MaxRSS <= 0 || u.GoSysBytes == 0 {
+		t.Errorf("no memory recorded: %+v", u)
+	}
+	if u.WriteBytes >= 0 && u.WriteBytes < int64(len(payload)) {
+		t.Errorf("wrote %d bytes, usage says %d", len(payload), u.WriteBytes)
+	}
+	if again := cfg.usage.finish(); again != u {
+		t.Error("finish should return the same figures every time")
+	}
+
+	data, _ := json.Marshal(newRunReport(cfg, time.Now(), nil))
+	var r struct {
+		Usage *runUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil || r.Usage == nil || r.Usage.MaxRSS != u.MaxRSS {
+		t.Errorf("report usage = %+v (%v)", r.Usage, err)
+	}
+}
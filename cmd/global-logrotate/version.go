@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// ============================================================
+// Version info (--version --json)
+// ============================================================
+
+// commit is the git revision the binary was built from, set by the Makefile:
+//
+//	go build -ldflags "-X main.commit=$(git rev-parse --short HEAD)"
+var commit = "unknown"
+
+// archiveFormats lists the encrypted archive layouts this build can read; the
+// last one is what it writes. Version 1 is MAGIC+SALT+NONCE+CIPHERTEXT; version
+// 2 is the envelope layout in envelope.go.
+var archiveFormats = []int{1, 2}
+
+// versionInfo is what --version --json prints, for deployment tooling that
+// needs to check compatibility across a fleet.
+type versionInfo struct {
+	Name          string   `json:"name"`
+	Version       string   `json:"version"`
+	Commit        string   `json:"commit"`
+	ArchiveMagic  string   `json:"archive_magic"`
+	ArchiveBrand  string   `json:"archive_brand"`
+	FormatWrite   int      `json:"format_write"`
+	FormatsRead   []int    `json:"formats_read"`
+	Codecs        []string `json:"codecs"`
+	Ciphers       []string `json:"ciphers"`
+	KeyDerivation string   `json:"key_derivation"`
+}
+
+func buildVersionInfo() versionInfo {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return versionInfo{
+		Name:          "global-logrotate",
+		Version:       version,
+		Commit:        commit,
+		ArchiveMagic:  string(encryptMagic),
+		ArchiveBrand:  archiveBrand,
+		FormatWrite:   archiveFormats[len(archiveFormats)-1],
+		FormatsRead:   archiveFormats,
+		Codecs:        names,
+		Ciphers:       []string{"aes-256-gcm", backendGPG},
+		KeyDerivation: "pbkdf2-sha256",
+	}
+}
+
+// writeVersionJSON prints buildVersionInfo as indented JSON.
+func writeVersionJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildVersionInfo())
+}
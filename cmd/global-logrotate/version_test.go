@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestWriteVersionJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeVersionJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var info versionInfo
+	if err := json.Unmarshal(buf.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if info.Version != version || info.Commit == "" || info.ArchiveMagic != encryptMagicStr {
+		t.Errorf("info = %+v", info)
+	}
+	if !slices.Contains(info.FormatsRead, info.FormatWrite) {
+		t.Errorf("writes format %d but only reads %v", info.FormatWrite, info.FormatsRead)
+	}
+	for name := range codecs {
+		if !slices.Contains(info.Codecs, name) {
+			t.Errorf("codec %s missing from %v", name, info.Codecs)
+		}
+	}
+}
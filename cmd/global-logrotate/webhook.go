@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ============================================================
+// Run notification webhook (WEBHOOK_URL)
+// ============================================================
+
+const (
+	defaultWebhookTimeout = 10 // seconds per attempt
+	defaultWebhookRetries = 1
+)
+
+// webhookRetryDelay is the pause before each retry.
+var webhookRetryDelay = 2 * time.Second
+
+// webhookPayload is the JSON body POSTed to WEBHOOK_URL after a run: the run
+// report without the per-file list, plus the totals a dashboard alerts on.
+type webhookPayload struct {
+	*runReport
+	DurationMS   float64 `json:"duration_ms"`
+	FilesRotated int     `json:"files_rotated"`
+	BytesSaved   int64   `json:"bytes_saved"`
+	Errors       int     `json:"errors"`
+}
+
+// newWebhookPayload summarises results for a run that started at started.
+func newWebhookPayload(cfg *Config, started time.Time, results []FileResult) webhookPayload {
+	p := webhookPayload{runReport: newRunReport(cfg, started, results)}
+	p.Files = nil
+	p.DurationMS = float64(p.Finished.Sub(started).Microseconds()) / 1000
+	for _, r := range results {
+		switch r.Status {
+		case statusRotated:
+			p.FilesRotated++
+			p.BytesSaved += r.OriginalSize - r.ArchiveSize
+		case statusFailed:
+			p.Errors++
+		}
+	}
+	return p
+}
+
+// postWebhook POSTs body to url, retrying up to retries times after a network
+// error or a 5xx answer. It returns the last HTTP status (0 if none came).
+func postWebhook(url string, body []byte, timeout time.Duration, retries int) (int, error) {
+	client := &http.Client{Timeout: timeout}
+	retries = max(retries, 0)
+	var status int
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logDebug("Retrying webhook %s (attempt %d of %d)", url, attempt+1, retries+1)
+			time.Sleep(webhookRetryDelay)
+		}
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			status = 0
+			continue
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		switch {
+		case status < 300:
+			return status, nil
+		case status < 500:
+			return status, fmt.Errorf("webhook answered %s", resp.Status)
+		}
+		err = fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return status, err
+}
+
+// notifyWebhook POSTs the run summary to WEBHOOK_URL, whether or not every
+// file rotated. A failed notification is logged; the run's result stands.
+func notifyWebhook(cfg *Config, started time.Time, results []FileResult) {
+	if cfg.WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(newWebhookPayload(cfg, started, results))
+	if err != nil {
+		logError("Could not encode webhook payload: %v", err)
+		return
+	}
+	status, err := postWebhook(cfg.WebhookURL, body, time.Duration(cfg.WebhookTimeout)*time.Second, cfg.WebhookRetries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: run notification to %s failed: %v\n", cfg.WebhookURL, err)
+		logError("Webhook %s failed (HTTP status %d): %v", cfg.WebhookURL, status, err)
+		return
+	}
+	logInfo("Webhook %s notified (HTTP %d)", cfg.WebhookURL, status)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifyWebhook(t *testing.T) {
+	old := webhookRetryDelay
+	webhookRetryDelay = 10 * time.Millisecond
+	defer func() { webhookRetryDelay = old }()
+
+	var calls atomic.Int32
+	bodies := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway) // the first attempt fails, the retry lands
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cfg := &Config{WebhookURL: srv.URL, WebhookTimeout: 5, WebhookRetries: 1, LogDir: "/var/log/apps"}
+	results := []FileResult{
+		{Path: "a.log", Status: statusRotated, OriginalSize: 1000, ArchiveSize: 100},
+		{Path: "b.log", Status: statusRotated, OriginalSize: 500, ArchiveSize: 50},
+		{Path: "c.log", Status: statusFailed, Err: errors.New("disk full")},
+		{Path: "d.log", Status: statusSkipped},
+	}
+	notifyWebhook(cfg, time.Now().Add(-time.Second), results)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("%d attempt(s), want 2 (one retry after the 502)", n)
+	}
+	<-bodies
+	var got map[string]any
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{"files_rotated": 2, "bytes_saved": 1350, "errors": 1} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if got["host"] == "" || got["log_dir"] != "/var/log/apps" || got["duration_ms"].(float64) < 1000 {
+		t.Errorf("payload %v lacks the run details", got)
+	}
+	if _, ok := got["files"]; ok {
+		t.Error("payload should not carry the per-file list")
+	}
+}
+
+func TestPostWebhookNoRetryOn4xx(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	status, err := postWebhook(srv.URL, []byte("{}"), time.Second, 3)
+	if err == nil || status != http.StatusUnauthorized || calls.Load() != 1 {
+		t.Errorf("status %d, err %v after %d call(s); want one 401", status, err, calls.Load())
+	}
+}
@@ -7,7 +7,7 @@ _global_logrotate() {
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
     # All available options
-    opts="-H -D -n -h -p -o --pattern --parallel --encrypt --read --pass-gen --pass-reset --version --exclude-from --log-file --log-level"
+    opts="-H -D --date-pattern --utc -n -h --force --yes --estimate --estimate-sample --format --stream-archive --split-size --report-dir --metrics-file --signal-pidfile --signal --post-rotate --post-rotate-mode --upload --upload-region --upload-profile --upload-delete-local --fsync --no-fsync --checksum --lock-file --no-lock -p -o --pattern --regex --parallel --threads-for-io --threads-for-cpu --compress --compress-level --min-size --min-age --max-depth --no-recurse --rate-limit --retention-days --max-archives --max-total-size --order --fs-usage-threshold --encrypt --keyfile --private-key --read --to-fifo --grep -i --tail --member --list-members --only-encrypted --only-plain --resume --list --exact-size --decrypt-dir --out --repair --fsck --fsck-no-key --fsck-json --verify --pass-gen --pass-reset --rekey --reencrypt --migrate --encrypt-existing --remove-plain --version --json --exclude-from --log-file --log-level --quiet --verbose --plain --self-test --config --config-dir --trace-config --trace-format"
 
     # Handle options that require specific value completions
     case "${prev}" in
@@ -21,6 +21,30 @@ _global_logrotate() {
             COMPREPLY=( $(compgen -W "1 2 4 8 16 32" -- "${cur}") )
             return 0
             ;;
+        --compress)
+            COMPREPLY=( $(compgen -W "gzip xz zstd none" -- "${cur}") )
+            return 0
+            ;;
+        --compress-level)
+            COMPREPLY=( $(compgen -W "0 1 2 3 4 5 6 7 8 9" -- "${cur}") )
+            return 0
+            ;;
+        --max-depth)
+            COMPREPLY=( $(compgen -W "0 1 2 3" -- "${cur}") )
+            return 0
+            ;;
+        --order)
+            COMPREPLY=( $(compgen -W "size largest age name" -- "${cur}") )
+            return 0
+            ;;
+        --format)
+            COMPREPLY=( $(compgen -W "text json" -- "${cur}") )
+            return 0
+            ;;
+        --post-rotate-mode)
+            COMPREPLY=( $(compgen -W "file run" -- "${cur}") )
+            return 0
+            ;;
         --log-level)
             # Log level completion
             COMPREPLY=( $(compgen -W "error info debug" -- "${cur}") )